@@ -0,0 +1,237 @@
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"kv-db-lab/storage"
+	"os"
+	"testing"
+	"time"
+)
+
+func openTestEngine(t *testing.T) *storage.Engine {
+	return openTestEngineWithOptions(t, func(opts *model.Options) {})
+}
+
+// openTestEngineWithOptions 打开引擎之前由configure修改配置
+func openTestEngineWithOptions(t *testing.T, configure func(opts *model.Options)) *storage.Engine {
+	dir, err := os.MkdirTemp("", "kv-db-lab-cluster")
+	assert.Nil(t, err)
+
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	opts.SyncWrites = false
+	configure(&opts)
+	engine, err := storage.OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = engine.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return engine
+}
+
+func startTestNode(t *testing.T, id string, bootstrap bool) (*Node, *storage.Engine) {
+	engine := openTestEngine(t)
+	raftDir, err := os.MkdirTemp("", "kv-db-lab-raft")
+	assert.Nil(t, err)
+
+	node, err := NewNode(engine, &model.ClusterOptions{
+		NodeID:           id,
+		RaftAddr:         "127.0.0.1:0",
+		RaftDir:          raftDir,
+		Bootstrap:        bootstrap,
+		Meta:             map[string]string{constant.ClusterMetaRedis: id + ":6380"},
+		ApplyTimeout:     5 * time.Second,
+		HeartbeatTimeout: 300 * time.Millisecond,
+		ElectionTimeout:  300 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = node.Close()
+		_ = os.RemoveAll(raftDir)
+	})
+	return node, engine
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if fn() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("condition not met before timeout")
+}
+
+func TestCluster_Replicate(t *testing.T) {
+	leader, leaderEngine := startTestNode(t, "node1", true)
+	waitFor(t, leader.IsLeader)
+
+	follower1, followerEngine1 := startTestNode(t, "node2", false)
+	follower2, followerEngine2 := startTestNode(t, "node3", false)
+	assert.Nil(t, leader.Join(follower1.ID(), follower1.RaftAddr(), map[string]string{constant.ClusterMetaRedis: "node2:6380"}))
+	assert.Nil(t, leader.Join(follower2.ID(), follower2.RaftAddr(), nil))
+
+	// 写入经leader复制到所有节点
+	assert.Nil(t, leaderEngine.Put([]byte("k1"), []byte("v1")))
+	ok, err := leaderEngine.CompareAndSwap([]byte("k1"), []byte("v0"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = leaderEngine.CompareAndSwap([]byte("k1"), []byte("v1"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	wb := leaderEngine.NewWriteBatch(&model.WriteBatchOptions{MaxBatchSize: 10})
+	assert.Nil(t, wb.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, wb.Put([]byte("k3"), []byte("v3")))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, leaderEngine.Delete([]byte("k3")))
+
+	value, err := leader.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), value)
+
+	for _, engine := range []*storage.Engine{followerEngine1, followerEngine2} {
+		engine := engine
+		waitFor(t, func() bool {
+			value, err := engine.Get([]byte("k2"))
+			_, errDeleted := engine.Get([]byte("k3"))
+			return err == nil && bytes.Equal(value, []byte("v2")) && errDeleted == constant.ErrNotExist
+		})
+		value, err := engine.Get([]byte("k1"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v2"), value)
+	}
+
+	// 从节点拒绝写入与线性一致读，并能找到leader的服务地址
+	assert.Equal(t, constant.ErrNotLeader, followerEngine1.Put([]byte("k4"), []byte("v4")))
+	_, err = follower1.Get([]byte("k1"))
+	assert.Equal(t, constant.ErrNotLeader, err)
+
+	id, meta, err := follower1.Leader()
+	assert.Nil(t, err)
+	assert.Equal(t, "node1", id)
+	waitFor(t, func() bool {
+		_, meta, _ = follower2.Leader()
+		return meta[constant.ClusterMetaRedis] == "node1:6380"
+	})
+}
+
+// memorySink 内存中的快照输出
+type memorySink struct {
+	bytes.Buffer
+}
+
+func (s *memorySink) ID() string    { return "test" }
+func (s *memorySink) Cancel() error { return nil }
+func (s *memorySink) Close() error  { return nil }
+
+func TestFSM_SnapshotRestore(t *testing.T) {
+	for name, index := range map[string]model.IndexType{"btree": model.Btree, "art": model.ART, "bplustree": model.BPlusTree} {
+		index := index
+		t.Run(name, func(t *testing.T) {
+			testFSMSnapshotRestore(t, index)
+		})
+	}
+}
+
+func testFSMSnapshotRestore(t *testing.T, index model.IndexType) {
+	withIndex := func(opts *model.Options) {
+		opts.Index = index
+	}
+	srcEngine := openTestEngineWithOptions(t, withIndex)
+	srcFSM, err := newFSM(srcEngine, t.TempDir()+"/"+constant.ClusterStateFileName)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, srcEngine.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	assert.Nil(t, srcEngine.Delete([]byte("key-0")))
+	srcFSM.state.AppliedIndex = 42
+	srcFSM.state.Peers["node1"] = map[string]string{constant.ClusterMetaHTTP: "127.0.0.1:8080"}
+
+	snapshot, err := srcFSM.Snapshot()
+	assert.Nil(t, err)
+
+	// 快照之后的写入不包含在快照中
+	assert.Nil(t, srcEngine.Put([]byte("key-after"), []byte("value")))
+
+	sink := &memorySink{}
+	assert.Nil(t, snapshot.Persist(sink))
+
+	dstEngine := openTestEngineWithOptions(t, withIndex)
+	assert.Nil(t, dstEngine.Put([]byte("stale"), []byte("value")))
+	assert.Nil(t, dstEngine.Put([]byte("key-1"), []byte("old")))
+	dstFSM, err := newFSM(dstEngine, t.TempDir()+"/"+constant.ClusterStateFileName)
+	assert.Nil(t, err)
+	assert.Nil(t, dstFSM.Restore(io.NopCloser(&sink.Buffer)))
+
+	// 除用户数据外引擎中还有已应用的日志序号
+	assert.Equal(t, 100, len(dstEngine.GetAllKeys()))
+	_, err = dstEngine.Get([]byte("stale"))
+	assert.Equal(t, constant.ErrNotExist, err)
+	_, err = dstEngine.Get([]byte("key-after"))
+	assert.Equal(t, constant.ErrNotExist, err)
+	value, err := dstEngine.Get([]byte("key-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-1"), value)
+
+	assert.Equal(t, uint64(42), dstFSM.state.AppliedIndex)
+	assert.Equal(t, "127.0.0.1:8080", dstFSM.peer("node1")[constant.ClusterMetaHTTP])
+	reopened, err := newFSM(dstEngine, t.TempDir()+"/"+constant.ClusterStateFileName)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(42), reopened.state.AppliedIndex)
+}
+
+func TestFSM_AppliedIndex(t *testing.T) {
+	engine := openTestEngineWithOptions(t, func(opts *model.Options) {
+		opts.MergeOperator = model.Int64AddOperator{}
+	})
+	statePath := t.TempDir() + "/" + constant.ClusterStateFileName
+	f, err := newFSM(engine, statePath)
+	assert.Nil(t, err)
+
+	writeLog := func(index uint64, cmd *model.WriteCommand) *raft.Log {
+		return &raft.Log{Index: index, Data: append([]byte{fsmWrite}, model.EncodeWriteCommand(cmd)...)}
+	}
+	incr := writeLog(1, &model.WriteCommand{
+		Type:    model.WriteApply,
+		Records: []*model.LogRecord{{Key: []byte("counter"), Value: []byte("3"), Status: constant.LogRecordMerge}},
+	})
+	result := f.Apply(incr).(*applyResult)
+	assert.Nil(t, result.err)
+	assert.True(t, result.ok)
+
+	// 条件不满足的写操作同样记录日志序号
+	cas := writeLog(2, &model.WriteCommand{
+		Type:         model.WriteCompareAndSwap,
+		Records:      []*model.LogRecord{{Key: []byte("counter"), Value: []byte("0"), Status: constant.LogRecordNormal}},
+		ExpectAbsent: true,
+	})
+	result = f.Apply(cas).(*applyResult)
+	assert.Nil(t, result.err)
+	assert.False(t, result.ok)
+
+	// 状态文件丢失后仍从引擎中读到已应用的日志序号，重放的日志不会重复应用
+	assert.Nil(t, os.RemoveAll(statePath))
+	f, err = newFSM(engine, statePath)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), f.state.AppliedIndex)
+	f.Apply(incr)
+	value, err := engine.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), value)
+
+	incr.Index = 3
+	f.Apply(incr)
+	value, err = engine.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("6"), value)
+}
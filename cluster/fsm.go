@@ -0,0 +1,361 @@
+package cluster
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"io"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"kv-db-lab/storage"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type fsmOpType = byte
+
+const (
+	// fsmWrite 日志内容为storage引擎的写操作
+	fsmWrite fsmOpType = iota + 1
+
+	// fsmPeer 日志内容为节点附加信息的变更
+	fsmPeer
+)
+
+// restoreBatchSize 从快照恢复时每个批次写入的数据条数
+const restoreBatchSize = 1024
+
+// applyResult 状态机应用一条写操作的结果
+type applyResult struct {
+	ok  bool
+	err error
+}
+
+// peerChange 节点附加信息变更，Meta为nil时表示节点离开集群
+type peerChange struct {
+	ID   string            `json:"id"`
+	Meta map[string]string `json:"meta"`
+}
+
+// fsmState
+//
+//	@Description: 状态机自身的元数据，与快照一同保存，节点信息变更后写入RaftDir
+type fsmState struct {
+	// 已应用到引擎的最后一条日志序号，重启后跳过这之前的日志
+	// 与写操作在同一批次内写入引擎的ClusterAppliedIndexKey，以引擎中的为准
+	AppliedIndex uint64 `json:"applied_index"`
+
+	// 各节点的附加信息
+	Peers map[string]map[string]string `json:"peers"`
+}
+
+// fsm
+//
+//	@Description: raft状态机，将提交的日志应用到storage引擎，并直接基于数据文件生成快照
+type fsm struct {
+	lock      *sync.RWMutex
+	engine    *storage.Engine
+	statePath string
+	state     *fsmState
+}
+
+func newFSM(engine *storage.Engine, statePath string) (*fsm, error) {
+	f := &fsm{
+		lock:      new(sync.RWMutex),
+		engine:    engine,
+		statePath: statePath,
+		state:     &fsmState{Peers: make(map[string]map[string]string)},
+	}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, f.state); err != nil {
+			return nil, err
+		}
+	}
+	if f.state.Peers == nil {
+		f.state.Peers = make(map[string]map[string]string)
+	}
+
+	index, err := engine.Get([]byte(constant.ClusterAppliedIndexKey))
+	if err == nil && len(index) == 8 {
+		f.state.AppliedIndex = binary.BigEndian.Uint64(index)
+	} else if err != nil && err != constant.ErrNotExist {
+		return nil, err
+	}
+	return f, nil
+}
+
+// appliedIndexRecord 记录已应用日志序号的数据，随日志中的写操作一同写入引擎
+func appliedIndexRecord(index uint64) *model.LogRecord {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, index)
+	return &model.LogRecord{Key: []byte(constant.ClusterAppliedIndexKey), Value: value, Status: constant.LogRecordNormal}
+}
+
+// saveAppliedIndex 单独写入已应用的日志序号，用于不修改引擎数据的日志
+func (f *fsm) saveAppliedIndex(index uint64) error {
+	_, err := f.engine.ApplyCommand(&model.WriteCommand{Type: model.WriteBatch}, appliedIndexRecord(index))
+	return err
+}
+
+// Apply 应用一条已提交的日志
+func (f *fsm) Apply(log *raft.Log) interface{} {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// 重启后raft会从最近的快照开始重放日志，已经写入引擎的不再重复应用
+	if log.Index <= f.state.AppliedIndex {
+		return &applyResult{ok: true}
+	}
+
+	// 写操作与日志序号在同一批次内写入引擎，宕机后不会重复应用同一条日志
+	result := &applyResult{}
+	var indexSaved bool
+	if len(log.Data) == 0 {
+		result.err = constant.ErrInvalidFSMOp
+	} else {
+		switch log.Data[0] {
+		case fsmWrite:
+			var cmd *model.WriteCommand
+			if cmd, result.err = model.DecodeWriteCommand(log.Data[1:]); result.err == nil {
+				result.ok, result.err = f.engine.ApplyCommand(cmd, appliedIndexRecord(log.Index))
+				indexSaved = true
+			}
+		case fsmPeer:
+			// 节点信息的变更可以重复应用，先于日志序号持久化
+			change := &peerChange{}
+			if result.err = json.Unmarshal(log.Data[1:], change); result.err == nil {
+				if change.Meta == nil {
+					delete(f.state.Peers, change.ID)
+				} else {
+					f.state.Peers[change.ID] = change.Meta
+				}
+				result.ok = true
+				result.err = f.saveState()
+			}
+		default:
+			result.err = constant.ErrInvalidFSMOp
+		}
+	}
+
+	f.state.AppliedIndex = log.Index
+	if !indexSaved {
+		if err := f.saveAppliedIndex(log.Index); err != nil && result.err == nil {
+			result.err = err
+		}
+	}
+	return result
+}
+
+// saveState 持久化状态机元数据，调用方需持有f.lock
+func (f *fsm) saveState() error {
+	data, err := json.Marshal(f.state)
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件并持久化到磁盘再重命名，避免写到一半时宕机
+	tmpPath := f.statePath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, f.statePath)
+}
+
+// peer 返回节点的附加信息
+func (f *fsm) peer(id string) map[string]string {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.state.Peers[id]
+}
+
+// Snapshot 记录当前数据文件的末尾位置，由Persist在后台读取该位置之前的数据文件
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	state := &fsmState{
+		AppliedIndex: f.state.AppliedIndex,
+		Peers:        make(map[string]map[string]string, len(f.state.Peers)),
+	}
+	for id, meta := range f.state.Peers {
+		state.Peers[id] = meta
+	}
+	return &fsmSnapshot{
+		engine: f.engine,
+		end:    f.engine.EndPosition(),
+		state:  state,
+	}, nil
+}
+
+// Restore 用快照中的数据文件替换本地引擎的数据
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	tmpDir, err := os.MkdirTemp("", "kv-db-lab-restore")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// 解出快照中的数据文件与状态机元数据
+	state := &fsmState{}
+	reader := tar.NewReader(rc)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Name == constant.ClusterStateFileName {
+			if err := json.NewDecoder(reader).Decode(state); err != nil {
+				return err
+			}
+			continue
+		}
+		file, err := os.Create(filepath.Join(tmpDir, filepath.Base(header.Name)))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, reader)
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if state.Peers == nil {
+		state.Peers = make(map[string]map[string]string)
+	}
+
+	// 用快照数据文件打开一个临时引擎，由其重建索引并解析合并操作数
+	// B+树索引启动时不回放数据文件，临时引擎统一使用BTree索引
+	opts := f.engine.Options()
+	opts.DirPath = tmpDir
+	opts.Index = model.Btree
+	snapshotEngine, err := storage.OpenWithOptions(&opts)
+	if err != nil {
+		return err
+	}
+	defer snapshotEngine.Close()
+
+	if err := f.replaceWith(snapshotEngine); err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.state = state
+	if err := f.saveState(); err != nil {
+		return err
+	}
+	return f.saveAppliedIndex(state.AppliedIndex)
+}
+
+// replaceWith 删除本地多余的key并写入快照中的全部数据，使本地引擎与快照一致
+func (f *fsm) replaceWith(snapshotEngine *storage.Engine) error {
+	records := make([]*model.LogRecord, 0, restoreBatchSize)
+	flush := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+		_, err := f.engine.ApplyCommand(&model.WriteCommand{Type: model.WriteBatch, Records: records})
+		records = make([]*model.LogRecord, 0, restoreBatchSize)
+		return err
+	}
+
+	for _, key := range f.engine.GetAllKeys() {
+		if _, err := snapshotEngine.Get(key); err != constant.ErrNotExist {
+			continue
+		}
+		records = append(records, &model.LogRecord{Key: key, Status: constant.LogRecordDelete})
+		if len(records) == restoreBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	var foldErr error
+	err := snapshotEngine.Fold(func(key []byte, value []byte) bool {
+		records = append(records, &model.LogRecord{Key: key, Value: value, Status: constant.LogRecordNormal})
+		if len(records) == restoreBatchSize {
+			foldErr = flush()
+		}
+		return foldErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	if foldErr != nil {
+		return foldErr
+	}
+	return flush()
+}
+
+// fsmSnapshot
+//
+//	@Description: 一次快照，内容为tar格式的状态机元数据与数据文件
+type fsmSnapshot struct {
+	engine *storage.Engine
+	end    *model.LogRecordPos
+	state  *fsmState
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := s.persist(sink); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) persist(sink raft.SnapshotSink) error {
+	writer := tar.NewWriter(sink)
+
+	state, err := json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+	if err := writer.WriteHeader(&tar.Header{Name: constant.ClusterStateFileName, Mode: 0644, Size: int64(len(state))}); err != nil {
+		return err
+	}
+	if _, err := writer.Write(state); err != nil {
+		return err
+	}
+
+	err = s.engine.CopyDataFiles(s.end, func(fileID uint, size int64, r io.Reader) error {
+		name := fmt.Sprintf("%09d", fileID) + constant.DataFileSuffix
+		if err := writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size}); err != nil {
+			return err
+		}
+		_, err := io.Copy(writer, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+func (s *fsmSnapshot) Release() {}
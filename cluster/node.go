@@ -0,0 +1,317 @@
+package cluster
+
+import (
+	"encoding/json"
+	"github.com/hashicorp/raft"
+	"github.com/sirupsen/logrus"
+	"io"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"kv-db-lab/storage"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// 保留的快照数量
+	retainSnapshotCount = 2
+
+	// raft节点间连接池大小与IO超时
+	maxPool          = 3
+	transportTimeout = 10 * time.Second
+)
+
+// Node
+//
+//	@Description: Raft集群中的一个节点，写操作经leader复制后由各节点的状态机应用到本地引擎
+type Node struct {
+	opts      *model.ClusterOptions
+	engine    *storage.Engine
+	raft      *raft.Raft
+	fsm       *fsm
+	store     *raftStore
+	transport *raft.NetworkTransport
+	logWriter io.WriteCloser
+	notifyCh  chan bool
+	closeCh   chan struct{}
+	doneCh    chan struct{}
+}
+
+// NewNode
+//
+//	@Description: 启动一个集群节点，并将engine的写操作交由该节点复制
+//	@param engine
+//	@param opts
+//	@return *Node
+//	@return error
+func NewNode(engine *storage.Engine, opts *model.ClusterOptions) (*Node, error) {
+	if engine == nil || opts == nil || opts.NodeID == "" || opts.RaftDir == "" {
+		return nil, constant.ErrEmptyParam
+	}
+	if err := os.MkdirAll(opts.RaftDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	node := &Node{
+		opts:      opts,
+		engine:    engine,
+		logWriter: logrus.StandardLogger().WriterLevel(logrus.InfoLevel),
+		notifyCh:  make(chan bool, 16),
+		closeCh:   make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+
+	var err error
+	defer func() {
+		if err != nil {
+			node.release()
+		}
+	}()
+
+	if node.fsm, err = newFSM(engine, filepath.Join(opts.RaftDir, constant.ClusterStateFileName)); err != nil {
+		return nil, err
+	}
+	if node.store, err = newRaftStore(filepath.Join(opts.RaftDir, "log")); err != nil {
+		return nil, err
+	}
+	snapshots, err := raft.NewFileSnapshotStore(opts.RaftDir, retainSnapshotCount, node.logWriter)
+	if err != nil {
+		return nil, err
+	}
+	if node.transport, err = raft.NewTCPTransport(opts.RaftAddr, nil, maxPool, transportTimeout, node.logWriter); err != nil {
+		return nil, err
+	}
+
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(opts.NodeID)
+	config.LogOutput = node.logWriter
+	config.NotifyCh = node.notifyCh
+	// 引擎数据本身已持久化，重启时无需从快照恢复，由状态机跳过已应用的日志
+	config.NoSnapshotRestoreOnStart = true
+	if opts.HeartbeatTimeout > 0 {
+		config.HeartbeatTimeout = opts.HeartbeatTimeout
+		config.LeaderLeaseTimeout = opts.HeartbeatTimeout
+	}
+	if opts.ElectionTimeout > 0 {
+		config.ElectionTimeout = opts.ElectionTimeout
+	}
+
+	if node.raft, err = raft.NewRaft(config, node.fsm, node.store, node.store, snapshots, node.transport); err != nil {
+		return nil, err
+	}
+
+	if opts.Bootstrap {
+		var hasState bool
+		if hasState, err = raft.HasExistingState(node.store, node.store, snapshots); err != nil {
+			return nil, err
+		}
+		if !hasState {
+			configuration := raft.Configuration{
+				Servers: []raft.Server{{ID: config.LocalID, Address: node.transport.LocalAddr()}},
+			}
+			if err = node.raft.BootstrapCluster(configuration).Error(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	engine.SetReplicator(node)
+	go node.watchLeadership()
+	return node, nil
+}
+
+// watchLeadership 成为leader后广播自身的附加信息，供其他节点转发请求
+func (n *Node) watchLeadership() {
+	defer close(n.doneCh)
+	for {
+		select {
+		case <-n.closeCh:
+			return
+		case isLeader := <-n.notifyCh:
+			if !isLeader || len(n.opts.Meta) == 0 {
+				continue
+			}
+			if err := n.applyPeer(n.opts.NodeID, n.opts.Meta); err != nil {
+				logrus.Warnf("cluster:publish node meta failed,err:%v", err)
+			}
+		}
+	}
+}
+
+// ID 返回节点ID
+func (n *Node) ID() string {
+	return n.opts.NodeID
+}
+
+// RaftAddr 返回Raft通信的实际监听地址
+func (n *Node) RaftAddr() string {
+	return string(n.transport.LocalAddr())
+}
+
+// IsLeader 当前节点是否为leader
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// Leader
+//
+//	@Description: 返回当前leader的节点ID与附加信息，没有leader时返回ErrNoLeader
+//	@receiver n
+//	@return string
+//	@return map[string]string
+//	@return error
+func (n *Node) Leader() (string, map[string]string, error) {
+	_, id := n.raft.LeaderWithID()
+	if id == "" {
+		return "", nil, constant.ErrNoLeader
+	}
+	return string(id), n.fsm.peer(string(id)), nil
+}
+
+// WaitLeader 等待集群选出leader
+func (n *Node) WaitLeader(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, id := n.raft.LeaderWithID(); id != "" {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return constant.ErrNoLeader
+}
+
+// Join
+//
+//	@Description: 将新节点加入集群，只能在leader上调用
+//	@receiver n
+//	@param id
+//	@param raftAddr
+//	@param meta  节点对外服务地址等附加信息
+//	@return error
+func (n *Node) Join(id, raftAddr string, meta map[string]string) error {
+	if !n.IsLeader() {
+		return constant.ErrNotLeader
+	}
+	if err := n.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(raftAddr), 0, n.opts.ApplyTimeout).Error(); err != nil {
+		return convertError(err)
+	}
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	return n.applyPeer(id, meta)
+}
+
+// Leave 将节点移出集群，只能在leader上调用
+func (n *Node) Leave(id string) error {
+	if !n.IsLeader() {
+		return constant.ErrNotLeader
+	}
+	if err := n.raft.RemoveServer(raft.ServerID(id), 0, n.opts.ApplyTimeout).Error(); err != nil {
+		return convertError(err)
+	}
+	return n.applyPeer(id, nil)
+}
+
+// Replicate
+//
+//	@Description: 实现model.Replicator，写操作经多数节点提交并在本地应用后返回
+//	@receiver n
+//	@param cmd
+//	@return bool
+//	@return error
+func (n *Node) Replicate(cmd *model.WriteCommand) (bool, error) {
+	result, err := n.apply(fsmWrite, model.EncodeWriteCommand(cmd))
+	if err != nil {
+		return false, err
+	}
+	return result.ok, result.err
+}
+
+func (n *Node) applyPeer(id string, meta map[string]string) error {
+	data, err := json.Marshal(&peerChange{ID: id, Meta: meta})
+	if err != nil {
+		return err
+	}
+	result, err := n.apply(fsmPeer, data)
+	if err != nil {
+		return err
+	}
+	return result.err
+}
+
+func (n *Node) apply(op fsmOpType, data []byte) (*applyResult, error) {
+	if !n.IsLeader() {
+		return nil, constant.ErrNotLeader
+	}
+
+	entry := make([]byte, 0, len(data)+1)
+	entry = append(entry, op)
+	entry = append(entry, data...)
+	future := n.raft.Apply(entry, n.opts.ApplyTimeout)
+	if err := future.Error(); err != nil {
+		return nil, convertError(err)
+	}
+	return future.Response().(*applyResult), nil
+}
+
+// LinearizableRead
+//
+//	@Description: 确认当前节点仍是leader且之前提交的写操作都已应用到本地引擎，之后读取本地引擎即为线性一致读
+//	@receiver n
+//	@return error
+func (n *Node) LinearizableRead() error {
+	if !n.IsLeader() {
+		return constant.ErrNotLeader
+	}
+	// Barrier本身作为一条日志经多数节点提交，返回时本节点在提交时刻仍是leader，且之前的日志都已应用
+	return convertError(n.raft.Barrier(n.opts.ApplyTimeout).Error())
+}
+
+// Get 线性一致地读取key
+func (n *Node) Get(key []byte) ([]byte, error) {
+	if err := n.LinearizableRead(); err != nil {
+		return nil, err
+	}
+	return n.engine.Get(key)
+}
+
+// Snapshot 立即生成一次快照
+func (n *Node) Snapshot() error {
+	return n.raft.Snapshot().Error()
+}
+
+// Close 停止节点，引擎恢复为直接写本地，引擎本身由调用方关闭
+func (n *Node) Close() error {
+	n.engine.SetReplicator(nil)
+	err := n.raft.Shutdown().Error()
+
+	close(n.closeCh)
+	<-n.doneCh
+	n.release()
+	return err
+}
+
+// release 释放节点持有的资源
+func (n *Node) release() {
+	if n.raft != nil && n.raft.State() != raft.Shutdown {
+		_ = n.raft.Shutdown().Error()
+	}
+	if n.transport != nil {
+		_ = n.transport.Close()
+	}
+	if n.store != nil {
+		_ = n.store.Close()
+	}
+	_ = n.logWriter.Close()
+}
+
+// convertError 将raft的错误转换为仓库内的错误
+func convertError(err error) error {
+	switch err {
+	case raft.ErrNotLeader, raft.ErrLeadershipLost, raft.ErrLeadershipTransferInProgress:
+		return constant.ErrNotLeader
+	default:
+		return err
+	}
+}
@@ -0,0 +1,232 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/hashicorp/raft"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"kv-db-lab/storage"
+	"sync"
+	"time"
+)
+
+var (
+	// raft日志与稳定存储的key前缀
+	logKeyPrefix    = []byte("l")
+	stableKeyPrefix = []byte("s")
+
+	// raft要求不存在的稳定存储key返回该错误信息
+	errKeyNotFound = errors.New("not found")
+)
+
+// raftStore
+//
+//	@Description: 基于bitcask引擎实现raft的LogStore与StableStore
+type raftStore struct {
+	lock       *sync.RWMutex
+	engine     *storage.Engine
+	firstIndex uint64
+	lastIndex  uint64
+}
+
+func newRaftStore(dirPath string) (*raftStore, error) {
+	opts := *model.DefaultOptions
+	opts.DirPath = dirPath
+	opts.SyncWrites = true
+	engine, err := storage.OpenWithOptions(&opts)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &raftStore{
+		lock:   new(sync.RWMutex),
+		engine: engine,
+	}
+	store.firstIndex = store.boundIndex(false)
+	store.lastIndex = store.boundIndex(true)
+	return store, nil
+}
+
+// boundIndex 通过前缀迭代找到最小/最大的日志序号
+func (s *raftStore) boundIndex(reverse bool) uint64 {
+	iter := s.engine.NewIterate(&model.IteratorOptions{Prefix: logKeyPrefix, Reverse: reverse})
+	defer iter.Close()
+
+	iter.Rewind()
+	if !iter.Valid() {
+		return 0
+	}
+	return binary.BigEndian.Uint64(iter.Key()[len(logKeyPrefix):])
+}
+
+func logKey(index uint64) []byte {
+	key := make([]byte, len(logKeyPrefix)+8)
+	copy(key, logKeyPrefix)
+	binary.BigEndian.PutUint64(key[len(logKeyPrefix):], index)
+	return key
+}
+
+func stableKey(key []byte) []byte {
+	return append(append([]byte{}, stableKeyPrefix...), key...)
+}
+
+// encodeLog
+/*
+term | type | appendedAt | dataSize | data | extensions
+ 8      1        8           var      var      var
+*/
+func encodeLog(log *raft.Log) []byte {
+	buf := make([]byte, 17, 17+binary.MaxVarintLen64+len(log.Data)+len(log.Extensions))
+	binary.BigEndian.PutUint64(buf[0:8], log.Term)
+	buf[8] = byte(log.Type)
+	var appendedAt int64
+	if !log.AppendedAt.IsZero() {
+		appendedAt = log.AppendedAt.UnixNano()
+	}
+	binary.BigEndian.PutUint64(buf[9:17], uint64(appendedAt))
+	buf = binary.AppendUvarint(buf, uint64(len(log.Data)))
+	buf = append(buf, log.Data...)
+	buf = append(buf, log.Extensions...)
+	return buf
+}
+
+func decodeLog(index uint64, buf []byte, log *raft.Log) error {
+	if len(buf) < 17 {
+		return constant.ErrInvalidFSMOp
+	}
+	log.Index = index
+	log.Term = binary.BigEndian.Uint64(buf[0:8])
+	log.Type = raft.LogType(buf[8])
+	log.AppendedAt = time.Time{}
+	if appendedAt := int64(binary.BigEndian.Uint64(buf[9:17])); appendedAt != 0 {
+		log.AppendedAt = time.Unix(0, appendedAt)
+	}
+
+	dataSize, n := binary.Uvarint(buf[17:])
+	if n <= 0 || uint64(len(buf)-17-n) < dataSize {
+		return constant.ErrInvalidFSMOp
+	}
+	start := 17 + n
+	log.Data = buf[start : start+int(dataSize)]
+	log.Extensions = nil
+	if rest := buf[start+int(dataSize):]; len(rest) > 0 {
+		log.Extensions = rest
+	}
+	return nil
+}
+
+// FirstIndex 返回第一条日志的序号，没有日志时为0
+func (s *raftStore) FirstIndex() (uint64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.firstIndex, nil
+}
+
+// LastIndex 返回最后一条日志的序号，没有日志时为0
+func (s *raftStore) LastIndex() (uint64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.lastIndex, nil
+}
+
+// GetLog 读取指定序号的日志
+func (s *raftStore) GetLog(index uint64, log *raft.Log) error {
+	value, err := s.engine.Get(logKey(index))
+	if err == constant.ErrNotExist {
+		return raft.ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return decodeLog(index, value, log)
+}
+
+// StoreLog 写入一条日志
+func (s *raftStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs 通过WriteBatch原子写入多条日志
+func (s *raftStore) StoreLogs(logs []*raft.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	wb := s.engine.NewWriteBatch(&model.WriteBatchOptions{SyncWrite: true, MaxBatchSize: uint(len(logs))})
+	for _, log := range logs {
+		if err := wb.Put(logKey(log.Index), encodeLog(log)); err != nil {
+			return err
+		}
+	}
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, log := range logs {
+		if s.firstIndex == 0 || log.Index < s.firstIndex {
+			s.firstIndex = log.Index
+		}
+		if log.Index > s.lastIndex {
+			s.lastIndex = log.Index
+		}
+	}
+	return nil
+}
+
+// DeleteRange 删除[min, max]区间内的日志
+func (s *raftStore) DeleteRange(min, max uint64) error {
+	for index := min; index <= max; index++ {
+		if err := s.engine.Delete(logKey(index)); err != nil && err != constant.ErrNotExist {
+			return err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.firstIndex = s.boundIndex(false)
+	s.lastIndex = s.boundIndex(true)
+	return nil
+}
+
+// Set 写入稳定存储
+func (s *raftStore) Set(key []byte, val []byte) error {
+	return s.engine.Put(stableKey(key), val)
+}
+
+// Get 读取稳定存储
+func (s *raftStore) Get(key []byte) ([]byte, error) {
+	value, err := s.engine.Get(stableKey(key))
+	if err == constant.ErrNotExist {
+		return nil, errKeyNotFound
+	}
+	return value, err
+}
+
+// SetUint64 写入uint64类型的稳定存储
+func (s *raftStore) SetUint64(key []byte, val uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, val)
+	return s.Set(key, buf)
+}
+
+// GetUint64 读取uint64类型的稳定存储，不存在时为0
+func (s *raftStore) GetUint64(key []byte) (uint64, error) {
+	value, err := s.Get(key)
+	if err == errKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, constant.ErrInvalidFSMOp
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
+
+func (s *raftStore) Close() error {
+	return s.engine.Close()
+}
@@ -7,6 +7,7 @@ type LogRecordStatus byte
 const (
 	LogRecordNormal LogRecordStatus = 100 + iota
 	LogRecordDelete
+	LogRecordMerge // 合并操作数，需与基础值合并后才是完整的value
 )
 
 // DefaultFileMode 默认创建文件的权限
@@ -57,3 +58,32 @@ const FileLockName = "lockFile"
 
 // DefaultMergeRatio 默认merge的阈值：无效数据大小与总数据大小比值
 const DefaultMergeRatio = 0.5
+
+// MaxMergeChainLength 单个key上未合并的操作数上限，超过后Apply直接写入合并后的完整值
+const MaxMergeChainLength = 64
+
+// WatchChannelSize 变更订阅的事件通道缓冲大小
+const WatchChannelSize = 1024
+
+// ReplicationRetainPrefix 主节点登记从节点读取位置时使用的名称前缀
+const ReplicationRetainPrefix = "replication:"
+
+// ClusterStateFileName Raft状态机记录节点信息的文件名，位于RaftDir
+const ClusterStateFileName = "fsm-state"
+
+// InternalKeyPrefix 引擎之上各层内部使用的keyspace前缀，不属于用户数据，redis层遍历key时跳过
+const InternalKeyPrefix = "\x00\x00kv-db-lab\x00"
+
+// ClusterAppliedIndexKey Raft状态机已应用的最后一条日志序号，与日志中的写操作在同一批次内写入引擎
+const ClusterAppliedIndexKey = InternalKeyPrefix + "raft-applied"
+
+// ReplicationPosKey 从节点已同步到的主节点日志位置，与应用的变更在同一批次内写入引擎
+const ReplicationPosKey = InternalKeyPrefix + "replication-pos"
+
+const (
+	// ClusterMetaRedis 节点redis服务地址在Meta中的key
+	ClusterMetaRedis = "redis"
+
+	// ClusterMetaHTTP 节点http服务地址在Meta中的key
+	ClusterMetaHTTP = "http"
+)
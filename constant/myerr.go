@@ -13,4 +13,31 @@ const (
 	ErrInvalidCRC  = Err("无效的CRC")
 	ErrWrongTypeOp = Err("此数据类型不支持该操作")
 	ErrExpireTime  = Err("此数据已经过期")
+
+	ErrIndexOutOfRange = Err("索引越界")
+	ErrNotInteger      = Err("数据不是整数或超出范围")
+	ErrNotFloat        = Err("数据不是合法的浮点数")
+	ErrIncrOverflow    = Err("自增或自减后溢出")
+	ErrInvalidExpire   = Err("过期时间不合法")
+	ErrNotBit          = Err("bit只能为0或1")
+	ErrStringTooLong   = Err("字符串长度超出限制")
+
+	ErrInvalidStreamID  = Err("无效的stream ID")
+	ErrStreamIDTooSmall = Err("ID必须大于stream中最大的ID")
+	ErrGroupExists      = Err("消费者组已存在")
+	ErrNoGroup          = Err("消费者组不存在")
+	ErrInvalidGeo       = Err("经纬度超出范围")
+	ErrInvalidGeoUnit   = Err("不支持的距离单位")
+
+	ErrMergeOperatorNotSet = Err("未配置合并操作符")
+	ErrMergeOperatorIndex  = Err("B+树索引不支持合并操作符")
+
+	ErrWatchLagged       = Err("订阅者消费过慢，事件通道已关闭")
+	ErrPositionCompacted = Err("该位置的数据文件已被merge重写")
+	ErrMergeRetained     = Err("仍有副本需要读取旧的数据文件，暂不允许merge")
+	ErrConditionFailed   = Err("写批次的提交条件不满足")
+
+	ErrNotLeader    = Err("当前节点不是集群的leader")
+	ErrNoLeader     = Err("集群当前没有leader")
+	ErrInvalidFSMOp = Err("无效的状态机日志")
 )
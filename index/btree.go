@@ -40,6 +40,8 @@ func (B *BTree) Get(key []byte) *model.LogRecordPos {
 	item := Item{
 		key: key,
 	}
+	B.lock.RLock()
+	defer B.lock.RUnlock()
 	btreeItem := B.tree.Get(item)
 	if btreeItem == nil {
 		return nil
@@ -63,6 +65,8 @@ func (B *BTree) Delete(key []byte) *model.LogRecordPos {
 }
 
 func (B *BTree) Size() int {
+	B.lock.RLock()
+	defer B.lock.RUnlock()
 	return B.tree.Len()
 }
 
@@ -149,7 +153,7 @@ func (B *BTreeIterator) Seek(key []byte) {
 		})
 	} else {
 		start = sort.Search(len(B.Values), func(i int) bool {
-			return bytes.Compare(B.Values[i].key, key) > 0
+			return bytes.Compare(B.Values[i].key, key) >= 0
 		})
 	}
 	B.CurrIndex = start
@@ -174,39 +178,3 @@ func (B *BTreeIterator) Value() *model.LogRecordPos {
 func (B *BTreeIterator) Close() {
 	B.Values = nil
 }
-
-// ==================Zset Iterator =================
-
-//func NewZSetIterator(tree *btree.BTree, suffix []byte, zsetKey []byte) *BTreeIterator {
-//	idx := 0
-//	values := make([]Item, tree.Len())
-//
-//	saveValueFn := func(item btree.Item) bool {
-//		enckey := item.(Item).key
-//		// 对encKey进行编码，取出对应字段的值
-//		zsetInternalKey := redis.DecodeZsetWithScore(enckey, zsetKey)
-//
-//		// 判断是否为key+version的前缀
-//		if item.(Item).pos==nil && zsetInternalKey
-//		if string(key[:len(zsetKey)+8]) == string(suffix) {
-//			// 查看是否为存有score的数据部分,如果有则该key为需要的部分
-//			if item.(Item).pos == nil {
-//				// 取出enckey中的score和key
-//				binary.LittleEndian.Uint32(buf[:4])
-//			}
-//		}
-//
-//		values[idx] = item.(Item)
-//		idx += 1
-//		return true
-//	}
-//
-//	//在迭代时将数据存入values中,倒叙插入方便实现ZPopmax
-//	tree.Descend(saveValueFn)
-//
-//	return &BTreeIterator{
-//		CurrIndex: 0,
-//		Reverse:   true,
-//		Values:    values,
-//	}
-//}
@@ -139,7 +139,7 @@ func (r *RadixIterator) Seek(key []byte) {
 		})
 	} else {
 		start = sort.Search(len(r.values), func(i int) bool {
-			return bytes.Compare(r.values[i].key, key) > 0
+			return bytes.Compare(r.values[i].key, key) >= 0
 		})
 	}
 	r.currIndex = start
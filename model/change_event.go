@@ -0,0 +1,24 @@
+package model
+
+import "kv-db-lab/constant"
+
+// ChangeRecord 一条已提交的数据变更
+type ChangeRecord struct {
+	Key    []byte
+	Value  []byte                   // 删除时为nil，合并操作数时为操作数
+	Status constant.LogRecordStatus // 变更类型：写入、删除或合并操作数
+	Pos    *LogRecordPos            // 该记录在数据文件中的位置
+}
+
+// ChangeEvent
+//
+//	@Description: 变更事件，单条写入为一个事件，WriteBatch提交的所有记录合并为同一个事件
+type ChangeEvent struct {
+	// 批写入的事务ID，非事务写入为0
+	TransID uint64
+
+	Records []*ChangeRecord
+
+	// 事件之后下一条记录的位置，可传给ChangesSince断点续读
+	Next *LogRecordPos
+}
@@ -124,9 +124,9 @@ func EncodeLogRecordPos(logRecordPos *LogRecordPos) []byte {
 	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*2)
 
 	var index int
-	index += binary.PutVarint(buf, int64(logRecordPos.FileID))
-	index += binary.PutVarint(buf, logRecordPos.Offset)
-	index += binary.PutVarint(buf, logRecordPos.Size)
+	index += binary.PutVarint(buf[index:], int64(logRecordPos.FileID))
+	index += binary.PutVarint(buf[index:], logRecordPos.Offset)
+	index += binary.PutVarint(buf[index:], logRecordPos.Size)
 	return buf[:index]
 }
 
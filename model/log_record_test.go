@@ -94,3 +94,12 @@ func TestGetLogRecordCRC(t *testing.T) {
 	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:])
 	assert.Equal(t, uint32(290887979), crc3)
 }
+
+func TestEncodeLogRecordPos(t *testing.T) {
+	// 每个字段依次写在前一个字段之后，解码后与原位置一致
+	pos := &LogRecordPos{FileID: 7, Offset: 123456, Size: 789}
+	decoded := DecodeLogRecordPos(EncodeLogRecordPos(pos))
+	assert.Equal(t, pos.FileID, decoded.FileID)
+	assert.Equal(t, pos.Offset, decoded.Offset)
+	assert.Equal(t, pos.Size, decoded.Size)
+}
@@ -0,0 +1,108 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// MergeOperator
+//
+//	@Description: 合并操作符，Apply写入的是操作数而非完整的value，读取和merge时再将基础值与操作数合并
+type MergeOperator interface {
+	// Name 操作符名称
+	Name() string
+
+	// FullMerge 将基础值(不存在时为nil)与按写入顺序排列的操作数合并为最终值
+	FullMerge(key []byte, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+// Int64AddOperator 整数累加，value与操作数均为十进制字符串
+type Int64AddOperator struct{}
+
+func (Int64AddOperator) Name() string {
+	return "int64-add"
+}
+
+func (Int64AddOperator) FullMerge(key []byte, existing []byte, operands [][]byte) ([]byte, error) {
+	var sum int64
+	if len(existing) != 0 {
+		n, err := strconv.ParseInt(string(existing), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		sum = n
+	}
+
+	for _, operand := range operands {
+		n, err := strconv.ParseInt(string(operand), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		sum += n
+	}
+	return []byte(strconv.FormatInt(sum, 10)), nil
+}
+
+// ListAppendOperator 列表追加，以Separator分隔各个元素
+type ListAppendOperator struct {
+	Separator []byte
+}
+
+func (ListAppendOperator) Name() string {
+	return "list-append"
+}
+
+func (op ListAppendOperator) FullMerge(key []byte, existing []byte, operands [][]byte) ([]byte, error) {
+	elements := make([][]byte, 0, len(operands)+1)
+	if len(existing) != 0 {
+		elements = append(elements, existing)
+	}
+	elements = append(elements, operands...)
+	return bytes.Join(elements, op.Separator), nil
+}
+
+// JSONMergePatchOperator 按RFC 7386 JSON Merge Patch依次将操作数合并到基础JSON对象上
+type JSONMergePatchOperator struct{}
+
+func (JSONMergePatchOperator) Name() string {
+	return "json-merge-patch"
+}
+
+func (JSONMergePatchOperator) FullMerge(key []byte, existing []byte, operands [][]byte) ([]byte, error) {
+	var doc interface{}
+	if len(existing) != 0 {
+		if err := json.Unmarshal(existing, &doc); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, operand := range operands {
+		var patch interface{}
+		if err := json.Unmarshal(operand, &patch); err != nil {
+			return nil, err
+		}
+		doc = mergePatch(doc, patch)
+	}
+	return json.Marshal(doc)
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+			continue
+		}
+		targetObj[k] = mergePatch(targetObj[k], v)
+	}
+	return targetObj
+}
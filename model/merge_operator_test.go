@@ -0,0 +1,24 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMergeOperator_FullMerge(t *testing.T) {
+	val, err := Int64AddOperator{}.FullMerge(nil, []byte("5"), [][]byte{[]byte("3"), []byte("-10")})
+	assert.Nil(t, err)
+	assert.Equal(t, "-2", string(val))
+
+	_, err = Int64AddOperator{}.FullMerge(nil, nil, [][]byte{[]byte("x")})
+	assert.NotNil(t, err)
+
+	val, err = ListAppendOperator{Separator: []byte(",")}.FullMerge(nil, nil, [][]byte{[]byte("a"), []byte("b")})
+	assert.Nil(t, err)
+	assert.Equal(t, "a,b", string(val))
+
+	val, err = JSONMergePatchOperator{}.FullMerge(nil, []byte(`{"a":1,"b":{"c":2,"d":3}}`),
+		[][]byte{[]byte(`{"b":{"c":null,"e":4}}`), []byte(`{"a":"x"}`)})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"a":"x","b":{"d":3,"e":4}}`, string(val))
+}
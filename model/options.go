@@ -1,13 +1,17 @@
 package model
 
-import "kv-db-lab/constant"
+import (
+	"kv-db-lab/constant"
+	"time"
+)
 
 type Options struct {
-	DirPath            string    // 数据库文件目录
-	DataFileSize       int64     // 数据存放数据阈值
-	SyncWrites         bool      // 写入数据是否需要持久化
-	Index              IndexType // 文件IO类型，主要区分启动load时的索引类型
-	DateFileMergeRatio float32   //标识无效数据的阈值，超过阈值才允许进行merge，否则不允许，merge过于频繁影响性能
+	DirPath            string        // 数据库文件目录
+	DataFileSize       int64         // 数据存放数据阈值
+	SyncWrites         bool          // 写入数据是否需要持久化
+	Index              IndexType     // 文件IO类型，主要区分启动load时的索引类型
+	DateFileMergeRatio float32       //标识无效数据的阈值，超过阈值才允许进行merge，否则不允许，merge过于频繁影响性能
+	MergeOperator      MergeOperator // 合并操作符，为空时不允许使用Apply
 }
 
 type IndexType = uint8
@@ -46,6 +50,47 @@ type WriteBatchOptions struct {
 	MaxBatchSize uint
 }
 
+// ReplicationOptions
+//
+//	@Description: 主从复制配置项
+type ReplicationOptions struct {
+	// 从节点落后主节点超过该数量的数据文件时，改为发送全量快照
+	MaxLagFiles uint
+
+	// 主节点在没有新写入时检查数据文件的间隔
+	PollInterval time.Duration
+
+	// 从节点断线后的重连间隔
+	RetryInterval time.Duration
+}
+
+// ClusterOptions
+//
+//	@Description: Raft集群节点配置项
+type ClusterOptions struct {
+	// 节点ID，集群内唯一
+	NodeID string
+
+	// Raft通信监听地址，端口为0时随机分配
+	RaftAddr string
+
+	// Raft日志、快照等元数据的存放目录，需与数据目录分开
+	RaftDir string
+
+	// 是否以单节点身份初始化集群，集群中仅第一个节点需要
+	Bootstrap bool
+
+	// 节点对外服务地址等附加信息，如redis、http，供从节点转发请求
+	Meta map[string]string
+
+	// 写操作等待日志提交并应用的超时时间
+	ApplyTimeout time.Duration
+
+	// 心跳超时与选举超时，测试中可适当调小
+	HeartbeatTimeout time.Duration
+	ElectionTimeout  time.Duration
+}
+
 var DefaultOptions = &Options{
 	DirPath:            "./../test_file",
 	DataFileSize:       1024 * 1024,
@@ -63,3 +108,17 @@ var DefaultWriteBatchOptions = &WriteBatchOptions{
 	SyncWrite:    true,
 	MaxBatchSize: 1000,
 }
+
+var DefaultReplicationOptions = &ReplicationOptions{
+	MaxLagFiles:   8,
+	PollInterval:  100 * time.Millisecond,
+	RetryInterval: time.Second,
+}
+
+var DefaultClusterOptions = &ClusterOptions{
+	RaftAddr:         "127.0.0.1:7000",
+	RaftDir:          "./../raft_file",
+	ApplyTimeout:     5 * time.Second,
+	HeartbeatTimeout: time.Second,
+	ElectionTimeout:  time.Second,
+}
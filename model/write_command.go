@@ -0,0 +1,141 @@
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"kv-db-lab/constant"
+)
+
+type WriteCommandType = byte
+
+const (
+	WritePut WriteCommandType = iota + 1
+	WriteDelete
+	WriteApply
+	WriteBatch
+	WriteCompareAndSwap
+	WriteDeleteIfEquals
+)
+
+// WriteCommand
+//
+//	@Description: 一次写操作的描述，配置了Replicator时引擎将写操作交给复制日志，由各节点按相同顺序应用
+type WriteCommand struct {
+	Type WriteCommandType
+
+	// 写入的数据，WriteBatch时为整个批次
+	Records []*LogRecord
+
+	// 条件写的期望值，ExpectAbsent为true时期望key不存在
+	Expected     []byte
+	ExpectAbsent bool
+
+	// WriteBatch的提交条件，Status为LogRecordDelete时期望key不存在，否则期望key的值为Value
+	Conditions []*LogRecord
+}
+
+// Replicator 复制写操作，返回条件写是否成功
+type Replicator interface {
+	Replicate(cmd *WriteCommand) (bool, error)
+}
+
+var ErrInvalidWriteCommand = errors.New("invalid write command")
+
+// EncodeWriteCommand
+/*
+type | expectAbsent | expectedSize | expected | recordNum | (status | keySize | key | valueSize | value)... | conditionNum | (status | keySize | key | valueSize | value)...
+ 1        1             var           var        var          1        var      var     var       var        var(可选)
+*/
+func EncodeWriteCommand(cmd *WriteCommand) []byte {
+	buf := []byte{cmd.Type, 0}
+	if cmd.ExpectAbsent {
+		buf[1] = 1
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(cmd.Expected)))
+	buf = append(buf, cmd.Expected...)
+
+	buf = appendRecords(buf, cmd.Records)
+	// 没有提交条件时省略，与之前的编码保持一致
+	if len(cmd.Conditions) > 0 {
+		buf = appendRecords(buf, cmd.Conditions)
+	}
+	return buf
+}
+
+func appendRecords(buf []byte, records []*LogRecord) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(records)))
+	for _, record := range records {
+		buf = append(buf, byte(record.Status))
+		buf = binary.AppendUvarint(buf, uint64(len(record.Key)))
+		buf = append(buf, record.Key...)
+		buf = binary.AppendUvarint(buf, uint64(len(record.Value)))
+		buf = append(buf, record.Value...)
+	}
+	return buf
+}
+
+// DecodeWriteCommand 对写操作进行解码
+func DecodeWriteCommand(buf []byte) (*WriteCommand, error) {
+	if len(buf) < 2 {
+		return nil, ErrInvalidWriteCommand
+	}
+	cmd := &WriteCommand{
+		Type:         buf[0],
+		ExpectAbsent: buf[1] == 1,
+	}
+	index := 2
+
+	readBytes := func() ([]byte, bool) {
+		size, n := binary.Uvarint(buf[index:])
+		if n <= 0 || uint64(len(buf)-index-n) < size {
+			return nil, false
+		}
+		index += n
+		b := buf[index : index+int(size)]
+		index += int(size)
+		return b, true
+	}
+
+	expected, ok := readBytes()
+	if !ok {
+		return nil, ErrInvalidWriteCommand
+	}
+	if !cmd.ExpectAbsent {
+		cmd.Expected = expected
+	}
+
+	readRecords := func() ([]*LogRecord, bool) {
+		num, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return nil, false
+		}
+		index += n
+
+		var records []*LogRecord
+		for i := uint64(0); i < num; i++ {
+			if index >= len(buf) {
+				return nil, false
+			}
+			record := &LogRecord{Status: constant.LogRecordStatus(buf[index])}
+			index++
+			if record.Key, ok = readBytes(); !ok {
+				return nil, false
+			}
+			if record.Value, ok = readBytes(); !ok {
+				return nil, false
+			}
+			records = append(records, record)
+		}
+		return records, true
+	}
+
+	if cmd.Records, ok = readRecords(); !ok {
+		return nil, ErrInvalidWriteCommand
+	}
+	if index < len(buf) {
+		if cmd.Conditions, ok = readRecords(); !ok {
+			return nil, ErrInvalidWriteCommand
+		}
+	}
+	return cmd, nil
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"testing"
+)
+
+func TestWriteCommand_EncodeDecode(t *testing.T) {
+	cmd := &WriteCommand{
+		Type: WriteBatch,
+		Records: []*LogRecord{
+			{Key: []byte("k1"), Value: []byte("v1"), Status: constant.LogRecordNormal},
+			{Key: []byte("k2"), Status: constant.LogRecordDelete},
+		},
+	}
+	decoded, err := DecodeWriteCommand(EncodeWriteCommand(cmd))
+	assert.Nil(t, err)
+	assert.Equal(t, WriteBatch, decoded.Type)
+	assert.Equal(t, 2, len(decoded.Records))
+	assert.Equal(t, []byte("k1"), decoded.Records[0].Key)
+	assert.Equal(t, []byte("v1"), decoded.Records[0].Value)
+	assert.Equal(t, constant.LogRecordDelete, decoded.Records[1].Status)
+
+	cas := &WriteCommand{
+		Type:         WriteCompareAndSwap,
+		Records:      []*LogRecord{{Key: []byte("k"), Value: []byte("new")}},
+		ExpectAbsent: true,
+	}
+	decoded, err = DecodeWriteCommand(EncodeWriteCommand(cas))
+	assert.Nil(t, err)
+	assert.True(t, decoded.ExpectAbsent)
+	assert.Nil(t, decoded.Expected)
+
+	cas.ExpectAbsent = false
+	cas.Expected = []byte("old")
+	decoded, err = DecodeWriteCommand(EncodeWriteCommand(cas))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("old"), decoded.Expected)
+
+	cmd.Conditions = []*LogRecord{
+		{Key: []byte("k1"), Status: constant.LogRecordDelete},
+		{Key: []byte("k3"), Value: []byte("v3"), Status: constant.LogRecordNormal},
+	}
+	decoded, err = DecodeWriteCommand(EncodeWriteCommand(cmd))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(decoded.Records))
+	assert.Equal(t, 2, len(decoded.Conditions))
+	assert.Equal(t, constant.LogRecordDelete, decoded.Conditions[0].Status)
+	assert.Equal(t, []byte("k3"), decoded.Conditions[1].Key)
+	assert.Equal(t, []byte("v3"), decoded.Conditions[1].Value)
+
+	_, err = DecodeWriteCommand(EncodeWriteCommand(cmd)[:10])
+	assert.Equal(t, ErrInvalidWriteCommand, err)
+}
@@ -1,6 +1,10 @@
 package pkg
 
-import "strconv"
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
 
 func FloatFromBytes(val []byte) float64 {
 	f, _ := strconv.ParseFloat(string(val), 64)
@@ -10,3 +14,32 @@ func FloatFromBytes(val []byte) float64 {
 func Float64ToBytes(val float64) []byte {
 	return []byte(strconv.FormatFloat(val, 'f', -1, 64))
 }
+
+// Float64ToOrderedBytes 保序的二进制编码，编码后的字节序与数值大小顺序一致，可用于按范围遍历
+func Float64ToOrderedBytes(val float64) []byte {
+	// -0与0视为相同的score
+	if val == 0 {
+		val = 0
+	}
+	bits := math.Float64bits(val)
+	// 非负数翻转符号位，负数翻转所有位
+	if bits&(1<<63) == 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+// FloatFromOrderedBytes 对Float64ToOrderedBytes的编码进行解码
+func FloatFromOrderedBytes(val []byte) float64 {
+	bits := binary.BigEndian.Uint64(val)
+	if bits&(1<<63) != 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
@@ -0,0 +1,19 @@
+package pkg
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestFloat64ToOrderedBytes(t *testing.T) {
+	values := []float64{math.Inf(-1), -1e10, -2.5, -1, -math.SmallestNonzeroFloat64, 0, math.SmallestNonzeroFloat64, 1, 2.5, 1e10, math.Inf(1)}
+	for i, v := range values {
+		assert.Equal(t, v, FloatFromOrderedBytes(Float64ToOrderedBytes(v)))
+		if i > 0 {
+			assert.Equal(t, -1, bytes.Compare(Float64ToOrderedBytes(values[i-1]), Float64ToOrderedBytes(v)))
+		}
+	}
+	assert.Equal(t, Float64ToOrderedBytes(0), Float64ToOrderedBytes(math.Copysign(0, -1)))
+}
@@ -0,0 +1,206 @@
+package pkg
+
+import "math"
+
+// 与redis一致的geohash实现，经纬度各以step个bit编码，交错排列后经度在高位
+// 最大精度step为26，编码结果为52bit，可以无损地存储为float64
+
+const (
+	GeoStepMax = 26
+
+	GeoLatMin = -85.05112878
+	GeoLatMax = 85.05112878
+	GeoLonMin = -180.0
+	GeoLonMax = 180.0
+
+	// earthRadius 与redis一致的地球半径，单位米
+	earthRadius = 6372797.560856
+
+	// mercatorMax 墨卡托投影的最大范围，单位米
+	mercatorMax = 20037726.37
+)
+
+// GeoArea geohash对应的经纬度范围
+type GeoArea struct {
+	Hash   uint64
+	Step   uint
+	LonMin float64
+	LonMax float64
+	LatMin float64
+	LatMax float64
+}
+
+// interleave64 将x的各bit放在偶数位，y的各bit放在奇数位
+func interleave64(x, y uint32) uint64 {
+	return spread(x) | spread(y)<<1
+}
+
+func deinterleave64(v uint64) (uint32, uint32) {
+	return squash(v), squash(v >> 1)
+}
+
+func spread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000FFFF0000FFFF
+	x = (x | x<<8) & 0x00FF00FF00FF00FF
+	x = (x | x<<4) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+func squash(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x>>4) & 0x00FF00FF00FF00FF
+	x = (x | x>>8) & 0x0000FFFF0000FFFF
+	x = (x | x>>16) & 0x00000000FFFFFFFF
+	return uint32(x)
+}
+
+// ValidGeoCoordinate 经纬度是否在可编码的范围内
+func ValidGeoCoordinate(lon, lat float64) bool {
+	return lon >= GeoLonMin && lon <= GeoLonMax && lat >= GeoLatMin && lat <= GeoLatMax
+}
+
+// GeoHashEncode
+//
+//	@Description: 在给定的纬度范围内编码经纬度
+//	@param lon
+//	@param lat
+//	@param latMin  redis存储时使用墨卡托投影的纬度范围，GEOHASH命令使用[-90, 90]
+//	@param latMax
+//	@param step
+//	@return uint64
+func GeoHashEncode(lon, lat, latMin, latMax float64, step uint) uint64 {
+	scale := float64(uint64(1) << step)
+	latOffset := (lat - latMin) / (latMax - latMin) * scale
+	lonOffset := (lon - GeoLonMin) / (GeoLonMax - GeoLonMin) * scale
+	// 正好在上边界时落在最后一个格子中
+	maxOffset := scale - 1
+	return interleave64(uint32(math.Min(latOffset, maxOffset)), uint32(math.Min(lonOffset, maxOffset)))
+}
+
+// GeoHashDecode 返回墨卡托纬度范围内的geohash对应的经纬度范围
+func GeoHashDecode(hash uint64, step uint) *GeoArea {
+	ilat, ilon := deinterleave64(hash)
+	scale := float64(uint64(1) << step)
+	latUnit := (GeoLatMax - GeoLatMin) / scale
+	lonUnit := (GeoLonMax - GeoLonMin) / scale
+	return &GeoArea{
+		Hash:   hash,
+		Step:   step,
+		LatMin: GeoLatMin + float64(ilat)*latUnit,
+		LatMax: GeoLatMin + float64(ilat+1)*latUnit,
+		LonMin: GeoLonMin + float64(ilon)*lonUnit,
+		LonMax: GeoLonMin + float64(ilon+1)*lonUnit,
+	}
+}
+
+// Center 范围的中心点，结果限制在合法的经纬度范围内
+func (a *GeoArea) Center() (float64, float64) {
+	lon := math.Max(GeoLonMin, math.Min(GeoLonMax, (a.LonMin+a.LonMax)/2))
+	lat := math.Max(GeoLatMin, math.Min(GeoLatMax, (a.LatMin+a.LatMax)/2))
+	return lon, lat
+}
+
+// GeoHashNeighbors 返回以hash为中心的3x3个格子，经纬度越界时回绕，结果已去重
+func GeoHashNeighbors(hash uint64, step uint) []uint64 {
+	ilat, ilon := deinterleave64(hash)
+	mask := uint32(uint64(1)<<step - 1)
+
+	seen := make(map[uint64]struct{}, 9)
+	result := make([]uint64, 0, 9)
+	for _, dlat := range []uint32{0, 1, mask} {
+		for _, dlon := range []uint32{0, 1, mask} {
+			// 加上mask即减1，与mask按位与后回绕
+			h := interleave64((ilat+dlat)&mask, (ilon+dlon)&mask)
+			if _, ok := seen[h]; !ok {
+				seen[h] = struct{}{}
+				result = append(result, h)
+			}
+		}
+	}
+	return result
+}
+
+// GeoEstimateStep 根据查询半径估算geohash的精度，使3x3个格子能覆盖查询范围
+func GeoEstimateStep(radius, lat float64) uint {
+	if radius == 0 {
+		return GeoStepMax
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	step -= 2
+
+	// 高纬度地区格子的实际宽度更小
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > GeoStepMax {
+		step = GeoStepMax
+	}
+	return uint(step)
+}
+
+// GeoBoundingBox 返回以(lon, lat)为中心、宽高为width与height(米)的矩形的经纬度范围
+func GeoBoundingBox(lon, lat, width, height float64) (lonMin, lonMax, latMin, latMax float64) {
+	latDelta := rad2deg(height / 2 / earthRadius)
+	// 靠近两极的一侧经度跨度更大
+	lonDeltaTop := rad2deg(width / 2 / earthRadius / math.Cos(deg2rad(lat+latDelta)))
+	lonDeltaBottom := rad2deg(width / 2 / earthRadius / math.Cos(deg2rad(lat-latDelta)))
+	lonDelta := math.Max(lonDeltaTop, lonDeltaBottom)
+	return lon - lonDelta, lon + lonDelta, lat - latDelta, lat + latDelta
+}
+
+// GeoDistance 以haversine公式计算两点之间的距离，单位米
+func GeoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lat2r := deg2rad(lat1), deg2rad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin(deg2rad(lon2-lon1) / 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// GeoInBox 点是否在以(lon, lat)为中心、宽高为width与height(米)的矩形内
+func GeoInBox(lon, lat, width, height, pointLon, pointLat float64) bool {
+	// 与redis一致，先判断纬度方向的距离，再在点所在的纬度上判断经度方向的距离
+	if GeoDistance(lon, lat, lon, pointLat) > height/2 {
+		return false
+	}
+	return GeoDistance(lon, pointLat, pointLon, pointLat) <= width/2
+}
+
+// geoBase32 GEOHASH命令使用的base32字母表
+const geoBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// GeoHashString 将经纬度编码为标准的11位geohash字符串，与redis一致最后一位固定为0
+func GeoHashString(lon, lat float64) string {
+	hash := GeoHashEncode(lon, lat, -90, 90, GeoStepMax)
+	buf := make([]byte, 11)
+	for i := 0; i < 11; i++ {
+		var idx uint64
+		if i < 10 {
+			idx = (hash >> (52 - uint(i+1)*5)) & 0x1f
+		}
+		buf[i] = geoBase32[idx]
+	}
+	return string(buf)
+}
+
+func deg2rad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func rad2deg(r float64) float64 {
+	return r * 180 / math.Pi
+}
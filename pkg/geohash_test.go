@@ -0,0 +1,33 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGeoHashEncode_Decode(t *testing.T) {
+	hash := GeoHashEncode(13.361389, 38.115556, GeoLatMin, GeoLatMax, GeoStepMax)
+	// redis中Palermo的score
+	assert.Equal(t, uint64(3479099956230698), hash)
+
+	lon, lat := GeoHashDecode(hash, GeoStepMax).Center()
+	assert.InDelta(t, 13.361389, lon, 1e-5)
+	assert.InDelta(t, 38.115556, lat, 1e-5)
+}
+
+func TestGeoHashNeighbors(t *testing.T) {
+	hash := GeoHashEncode(13.361389, 38.115556, GeoLatMin, GeoLatMax, 10)
+	neighbors := GeoHashNeighbors(hash, 10)
+	assert.Equal(t, 9, len(neighbors))
+	assert.Equal(t, hash, neighbors[0])
+
+	center := GeoHashDecode(hash, 10)
+	for _, h := range neighbors[1:] {
+		area := GeoHashDecode(h, 10)
+		assert.InDelta(t, (center.LonMin+center.LonMax)/2, (area.LonMin+area.LonMax)/2, center.LonMax-center.LonMin+1e-9)
+		assert.InDelta(t, (center.LatMin+center.LatMax)/2, (area.LatMin+area.LatMax)/2, center.LatMax-center.LatMin+1e-9)
+	}
+
+	// 精度为1时只有4个格子
+	assert.Equal(t, 4, len(GeoHashNeighbors(0, 1)))
+}
@@ -0,0 +1,34 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		str     string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"user:*:name", "user:1/2:name", true},
+		{"a\\*b", "a*b", true},
+		{"a\\*b", "axb", false},
+		{"**a", "bba", true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, GlobMatch([]byte(c.pattern), []byte(c.str)), c.pattern+" "+c.str)
+	}
+}
@@ -22,3 +22,15 @@ func LogRecordKeySeq(key []byte, transID uint64) []byte {
 
 	return encKey
 }
+
+// PrefixEnd 返回大于所有以prefix为前缀的key的最小key，prefix全为0xff时返回nil
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
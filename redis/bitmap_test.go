@@ -0,0 +1,147 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"testing"
+)
+
+func TestRedisDataStructure_Bitmap(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("dau")
+
+	// 跨越多个分块的稀疏offset
+	offsets := []uint64{3, bitmapChunkSize*8 + 1, bitmapChunkSize*8*5 + 7}
+	for _, offset := range offsets {
+		old, err := rds.SetBit(key, offset, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, byte(0), old)
+	}
+	old, err := rds.SetBit(key, 3, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(1), old)
+
+	typ, err := rds.Type(key)
+	assert.Nil(t, err)
+	assert.Equal(t, Bitmap, typ)
+	// 元数据与3个分块
+	assert.Equal(t, 4, len(rds.engine.GetAllKeys()))
+
+	for _, offset := range offsets {
+		bit, err := rds.GetBit(key, offset)
+		assert.Nil(t, err)
+		assert.Equal(t, byte(1), bit)
+	}
+	bit, err := rds.GetBit(key, bitmapChunkSize*8*3)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0), bit)
+
+	count, err := rds.BitCount(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+	count, err = rds.BitCount(key, 1, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	count, err = rds.BitCount(key, -1, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	pos, err := rds.BitPos(key, 1, 1, 0, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(bitmapChunkSize*8+1), pos)
+	pos, err = rds.BitPos(key, 0, 0, 0, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), pos)
+
+	old, err = rds.SetBit(key, 3, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(1), old)
+	count, _ = rds.BitCount(key, 0, -1)
+	assert.Equal(t, 2, count)
+
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	_, err = rds.SetBit([]byte("hash"), 0, 1)
+	assert.Equal(t, constant.ErrWrongTypeOp, err)
+	_, err = rds.BitCount([]byte("hash"), 0, -1)
+	assert.Equal(t, constant.ErrWrongTypeOp, err)
+}
+
+func TestRedisDataStructure_BitPos(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("str")
+	assert.Nil(t, rds.Set(key, []byte{0xff, 0xf0, 0x00}, 0))
+
+	pos, err := rds.BitPos(key, 0, 0, 0, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), pos)
+	pos, err = rds.BitPos(key, 1, 2, 0, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), pos)
+
+	assert.Nil(t, rds.Set(key, []byte{0xff, 0xff}, 0))
+	pos, err = rds.BitPos(key, 0, 0, 0, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(16), pos)
+	pos, err = rds.BitPos(key, 0, 0, -1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), pos)
+
+	pos, err = rds.BitPos([]byte("missing"), 1, 0, 0, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), pos)
+	pos, err = rds.BitPos([]byte("missing"), 0, 0, 0, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), pos)
+}
+
+func TestRedisDataStructure_BitOp(t *testing.T) {
+	rds := openTestRds(t)
+	assert.Nil(t, rds.Set([]byte("a"), []byte{0xf0, 0x0f}, 0))
+	_, _ = rds.SetBit([]byte("b"), 0, 1)
+	_, _ = rds.SetBit([]byte("b"), bitmapChunkSize*8*2, 1)
+
+	length, err := rds.BitOp(BitOpOr, []byte("dest"), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(bitmapChunkSize*2+1), length)
+	count, err := rds.BitCount([]byte("dest"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 9, count)
+	// 全为0的中间分块不写入
+	_, err = rds.db.Get(bitmapChunkKey([]byte("dest"), mustMeta(t, rds, []byte("dest")), 1))
+	assert.Equal(t, constant.ErrNotExist, err)
+
+	length, err = rds.BitOp(BitOpAnd, []byte("dest"), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(bitmapChunkSize*2+1), length)
+	count, _ = rds.BitCount([]byte("dest"), 0, -1)
+	assert.Equal(t, 1, count)
+
+	_, err = rds.BitOp(BitOpXor, []byte("dest"), []byte("a"), []byte("a"))
+	assert.Nil(t, err)
+	count, _ = rds.BitCount([]byte("dest"), 0, -1)
+	assert.Equal(t, 0, count)
+	n, _ := rds.Exists([]byte("dest"))
+	assert.Equal(t, 1, n)
+
+	_, err = rds.BitOp(BitOpNot, []byte("dest"), []byte("a"))
+	assert.Nil(t, err)
+	pos, err := rds.BitPos([]byte("dest"), 1, 0, 0, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), pos)
+
+	_, err = rds.BitOp(BitOpNot, []byte("dest"), []byte("a"), []byte("b"))
+	assert.Equal(t, constant.ErrEmptyParam, err)
+
+	// 源key都不存在时删除destination
+	length, err = rds.BitOp(BitOpOr, []byte("dest"), []byte("missing"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), length)
+	n, _ = rds.Exists([]byte("dest"))
+	assert.Equal(t, 0, n)
+}
+
+func mustMeta(t *testing.T, rds *RedisDataStructure, key []byte) *metaData {
+	encValue, err := rds.db.Get(key)
+	assert.Nil(t, err)
+	return decodeMetaData(encValue)
+}
@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/tidwall/redcon"
+	"kv-db-lab/pkg"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// =========================ACL=============================================================
+// 与redis 6的ACL一致，每个用户有启用状态、密码、允许执行的命令以及允许访问的key模式
+// 新连接以default用户的身份执行命令，default用户需要密码时先通过AUTH或HELLO AUTH认证
+// requirepass 即default用户的密码；配置了aclfile时由ACL LOAD与ACL SAVE读写其中的用户
+
+const defaultUserName = "default"
+
+var (
+	errNoAuth          = errors.New("NOAUTH Authentication required.")
+	errWrongPass       = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	errAuthNoPassword  = errors.New("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	errHelloNoAuth     = errors.New("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	errNoPermKey       = errors.New("NOPERM No permissions to access a key")
+	errNoACLFile       = errors.New("ERR This Redis instance is not configured to use an ACL file.")
+	errDeleteDefault   = errors.New("ERR The 'default' user cannot be removed")
+	errRequirePassFile = errors.New("requirepass can't be used together with aclfile, configure the default user in the ACL file")
+	// errUserRemoved 认证的用户已被删除，与redis一致断开连接
+	errUserRemoved = errors.New("ERR the user of the connection has been removed")
+)
+
+func newNoPermCommandError(user, cmd string) error {
+	return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", user, cmd)
+}
+
+func newACLRuleError(rule string, err error) error {
+	return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': %v", rule, err)
+}
+
+var (
+	errACLSyntax          = errors.New("Syntax error")
+	errACLUnknownCommand  = errors.New("Unknown command or category name in ACL")
+	errACLInvalidHash     = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errACLInvalidUsername = errors.New("Usernames can't contain spaces or null characters")
+)
+
+// aclCategoryNames 命令表中出现的所有ACL分类
+var aclCategoryNames = make(map[string]struct{})
+
+func init() {
+	for _, c := range commandTable {
+		for _, category := range c.aclCategories() {
+			aclCategoryNames[category] = struct{}{}
+		}
+	}
+}
+
+// aclUser
+//
+//	@Description: 一个ACL用户，修改时复制一份，修改完成后整体替换，读取时不需要复制
+type aclUser struct {
+	name      string
+	enabled   bool
+	nopass    bool
+	passwords []string // 密码的SHA256，十六进制小写
+	keys      []string // 允许访问的key模式
+
+	commands     map[string]struct{} // 允许执行的命令
+	commandRules []string            // 按顺序生效的命令规则，用于ACL LIST
+}
+
+// newACLUser 新用户与redis一致，未启用、没有密码且不能执行任何命令
+func newACLUser(name string) *aclUser {
+	return &aclUser{
+		name:         name,
+		commands:     make(map[string]struct{}),
+		commandRules: []string{"-@all"},
+	}
+}
+
+// newDefaultUser default用户不需要密码，可以执行所有命令并访问所有key
+func newDefaultUser() *aclUser {
+	u := newACLUser(defaultUserName)
+	for _, rule := range []string{"on", "nopass", "allkeys", "allcommands"} {
+		_ = u.apply(rule)
+	}
+	return u
+}
+
+func (u *aclUser) clone() *aclUser {
+	c := *u
+	c.passwords = append([]string{}, u.passwords...)
+	c.keys = append([]string{}, u.keys...)
+	c.commandRules = append([]string{}, u.commandRules...)
+	c.commands = make(map[string]struct{}, len(u.commands))
+	for name := range u.commands {
+		c.commands[name] = struct{}{}
+	}
+	return &c
+}
+
+func hashPassword(password []byte) string {
+	sum := sha256.Sum256(password)
+	return hex.EncodeToString(sum[:])
+}
+
+// isPasswordHash 是否为64位十六进制小写的SHA256
+func isPasswordHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPassword 用户是否启用且密码正确
+func (u *aclUser) checkPassword(password []byte) bool {
+	if !u.enabled {
+		return false
+	}
+	if u.nopass {
+		return true
+	}
+	hash := hashPassword(password)
+	for _, p := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(p), []byte(hash)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *aclUser) canRun(redisCmd *redisCommand) bool {
+	_, ok := u.commands[redisCmd.name]
+	return ok
+}
+
+func (u *aclUser) canAccess(key []byte) bool {
+	for _, pattern := range u.keys {
+		if pkg.GlobMatch([]byte(pattern), key) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply
+//
+//	@Description: 应用一条ACL规则，与redis ACL SETUSER的规则一致
+//	on off | >password <password #hash !hash nopass resetpass | ~pattern allkeys resetkeys |
+//	+command -command +@category -@category allcommands nocommands | reset
+func (u *aclUser) apply(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass = true
+		u.passwords = nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = nil
+	case "allkeys":
+		u.keys = []string{"*"}
+	case "resetkeys":
+		u.keys = nil
+	case "allcommands":
+		return u.apply("+@all")
+	case "nocommands":
+		return u.apply("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "off", "-@all"} {
+			_ = u.apply(r)
+		}
+	default:
+		if rule == "" {
+			return errACLSyntax
+		}
+		switch rule[0] {
+		case '>':
+			u.addPassword(hashPassword([]byte(rule[1:])))
+		case '#':
+			if !isPasswordHash(rule[1:]) {
+				return errACLInvalidHash
+			}
+			u.addPassword(rule[1:])
+		case '<':
+			u.removePassword(hashPassword([]byte(rule[1:])))
+		case '!':
+			if !isPasswordHash(rule[1:]) {
+				return errACLInvalidHash
+			}
+			u.removePassword(rule[1:])
+		case '~':
+			u.addKeyPattern(rule[1:])
+		case '+', '-':
+			return u.applyCommandRule(rule)
+		default:
+			return errACLSyntax
+		}
+	}
+	return nil
+}
+
+func (u *aclUser) addPassword(hash string) {
+	u.nopass = false
+	for _, p := range u.passwords {
+		if p == hash {
+			return
+		}
+	}
+	u.passwords = append(u.passwords, hash)
+}
+
+func (u *aclUser) removePassword(hash string) {
+	u.nopass = false
+	for i, p := range u.passwords {
+		if p == hash {
+			u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+			return
+		}
+	}
+}
+
+// addKeyPattern 已允许所有key时不再添加
+func (u *aclUser) addKeyPattern(pattern string) {
+	for _, p := range u.keys {
+		if p == "*" || p == pattern {
+			return
+		}
+	}
+	if pattern == "*" {
+		u.keys = nil
+	}
+	u.keys = append(u.keys, pattern)
+}
+
+// applyCommandRule +command -command +@category -@category，@all会覆盖之前的所有命令规则
+func (u *aclUser) applyCommandRule(rule string) error {
+	allow := rule[0] == '+'
+	name := strings.ToLower(rule[1:])
+
+	// 遍历supportedCommands而不是commandTable，避免命令表与ACL命令之间的初始化循环
+	var matched []*redisCommand
+	switch {
+	case name == "@all":
+		for _, c := range supportedCommands {
+			matched = append(matched, c)
+		}
+		u.commandRules = nil
+	case strings.HasPrefix(name, "@"):
+		if _, ok := aclCategoryNames[name]; !ok {
+			return errACLUnknownCommand
+		}
+		for _, c := range supportedCommands {
+			for _, category := range c.aclCategories() {
+				if category == name {
+					matched = append(matched, c)
+					break
+				}
+			}
+		}
+	default:
+		c, ok := supportedCommands[name]
+		if !ok {
+			return errACLUnknownCommand
+		}
+		matched = []*redisCommand{c}
+	}
+
+	for _, c := range matched {
+		if allow {
+			u.commands[c.name] = struct{}{}
+		} else {
+			delete(u.commands, c.name)
+		}
+	}
+	u.commandRules = append(u.commandRules, rule[:1]+name)
+	return nil
+}
+
+// describe 与redis ACL LIST一致的用户描述，同时作为ACL文件中的一行
+func (u *aclUser) describe() string {
+	var sb strings.Builder
+	sb.WriteString("user ")
+	sb.WriteString(u.name)
+	if u.enabled {
+		sb.WriteString(" on")
+	} else {
+		sb.WriteString(" off")
+	}
+	if u.nopass {
+		sb.WriteString(" nopass")
+	}
+	for _, p := range u.passwords {
+		sb.WriteString(" #")
+		sb.WriteString(p)
+	}
+	for _, pattern := range u.keys {
+		sb.WriteString(" ~")
+		sb.WriteString(pattern)
+	}
+	for _, rule := range u.commandRules {
+		sb.WriteString(" ")
+		sb.WriteString(rule)
+	}
+	return sb.String()
+}
+
+// aclUsers 所有的ACL用户，用户由名称引用，修改或重新加载后已认证的连接立即按新的权限执行命令
+type aclUsers struct {
+	mu    sync.RWMutex
+	users map[string]*aclUser
+	file  string // ACL文件的路径，为空时不能LOAD与SAVE
+}
+
+// newACLUsers 配置了ACL文件时从文件中加载用户，否则只有default用户
+func newACLUsers(file string) (*aclUsers, error) {
+	a := &aclUsers{
+		users: map[string]*aclUser{defaultUserName: newDefaultUser()},
+		file:  file,
+	}
+	if file != "" {
+		if err := a.load(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// authRequired 未认证的连接是否需要先认证，default用户需要密码或未启用时需要
+// 调用方需持有读锁
+func (a *aclUsers) authRequired() bool {
+	u := a.users[defaultUserName]
+	return !u.nopass || !u.enabled
+}
+
+// autoLogin 与redis一致，default用户不需要密码且已启用时新连接直接通过认证
+func (a *aclUsers) autoLogin() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return !a.authRequired()
+}
+
+// authenticate 用户存在、已启用且密码正确时返回true
+func (a *aclUsers) authenticate(name string, password []byte) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u, ok := a.users[name]
+	return ok && u.checkPassword(password)
+}
+
+// defaultNoPass default用户是否不需要密码
+func (a *aclUsers) defaultNoPass() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.users[defaultUserName].nopass
+}
+
+// setRequirePass 与redis一致，将default用户的密码替换为requirepass，为空时不需要密码
+func (a *aclUsers) setRequirePass(password string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := a.users[defaultUserName].clone()
+	_ = u.apply("resetpass")
+	if password == "" {
+		_ = u.apply("nopass")
+	} else {
+		_ = u.apply(">" + password)
+	}
+	a.users[defaultUserName] = u
+}
+
+// setUser 依次应用规则，任一规则出错时不修改用户，用户不存在时创建
+func (a *aclUsers) setUser(name string, rules []string) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n\x00") {
+		return fmt.Errorf("ERR %v", errACLInvalidUsername)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var u *aclUser
+	if old, ok := a.users[name]; ok {
+		u = old.clone()
+	} else {
+		u = newACLUser(name)
+	}
+	for _, rule := range rules {
+		if err := u.apply(rule); err != nil {
+			return newACLRuleError(rule, err)
+		}
+	}
+	a.users[name] = u
+	return nil
+}
+
+// deleteUsers 返回删除的用户数量，default用户不能删除
+func (a *aclUsers) deleteUsers(names []string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, name := range names {
+		if name == defaultUserName {
+			return 0, errDeleteDefault
+		}
+	}
+	var n int
+	for _, name := range names {
+		if _, ok := a.users[name]; ok {
+			delete(a.users, name)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// sortedUsers 按名称排序的所有用户，调用方需持有读锁
+func (a *aclUsers) sortedUsers() []*aclUser {
+	users := make([]*aclUser, 0, len(a.users))
+	for _, u := range a.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].name < users[j].name
+	})
+	return users
+}
+
+func (a *aclUsers) list() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	lines := make([]string, 0, len(a.users))
+	for _, u := range a.sortedUsers() {
+		lines = append(lines, u.describe())
+	}
+	return lines
+}
+
+func (a *aclUsers) names() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	names := make([]string, 0, len(a.users))
+	for _, u := range a.sortedUsers() {
+		names = append(names, u.name)
+	}
+	return names
+}
+
+// load
+//
+//	@Description: 从ACL文件中加载用户并替换所有用户，文件有误时不修改
+//	每行为 user 名称 规则...，以 # 开头的行为注释，文件中没有default用户时使用默认的default用户
+func (a *aclUsers) load() error {
+	if a.file == "" {
+		return errNoACLFile
+	}
+	f, err := os.Open(a.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	users := make(map[string]*aclUser)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: line should start with user keyword", a.file, line)
+		}
+		name := fields[1]
+		if _, ok := users[name]; ok {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", a.file, line, name)
+		}
+		u := newACLUser(name)
+		for _, rule := range fields[2:] {
+			if err = u.apply(rule); err != nil {
+				return fmt.Errorf("%s:%d: error in user declaration '%s': %v", a.file, line, rule, err)
+			}
+		}
+		users[name] = u
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if _, ok := users[defaultUserName]; !ok {
+		users[defaultUserName] = newDefaultUser()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users = users
+	return nil
+}
+
+// save 先写入临时文件再替换ACL文件，避免写入中途失败时丢失原有的用户
+func (a *aclUsers) save() error {
+	if a.file == "" {
+		return errNoACLFile
+	}
+	var sb strings.Builder
+	for _, line := range a.list() {
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(a.file), filepath.Base(a.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.WriteString(sb.String()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), a.file)
+}
+
+// checkPermission
+//
+//	@Description: 检查客户端是否已认证，以及当前用户能否执行该命令并访问其中的key
+//	AUTH与HELLO不需要认证也不受ACL限制
+//	@receiver cli
+//	@param redisCmd
+//	@param args  包含命令名
+//	@return error
+func (cli *BitcaskClient) checkPermission(redisCmd *redisCommand, args [][]byte) error {
+	if redisCmd.hasFlag(flagNoAuth) {
+		return nil
+	}
+	acl := cli.server.acl
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	if !cli.authenticated && acl.authRequired() {
+		return errNoAuth
+	}
+	u, ok := acl.users[cli.user]
+	if !ok {
+		return errUserRemoved
+	}
+	if !u.canRun(redisCmd) {
+		return newNoPermCommandError(u.name, redisCmd.name)
+	}
+	for _, key := range redisCmd.keys(args) {
+		if !u.canAccess(key) {
+			return errNoPermKey
+		}
+	}
+	return nil
+}
+
+// authRequired 客户端是否需要先认证
+func (cli *BitcaskClient) authRequired() bool {
+	acl := cli.server.acl
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	return !cli.authenticated && acl.authRequired()
+}
+
+// login 验证用户名与密码，成功后以该用户的身份执行之后的命令
+func (cli *BitcaskClient) login(name string, password []byte) error {
+	if !cli.server.acl.authenticate(name, password) {
+		return errWrongPass
+	}
+	cli.user = name
+	cli.authenticated = true
+	return nil
+}
+
+// auth [username] password 未指定用户名时认证default用户
+func auth(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) > 2 {
+		return nil, errSyntax
+	}
+	name, password := defaultUserName, args[0]
+	if len(args) == 2 {
+		name, password = string(args[0]), args[1]
+	} else if cli.server.acl.defaultNoPass() {
+		return nil, errAuthNoPassword
+	}
+	if err := cli.login(name, password); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+// aclCommand ACL SETUSER name rule... | DELUSER name... | USERS | LIST | WHOAMI | LOAD | SAVE
+func aclCommand(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	acl := cli.server.acl
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "setuser", "deluser":
+		if len(args) < 2 {
+			return nil, newWrongNumberOfArgsError("acl|" + sub)
+		}
+	case "users", "list", "whoami", "load", "save":
+		if len(args) != 1 {
+			return nil, newWrongNumberOfArgsError("acl|" + sub)
+		}
+	default:
+		return nil, newUnknownSubcommandError("acl", sub)
+	}
+
+	var err error
+	switch sub {
+	case "setuser":
+		rules := make([]string, 0, len(args)-2)
+		for _, rule := range args[2:] {
+			rules = append(rules, string(rule))
+		}
+		err = acl.setUser(string(args[1]), rules)
+	case "deluser":
+		names := make([]string, 0, len(args)-1)
+		for _, name := range args[1:] {
+			names = append(names, string(name))
+		}
+		n, err := acl.deleteUsers(names)
+		if err != nil {
+			return nil, err
+		}
+		return redcon.SimpleInt(n), nil
+	case "users":
+		return acl.names(), nil
+	case "list":
+		return acl.list(), nil
+	case "whoami":
+		return cli.user, nil
+	case "load":
+		err = aclFileError(acl.load())
+	case "save":
+		err = aclFileError(acl.save())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+// aclFileError 读写ACL文件的错误以ERR开头回复
+func aclFileError(err error) error {
+	if err == nil || err == errNoACLFile {
+		return err
+	}
+	return fmt.Errorf("ERR %v", err)
+}
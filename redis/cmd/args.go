@@ -0,0 +1,121 @@
+package main
+
+import (
+	"kv-db-lab/constant"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func parseInt(arg []byte) (int64, error) {
+	v, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, constant.ErrNotInteger
+	}
+	return v, nil
+}
+
+func parseFloat(arg []byte) (float64, error) {
+	v, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, constant.ErrNotFloat
+	}
+	return v, nil
+}
+
+// isArg 参数是否为指定的选项，不区分大小写
+func isArg(arg []byte, option string) bool {
+	return strings.EqualFold(string(arg), option)
+}
+
+// argParser
+//
+//	@Description: 按顺序读取命令的参数，出错后后续的读取均返回零值，由err返回第一个错误，
+//	使可选参数较多的命令不必在每一步检查错误
+type argParser struct {
+	args [][]byte
+	pos  int
+	e    error
+}
+
+func newArgParser(args [][]byte) *argParser {
+	return &argParser{args: args}
+}
+
+// more 是否还有未读取的参数，出错后返回false
+func (p *argParser) more() bool {
+	return p.e == nil && p.pos < len(p.args)
+}
+
+// fail 记录错误，只保留第一个
+func (p *argParser) fail(err error) {
+	if p.e == nil {
+		p.e = err
+	}
+}
+
+func (p *argParser) err() error {
+	return p.e
+}
+
+// next 读取下一个参数，参数不足时记为语法错误
+func (p *argParser) next() []byte {
+	if p.e != nil {
+		return nil
+	}
+	if p.pos >= len(p.args) {
+		p.fail(errSyntax)
+		return nil
+	}
+	arg := p.args[p.pos]
+	p.pos++
+	return arg
+}
+
+func (p *argParser) nextInt() int64 {
+	arg := p.next()
+	if p.e != nil {
+		return 0
+	}
+	v, err := parseInt(arg)
+	if err != nil {
+		p.fail(err)
+	}
+	return v
+}
+
+func (p *argParser) nextFloat() float64 {
+	arg := p.next()
+	if p.e != nil {
+		return 0
+	}
+	v, err := parseFloat(arg)
+	if err != nil {
+		p.fail(err)
+	}
+	return v
+}
+
+// tryOption 下一个参数为option时读取该参数并返回true，否则不移动位置
+func (p *argParser) tryOption(option string) bool {
+	if !p.more() || !isArg(p.args[p.pos], option) {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+// rest 读取剩余的所有参数
+func (p *argParser) rest() [][]byte {
+	if p.e != nil {
+		return nil
+	}
+	rest := p.args[p.pos:]
+	p.pos = len(p.args)
+	return rest
+}
+
+// unknown 当前参数无法识别，记为语法错误
+func (p *argParser) unknown() {
+	p.fail(errSyntax)
+}
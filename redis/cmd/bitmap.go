@@ -0,0 +1,151 @@
+package main
+
+import (
+	"github.com/tidwall/redcon"
+	"kv-db-lab/constant"
+	"kv-db-lab/redis"
+)
+
+func parseBit(arg []byte) (byte, error) {
+	switch string(arg) {
+	case "0":
+		return 0, nil
+	case "1":
+		return 1, nil
+	}
+	return 0, constant.ErrNotBit
+}
+
+func parseBitOffset(arg []byte) (uint64, error) {
+	offset, err := parseInt(arg)
+	if err != nil || offset < 0 {
+		return 0, constant.ErrNotInteger
+	}
+	return uint64(offset), nil
+}
+
+func setbit(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	offset, err := parseBitOffset(args[1])
+	if err != nil {
+		return nil, err
+	}
+	bit, err := parseBit(args[2])
+	if err != nil {
+		return nil, err
+	}
+	old, err := cli.db.SetBit(args[0], offset, bit)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(old), nil
+}
+
+func getbit(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	offset, err := parseBitOffset(args[1])
+	if err != nil {
+		return nil, err
+	}
+	bit, err := cli.db.GetBit(args[0], offset)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(bit), nil
+}
+
+// bitcount key [start end]
+func bitcount(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	start, end := int64(0), int64(-1)
+	switch len(args) {
+	case 1:
+	case 3:
+		var err error
+		if start, err = parseInt(args[1]); err != nil {
+			return nil, err
+		}
+		if end, err = parseInt(args[2]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errSyntax
+	}
+	n, err := cli.db.BitCount(args[0], start, end)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+// bitpos key bit [start [end]]
+func bitpos(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	bit, err := parseBit(args[1])
+	if err != nil {
+		return nil, err
+	}
+	start, end := int64(0), int64(-1)
+	var hasEnd bool
+	switch len(args) {
+	case 2:
+	case 4:
+		if end, err = parseInt(args[3]); err != nil {
+			return nil, err
+		}
+		hasEnd = true
+		fallthrough
+	case 3:
+		if start, err = parseInt(args[2]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errSyntax
+	}
+	pos, err := cli.db.BitPos(args[0], bit, start, end, hasEnd)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(pos), nil
+}
+
+// bitop AND|OR|XOR|NOT destkey key [key ...]
+func bitop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	var op redis.BitOperation
+	switch {
+	case isArg(args[0], "and"):
+		op = redis.BitOpAnd
+	case isArg(args[0], "or"):
+		op = redis.BitOpOr
+	case isArg(args[0], "xor"):
+		op = redis.BitOpXor
+	case isArg(args[0], "not"):
+		op = redis.BitOpNot
+	default:
+		return nil, errSyntax
+	}
+	n, err := cli.db.BitOp(op, args[1], args[2:]...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func pfadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	changed, err := cli.db.PFAdd(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return boolInt(changed), nil
+}
+
+func pfcount(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	n, err := cli.db.PFCount(args...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func pfmerge(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if err := cli.db.PFMerge(args[0], args[1:]...); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
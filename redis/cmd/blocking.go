@@ -0,0 +1,412 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// =========================阻塞命令=============================================================
+// 阻塞命令没有数据时在持有数据库锁期间登记在等待的key上，释放锁后等待，超时后回复null
+// 写入key的命令在释放数据库锁之前，按登记的顺序代等待的客户端执行命令并把回复交给它，
+// 因此key上的数据总是先交给最早等待的客户端，新到的命令不会抢在等待的客户端之前
+// 客户端阻塞期间redcon不再读取该连接，等待期间定期检查连接，断开后取消登记，不再为其弹出数据
+// 事务中的阻塞命令与redis一致不阻塞，没有数据时直接回复null
+
+var (
+	errTimeoutNotFloat = errors.New("ERR timeout is not a float or out of range")
+	errTimeoutNegative = errors.New("ERR timeout is negative")
+)
+
+// blockedCheckInterval 阻塞期间检查连接是否断开的间隔
+const blockedCheckInterval = 100 * time.Millisecond
+
+// blockedError 阻塞命令没有可用的数据，已登记的客户端由execClientCommand等待
+// 代为执行命令时没有数据，client为nil
+type blockedError struct {
+	client  *blockedClient
+	timeout time.Duration // 为0时一直等待
+	// timeoutReply 超时后的回复
+	timeoutReply interface{}
+}
+
+func (e *blockedError) Error() string {
+	return "blocked"
+}
+
+// parseTimeout 解析以秒为单位的超时时间，可以为小数，0表示一直等待
+func parseTimeout(arg []byte) (time.Duration, error) {
+	v, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || v*float64(time.Second) > math.MaxInt64 {
+		return 0, errTimeoutNotFloat
+	}
+	if v < 0 {
+		return 0, errTimeoutNegative
+	}
+	return time.Duration(v * float64(time.Second)), nil
+}
+
+// block 没有可用的数据时登记在keys上，事务中直接回复timeoutReply，代为执行时不再重复登记
+// 调用方持有所在数据库的锁，登记之后的写入一定会为该客户端执行命令
+func (cli *BitcaskClient) block(keys [][]byte, timeout time.Duration, timeoutReply interface{}) (interface{}, error) {
+	if cli.tx != nil {
+		return timeoutReply, nil
+	}
+	if cli.blocked != nil {
+		return nil, &blockedError{}
+	}
+	bc := cli.server.blocking.register(cli, keys, timeoutReply)
+	return nil, &blockedError{client: bc, timeout: timeout, timeoutReply: timeoutReply}
+}
+
+// blockedClient 等待在一组key上的客户端，claimed、cancelled与done由blockingKeys.mu保护
+type blockedClient struct {
+	client       *BitcaskClient
+	redisCmd     *redisCommand
+	args         [][]byte // 包含命令名，客户端等待期间redcon不会复用读缓冲区
+	keys         []watchKey
+	timeoutReply interface{}
+	result       chan blockedResult // 容量为1
+	claimed      bool               // 写入的命令正在代为执行
+	cancelled    bool               // 代为执行期间超时或连接断开，执行后没有数据时取消
+	done         bool               // 已取消登记
+}
+
+// blockedResult 代为执行命令的回复
+type blockedResult struct {
+	res interface{}
+	err error
+}
+
+// blockingKeys
+//
+//	@Description: 阻塞在各个key上的客户端，每个key上按登记的顺序排列
+type blockingKeys struct {
+	mu      sync.Mutex
+	waiters map[watchKey][]*blockedClient
+	ready   map[watchKey]struct{} // 被写入且有客户端等待的key，由写入的命令在释放数据库锁之前处理
+	closeCh chan struct{}         // 服务停止时关闭，唤醒所有阻塞的客户端
+}
+
+func newBlockingKeys() *blockingKeys {
+	return &blockingKeys{
+		waiters: make(map[watchKey][]*blockedClient),
+		ready:   make(map[watchKey]struct{}),
+		closeCh: make(chan struct{}),
+	}
+}
+
+// register 在客户端当前数据库的keys上登记，记录正在执行的命令
+func (b *blockingKeys) register(cli *BitcaskClient, keys [][]byte, timeoutReply interface{}) *blockedClient {
+	bc := &blockedClient{
+		client:       cli,
+		redisCmd:     cli.current,
+		args:         cli.currentArgs,
+		timeoutReply: timeoutReply,
+		result:       make(chan blockedResult, 1),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range keys {
+		wk := watchKey{db: cli.dbIndex, key: string(key)}
+		bc.keys = append(bc.keys, wk)
+		b.waiters[wk] = append(b.waiters[wk], bc)
+	}
+	return bc
+}
+
+// removeLocked 取消登记
+func (b *blockingKeys) removeLocked(bc *blockedClient) {
+	bc.done = true
+	for _, wk := range bc.keys {
+		waiters := b.waiters[wk]
+		for i, w := range waiters {
+			if w == bc {
+				waiters = append(waiters[:i:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(b.waiters, wk)
+			delete(b.ready, wk)
+			continue
+		}
+		b.waiters[wk] = waiters
+	}
+}
+
+// cancel 等待的客户端超时或连接断开时取消登记，返回false时回复已经或即将发送到result
+func (b *blockingKeys) cancel(bc *blockedClient) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if bc.done {
+		return false
+	}
+	if bc.claimed {
+		bc.cancelled = true
+		return false
+	}
+	b.removeLocked(bc)
+	return true
+}
+
+// empty 没有阻塞的客户端时写入无需处理
+func (b *blockingKeys) empty() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.waiters) == 0
+}
+
+// signal key被写入，有客户端等待时标记为待处理
+func (b *blockingKeys) signal(db int, key []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wk := watchKey{db: db, key: string(key)}
+	if len(b.waiters[wk]) > 0 {
+		b.ready[wk] = struct{}{}
+	}
+}
+
+// signalDB 标记数据库中所有有客户端等待的key，用于SWAPDB等替换整个数据库的命令
+func (b *blockingKeys) signalDB(db int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for wk := range b.waiters {
+		if wk.db == db {
+			b.ready[wk] = struct{}{}
+		}
+	}
+}
+
+// takeReady 取出held中数据库的待处理key
+func (b *blockingKeys) takeReady(held func(db int) bool) []watchKey {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []watchKey
+	for wk := range b.ready {
+		if held(wk.db) {
+			keys = append(keys, wk)
+			delete(b.ready, wk)
+		}
+	}
+	return keys
+}
+
+// claim 取出key上最早登记且未在执行的客户端，执行期间不会被取消
+func (b *blockingKeys) claim(wk watchKey) *blockedClient {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, bc := range b.waiters[wk] {
+		if !bc.claimed {
+			bc.claimed = true
+			return bc
+		}
+	}
+	return nil
+}
+
+// release 代为执行之后，有回复时取消登记并交给客户端，没有数据时继续等待，执行期间已被取消的回复timeoutReply
+func (b *blockingKeys) release(bc *blockedClient, result *blockedResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bc.claimed = false
+	if result == nil {
+		if !bc.cancelled {
+			return
+		}
+		result = &blockedResult{res: bc.timeoutReply}
+	}
+	b.removeLocked(bc)
+	bc.result <- *result
+}
+
+// close 服务停止时唤醒所有阻塞的客户端
+func (b *blockingKeys) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-b.closeCh:
+	default:
+		close(b.closeCh)
+	}
+}
+
+// serveBlocked
+//
+//	@Description: 写入的命令释放数据库锁之前调用，按登记的顺序代等待在被写入的key上的客户端执行命令
+//	客户端的命令没有数据时该key上之后的客户端同样没有数据，连接已断开的客户端直接取消登记
+//	代为执行的命令可能写入其他key，例如BLMOVE的目标list，循环直到没有待处理的key
+//	@receiver svr
+//	@param held  调用方持有锁的数据库
+func (svr *BitcaskServer) serveBlocked(held func(db int) bool) {
+	b := svr.blocking
+	if b.empty() {
+		return
+	}
+	for {
+		keys := b.takeReady(held)
+		if len(keys) == 0 {
+			return
+		}
+		for _, wk := range keys {
+			for {
+				bc := b.claim(wk)
+				if bc == nil {
+					break
+				}
+				cli := bc.client
+				if peerClosed(cli.netConn) {
+					b.mu.Lock()
+					bc.cancelled = true
+					b.mu.Unlock()
+					b.release(bc, nil)
+					continue
+				}
+				cli.blocked = bc
+				res, err := cli.execute(bc.redisCmd, bc.args)
+				cli.blocked = nil
+				if _, ok := err.(*blockedError); ok {
+					b.release(bc, nil)
+					break
+				}
+				b.release(bc, &blockedResult{res: res, err: err})
+			}
+		}
+	}
+}
+
+// waitUnblocked
+//
+//	@Description: 等待写入的命令代为执行后的回复，超时、服务停止或连接断开时取消登记
+//	取消时命令正在被代为执行，则等待其回复
+//	@receiver cli
+//	@param blocked  首次执行时返回的阻塞信息
+//	@return interface{}
+//	@return error
+func (cli *BitcaskClient) waitUnblocked(blocked *blockedError) (interface{}, error) {
+	b := cli.server.blocking
+	bc := blocked.client
+
+	var timeout <-chan time.Time
+	if blocked.timeout > 0 {
+		timer := time.NewTimer(blocked.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	ticker := time.NewTicker(blockedCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case r := <-bc.result:
+			return r.res, r.err
+		case <-timeout:
+		case <-b.closeCh:
+		case <-ticker.C:
+			if !peerClosed(cli.netConn) {
+				continue
+			}
+		}
+		if b.cancel(bc) {
+			return blocked.timeoutReply, nil
+		}
+		r := <-bc.result
+		return r.res, r.err
+	}
+}
+
+// blockingPop BLPOP与BRPOP，按参数的顺序从第一个非空的list中弹出，回复 [key, element]
+func (cli *BitcaskClient) blockingPop(args [][]byte, left bool) (interface{}, error) {
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+	keys := args[:len(args)-1]
+	for _, key := range keys {
+		var element []byte
+		if left {
+			element, err = cli.db.LPop(key)
+		} else {
+			element, err = cli.db.RPop(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if element != nil {
+			return []interface{}{key, element}, nil
+		}
+	}
+	return cli.block(keys, timeout, respNullArray{})
+}
+
+func blpop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.blockingPop(args, true)
+}
+
+func brpop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.blockingPop(args, false)
+}
+
+// blockingMove BLMOVE与BRPOPLPUSH，source为空时阻塞，回复移动的元素
+func (cli *BitcaskClient) blockingMove(source, destination []byte, srcLeft, dstLeft bool, timeoutArg []byte) (interface{}, error) {
+	timeout, err := parseTimeout(timeoutArg)
+	if err != nil {
+		return nil, err
+	}
+	value, err := cli.db.LMove(source, destination, srcLeft, dstLeft)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		return value, nil
+	}
+	return cli.block([][]byte{source}, timeout, nil)
+}
+
+func blmove(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	srcLeft, err := parseListSide(args[2])
+	if err != nil {
+		return nil, err
+	}
+	dstLeft, err := parseListSide(args[3])
+	if err != nil {
+		return nil, err
+	}
+	return cli.blockingMove(args[0], args[1], srcLeft, dstLeft, args[4])
+}
+
+func brpoplpush(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.blockingMove(args[0], args[1], false, true, args[2])
+}
+
+// blockingZPop BZPOPMIN与BZPOPMAX，按参数的顺序从第一个非空的zset中弹出，回复 [key, member, score]
+func (cli *BitcaskClient) blockingZPop(args [][]byte, max bool) (interface{}, error) {
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+	keys := args[:len(args)-1]
+	for _, key := range keys {
+		pop := cli.db.ZPopMin
+		if max {
+			pop = cli.db.ZPopMax
+		}
+		members, err := pop(key, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(members) > 0 {
+			return []interface{}{key, members[0].Member, members[0].Score}, nil
+		}
+	}
+	return cli.block(keys, timeout, respNullArray{})
+}
+
+func bzpopmin(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.blockingZPop(args, false)
+}
+
+func bzpopmax(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.blockingZPop(args, true)
+}
@@ -1,33 +1,106 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"github.com/sirupsen/logrus"
 	"github.com/tidwall/redcon"
 	"kv-db-lab/constant"
-	"kv-db-lab/pkg"
 	"kv-db-lab/redis"
+	"net"
 	"strings"
+	"sync"
 )
 
 func newWrongNumberOfArgsError(cmd string) error {
 	return fmt.Errorf("ERR wrong number of arguments for '%s' command", cmd)
 }
 
-type cmdHandler func(cli *BitcaskClient, args [][]byte) (interface{}, error)
+// newUnknownCommandError 与redis一致，错误信息中附带前几个参数
+func newUnknownCommandError(cmd string, args [][]byte) error {
+	var sb strings.Builder
+	for i, arg := range args {
+		if i == 3 {
+			break
+		}
+		fmt.Fprintf(&sb, "'%s' ", arg)
+	}
+	return fmt.Errorf("ERR unknown command '%s', with args beginning with: %s", cmd, sb.String())
+}
 
-var supportedCommands = map[string]cmdHandler{
-	"set":   set,
-	"get":   get,
-	"hset":  hset,
-	"sadd":  sadd,
-	"lpush": lpush,
-	"zadd":  zadd,
+func newUnknownSubcommandError(cmd, sub string) error {
+	return fmt.Errorf("ERR unknown subcommand '%s'. Try %s HELP.", sub, strings.ToUpper(cmd))
 }
 
+func newSubscribedContextError(cmd string) error {
+	return fmt.Errorf("ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", cmd)
+}
+
+var (
+	errSyntax            = errors.New("ERR syntax error")
+	errInvalidCommand    = errors.New("ERR Invalid command specified")
+	errInvalidArgs       = errors.New("ERR Invalid number of arguments specified for command")
+	errNoKeys            = errors.New("ERR The command has no key arguments")
+	errInvalidCursor     = errors.New("ERR invalid cursor")
+	errInvalidScoreRange = errors.New("ERR min or max is not a float")
+	errInvalidSetExpire  = errors.New("ERR invalid expire time in 'set' command")
+	errNoProto           = errors.New("NOPROTO unsupported protocol version")
+	errGeoMemberNotExist = errors.New("ERR could not decode requested zset member")
+	errDBIndexOutOfRange = errors.New("ERR DB index is out of range")
+	errSameObject        = errors.New("ERR source and destination objects are the same")
+	errClusterSelect     = errors.New("ERR SELECT is not allowed in cluster mode")
+
+	errNestedMulti         = errors.New("ERR MULTI calls can not be nested")
+	errExecWithoutMulti    = errors.New("ERR EXEC without MULTI")
+	errDiscardWithoutMulti = errors.New("ERR DISCARD without MULTI")
+	errWatchInsideMulti    = errors.New("ERR WATCH inside MULTI is not allowed")
+	errExecAbort           = errors.New("EXECABORT Transaction discarded because of previous errors.")
+)
+
+type cmdHandler func(cli *BitcaskClient, args [][]byte) (interface{}, error)
+
 type BitcaskClient struct {
-	server *BitcaskServer
-	db     *redis.RedisDataStructure
+	server  *BitcaskServer
+	db      *redis.RedisDataStructure // 执行命令前按dbIndex获取，SWAPDB后立即生效
+	dbIndex int
+	leader  *leaderConn // 集群模式下从节点到leader的转发连接
+
+	id    int64
+	name  string
+	proto int // 通过HELLO协商的协议版本，默认为2
+
+	// 执行命令的ACL用户，default用户需要密码时未认证的连接只能执行AUTH与HELLO
+	user          string
+	authenticated bool
+
+	// 事务状态，multiErr为入队时出现了错误，EXEC时放弃事务
+	multi    bool
+	multiErr bool
+	queued   []*queuedCommand
+	tx       *transaction // EXEC期间不为nil
+
+	// WATCH的key，watchDirty由server.watchLock保护
+	watched    []watchKey
+	watchDirty bool
+
+	// 正在执行的命令，阻塞命令登记时记录，之后由写入key的命令代为执行
+	current     *redisCommand
+	currentArgs [][]byte
+	blocked     *blockedClient // 代为执行阻塞命令期间不为nil，没有数据时不再重复登记
+
+	// 订阅的频道与模式，只在处理该客户端命令的协程中访问
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	pushCh   chan respPush // 待推送的消息，首次订阅时创建
+	// 首次订阅后连接脱离redcon，命令的回复与推送的消息由writeLock互斥写入
+	detached  bool
+	done      chan struct{}
+	writeLock sync.Mutex
+	netConn   net.Conn
+}
+
+// resp3 是否使用RESP3协议回复
+func (cli *BitcaskClient) resp3() bool {
+	return cli.proto == 3
 }
 
 func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
@@ -37,113 +110,137 @@ func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
 	switch command {
 	case "quit":
 		_ = conn.Close()
-	case "ping":
-		conn.WriteString("PONG")
 	default:
-		cmdFunc, ok := supportedCommands[command]
+		redisCmd, ok := supportedCommands[command]
 		if !ok {
-			conn.WriteError("Err unsupported command: '" + command + "'")
+			client.multiErr = client.multi
+			writeReply(conn, client, newUnknownCommandError(string(cmd.Args[0]), cmd.Args[1:]))
 			return
 		}
-		res, err := cmdFunc(client, cmd.Args[1:])
-		if err != nil {
-			if err == constant.ErrNotExist {
-				conn.WriteNull()
-			} else {
-				conn.WriteError(err.Error())
+		if !redisCmd.checkArity(cmd.Args) {
+			client.multiErr = client.multi
+			writeReply(conn, client, newWrongNumberOfArgsError(command))
+			return
+		}
+
+		// 未认证或没有权限时拒绝执行，事务中同样会使EXEC放弃事务
+		if err := client.checkPermission(redisCmd, cmd.Args); err != nil {
+			if err == errUserRemoved {
+				_ = conn.Close()
+				return
 			}
+			client.multiErr = client.multi
+			writeReply(conn, client, err)
 			return
 		}
-		conn.WriteAny(res)
-	}
-}
 
-func set(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("set")
-	}
+		// RESP2下订阅期间的回复与推送的消息无法区分，只能执行订阅相关的命令
+		if client.subscriptions() > 0 && !client.resp3() && !allowedWhenSubscribed(command) {
+			writeReply(conn, client, newSubscribedContextError(command))
+			return
+		}
 
-	key, value := args[0], args[1]
-	if err := cli.db.Set(key, value, 0); err != nil {
-		return nil, err
-	}
-	return redcon.SimpleString("OK"), nil
-}
+		// 集群模式下从节点转发给leader，leader确认自身身份并应用完已提交的日志后再执行，保证读到最新数据
+		// 连接相关的命令不访问数据，发布订阅、配置与ACL只在当前节点内有效，均由当前节点直接处理
+		if node := client.server.node; node != nil && !isNodeLocal(redisCmd) {
+			if !node.IsLeader() {
+				client.proxyToLeader(conn, cmd)
+				return
+			}
+			if err := node.LinearizableRead(); err != nil {
+				writeReply(conn, client, err)
+				return
+			}
+		}
 
-func get(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 1 {
-		logrus.Errorf("get failed,failed args")
-		return nil, newWrongNumberOfArgsError("get")
-	}
+		// MULTI之后除事务相关的命令外都进入队列
+		if client.multi && !isTransactionControl(command) {
+			client.queue(redisCmd, cmd.Args)
+			writeReply(conn, client, redcon.SimpleString("QUEUED"))
+			return
+		}
 
-	value, err := cli.db.Get(args[0])
-	if err != nil {
-		logrus.Errorf("get failed,err:", err.Error())
-		return nil, err
+		res, err := client.executeLocked(redisCmd, cmd.Args)
+		// 阻塞命令在不持有事务锁时等待
+		if blocked, ok := err.(*blockedError); ok {
+			res, err = client.waitUnblocked(blocked)
+		}
+		if err != nil {
+			writeReply(conn, client, err)
+			return
+		}
+		writeReply(conn, client, res)
+		client.detachIfSubscribed(conn)
 	}
-	return value, nil
 }
 
-func hset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 3 {
-		return nil, newWrongNumberOfArgsError("hset")
-	}
+// isNodeLocal 集群模式下由当前节点直接处理的命令
+func isNodeLocal(redisCmd *redisCommand) bool {
+	return redisCmd.group == "connection" || redisCmd.group == "pubsub" || redisCmd.name == "config" || redisCmd.name == "acl"
+}
 
-	var ok = 0
-	key, field, value := args[0], args[1], args[2]
-	res, err := cli.db.HSet(key, field, value)
-	if err != nil {
-		return nil, err
-	}
-	if res {
-		ok = 1
+// isTransactionControl MULTI状态下直接执行而不进入队列的命令
+func isTransactionControl(command string) bool {
+	switch command {
+	case "multi", "exec", "discard", "watch":
+		return true
 	}
-	return redcon.SimpleInt(ok), nil
+	return false
 }
 
-func sadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("sadd")
-	}
+// executeLocked 持有事务锁的读锁执行命令，写命令同时持有所在数据库的锁，EXEC与脚本自行持有写锁
+// 写命令释放数据库锁之前为阻塞在被写入的key上的客户端执行命令
+func (cli *BitcaskClient) executeLocked(redisCmd *redisCommand, args [][]byte) (interface{}, error) {
+	if redisCmd.group == "transaction" || redisCmd.group == "scripting" {
+		return cli.execute(redisCmd, args)
+	}
+	svr := cli.server
+	svr.txLock.RLock()
+	defer svr.txLock.RUnlock()
+	if !redisCmd.isWrite() {
+		return cli.execute(redisCmd, args)
+	}
+	index := cli.dbIndex
+	defer svr.lockWrite(redisCmd, index)()
+	res, err := cli.execute(redisCmd, args)
+	svr.serveBlocked(func(db int) bool {
+		return db == index || locksAllDBs(redisCmd)
+	})
+	return res, err
+}
 
-	var ok = 0
-	key, member := args[0], args[1]
-	res, err := cli.db.SAdd(key, member)
+// execute 在当前选择的数据库上执行命令，args包含命令名，写命令执行成功后使WATCH了相关key的事务失效
+func (cli *BitcaskClient) execute(redisCmd *redisCommand, args [][]byte) (interface{}, error) {
+	db, err := cli.database(cli.dbIndex)
 	if err != nil {
 		return nil, err
 	}
-	if res {
-		ok = 1
-	}
-	return redcon.SimpleInt(ok), nil
-}
+	cli.db = db
+	cli.current, cli.currentArgs = redisCmd, args
 
-func lpush(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("lpush")
+	res, err := redisCmd.handler(cli, args[1:])
+	if err == constant.ErrNotExist {
+		res, err = nil, nil
 	}
-
-	key, value := args[0], args[1]
-	res, err := cli.db.LPush(key, value)
-	if err != nil {
-		return nil, err
+	if err == nil && redisCmd.isWrite() {
+		cli.server.touchKeys(cli.dbIndex, redisCmd.keys(args))
 	}
-	return redcon.SimpleInt(res), nil
+	return res, err
 }
 
-func zadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 3 {
-		return nil, newWrongNumberOfArgsError("zadd")
-	}
-
-	var ok = 0
-	key, score, member := args[0], args[1], args[2]
-	res, err := cli.db.ZAdd(key, pkg.FloatFromBytes(score), member)
+// database 返回逻辑数据库，EXEC期间返回写入暂存在事务中的视图
+func (cli *BitcaskClient) database(index int) (*redis.RedisDataStructure, error) {
+	db, err := cli.server.database(index)
 	if err != nil {
 		return nil, err
 	}
-	if res {
-		ok = 1
+	return cli.inTx(db), nil
+}
+
+// inTx EXEC期间将数据库替换为事务中的视图
+func (cli *BitcaskClient) inTx(db *redis.RedisDataStructure) *redis.RedisDataStructure {
+	if cli.tx == nil {
+		return db
 	}
-	return redcon.SimpleInt(ok), nil
+	return cli.tx.view(db)
 }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"github.com/tidwall/redcon"
+	"kv-db-lab/constant"
+	"net"
+	"strings"
+	"time"
+)
+
+// proxyTimeout 转发命令到leader的超时时间
+const proxyTimeout = 5 * time.Second
+
+// leaderConn
+//
+//	@Description: 到leader redis服务的连接，从节点通过它原样转发命令
+type leaderConn struct {
+	net.Conn
+	addr string
+}
+
+// dialLeader 连接leader，配置了masterauth时先完成认证
+func dialLeader(addr, user, password string) (*leaderConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, proxyTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &leaderConn{Conn: conn, addr: addr}
+	if password == "" {
+		return c, nil
+	}
+
+	args := [][]byte{[]byte("auth"), []byte(password)}
+	if user != "" {
+		args = [][]byte{[]byte("auth"), []byte(user), []byte(password)}
+	}
+	if err = c.call(args); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// do 发送一条命令并返回leader的原始回复
+func (c *leaderConn) do(args [][]byte) ([]byte, error) {
+	_ = c.SetDeadline(time.Now().Add(proxyTimeout))
+
+	buf := redcon.AppendArray(nil, len(args))
+	for _, arg := range args {
+		buf = redcon.AppendBulk(buf, arg)
+	}
+	if _, err := c.Write(buf); err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, 0, 512)
+	chunk := make([]byte, 4096)
+	for {
+		n, err := c.Read(chunk)
+		reply = append(reply, chunk[:n]...)
+		if size, _ := redcon.ReadNextRESP(reply); size > 0 {
+			return reply[:size], nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// call 发送一条命令，leader回复错误时返回该错误
+func (c *leaderConn) call(args [][]byte) error {
+	reply, err := c.do(args)
+	if err != nil {
+		return err
+	}
+	if _, resp := redcon.ReadNextRESP(reply); resp.Type == redcon.Error {
+		return errors.New(resp.String())
+	}
+	return nil
+}
+
+// masterAuth 返回连接leader时认证的用户与密码
+func (svr *BitcaskServer) masterAuth() (string, string) {
+	svr.configLock.RLock()
+	defer svr.configLock.RUnlock()
+	return svr.config.masterUser, svr.config.masterAuth
+}
+
+// proxyToLeader 从节点不执行命令，转发给leader后把回复原样返回给客户端
+func (cli *BitcaskClient) proxyToLeader(conn redcon.Conn, cmd redcon.Command) {
+	_, meta, err := cli.server.node.Leader()
+	if err != nil {
+		conn.WriteError("CLUSTERDOWN " + err.Error())
+		return
+	}
+	addr := meta[constant.ClusterMetaRedis]
+	if addr == "" {
+		conn.WriteError("CLUSTERDOWN leader redis address unknown")
+		return
+	}
+
+	if cli.leader == nil || cli.leader.addr != addr {
+		cli.closeLeaderConn()
+		user, password := cli.server.masterAuth()
+		if cli.leader, err = dialLeader(addr, user, password); err != nil {
+			conn.WriteError("ERR forward to leader failed: " + err.Error())
+			return
+		}
+	}
+
+	reply, err := cli.leader.do(cmd.Args)
+	if err != nil {
+		cli.closeLeaderConn()
+		conn.WriteError("ERR forward to leader failed: " + err.Error())
+		return
+	}
+	conn.WriteRaw(reply)
+}
+
+func (cli *BitcaskClient) closeLeaderConn() {
+	if cli.leader != nil {
+		_ = cli.leader.Close()
+		cli.leader = nil
+	}
+}
+
+// clusterCommand 集群管理命令，目前支持 CLUSTER JOIN id raftAddr redisAddr
+func clusterCommand(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if cli.server.node == nil {
+		return nil, errors.New("ERR This instance has cluster support disabled")
+	}
+	if len(args) == 0 {
+		return nil, newWrongNumberOfArgsError("cluster")
+	}
+
+	switch strings.ToLower(string(args[0])) {
+	case "join":
+		if len(args) != 4 {
+			return nil, newWrongNumberOfArgsError("cluster join")
+		}
+		meta := map[string]string{constant.ClusterMetaRedis: string(args[3])}
+		if err := cli.server.node.Join(string(args[1]), string(args[2]), meta); err != nil {
+			return nil, err
+		}
+		return redcon.SimpleString("OK"), nil
+	default:
+		return nil, errors.New("ERR unknown subcommand '" + string(args[0]) + "'")
+	}
+}
+
+// joinCluster 启动时请求leader将本节点加入集群
+func (svr *BitcaskServer) joinCluster(raftAddr string) error {
+	cfg := svr.config
+	conn, err := dialLeader(cfg.join, cfg.masterUser, cfg.masterAuth)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.call([][]byte{[]byte("cluster"), []byte("join"), []byte(cfg.nodeID), []byte(raftAddr), []byte(cfg.addr)})
+}
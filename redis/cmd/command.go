@@ -0,0 +1,380 @@
+package main
+
+import (
+	"github.com/tidwall/redcon"
+	"sort"
+	"strings"
+)
+
+// 命令的标识，与redis COMMAND命令返回的flags一致
+const (
+	flagWrite    = "write"
+	flagReadonly = "readonly"
+	flagDenyOOM  = "denyoom"
+	flagFast     = "fast"
+	flagLoading  = "loading"
+	flagStale    = "stale"
+	flagMovable  = "movablekeys"
+	flagPubSub   = "pubsub"
+	flagBlocking = "blocking"
+	flagNoScript = "noscript"
+	flagNoAuth   = "no_auth"
+)
+
+// redisCommand
+//
+//	@Description: 命令表中的一条命令，arity与key的位置含义与redis COMMAND命令一致
+type redisCommand struct {
+	name    string
+	handler cmdHandler
+
+	// arity 正数为固定的参数个数，负数为最少的参数个数，均包含命令名
+	arity int
+	flags []string
+
+	// firstKey lastKey step 参数中key的位置，firstKey为0表示没有key，lastKey为负数表示从末尾倒数
+	firstKey int
+	lastKey  int
+	step     int
+	// getKeys key的位置不固定时从参数中解析key
+	getKeys func(args [][]byte) [][]byte
+
+	// group 命令所属的数据类型，同时作为ACL分类
+	group string
+}
+
+// isWrite 命令是否会修改数据
+func (c *redisCommand) isWrite() bool {
+	return c.hasFlag(flagWrite)
+}
+
+func (c *redisCommand) hasFlag(flag string) bool {
+	for _, f := range c.flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkArity 参数个数是否满足arity，args包含命令名
+func (c *redisCommand) checkArity(args [][]byte) bool {
+	if c.arity >= 0 {
+		return len(args) == c.arity
+	}
+	return len(args) >= -c.arity
+}
+
+// keys 返回参数中的所有key，args包含命令名
+func (c *redisCommand) keys(args [][]byte) [][]byte {
+	if c.getKeys != nil {
+		return c.getKeys(args)
+	}
+	if c.firstKey == 0 {
+		return nil
+	}
+
+	last := c.lastKey
+	if last < 0 {
+		last += len(args)
+	}
+	keys := make([][]byte, 0)
+	for i := c.firstKey; i <= last && i < len(args); i += c.step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// aclCategories 与redis一致的ACL分类
+func (c *redisCommand) aclCategories() []string {
+	categories := []string{"@" + c.group}
+	if c.isWrite() {
+		categories = append(categories, "@write")
+	} else if c.hasFlag(flagReadonly) {
+		categories = append(categories, "@read")
+	}
+	if c.hasFlag(flagFast) {
+		categories = append(categories, "@fast")
+	} else {
+		categories = append(categories, "@slow")
+	}
+	if c.hasFlag(flagBlocking) {
+		categories = append(categories, "@blocking")
+	}
+	return categories
+}
+
+// info COMMAND与COMMAND INFO中一条命令的描述
+func (c *redisCommand) info() []interface{} {
+	flags := make([]interface{}, 0, len(c.flags))
+	for _, f := range c.flags {
+		flags = append(flags, redcon.SimpleString(f))
+	}
+	categories := make([]interface{}, 0)
+	for _, category := range c.aclCategories() {
+		categories = append(categories, redcon.SimpleString(category))
+	}
+	return []interface{}{
+		c.name,
+		redcon.SimpleInt(c.arity),
+		flags,
+		redcon.SimpleInt(c.firstKey),
+		redcon.SimpleInt(c.lastKey),
+		redcon.SimpleInt(c.step),
+		categories,
+	}
+}
+
+// supportedCommands 命令名到命令的映射，由commandTable生成
+var supportedCommands = make(map[string]*redisCommand)
+
+func init() {
+	for _, c := range commandTable {
+		supportedCommands[c.name] = c
+	}
+	// command命令需要遍历命令表，在此处赋值避免初始化循环
+	supportedCommands["command"].handler = commandCommand
+}
+
+// cmdFlags 便于书写命令表
+func cmdFlags(flags ...string) []string {
+	return flags
+}
+
+var commandTable = []*redisCommand{
+	// connection
+	{name: "ping", handler: ping, arity: -1, flags: cmdFlags(flagFast, flagStale), group: "connection"},
+	{name: "echo", handler: echo, arity: 2, flags: cmdFlags(flagFast, flagStale), group: "connection"},
+	{name: "hello", handler: hello, arity: -1, flags: cmdFlags(flagNoScript, flagFast, flagLoading, flagStale, flagNoAuth), group: "connection"},
+	{name: "auth", handler: auth, arity: -2, flags: cmdFlags(flagNoScript, flagLoading, flagStale, flagFast, flagNoAuth), group: "connection"},
+	{name: "select", handler: selectCommand, arity: 2, flags: cmdFlags(flagLoading, flagStale, flagFast), group: "connection"},
+	{name: "command", arity: -1, flags: cmdFlags(flagLoading, flagStale), group: "connection"},
+
+	// transaction
+	{name: "multi", handler: multi, arity: 1, flags: cmdFlags(flagNoScript, flagLoading, flagStale, flagFast), group: "transaction"},
+	{name: "exec", handler: execCommand, arity: 1, flags: cmdFlags(flagNoScript, flagLoading, flagStale), group: "transaction"},
+	{name: "discard", handler: discard, arity: 1, flags: cmdFlags(flagNoScript, flagLoading, flagStale, flagFast), group: "transaction"},
+	{name: "watch", handler: watch, arity: -2, flags: cmdFlags(flagNoScript, flagLoading, flagStale, flagFast), firstKey: 1, lastKey: -1, step: 1, group: "transaction"},
+	{name: "unwatch", handler: unwatch, arity: 1, flags: cmdFlags(flagNoScript, flagLoading, flagStale, flagFast), group: "transaction"},
+
+	// pubsub
+	{name: "subscribe", handler: subscribe, arity: -2, flags: cmdFlags(flagNoScript, flagPubSub, flagLoading, flagStale), group: "pubsub"},
+	{name: "unsubscribe", handler: unsubscribe, arity: -1, flags: cmdFlags(flagNoScript, flagPubSub, flagLoading, flagStale), group: "pubsub"},
+	{name: "psubscribe", handler: psubscribe, arity: -2, flags: cmdFlags(flagNoScript, flagPubSub, flagLoading, flagStale), group: "pubsub"},
+	{name: "punsubscribe", handler: punsubscribe, arity: -1, flags: cmdFlags(flagNoScript, flagPubSub, flagLoading, flagStale), group: "pubsub"},
+	{name: "publish", handler: publish, arity: 3, flags: cmdFlags(flagPubSub, flagLoading, flagStale, flagFast), group: "pubsub"},
+	{name: "pubsub", handler: pubsubCommand, arity: -2, flags: cmdFlags(flagPubSub, flagLoading, flagStale), group: "pubsub"},
+
+	// scripting
+	{name: "eval", handler: eval, arity: -3, flags: cmdFlags(flagNoScript, flagStale, flagMovable), getKeys: evalKeys, group: "scripting"},
+	{name: "evalsha", handler: evalsha, arity: -3, flags: cmdFlags(flagNoScript, flagStale, flagMovable), getKeys: evalKeys, group: "scripting"},
+	{name: "script", handler: scriptCommand, arity: -2, flags: cmdFlags(flagNoScript, flagStale), group: "scripting"},
+
+	// server
+	{name: "cluster", handler: clusterCommand, arity: -2, flags: cmdFlags(flagStale), group: "admin"},
+	{name: "acl", handler: aclCommand, arity: -2, flags: cmdFlags(flagNoScript, flagLoading, flagStale), group: "admin"},
+	{name: "config", handler: configCommand, arity: -2, flags: cmdFlags(flagNoScript, flagLoading, flagStale), group: "admin"},
+
+	// keyspace
+	{name: "dbsize", handler: dbsize, arity: 1, flags: cmdFlags(flagReadonly, flagFast), group: "keyspace"},
+	{name: "flushdb", handler: flushdb, arity: -1, flags: cmdFlags(flagWrite), group: "keyspace"},
+	{name: "flushall", handler: flushall, arity: -1, flags: cmdFlags(flagWrite), group: "keyspace"},
+	{name: "swapdb", handler: swapdb, arity: 3, flags: cmdFlags(flagWrite, flagFast), group: "keyspace"},
+	{name: "move", handler: move, arity: 3, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "keyspace"},
+	{name: "del", handler: del, arity: -2, flags: cmdFlags(flagWrite), firstKey: 1, lastKey: -1, step: 1, group: "keyspace"},
+	{name: "exists", handler: exists, arity: -2, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: -1, step: 1, group: "keyspace"},
+	{name: "type", handler: typeCommand, arity: 2, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "keyspace"},
+	{name: "expire", handler: expire, arity: 3, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "keyspace"},
+	{name: "pexpire", handler: pexpire, arity: 3, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "keyspace"},
+	{name: "expireat", handler: expireat, arity: 3, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "keyspace"},
+	{name: "pexpireat", handler: pexpireat, arity: 3, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "keyspace"},
+	{name: "persist", handler: persist, arity: 2, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "keyspace"},
+	{name: "ttl", handler: ttl, arity: 2, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "keyspace"},
+	{name: "pttl", handler: pttl, arity: 2, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "keyspace"},
+	{name: "rename", handler: rename, arity: 3, flags: cmdFlags(flagWrite), firstKey: 1, lastKey: 2, step: 1, group: "keyspace"},
+	{name: "renamenx", handler: renamenx, arity: 3, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 2, step: 1, group: "keyspace"},
+	{name: "keys", handler: keys, arity: 2, flags: cmdFlags(flagReadonly), group: "keyspace"},
+	{name: "scan", handler: scan, arity: -2, flags: cmdFlags(flagReadonly), group: "keyspace"},
+	{name: "randomkey", handler: randomkey, arity: 1, flags: cmdFlags(flagReadonly), group: "keyspace"},
+
+	// string
+	{name: "set", handler: set, arity: -3, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "get", handler: get, arity: 2, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "setnx", handler: setnx, arity: 3, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "setex", handler: setex, arity: 4, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "psetex", handler: psetex, arity: 4, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "getset", handler: getset, arity: 3, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "getdel", handler: getdel, arity: 2, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "mset", handler: mset, arity: -3, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: -1, step: 2, group: "string"},
+	{name: "msetnx", handler: msetnx, arity: -3, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: -1, step: 2, group: "string"},
+	{name: "mget", handler: mget, arity: -2, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: -1, step: 1, group: "string"},
+	{name: "append", handler: appendCommand, arity: 3, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "strlen", handler: strlen, arity: 2, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "getrange", handler: getrange, arity: 4, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "setrange", handler: setrange, arity: 4, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "incr", handler: incr, arity: 2, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "decr", handler: decr, arity: 2, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "incrby", handler: incrby, arity: 3, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "decrby", handler: decrby, arity: 3, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+	{name: "incrbyfloat", handler: incrbyfloat, arity: 3, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "string"},
+
+	// bitmap
+	{name: "setbit", handler: setbit, arity: 4, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: 1, step: 1, group: "bitmap"},
+	{name: "getbit", handler: getbit, arity: 3, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "bitmap"},
+	{name: "bitcount", handler: bitcount, arity: -2, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "bitmap"},
+	{name: "bitpos", handler: bitpos, arity: -3, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "bitmap"},
+	{name: "bitop", handler: bitop, arity: -4, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 2, lastKey: -1, step: 1, group: "bitmap"},
+
+	// hyperloglog
+	{name: "pfadd", handler: pfadd, arity: -2, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "hyperloglog"},
+	{name: "pfcount", handler: pfcount, arity: -2, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: -1, step: 1, group: "hyperloglog"},
+	{name: "pfmerge", handler: pfmerge, arity: -2, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: -1, step: 1, group: "hyperloglog"},
+
+	// hash
+	{name: "hset", handler: hset, arity: -4, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+	{name: "hmset", handler: hmset, arity: -4, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+	{name: "hsetnx", handler: hsetnx, arity: 4, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+	{name: "hget", handler: hget, arity: 3, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+	{name: "hmget", handler: hmget, arity: -3, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+	{name: "hdel", handler: hdel, arity: -3, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+	{name: "hgetall", handler: hgetall, arity: 2, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+	{name: "hkeys", handler: hkeys, arity: 2, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+	{name: "hvals", handler: hvals, arity: 2, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+	{name: "hlen", handler: hlen, arity: 2, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+	{name: "hexists", handler: hexists, arity: 3, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+	{name: "hincrby", handler: hincrby, arity: 4, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+	{name: "hincrbyfloat", handler: hincrbyfloat, arity: 4, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+	{name: "hscan", handler: hscan, arity: -3, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "hash"},
+
+	// list
+	{name: "lpush", handler: lpush, arity: -3, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "list"},
+	{name: "rpush", handler: rpush, arity: -3, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "list"},
+	{name: "lpop", handler: lpop, arity: 2, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "list"},
+	{name: "rpop", handler: rpop, arity: 2, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "list"},
+	{name: "llen", handler: llen, arity: 2, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "list"},
+	{name: "lindex", handler: lindex, arity: 3, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "list"},
+	{name: "lrange", handler: lrange, arity: 4, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "list"},
+	{name: "lset", handler: lset, arity: 4, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: 1, step: 1, group: "list"},
+	{name: "ltrim", handler: ltrim, arity: 4, flags: cmdFlags(flagWrite), firstKey: 1, lastKey: 1, step: 1, group: "list"},
+	{name: "lrem", handler: lrem, arity: 4, flags: cmdFlags(flagWrite), firstKey: 1, lastKey: 1, step: 1, group: "list"},
+	{name: "linsert", handler: linsert, arity: 5, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: 1, step: 1, group: "list"},
+	{name: "lmove", handler: lmove, arity: 5, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: 2, step: 1, group: "list"},
+	{name: "rpoplpush", handler: rpoplpush, arity: 3, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: 2, step: 1, group: "list"},
+	{name: "blpop", handler: blpop, arity: -3, flags: cmdFlags(flagWrite, flagBlocking), firstKey: 1, lastKey: -2, step: 1, group: "list"},
+	{name: "brpop", handler: brpop, arity: -3, flags: cmdFlags(flagWrite, flagBlocking), firstKey: 1, lastKey: -2, step: 1, group: "list"},
+	{name: "blmove", handler: blmove, arity: 6, flags: cmdFlags(flagWrite, flagDenyOOM, flagBlocking), firstKey: 1, lastKey: 2, step: 1, group: "list"},
+	{name: "brpoplpush", handler: brpoplpush, arity: 4, flags: cmdFlags(flagWrite, flagDenyOOM, flagBlocking), firstKey: 1, lastKey: 2, step: 1, group: "list"},
+
+	// set
+	{name: "sadd", handler: sadd, arity: -3, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "set"},
+	{name: "srem", handler: srem, arity: -3, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "set"},
+	{name: "sismember", handler: sismember, arity: 3, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "set"},
+	{name: "smembers", handler: smembers, arity: 2, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "set"},
+	{name: "scard", handler: scard, arity: 2, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "set"},
+	{name: "spop", handler: spop, arity: -2, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "set"},
+	{name: "srandmember", handler: srandmember, arity: -2, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "set"},
+	{name: "smove", handler: smove, arity: 4, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 2, step: 1, group: "set"},
+	{name: "sinter", handler: sinter, arity: -2, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: -1, step: 1, group: "set"},
+	{name: "sunion", handler: sunion, arity: -2, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: -1, step: 1, group: "set"},
+	{name: "sdiff", handler: sdiff, arity: -2, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: -1, step: 1, group: "set"},
+	{name: "sinterstore", handler: sinterstore, arity: -3, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: -1, step: 1, group: "set"},
+	{name: "sunionstore", handler: sunionstore, arity: -3, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: -1, step: 1, group: "set"},
+	{name: "sdiffstore", handler: sdiffstore, arity: -3, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: -1, step: 1, group: "set"},
+	{name: "sscan", handler: sscan, arity: -3, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "set"},
+
+	// sorted set
+	{name: "zadd", handler: zadd, arity: -4, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "sortedset"},
+	{name: "zincrby", handler: zincrby, arity: 4, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "sortedset"},
+	{name: "zscore", handler: zscore, arity: 3, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "sortedset"},
+	{name: "zrem", handler: zrem, arity: -3, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "sortedset"},
+	{name: "zrange", handler: zrange, arity: -4, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "sortedset"},
+	{name: "zrevrange", handler: zrevrange, arity: -4, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "sortedset"},
+	{name: "zrangebyscore", handler: zrangebyscore, arity: -4, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "sortedset"},
+	{name: "zcount", handler: zcount, arity: 4, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "sortedset"},
+	{name: "zrank", handler: zrank, arity: 3, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "sortedset"},
+	{name: "zpopmin", handler: zpopmin, arity: -2, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "sortedset"},
+	{name: "zpopmax", handler: zpopmax, arity: -2, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "sortedset"},
+	{name: "bzpopmin", handler: bzpopmin, arity: -3, flags: cmdFlags(flagWrite, flagFast, flagBlocking), firstKey: 1, lastKey: -2, step: 1, group: "sortedset"},
+	{name: "bzpopmax", handler: bzpopmax, arity: -3, flags: cmdFlags(flagWrite, flagFast, flagBlocking), firstKey: 1, lastKey: -2, step: 1, group: "sortedset"},
+
+	// geo
+	{name: "geoadd", handler: geoadd, arity: -5, flags: cmdFlags(flagWrite, flagDenyOOM), firstKey: 1, lastKey: 1, step: 1, group: "geo"},
+	{name: "geopos", handler: geopos, arity: -2, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "geo"},
+	{name: "geodist", handler: geodist, arity: -4, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "geo"},
+	{name: "geohash", handler: geohash, arity: -2, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "geo"},
+	{name: "geosearch", handler: geosearch, arity: -7, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "geo"},
+
+	// stream
+	{name: "xadd", handler: xadd, arity: -5, flags: cmdFlags(flagWrite, flagDenyOOM, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "stream"},
+	{name: "xlen", handler: xlen, arity: 2, flags: cmdFlags(flagReadonly, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "stream"},
+	{name: "xrange", handler: xrange, arity: -4, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "stream"},
+	{name: "xrevrange", handler: xrevrange, arity: -4, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "stream"},
+	{name: "xdel", handler: xdel, arity: -3, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "stream"},
+	{name: "xtrim", handler: xtrim, arity: -4, flags: cmdFlags(flagWrite), firstKey: 1, lastKey: 1, step: 1, group: "stream"},
+	{name: "xgroup", handler: xgroup, arity: -2, flags: cmdFlags(flagWrite, flagMovable), getKeys: xgroupKeys, group: "stream"},
+	{name: "xreadgroup", handler: xreadgroup, arity: -7, flags: cmdFlags(flagWrite, flagMovable), getKeys: xreadgroupKeys, group: "stream"},
+	{name: "xack", handler: xack, arity: -4, flags: cmdFlags(flagWrite, flagFast), firstKey: 1, lastKey: 1, step: 1, group: "stream"},
+	{name: "xpending", handler: xpending, arity: -3, flags: cmdFlags(flagReadonly), firstKey: 1, lastKey: 1, step: 1, group: "stream"},
+}
+
+// commandCommand
+//
+//	@Description: COMMAND 返回所有命令的描述
+//	COMMAND COUNT | COMMAND INFO name... | COMMAND LIST | COMMAND GETKEYS cmd args...
+func commandCommand(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) == 0 {
+		result := make([]interface{}, 0, len(commandTable))
+		for _, c := range commandTable {
+			result = append(result, c.info())
+		}
+		return result, nil
+	}
+
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "count":
+		if len(args) != 1 {
+			return nil, newWrongNumberOfArgsError("command|count")
+		}
+		return redcon.SimpleInt(len(commandTable)), nil
+	case "list":
+		if len(args) != 1 {
+			return nil, newWrongNumberOfArgsError("command|list")
+		}
+		names := make([]string, 0, len(commandTable))
+		for _, c := range commandTable {
+			names = append(names, c.name)
+		}
+		sort.Strings(names)
+		return names, nil
+	case "info":
+		// 不存在的命令返回nil
+		result := make([]interface{}, 0, len(args)-1)
+		for _, name := range args[1:] {
+			if c, ok := supportedCommands[strings.ToLower(string(name))]; ok {
+				result = append(result, c.info())
+			} else {
+				result = append(result, nil)
+			}
+		}
+		return result, nil
+	case "getkeys":
+		if len(args) < 2 {
+			return nil, newWrongNumberOfArgsError("command|getkeys")
+		}
+		c, ok := supportedCommands[strings.ToLower(string(args[1]))]
+		if !ok {
+			return nil, errInvalidCommand
+		}
+		if !c.checkArity(args[1:]) {
+			return nil, errInvalidArgs
+		}
+		keys := c.keys(args[1:])
+		if len(keys) == 0 {
+			return nil, errNoKeys
+		}
+		return keys, nil
+	default:
+		return nil, newUnknownSubcommandError("command", sub)
+	}
+}
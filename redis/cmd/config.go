@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/tidwall/redcon"
+	"kv-db-lab/model"
+	"kv-db-lab/pkg"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =========================配置=============================================================
+// 配置文件的格式与redis.conf一致，每行为 名称 值，值中含空格时使用双引号，以 # 开头的行为注释
+// 命令行参数与配置项同名，同时出现时以命令行为准；CONFIG GET/SET读写同一组配置项，只有部分配置项可以在运行期间修改
+
+// serverConfig 服务的配置，运行期间由BitcaskServer.configLock保护
+type serverConfig struct {
+	addr      string
+	opts      model.Options // 0号数据库的引擎配置，其他数据库只替换目录
+	databases int
+
+	maxClients      int
+	timeout         time.Duration // 客户端空闲超过该时间后断开，为0时不断开，修改后对新连接生效
+	shutdownTimeout time.Duration // 停止服务时等待连接断开的最长时间
+
+	notifyKeyspaceEvents string
+
+	luaTimeLimit int // Lua脚本的最长执行毫秒数，超过后终止脚本，为0时不限制
+
+	// 访问控制，requirepass为default用户的密码，aclfile为ACL LOAD与ACL SAVE读写的文件
+	requirepass string
+	aclFile     string
+
+	// TLS相关，tlsAddr为空时不监听TLS连接
+	tlsAddr        string
+	tlsCertFile    string
+	tlsKeyFile     string
+	tlsCACertFile  string
+	tlsAuthClients string
+
+	// 集群相关，nodeID为空时以单机模式运行
+	nodeID    string
+	raftAddr  string
+	raftDir   string
+	bootstrap bool
+	join      string
+	// 转发命令与加入集群时连接leader所用的用户与密码，与redis的masteruser、masterauth一致
+	masterUser string
+	masterAuth string
+}
+
+func defaultServerConfig() *serverConfig {
+	opts := *model.DefaultOptions
+	opts.DirPath = "./../redis_test_file"
+	return &serverConfig{
+		addr:            "127.0.0.1:6380",
+		opts:            opts,
+		databases:       16,
+		maxClients:      10000,
+		shutdownTimeout: 10 * time.Second,
+		luaTimeLimit:    5000,
+		tlsAuthClients:  "yes",
+		raftAddr:        model.DefaultClusterOptions.RaftAddr,
+		raftDir:         model.DefaultClusterOptions.RaftDir,
+	}
+}
+
+// configParam
+//
+//	@Description: 一个配置项，同时作为命令行参数、配置文件中的名称以及CONFIG GET/SET的参数
+type configParam struct {
+	name    string
+	usage   string
+	mutable bool // 是否可以通过CONFIG SET在运行期间修改
+	isBool  bool
+	get     func(c *serverConfig) string
+	set     func(c *serverConfig, value string) error
+	apply   func(svr *BitcaskServer) // CONFIG SET修改之后使之生效
+}
+
+var (
+	errConfigNotInteger = errors.New("argument couldn't be parsed into an integer")
+	errConfigNotBool    = errors.New("argument must be 'yes' or 'no'")
+	errConfigNotFloat   = errors.New("argument couldn't be parsed into a float")
+	errConfigOutOfRange = errors.New("argument must be between the allowed range")
+	errConfigImmutable  = errors.New("can't set immutable config")
+	errConfigDuplicate  = errors.New("duplicate parameter")
+)
+
+var configParams = []*configParam{
+	stringParam("addr", "redis服务监听地址", func(c *serverConfig) *string { return &c.addr }),
+	stringParam("dir", "数据目录，0号以外的数据库使用 <dir>-db<n> 目录", func(c *serverConfig) *string { return &c.opts.DirPath }),
+	intParam("databases", "逻辑数据库的数量", 1, func(c *serverConfig) *int { return &c.databases }),
+	{
+		name:  "data-file-size",
+		usage: "单个数据文件的大小上限，单位为字节",
+		get: func(c *serverConfig) string {
+			return strconv.FormatInt(c.opts.DataFileSize, 10)
+		},
+		set: func(c *serverConfig, value string) error {
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errConfigNotInteger
+			}
+			if v <= 0 {
+				return errConfigOutOfRange
+			}
+			c.opts.DataFileSize = v
+			return nil
+		},
+	},
+	boolParam("sync-writes", "每次写入后是否持久化到磁盘", func(c *serverConfig) *bool { return &c.opts.SyncWrites }),
+	{
+		name:  "index-type",
+		usage: "内存索引的类型，btree、art或bplustree",
+		get: func(c *serverConfig) string {
+			return indexTypeNames[c.opts.Index]
+		},
+		set: func(c *serverConfig, value string) error {
+			for index, name := range indexTypeNames {
+				if strings.EqualFold(value, name) {
+					c.opts.Index = index
+					return nil
+				}
+			}
+			return errors.New("argument must be one of btree, art, bplustree")
+		},
+	},
+	{
+		name:  "merge-ratio",
+		usage: "无效数据占比超过该值时才允许merge，取值0到1",
+		get: func(c *serverConfig) string {
+			return formatFloat(float64(c.opts.DateFileMergeRatio))
+		},
+		set: func(c *serverConfig, value string) error {
+			v, err := strconv.ParseFloat(value, 32)
+			if err != nil {
+				return errConfigNotFloat
+			}
+			if v < 0 || v > 1 {
+				return errConfigOutOfRange
+			}
+			c.opts.DateFileMergeRatio = float32(v)
+			return nil
+		},
+	},
+	mutableParam(intParam("maxclients", "最大客户端连接数", 1, func(c *serverConfig) *int { return &c.maxClients }), nil),
+	mutableParam(secondsParam("timeout", "客户端空闲超过该秒数后断开，0表示不断开", func(c *serverConfig) *time.Duration { return &c.timeout }),
+		func(svr *BitcaskServer) {
+			if svr.server != nil {
+				svr.server.SetIdleClose(svr.config.timeout)
+			}
+			if svr.tlsServer != nil {
+				svr.tlsServer.SetIdleClose(svr.config.timeout)
+			}
+		}),
+	mutableParam(secondsParam("shutdown-timeout", "停止服务时等待连接断开的最长秒数", func(c *serverConfig) *time.Duration { return &c.shutdownTimeout }), nil),
+	mutableParam(&configParam{
+		name:  "notify-keyspace-events",
+		usage: "与redis一致的keyspace通知配置，如KEA，为空时不通知",
+		get: func(c *serverConfig) string {
+			return c.notifyKeyspaceEvents
+		},
+		set: func(c *serverConfig, value string) error {
+			events, err := parseKeyspaceEvents(value)
+			if err != nil {
+				return fmt.Errorf("Invalid event class character. Use 'A%sKE'.", keyspaceEventClasses)
+			}
+			c.notifyKeyspaceEvents = events.String()
+			return nil
+		},
+	}, func(svr *BitcaskServer) {
+		_ = svr.setKeyspaceEvents(svr.config.notifyKeyspaceEvents)
+	}),
+	mutableParam(intParam("lua-time-limit", "Lua脚本的最长执行毫秒数，超过后终止脚本并放弃其写入，0表示不限制", 0, func(c *serverConfig) *int { return &c.luaTimeLimit }), nil),
+	mutableParam(stringParam("requirepass", "default用户的密码，为空时不需要认证", func(c *serverConfig) *string { return &c.requirepass }),
+		func(svr *BitcaskServer) {
+			svr.acl.setRequirePass(svr.config.requirepass)
+		}),
+	stringParam("aclfile", "ACL文件路径，启动时从中加载用户，不能与requirepass同时使用", func(c *serverConfig) *string { return &c.aclFile }),
+
+	stringParam("tls-addr", "TLS连接的监听地址，为空时不监听", func(c *serverConfig) *string { return &c.tlsAddr }),
+	stringParam("tls-cert-file", "服务端证书文件，PEM格式", func(c *serverConfig) *string { return &c.tlsCertFile }),
+	stringParam("tls-key-file", "服务端私钥文件，PEM格式", func(c *serverConfig) *string { return &c.tlsKeyFile }),
+	stringParam("tls-ca-cert-file", "验证客户端证书的CA证书文件，为空时不验证客户端", func(c *serverConfig) *string { return &c.tlsCACertFile }),
+	{
+		name:  "tls-auth-clients",
+		usage: "配置了CA证书时是否要求客户端证书，yes、no或optional",
+		get: func(c *serverConfig) string {
+			return c.tlsAuthClients
+		},
+		set: func(c *serverConfig, value string) error {
+			value = strings.ToLower(value)
+			if _, ok := tlsClientAuthTypes[value]; !ok {
+				return errors.New("argument must be one of yes, no, optional")
+			}
+			c.tlsAuthClients = value
+			return nil
+		},
+	},
+
+	stringParam("node-id", "集群节点ID，为空时以单机模式运行", func(c *serverConfig) *string { return &c.nodeID }),
+	stringParam("raft-addr", "Raft通信地址", func(c *serverConfig) *string { return &c.raftAddr }),
+	stringParam("raft-dir", "Raft日志与快照目录", func(c *serverConfig) *string { return &c.raftDir }),
+	boolParam("bootstrap", "以单节点身份初始化集群", func(c *serverConfig) *bool { return &c.bootstrap }),
+	stringParam("join", "加入集群时leader的redis服务地址", func(c *serverConfig) *string { return &c.join }),
+	mutableParam(stringParam("masteruser", "连接leader时认证的用户，为空时使用default用户", func(c *serverConfig) *string { return &c.masterUser }), nil),
+	mutableParam(stringParam("masterauth", "连接leader时认证的密码，为空时不认证", func(c *serverConfig) *string { return &c.masterAuth }), nil),
+}
+
+// configParamsByName 配置项名称到配置项的映射
+var configParamsByName = make(map[string]*configParam)
+
+func init() {
+	for _, p := range configParams {
+		configParamsByName[p.name] = p
+	}
+}
+
+// indexTypeNames 索引类型在配置中的名称
+var indexTypeNames = map[model.IndexType]string{
+	model.Btree:     "btree",
+	model.ART:       "art",
+	model.BPlusTree: "bplustree",
+}
+
+func stringParam(name, usage string, field func(c *serverConfig) *string) *configParam {
+	return &configParam{
+		name:  name,
+		usage: usage,
+		get: func(c *serverConfig) string {
+			return *field(c)
+		},
+		set: func(c *serverConfig, value string) error {
+			*field(c) = value
+			return nil
+		},
+	}
+}
+
+func intParam(name, usage string, min int, field func(c *serverConfig) *int) *configParam {
+	return &configParam{
+		name:  name,
+		usage: usage,
+		get: func(c *serverConfig) string {
+			return strconv.Itoa(*field(c))
+		},
+		set: func(c *serverConfig, value string) error {
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return errConfigNotInteger
+			}
+			if v < min {
+				return errConfigOutOfRange
+			}
+			*field(c) = v
+			return nil
+		},
+	}
+}
+
+// secondsParam 以秒为单位的时长，与redis的timeout一致
+func secondsParam(name, usage string, field func(c *serverConfig) *time.Duration) *configParam {
+	return &configParam{
+		name:  name,
+		usage: usage,
+		get: func(c *serverConfig) string {
+			return strconv.FormatInt(int64(*field(c)/time.Second), 10)
+		},
+		set: func(c *serverConfig, value string) error {
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errConfigNotInteger
+			}
+			if v < 0 || v > math.MaxInt64/int64(time.Second) {
+				return errConfigOutOfRange
+			}
+			*field(c) = time.Duration(v) * time.Second
+			return nil
+		},
+	}
+}
+
+// boolParam 与redis一致以yes和no表示，命令行中也可以使用true和false
+func boolParam(name, usage string, field func(c *serverConfig) *bool) *configParam {
+	return &configParam{
+		name:   name,
+		usage:  usage,
+		isBool: true,
+		get: func(c *serverConfig) string {
+			if *field(c) {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(c *serverConfig, value string) error {
+			switch strings.ToLower(value) {
+			case "yes", "true", "1":
+				*field(c) = true
+			case "no", "false", "0":
+				*field(c) = false
+			default:
+				return errConfigNotBool
+			}
+			return nil
+		},
+	}
+}
+
+// mutableParam 允许在运行期间修改配置项，apply为修改之后使之生效的函数
+func mutableParam(p *configParam, apply func(svr *BitcaskServer)) *configParam {
+	p.mutable = true
+	p.apply = apply
+	return p
+}
+
+// configValue 将配置项适配为命令行参数
+type configValue struct {
+	param  *configParam
+	config *serverConfig
+}
+
+func (v *configValue) String() string {
+	// flag包会以零值调用String判断默认值
+	if v.param == nil {
+		return ""
+	}
+	return v.param.get(v.config)
+}
+
+func (v *configValue) Set(value string) error {
+	return v.param.set(v.config, value)
+}
+
+func (v *configValue) IsBoolFlag() bool {
+	return v.param.isBool
+}
+
+// parseConfig
+//
+//	@Description: 解析命令行参数，指定了 -config 时先读取配置文件，命令行中出现的参数覆盖配置文件中的值
+//	@param name  程序名
+//	@param args  不包含程序名的命令行参数
+//	@return *serverConfig
+//	@return error
+func parseConfig(name string, args []string) (*serverConfig, error) {
+	cfg := defaultServerConfig()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", "", "配置文件路径，格式与redis.conf一致")
+	for _, p := range configParams {
+		fs.Var(&configValue{param: p, config: cfg}, p.name, p.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *configFile == "" {
+		return cfg, nil
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	if err := loadConfigFile(cfg, *configFile, explicit); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadConfigFile 读取配置文件，跳过skip中的配置项
+func loadConfigFile(cfg *serverConfig, path string, skip map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, _ := strings.Cut(text, " ")
+		name = strings.ToLower(name)
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			if value, err = strconv.Unquote(value); err != nil {
+				return fmt.Errorf("%s:%d: invalid quoted value", path, line)
+			}
+		}
+
+		p, ok := configParamsByName[name]
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown config '%s'", path, line, name)
+		}
+		if skip[name] {
+			continue
+		}
+		if err = p.set(cfg, value); err != nil {
+			return fmt.Errorf("%s:%d: '%s' %v", path, line, name, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// configCommand CONFIG GET pattern... | CONFIG SET name value [name value ...]
+func configCommand(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "get":
+		if len(args) < 2 {
+			return nil, newWrongNumberOfArgsError("config|get")
+		}
+		return cli.server.configGet(args[1:]), nil
+	case "set":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return nil, newWrongNumberOfArgsError("config|set")
+		}
+		if err := cli.server.configSet(args[1:]); err != nil {
+			return nil, err
+		}
+		return redcon.SimpleString("OK"), nil
+	default:
+		return nil, newUnknownSubcommandError("config", sub)
+	}
+}
+
+// configGet 返回名称与任一模式匹配的配置项
+func (svr *BitcaskServer) configGet(patterns [][]byte) respMap {
+	svr.configLock.RLock()
+	defer svr.configLock.RUnlock()
+
+	result := make(respMap, 0)
+	for _, p := range configParams {
+		for _, pattern := range patterns {
+			if pkg.GlobMatch([]byte(strings.ToLower(string(pattern))), []byte(p.name)) {
+				result = append(result, p.name, p.get(svr.config))
+				break
+			}
+		}
+	}
+	return result
+}
+
+// configSet 与redis一致，多个配置项全部修改成功或全部不修改
+func (svr *BitcaskServer) configSet(args [][]byte) error {
+	svr.configLock.Lock()
+	defer svr.configLock.Unlock()
+
+	next := *svr.config
+	changed := make([]*configParam, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		name := strings.ToLower(string(args[i]))
+		p, ok := configParamsByName[name]
+		if !ok {
+			return fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", name)
+		}
+		var err error
+		switch {
+		case !p.mutable:
+			err = errConfigImmutable
+		case containsParam(changed, p):
+			err = errConfigDuplicate
+		default:
+			err = p.set(&next, string(args[i+1]))
+		}
+		if err != nil {
+			return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", name, err)
+		}
+		changed = append(changed, p)
+	}
+
+	*svr.config = next
+	for _, p := range changed {
+		if p.apply != nil {
+			p.apply(svr)
+		}
+	}
+	return nil
+}
+
+func containsParam(params []*configParam, p *configParam) bool {
+	for _, e := range params {
+		if e == p {
+			return true
+		}
+	}
+	return false
+}
+
+// maxClients 当前允许的最大客户端连接数
+func (svr *BitcaskServer) maxClients() int {
+	svr.configLock.RLock()
+	defer svr.configLock.RUnlock()
+	return svr.config.maxClients
+}
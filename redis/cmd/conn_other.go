@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import "net"
+
+// peerClosed 不支持查看连接的平台上，断开的连接要等到写入回复时才能发现
+func peerClosed(conn net.Conn) bool {
+	return false
+}
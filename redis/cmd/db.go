@@ -0,0 +1,123 @@
+package main
+
+import (
+	"github.com/tidwall/redcon"
+)
+
+// parseDBIndex 解析逻辑数据库的编号，集群模式下只能使用0号数据库
+func parseDBIndex(cli *BitcaskClient, arg []byte) (int, error) {
+	index, err := parseInt(arg)
+	if err != nil {
+		return 0, err
+	}
+	if index < 0 || index >= int64(cli.server.databases) {
+		return 0, errDBIndexOutOfRange
+	}
+	if cli.server.node != nil && index != 0 {
+		return 0, errClusterSelect
+	}
+	return int(index), nil
+}
+
+func selectCommand(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	index, err := parseDBIndex(cli, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if _, err = cli.server.database(index); err != nil {
+		return nil, err
+	}
+	cli.dbIndex = index
+	return redcon.SimpleString("OK"), nil
+}
+
+func swapdb(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	i, err := parseDBIndex(cli, args[0])
+	if err != nil {
+		return nil, err
+	}
+	j, err := parseDBIndex(cli, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if err = cli.server.swapDB(i, j); err != nil {
+		return nil, err
+	}
+	cli.server.touchDB(i)
+	cli.server.touchDB(j)
+	cli.server.blocking.signalDB(i)
+	cli.server.blocking.signalDB(j)
+	return redcon.SimpleString("OK"), nil
+}
+
+// parseFlushMode 与redis一致接受ASYNC与SYNC，均同步删除
+func parseFlushMode(args [][]byte) error {
+	switch {
+	case len(args) == 0:
+		return nil
+	case len(args) == 1 && (isArg(args[0], "async") || isArg(args[0], "sync")):
+		return nil
+	}
+	return errSyntax
+}
+
+func flushdb(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if err := parseFlushMode(args); err != nil {
+		return nil, err
+	}
+	if err := cli.db.FlushDB(); err != nil {
+		return nil, err
+	}
+	cli.server.touchDB(cli.dbIndex)
+	return redcon.SimpleString("OK"), nil
+}
+
+func flushall(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if err := parseFlushMode(args); err != nil {
+		return nil, err
+	}
+	dbs, err := cli.server.existingDatabases()
+	if err != nil {
+		return nil, err
+	}
+	for _, db := range dbs {
+		if err = cli.inTx(db).FlushDB(); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < cli.server.databases; i++ {
+		cli.server.touchDB(i)
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func dbsize(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	n, err := cli.db.DBSize()
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+// move 返回是否迁移
+func move(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	index, err := parseDBIndex(cli, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if index == cli.dbIndex {
+		return nil, errSameObject
+	}
+	dst, err := cli.database(index)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := cli.db.Move(args[0], dst)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		cli.server.touchKeys(index, args[:1])
+	}
+	return boolInt(ok), nil
+}
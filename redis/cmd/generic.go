@@ -0,0 +1,321 @@
+package main
+
+import (
+	"github.com/tidwall/redcon"
+	"kv-db-lab/constant"
+	"kv-db-lab/redis"
+	"strconv"
+	"sync"
+)
+
+func ping(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) > 1 {
+		return nil, newWrongNumberOfArgsError("ping")
+	}
+	// 与redis一致，RESP2下订阅期间回复 pong 与参数组成的数组
+	if cli.subscriptions() > 0 && !cli.resp3() {
+		message := []byte{}
+		if len(args) == 1 {
+			message = args[0]
+		}
+		return []interface{}{"pong", message}, nil
+	}
+	if len(args) == 0 {
+		return redcon.SimpleString("PONG"), nil
+	}
+	return args[0], nil
+}
+
+// serverVersion HELLO中回复的版本，表示兼容的redis版本
+const serverVersion = "7.0.0"
+
+// hello [protover [AUTH username password] [SETNAME clientname]] 协商协议版本，回复服务端的信息
+// 需要认证的连接只能通过AUTH选项同时完成认证
+func hello(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	proto := cli.proto
+	p := newArgParser(args)
+	if p.more() {
+		v := p.nextInt()
+		if err := p.err(); err != nil {
+			return nil, err
+		}
+		if v != 2 && v != 3 {
+			return nil, errNoProto
+		}
+		proto = int(v)
+	}
+	var name, username, password []byte
+	for p.more() {
+		switch {
+		case p.tryOption("auth"):
+			username, password = p.next(), p.next()
+		case p.tryOption("setname"):
+			name = p.next()
+		default:
+			p.unknown()
+		}
+	}
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	if username != nil {
+		if err := cli.login(string(username), password); err != nil {
+			return nil, err
+		}
+	} else if cli.authRequired() {
+		return nil, errHelloNoAuth
+	}
+
+	cli.proto = proto
+	if name != nil {
+		cli.name = string(name)
+	}
+	mode, role := "standalone", "master"
+	if node := cli.server.node; node != nil {
+		mode = "cluster"
+		if !node.IsLeader() {
+			role = "replica"
+		}
+	}
+	return respMap{
+		"server", "redis",
+		"version", serverVersion,
+		"proto", redcon.SimpleInt(proto),
+		"id", redcon.SimpleInt(cli.id),
+		"mode", mode,
+		"role", role,
+		"modules", []interface{}{},
+	}, nil
+}
+
+func echo(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return args[0], nil
+}
+
+// del 返回实际删除的key数量
+func del(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	var deleted int
+	for _, key := range args {
+		n, err := cli.db.Exists(key)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			continue
+		}
+		if err = cli.db.Del(key); err != nil {
+			return nil, err
+		}
+		deleted++
+	}
+	return redcon.SimpleInt(deleted), nil
+}
+
+func exists(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	n, err := cli.db.Exists(args...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func typeCommand(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	typ, err := cli.db.Type(args[0])
+	if err == constant.ErrNotExist {
+		return redcon.SimpleString("none"), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString(redis.TypeName(typ)), nil
+}
+
+// expireGeneric 解析过期时间后调用对应的设置方法
+func expireGeneric(args [][]byte, fn func(key []byte, v int64) (bool, error)) (interface{}, error) {
+	v, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	ok, err := fn(args[0], v)
+	if err != nil {
+		return nil, err
+	}
+	return boolInt(ok), nil
+}
+
+func expire(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return expireGeneric(args, cli.db.Expire)
+}
+
+func pexpire(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return expireGeneric(args, cli.db.PExpire)
+}
+
+func expireat(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return expireGeneric(args, cli.db.ExpireAt)
+}
+
+func pexpireat(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return expireGeneric(args, cli.db.PExpireAt)
+}
+
+func persist(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	ok, err := cli.db.Persist(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return boolInt(ok), nil
+}
+
+func ttl(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	v, err := cli.db.TTL(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(v), nil
+}
+
+func pttl(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	v, err := cli.db.PTTL(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(v), nil
+}
+
+func rename(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if err := cli.db.Rename(args[0], args[1]); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func renamenx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	ok, err := cli.db.RenameNX(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return boolInt(ok), nil
+}
+
+func keys(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.db.Keys(args[0])
+}
+
+// scanOptions SCAN系列命令的 MATCH pattern COUNT count [TYPE type]
+type scanOptions struct {
+	match   []byte
+	count   int
+	keyType string
+}
+
+func parseScanOptions(args [][]byte, allowType bool) (*scanOptions, error) {
+	opts := &scanOptions{}
+	p := newArgParser(args)
+	for p.more() {
+		switch {
+		case p.tryOption("match"):
+			opts.match = p.next()
+		case p.tryOption("count"):
+			count := p.nextInt()
+			if p.err() == nil && count < 1 {
+				p.fail(errSyntax)
+			}
+			opts.count = int(count)
+		case allowType && p.tryOption("type"):
+			opts.keyType = string(p.next())
+		default:
+			p.unknown()
+		}
+	}
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+func parseCursor(arg []byte) (uint64, error) {
+	cursor, err := strconv.ParseUint(string(arg), 10, 64)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	return cursor, nil
+}
+
+// scanReply SCAN系列命令的回复 [cursor, [elements]]
+func scanReply(cursor uint64, elements interface{}) interface{} {
+	return []interface{}{strconv.FormatUint(cursor, 10), elements}
+}
+
+func scan(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	cursor, err := parseCursor(args[0])
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseScanOptions(args[1:], true)
+	if err != nil {
+		return nil, err
+	}
+	var after []byte
+	if cursor != 0 {
+		var ok bool
+		if after, ok = cli.server.cursors.load(cursor); !ok {
+			return nil, errInvalidCursor
+		}
+	}
+	keys, last, err := cli.db.Scan(after, opts.match, opts.count, opts.keyType)
+	if err != nil {
+		return nil, err
+	}
+	var next uint64
+	if last != nil {
+		next = cli.server.cursors.save(last)
+	}
+	return scanReply(next, keys), nil
+}
+
+// scanCursorCapacity 保留的SCAN游标数量，更早的游标失效
+const scanCursorCapacity = 1 << 14
+
+// scanCursors
+//
+//	@Description: SCAN的游标编号与上次遍历到的key的对应关系，下次从该key之后继续
+//	编号从1开始递增分配，按编号取模存放，只保留最近的scanCursorCapacity个，游标可以在任意数据库中使用
+type scanCursors struct {
+	mu      sync.Mutex
+	last    uint64
+	entries [scanCursorCapacity]scanCursor
+}
+
+type scanCursor struct {
+	id  uint64
+	key []byte
+}
+
+// save 为key分配新的游标编号
+func (c *scanCursors) save(key []byte) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last++
+	c.entries[c.last%scanCursorCapacity] = scanCursor{id: c.last, key: key}
+	return c.last
+}
+
+// load 返回游标对应的key，游标不存在或已被覆盖时返回false
+func (c *scanCursors) load(id uint64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entries[id%scanCursorCapacity]
+	if entry.id != id {
+		return nil, false
+	}
+	return entry.key, true
+}
+
+func randomkey(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	key, err := cli.db.RandomKey()
+	if err != nil {
+		return nil, err
+	}
+	return bulkOrNil(key), nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"github.com/tidwall/redcon"
+	"kv-db-lab/constant"
+	"kv-db-lab/redis"
+	"strconv"
+)
+
+// formatGeoFloat 与redis一致，坐标以字符串回复
+func formatGeoFloat(v float64) []byte {
+	return strconv.AppendFloat(nil, v, 'f', -1, 64)
+}
+
+// formatGeoDist 与redis一致，距离保留4位小数
+func formatGeoDist(meters, unit float64) []byte {
+	return strconv.AppendFloat(nil, meters/unit, 'f', 4, 64)
+}
+
+// nextGeoUnit 读取距离单位，返回对应的米数
+func nextGeoUnit(p *argParser) float64 {
+	arg := p.next()
+	if p.err() != nil {
+		return 1
+	}
+	unit, err := redis.GeoUnitToMeters(arg)
+	if err != nil {
+		p.fail(err)
+		return 1
+	}
+	return unit
+}
+
+// geoadd key longitude latitude member [longitude latitude member ...]
+func geoadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if (len(args)-1)%3 != 0 {
+		return nil, errSyntax
+	}
+	locations := make([]*redis.GeoLocation, 0, (len(args)-1)/3)
+	for i := 1; i < len(args); i += 3 {
+		lon, err := parseFloat(args[i])
+		if err != nil {
+			return nil, err
+		}
+		lat, err := parseFloat(args[i+1])
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, &redis.GeoLocation{Member: args[i+2], Longitude: lon, Latitude: lat})
+	}
+	n, err := cli.db.GeoAdd(args[0], locations...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func geopos(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	locations, err := cli.db.GeoPos(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(locations))
+	for _, loc := range locations {
+		if loc == nil {
+			result = append(result, nil)
+			continue
+		}
+		result = append(result, []interface{}{formatGeoFloat(loc.Longitude), formatGeoFloat(loc.Latitude)})
+	}
+	return result, nil
+}
+
+// geodist key member1 member2 [m|km|ft|mi]
+func geodist(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	unit := 1.0
+	switch len(args) {
+	case 3:
+	case 4:
+		var err error
+		if unit, err = redis.GeoUnitToMeters(args[3]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errSyntax
+	}
+	meters, err := cli.db.GeoDist(args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return formatGeoDist(meters, unit), nil
+}
+
+func geohash(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	hashes, err := cli.db.GeoHash(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return bulkArray(hashes), nil
+}
+
+// geosearch key FROMMEMBER member|FROMLONLAT lon lat BYRADIUS r unit|BYBOX w h unit
+// [ASC|DESC] [COUNT n [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func geosearch(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	query := &redis.GeoSearchQuery{}
+	var hasFrom, hasBy, withCoord, withDist, withHash bool
+	unit := 1.0
+
+	p := newArgParser(args[1:])
+	for p.more() {
+		switch {
+		case !hasFrom && p.tryOption("frommember"):
+			query.Member, hasFrom = p.next(), true
+		case !hasFrom && p.tryOption("fromlonlat"):
+			query.Longitude, query.Latitude, hasFrom = p.nextFloat(), p.nextFloat(), true
+		case !hasBy && p.tryOption("byradius"):
+			query.Radius = p.nextFloat()
+			unit = nextGeoUnit(p)
+			query.Radius *= unit
+			hasBy = true
+		case !hasBy && p.tryOption("bybox"):
+			query.Width, query.Height = p.nextFloat(), p.nextFloat()
+			unit = nextGeoUnit(p)
+			query.Width *= unit
+			query.Height *= unit
+			hasBy = true
+		case p.tryOption("asc"):
+			query.Sort = redis.GeoSortAsc
+		case p.tryOption("desc"):
+			query.Sort = redis.GeoSortDesc
+		case p.tryOption("count"):
+			if query.Count = p.nextInt(); p.err() == nil && query.Count <= 0 {
+				p.fail(errSyntax)
+			}
+			query.Any = p.tryOption("any")
+		case p.tryOption("withcoord"):
+			withCoord = true
+		case p.tryOption("withdist"):
+			withDist = true
+		case p.tryOption("withhash"):
+			withHash = true
+		default:
+			p.unknown()
+		}
+	}
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	if !hasFrom || !hasBy {
+		return nil, errSyntax
+	}
+
+	results, err := cli.db.GeoSearch(args[0], query)
+	if err == constant.ErrNotExist && query.Member != nil {
+		return nil, errGeoMemberNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reply := make([]interface{}, 0, len(results))
+	for _, res := range results {
+		if !withCoord && !withDist && !withHash {
+			reply = append(reply, res.Member)
+			continue
+		}
+		item := []interface{}{res.Member}
+		if withDist {
+			item = append(item, formatGeoDist(res.Dist, unit))
+		}
+		if withHash {
+			item = append(item, redcon.SimpleInt(res.Hash))
+		}
+		if withCoord {
+			item = append(item, []interface{}{formatGeoFloat(res.Longitude), formatGeoFloat(res.Latitude)})
+		}
+		reply = append(reply, item)
+	}
+	return reply, nil
+}
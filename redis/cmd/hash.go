@@ -0,0 +1,161 @@
+package main
+
+import (
+	"github.com/tidwall/redcon"
+	"kv-db-lab/redis"
+)
+
+func parseFieldValues(cmd string, args [][]byte) ([]*redis.FieldValue, error) {
+	if len(args)%2 != 0 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+	fieldValues := make([]*redis.FieldValue, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		fieldValues = append(fieldValues, &redis.FieldValue{Field: args[i], Value: args[i+1]})
+	}
+	return fieldValues, nil
+}
+
+// hset 返回新增的field数量
+func hset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	fieldValues, err := parseFieldValues("hset", args[1:])
+	if err != nil {
+		return nil, err
+	}
+	n, err := cli.db.HMSet(args[0], fieldValues)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func hmset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	fieldValues, err := parseFieldValues("hmset", args[1:])
+	if err != nil {
+		return nil, err
+	}
+	if _, err = cli.db.HMSet(args[0], fieldValues); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func hsetnx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	ok, err := cli.db.HSetNX(args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return boolInt(ok), nil
+}
+
+func hget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	value, err := cli.db.HGet(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return bulkOrNil(value), nil
+}
+
+func hmget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	values, err := cli.db.HMGet(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return bulkArray(values), nil
+}
+
+// hdel 返回删除的field数量
+func hdel(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	var deleted int
+	for _, field := range args[1:] {
+		ok, err := cli.db.HDel(args[0], field)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			deleted++
+		}
+	}
+	return redcon.SimpleInt(deleted), nil
+}
+
+func hgetall(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	fieldValues, err := cli.db.HGetAll(args[0])
+	if err != nil {
+		return nil, err
+	}
+	result := make(respMap, 0, len(fieldValues)*2)
+	for _, fv := range fieldValues {
+		result = append(result, fv.Field, fv.Value)
+	}
+	return result, nil
+}
+
+func hkeys(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.db.HKeys(args[0])
+}
+
+func hvals(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.db.HVals(args[0])
+}
+
+func hlen(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	n, err := cli.db.HLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func hexists(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	ok, err := cli.db.HExists(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return boolInt(ok), nil
+}
+
+func hincrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	increment, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	n, err := cli.db.HIncrBy(args[0], args[1], increment)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func hincrbyfloat(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	increment, err := parseFloat(args[2])
+	if err != nil {
+		return nil, err
+	}
+	v, err := cli.db.HIncrByFloat(args[0], args[1], increment)
+	if err != nil {
+		return nil, err
+	}
+	// 与redis一致，结果以字符串回复
+	return formatFloat(v), nil
+}
+
+func hscan(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	cursor, err := parseCursor(args[1])
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseScanOptions(args[2:], false)
+	if err != nil {
+		return nil, err
+	}
+	fieldValues, next, err := cli.db.HScan(args[0], cursor, opts.match, opts.count)
+	if err != nil {
+		return nil, err
+	}
+	elements := make([][]byte, 0, len(fieldValues)*2)
+	for _, fv := range fieldValues {
+		elements = append(elements, fv.Field, fv.Value)
+	}
+	return scanReply(next, elements), nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import "github.com/tidwall/redcon"
+
+// pushGeneric 依次写入所有元素，返回最终的长度
+func pushGeneric(args [][]byte, fn func(key, element []byte) (uint32, error)) (interface{}, error) {
+	var n uint32
+	for _, element := range args[1:] {
+		var err error
+		if n, err = fn(args[0], element); err != nil {
+			return nil, err
+		}
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func lpush(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return pushGeneric(args, cli.db.LPush)
+}
+
+func rpush(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return pushGeneric(args, cli.db.RPush)
+}
+
+func lpop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	value, err := cli.db.LPop(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return bulkOrNil(value), nil
+}
+
+func rpop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	value, err := cli.db.RPop(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return bulkOrNil(value), nil
+}
+
+func llen(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	n, err := cli.db.LLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func lindex(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	index, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	value, err := cli.db.LIndex(args[0], index)
+	if err != nil {
+		return nil, err
+	}
+	return bulkOrNil(value), nil
+}
+
+func lrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	start, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	return cli.db.LRange(args[0], start, stop)
+}
+
+func lset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	index, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if err = cli.db.LSet(args[0], index, args[2]); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func ltrim(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	start, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	if err = cli.db.LTrim(args[0], start, stop); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func lrem(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	count, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	n, err := cli.db.LRem(args[0], count, args[2])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func linsert(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	var before bool
+	switch {
+	case isArg(args[1], "before"):
+		before = true
+	case isArg(args[1], "after"):
+	default:
+		return nil, errSyntax
+	}
+	n, err := cli.db.LInsert(args[0], before, args[2], args[3])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+// parseListSide 解析LEFT或RIGHT，返回是否为LEFT
+func parseListSide(arg []byte) (bool, error) {
+	switch {
+	case isArg(arg, "left"):
+		return true, nil
+	case isArg(arg, "right"):
+		return false, nil
+	}
+	return false, errSyntax
+}
+
+func lmove(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	srcLeft, err := parseListSide(args[2])
+	if err != nil {
+		return nil, err
+	}
+	dstLeft, err := parseListSide(args[3])
+	if err != nil {
+		return nil, err
+	}
+	value, err := cli.db.LMove(args[0], args[1], srcLeft, dstLeft)
+	if err != nil {
+		return nil, err
+	}
+	return bulkOrNil(value), nil
+}
+
+func rpoplpush(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	value, err := cli.db.RPopLPush(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return bulkOrNil(value), nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"github.com/tidwall/redcon"
+	"kv-db-lab/model"
+	"kv-db-lab/redis"
+	"kv-db-lab/storage"
+	"math"
+)
+
+// =========================事务=============================================================
+// MULTI之后的命令进入客户端的队列，EXEC时持有服务端的事务锁依次执行，期间不会与其他客户端的命令交错
+// 每个逻辑数据库的写入暂存在同一个写批次中，全部命令执行后再提交，因此单个数据库内的写入原子生效
+// WATCH的key在EXEC之前被任意写命令修改时，EXEC放弃执行并回复null
+
+// queuedCommand MULTI之后进入队列的命令
+type queuedCommand struct {
+	cmd  *redisCommand
+	args [][]byte // 包含命令名，已从连接的读缓冲区中复制
+}
+
+// watchKey WATCH的key，以所在的逻辑数据库区分
+type watchKey struct {
+	db  int
+	key string
+}
+
+// transaction EXEC期间每个逻辑数据库对应的视图，视图的写入暂存在各自的写批次中
+// 视图中的变更通知同样暂存，写批次提交之后才发布
+type transaction struct {
+	server  *BitcaskServer
+	views   map[*redis.RedisDataStructure]*redis.RedisDataStructure
+	batches []*storage.WriteBatch
+	events  []txEvent
+}
+
+// txEvent 事务中暂存的变更通知，batch为所在写批次的下标
+type txEvent struct {
+	db    *redis.RedisDataStructure
+	batch int
+	class redis.EventClass
+	event string
+	key   []byte
+}
+
+func newTransaction(svr *BitcaskServer) *transaction {
+	return &transaction{server: svr, views: make(map[*redis.RedisDataStructure]*redis.RedisDataStructure)}
+}
+
+// view 返回数据库在事务中的视图，以数据库实例区分，SWAPDB之后同一编号对应另一个视图
+func (tx *transaction) view(db *redis.RedisDataStructure) *redis.RedisDataStructure {
+	if view, ok := tx.views[db]; ok {
+		return view
+	}
+	// 事务中的写入数量不设上限
+	opts := *model.DefaultWriteBatchOptions
+	opts.MaxBatchSize = math.MaxUint32
+	wb := db.Engine().NewWriteBatch(&opts)
+	batch := len(tx.batches)
+	view := db.WithBatch(wb, func(class redis.EventClass, event string, key []byte) {
+		tx.events = append(tx.events, txEvent{db: db, batch: batch, class: class, event: event, key: append([]byte{}, key...)})
+	})
+	tx.views[db] = view
+	tx.batches = append(tx.batches, wb)
+	return view
+}
+
+// commit 依次提交每个数据库的写批次，跨数据库的写入不保证原子性
+// 之后按发生的顺序发布已提交的写批次中的变更通知，提交失败的写批次不发布
+func (tx *transaction) commit() error {
+	var err error
+	committed := 0
+	for _, wb := range tx.batches {
+		if err = wb.Commit(); err != nil {
+			break
+		}
+		committed++
+	}
+	for _, e := range tx.events {
+		if e.batch < committed {
+			tx.server.keyChanged(e.db, e.class, e.event, e.key)
+		}
+	}
+	tx.events = nil
+	return err
+}
+
+// queue 复制参数后加入事务队列，redcon在命令处理完成后会复用读缓冲区
+func (cli *BitcaskClient) queue(redisCmd *redisCommand, args [][]byte) {
+	copied := make([][]byte, len(args))
+	for i, arg := range args {
+		copied[i] = append([]byte{}, arg...)
+	}
+	cli.queued = append(cli.queued, &queuedCommand{cmd: redisCmd, args: copied})
+}
+
+// resetMulti 退出MULTI状态并清空队列
+func (cli *BitcaskClient) resetMulti() {
+	cli.multi = false
+	cli.multiErr = false
+	cli.queued = nil
+}
+
+func multi(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if cli.multi {
+		return nil, errNestedMulti
+	}
+	cli.multi = true
+	return redcon.SimpleString("OK"), nil
+}
+
+func discard(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if !cli.multi {
+		return nil, errDiscardWithoutMulti
+	}
+	cli.resetMulti()
+	cli.server.unwatch(cli)
+	return redcon.SimpleString("OK"), nil
+}
+
+// execCommand
+//
+//	@Description: EXEC 执行队列中的命令，回复每条命令的结果，执行出错的命令回复错误但不影响其他命令
+//	入队时出现错误则放弃事务，WATCH的key被修改时回复null
+func execCommand(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if !cli.multi {
+		return nil, errExecWithoutMulti
+	}
+	queued, aborted := cli.queued, cli.multiErr
+	cli.resetMulti()
+
+	svr := cli.server
+	svr.txLock.Lock()
+	defer svr.txLock.Unlock()
+
+	// 持有事务锁后其他客户端的命令不会再修改数据，此时判断WATCH的key是否被修改
+	dirty := svr.unwatch(cli)
+	if aborted {
+		return nil, errExecAbort
+	}
+	if dirty {
+		return respNullArray{}, nil
+	}
+
+	cli.tx = newTransaction(svr)
+	defer func() {
+		cli.tx = nil
+	}()
+	replies := make([]interface{}, 0, len(queued))
+	for _, q := range queued {
+		res, err := cli.execute(q.cmd, q.args)
+		if err != nil {
+			res = err
+		}
+		replies = append(replies, res)
+	}
+	if err := cli.tx.commit(); err != nil {
+		return nil, err
+	}
+	// 持有事务锁的写锁，提交之后可以为所有数据库中阻塞的客户端执行命令
+	svr.serveBlocked(func(int) bool { return true })
+	return replies, nil
+}
+
+func watch(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if cli.multi {
+		return nil, errWatchInsideMulti
+	}
+	cli.server.watch(cli, cli.dbIndex, args)
+	return redcon.SimpleString("OK"), nil
+}
+
+func unwatch(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	cli.server.unwatch(cli)
+	return redcon.SimpleString("OK"), nil
+}
+
+// watch 登记客户端WATCH的key
+func (svr *BitcaskServer) watch(cli *BitcaskClient, db int, keys [][]byte) {
+	svr.watchLock.Lock()
+	defer svr.watchLock.Unlock()
+
+	for _, key := range keys {
+		wk := watchKey{db: db, key: string(key)}
+		clients, ok := svr.watchers[wk]
+		if !ok {
+			clients = make(map[*BitcaskClient]struct{})
+			svr.watchers[wk] = clients
+		}
+		if _, ok = clients[cli]; !ok {
+			clients[cli] = struct{}{}
+			cli.watched = append(cli.watched, wk)
+		}
+	}
+}
+
+// unwatch 取消客户端所有的WATCH，返回WATCH之后是否有key被修改
+func (svr *BitcaskServer) unwatch(cli *BitcaskClient) bool {
+	svr.watchLock.Lock()
+	defer svr.watchLock.Unlock()
+
+	for _, wk := range cli.watched {
+		clients := svr.watchers[wk]
+		delete(clients, cli)
+		if len(clients) == 0 {
+			delete(svr.watchers, wk)
+		}
+	}
+	dirty := cli.watchDirty
+	cli.watched = nil
+	cli.watchDirty = false
+	return dirty
+}
+
+// touchKeys 使WATCH了这些key的客户端的事务失效
+func (svr *BitcaskServer) touchKeys(db int, keys [][]byte) {
+	svr.watchLock.Lock()
+	defer svr.watchLock.Unlock()
+
+	if len(svr.watchers) == 0 {
+		return
+	}
+	for _, key := range keys {
+		for cli := range svr.watchers[watchKey{db: db, key: string(key)}] {
+			cli.watchDirty = true
+		}
+	}
+}
+
+// touchDB 使WATCH了该数据库中任意key的客户端的事务失效，用于FLUSHDB、SWAPDB等修改整个数据库的命令
+func (svr *BitcaskServer) touchDB(db int) {
+	svr.watchLock.Lock()
+	defer svr.watchLock.Unlock()
+
+	for wk, clients := range svr.watchers {
+		if wk.db != db {
+			continue
+		}
+		for cli := range clients {
+			cli.watchDirty = true
+		}
+	}
+}
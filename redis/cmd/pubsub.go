@@ -0,0 +1,435 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tidwall/redcon"
+	"kv-db-lab/pkg"
+	"kv-db-lab/redis"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// =========================发布订阅=============================================================
+// 首次订阅后连接脱离redcon的读写循环，由serveDetached读取并执行之后的命令，以便随时推送消息
+// 推送的消息先进入客户端的缓冲队列，再由单独的协程写入连接，发布者不会因订阅者读取过慢而阻塞
+// 订阅关系只在当前节点内有效，集群模式下不转发给leader
+
+// pushBufferSize 每个订阅者缓冲的消息数量，超出时与redis的client-output-buffer-limit一致断开连接
+const pushBufferSize = 1024
+
+// pubsubHub 频道与模式的订阅关系
+type pubsubHub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*BitcaskClient]struct{}
+	patterns map[string]map[*BitcaskClient]struct{}
+	detached map[*BitcaskClient]struct{} // 已脱离redcon的连接，服务停止时由hub关闭
+}
+
+func newPubsubHub() *pubsubHub {
+	return &pubsubHub{
+		channels: make(map[string]map[*BitcaskClient]struct{}),
+		patterns: make(map[string]map[*BitcaskClient]struct{}),
+		detached: make(map[*BitcaskClient]struct{}),
+	}
+}
+
+// subscribe 登记订阅关系，返回是否为新的订阅
+func (h *pubsubHub) subscribe(subs map[string]map[*BitcaskClient]struct{}, name string, cli *BitcaskClient) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clients, ok := subs[name]
+	if !ok {
+		clients = make(map[*BitcaskClient]struct{})
+		subs[name] = clients
+	}
+	if _, ok = clients[cli]; ok {
+		return false
+	}
+	clients[cli] = struct{}{}
+	return true
+}
+
+// unsubscribe 取消订阅关系
+func (h *pubsubHub) unsubscribe(subs map[string]map[*BitcaskClient]struct{}, name string, cli *BitcaskClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clients := subs[name]
+	delete(clients, cli)
+	if len(clients) == 0 {
+		delete(subs, name)
+	}
+}
+
+// publish 将消息推送给订阅了该频道以及匹配该频道的模式的客户端，返回接收的客户端数量
+func (h *pubsubHub) publish(channel string, message []byte) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	// 消息可能引用连接的读缓冲区，复制一份供所有订阅者共享
+	message = append([]byte{}, message...)
+	var n int
+	for cli := range h.channels[channel] {
+		cli.push(respPush{"message", channel, message})
+		n++
+	}
+	for pattern, clients := range h.patterns {
+		if !pkg.GlobMatch([]byte(pattern), []byte(channel)) {
+			continue
+		}
+		for cli := range clients {
+			cli.push(respPush{"pmessage", pattern, channel, message})
+			n++
+		}
+	}
+	return n
+}
+
+// empty 没有任何订阅时发布消息可以直接跳过
+func (h *pubsubHub) empty() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.channels) == 0 && len(h.patterns) == 0
+}
+
+// closeDetached 关闭所有脱离redcon的连接，redcon的Close不会关闭这些连接
+func (h *pubsubHub) closeDetached() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for cli := range h.detached {
+		_ = cli.netConn.Close()
+	}
+}
+
+// subscriptions 客户端订阅的频道与模式的数量
+func (cli *BitcaskClient) subscriptions() int {
+	return len(cli.channels) + len(cli.patterns)
+}
+
+// push 将消息放入客户端的缓冲队列，队列已满时断开连接
+func (cli *BitcaskClient) push(msg respPush) {
+	select {
+	case cli.pushCh <- msg:
+	default:
+		_ = cli.netConn.Close()
+	}
+}
+
+// detachIfSubscribed 在首次订阅的回复写入后使连接脱离redcon的读写循环
+func (cli *BitcaskClient) detachIfSubscribed(conn redcon.Conn) {
+	if cli.detached || cli.subscriptions() == 0 {
+		return
+	}
+	cli.detached = true
+	cli.done = make(chan struct{})
+	hub := cli.server.pubsub
+	hub.mu.Lock()
+	hub.detached[cli] = struct{}{}
+	hub.mu.Unlock()
+	go cli.serveDetached(conn.Detach())
+}
+
+// serveDetached 读取并执行脱离后的命令，连接断开时释放客户端的订阅等资源
+func (cli *BitcaskClient) serveDetached(dconn redcon.DetachedConn) {
+	defer cli.closeDetached()
+
+	// 脱离之前写入的回复尚未发送，先于推送的消息发送
+	cli.writeLock.Lock()
+	err := dconn.Flush()
+	cli.writeLock.Unlock()
+	if err != nil {
+		return
+	}
+	go cli.writePushes(dconn)
+
+	for {
+		cmd, err := dconn.ReadCommand()
+		if err != nil {
+			return
+		}
+		cli.writeLock.Lock()
+		execClientCommand(dconn, cmd)
+		err = dconn.Flush()
+		cli.writeLock.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writePushes 将缓冲队列中的消息写入连接，与命令的回复互斥
+func (cli *BitcaskClient) writePushes(dconn redcon.DetachedConn) {
+	for {
+		select {
+		case <-cli.done:
+			return
+		case msg := <-cli.pushCh:
+			cli.writeLock.Lock()
+			writeReply(dconn, cli, msg)
+			// 合并已到达的消息后再写入网络
+			for n := len(cli.pushCh); n > 0; n-- {
+				writeReply(dconn, cli, <-cli.pushCh)
+			}
+			err := dconn.Flush()
+			cli.writeLock.Unlock()
+			if err != nil {
+				_ = cli.netConn.Close()
+				return
+			}
+		}
+	}
+}
+
+// closeDetached 脱离后的连接断开时调用，redcon的close回调不再处理该连接
+func (cli *BitcaskClient) closeDetached() {
+	close(cli.done)
+	_ = cli.netConn.Close()
+
+	hub := cli.server.pubsub
+	for channel := range cli.channels {
+		hub.unsubscribe(hub.channels, channel, cli)
+	}
+	for pattern := range cli.patterns {
+		hub.unsubscribe(hub.patterns, pattern, cli)
+	}
+	hub.mu.Lock()
+	delete(hub.detached, cli)
+	hub.mu.Unlock()
+
+	cli.closeLeaderConn()
+	cli.server.unwatch(cli)
+	cli.server.removeClient(cli)
+}
+
+// allowedWhenSubscribed RESP2下订阅期间只能执行的命令
+func allowedWhenSubscribed(command string) bool {
+	switch command {
+	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe", "ping", "quit":
+		return true
+	}
+	return false
+}
+
+// subsOf 返回频道或模式的订阅关系
+func (h *pubsubHub) subsOf(pattern bool) map[string]map[*BitcaskClient]struct{} {
+	if pattern {
+		return h.patterns
+	}
+	return h.channels
+}
+
+// subsOf 返回客户端订阅的频道或模式
+func (cli *BitcaskClient) subsOf(pattern bool) map[string]struct{} {
+	if pattern {
+		return cli.patterns
+	}
+	return cli.channels
+}
+
+// subscribeAll 订阅每个频道或模式，每个都回复一条确认，其中包含当前订阅的总数
+func (cli *BitcaskClient) subscribeAll(pattern bool, names [][]byte) (interface{}, error) {
+	kind := pubsubKind("subscribe", pattern)
+	if cli.tx != nil {
+		return nil, fmt.Errorf("ERR %s isn't allowed inside MULTI", strings.ToUpper(kind))
+	}
+	if cli.pushCh == nil {
+		cli.pushCh = make(chan respPush, pushBufferSize)
+		cli.channels = make(map[string]struct{})
+		cli.patterns = make(map[string]struct{})
+	}
+
+	hub := cli.server.pubsub
+	replies := make(multiReply, 0, len(names))
+	for _, name := range names {
+		if hub.subscribe(hub.subsOf(pattern), string(name), cli) {
+			cli.subsOf(pattern)[string(name)] = struct{}{}
+		}
+		replies = append(replies, respPush{kind, name, cli.subscriptions()})
+	}
+	return replies, nil
+}
+
+// unsubscribeAll 取消订阅指定的频道或模式，未指定时取消全部
+func (cli *BitcaskClient) unsubscribeAll(pattern bool, names [][]byte) (interface{}, error) {
+	kind := pubsubKind("unsubscribe", pattern)
+	if cli.tx != nil {
+		return nil, fmt.Errorf("ERR %s isn't allowed inside MULTI", strings.ToUpper(kind))
+	}
+	subscribed := cli.subsOf(pattern)
+	if len(names) == 0 {
+		// 没有任何订阅时也回复一条确认
+		if len(subscribed) == 0 {
+			return respPush{kind, nil, cli.subscriptions()}, nil
+		}
+		for name := range subscribed {
+			names = append(names, []byte(name))
+		}
+		sort.Slice(names, func(i, j int) bool {
+			return string(names[i]) < string(names[j])
+		})
+	}
+
+	hub := cli.server.pubsub
+	replies := make(multiReply, 0, len(names))
+	for _, name := range names {
+		if _, ok := subscribed[string(name)]; ok {
+			hub.unsubscribe(hub.subsOf(pattern), string(name), cli)
+			delete(subscribed, string(name))
+		}
+		replies = append(replies, respPush{kind, name, cli.subscriptions()})
+	}
+	return replies, nil
+}
+
+// pubsubKind 回复中的类型，模式的订阅以p开头
+func pubsubKind(kind string, pattern bool) string {
+	if pattern {
+		return "p" + kind
+	}
+	return kind
+}
+
+func subscribe(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.subscribeAll(false, args)
+}
+
+func unsubscribe(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.unsubscribeAll(false, args)
+}
+
+func psubscribe(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.subscribeAll(true, args)
+}
+
+func punsubscribe(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.unsubscribeAll(true, args)
+}
+
+// publish 返回接收到消息的客户端数量
+func publish(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return redcon.SimpleInt(cli.server.pubsub.publish(string(args[0]), args[1])), nil
+}
+
+// pubsubCommand PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func pubsubCommand(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	hub := cli.server.pubsub
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "channels":
+		if len(args) > 2 {
+			return nil, newWrongNumberOfArgsError("pubsub|channels")
+		}
+		channels := make([]string, 0, len(hub.channels))
+		for channel := range hub.channels {
+			if len(args) == 1 || pkg.GlobMatch(args[1], []byte(channel)) {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Strings(channels)
+		return channels, nil
+	case "numsub":
+		result := make(respMap, 0, 2*(len(args)-1))
+		for _, channel := range args[1:] {
+			result = append(result, channel, redcon.SimpleInt(len(hub.channels[string(channel)])))
+		}
+		return result, nil
+	case "numpat":
+		if len(args) != 1 {
+			return nil, newWrongNumberOfArgsError("pubsub|numpat")
+		}
+		return redcon.SimpleInt(len(hub.patterns)), nil
+	default:
+		return nil, newUnknownSubcommandError("pubsub", sub)
+	}
+}
+
+// keyspaceEventClasses 支持的事件分类，A为全部分类的简写
+const keyspaceEventClasses = "g$lshzxt"
+
+// keyspaceEvents
+//
+//	@Description: notify-keyspace-events的配置，K发布到__keyspace@<db>__:<key>，E发布到__keyevent@<db>__:<event>
+type keyspaceEvents struct {
+	keyspace bool
+	keyevent bool
+	classes  string
+}
+
+// parseKeyspaceEvents 与redis一致解析notify-keyspace-events的取值，空字符串表示不通知
+func parseKeyspaceEvents(s string) (*keyspaceEvents, error) {
+	events := &keyspaceEvents{}
+	for _, c := range s {
+		switch {
+		case c == 'K':
+			events.keyspace = true
+		case c == 'E':
+			events.keyevent = true
+		case c == 'A':
+			events.classes = keyspaceEventClasses
+		case strings.ContainsRune(keyspaceEventClasses, c):
+			if !strings.ContainsRune(events.classes, c) {
+				events.classes += string(c)
+			}
+		default:
+			return nil, fmt.Errorf("ERR Invalid event class character '%c'", c)
+		}
+	}
+	return events, nil
+}
+
+// String 与redis CONFIG GET的格式一致，全部分类时以A表示
+func (e *keyspaceEvents) String() string {
+	var sb strings.Builder
+	if len(e.classes) == len(keyspaceEventClasses) {
+		sb.WriteByte('A')
+	} else {
+		for i := 0; i < len(keyspaceEventClasses); i++ {
+			if strings.IndexByte(e.classes, keyspaceEventClasses[i]) >= 0 {
+				sb.WriteByte(keyspaceEventClasses[i])
+			}
+		}
+	}
+	if e.keyspace {
+		sb.WriteByte('K')
+	}
+	if e.keyevent {
+		sb.WriteByte('E')
+	}
+	return sb.String()
+}
+
+// enabled 是否需要发布该分类的事件
+func (e *keyspaceEvents) enabled(class redis.EventClass) bool {
+	return (e.keyspace || e.keyevent) && strings.IndexByte(e.classes, byte(class)) >= 0
+}
+
+// setKeyspaceEvents 修改notify-keyspace-events的配置
+func (svr *BitcaskServer) setKeyspaceEvents(s string) error {
+	events, err := parseKeyspaceEvents(s)
+	if err != nil {
+		return err
+	}
+	svr.keyspaceEvents.Store(events)
+	return nil
+}
+
+// notifyKeyspaceEvent 按notify-keyspace-events的配置发布数据库中key的变更
+func (svr *BitcaskServer) notifyKeyspaceEvent(rds *redis.RedisDataStructure, class redis.EventClass, event string, key []byte) {
+	events, _ := svr.keyspaceEvents.Load().(*keyspaceEvents)
+	if events == nil || !events.enabled(class) || svr.pubsub.empty() {
+		return
+	}
+	index, ok := svr.indexOf(rds)
+	if !ok {
+		return
+	}
+	if events.keyspace {
+		svr.pubsub.publish(fmt.Sprintf("__keyspace@%d__:%s", index, key), []byte(event))
+	}
+	if events.keyevent {
+		svr.pubsub.publish(fmt.Sprintf("__keyevent@%d__:%s", index, event), key)
+	}
+}
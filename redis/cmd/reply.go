@@ -0,0 +1,248 @@
+package main
+
+import (
+	"github.com/tidwall/redcon"
+	"kv-db-lab/constant"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 回复的类型，RESP2下均编码为数组，RESP3下使用对应的类型
+type (
+	// respMap key与value交替排列
+	respMap []interface{}
+	// respSet 无序且不重复的集合
+	respSet []interface{}
+	// respNullArray RESP2下编码为 *-1
+	respNullArray struct{}
+	// respPair 成员及其score等两个元素的组合，RESP2下展开到外层数组中
+	respPair [2]interface{}
+	// respPush 订阅的确认以及推送的消息，RESP3下编码为push类型
+	respPush []interface{}
+	// multiReply 一条命令产生的多个回复，如订阅多个频道时每个频道一条确认，只能作为最外层的回复
+	multiReply []interface{}
+)
+
+// redisErrors redis层的错误与redis一致的错误信息
+var redisErrors = map[error]string{
+	constant.ErrWrongTypeOp:      "WRONGTYPE Operation against a key holding the wrong kind of value",
+	constant.ErrNotInteger:       "ERR value is not an integer or out of range",
+	constant.ErrNotFloat:         "ERR value is not a valid float",
+	constant.ErrIncrOverflow:     "ERR increment or decrement would overflow",
+	constant.ErrIndexOutOfRange:  "ERR index out of range",
+	constant.ErrInvalidExpire:    "ERR invalid expire time",
+	constant.ErrNotBit:           "ERR bit is not an integer or out of range",
+	constant.ErrStringTooLong:    "ERR string exceeds maximum allowed size (proto-max-bulk-len)",
+	constant.ErrEmptyParam:       "ERR syntax error",
+	constant.ErrInvalidStreamID:  "ERR Invalid stream ID specified as stream command argument",
+	constant.ErrStreamIDTooSmall: "ERR The ID specified in XADD is equal or smaller than the target stream top item",
+	constant.ErrGroupExists:      "BUSYGROUP Consumer Group name already exists",
+	constant.ErrNoGroup:          "NOGROUP No such key or consumer group",
+	constant.ErrInvalidGeo:       "ERR invalid longitude,latitude pair",
+	constant.ErrInvalidGeoUnit:   "ERR unsupported unit provided. please use M, KM, FT, MI",
+}
+
+// errorMessage 返回错误回复的内容，未登记的错误统一加上ERR前缀
+func errorMessage(err error) string {
+	if msg, ok := redisErrors[err]; ok {
+		return msg
+	}
+	msg := err.Error()
+	if prefix, _, _ := strings.Cut(msg, " "); isErrorCode(prefix) {
+		return msg
+	}
+	return "ERR " + msg
+}
+
+// isErrorCode 是否为 ERR WRONGTYPE 等由大写字母组成的错误码
+func isErrorCode(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// formatFloat 与redis一致，浮点数以最短的形式表示
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	}
+	if abs := math.Abs(v); abs != 0 && (abs < 1e-4 || abs >= 1e21) {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func appendNull(b []byte, resp3 bool) []byte {
+	if resp3 {
+		return append(b, "_\r\n"...)
+	}
+	return redcon.AppendNull(b)
+}
+
+func appendAggregate(b []byte, prefix byte, n int) []byte {
+	b = append(b, prefix)
+	b = strconv.AppendInt(b, int64(n), 10)
+	return append(b, '\r', '\n')
+}
+
+// appendReply
+//
+//	@Description: 按协议版本编码一个回复
+//	@param b
+//	@param v  整数类型编码为integer，float64在RESP2下编码为字符串
+//	@param resp3  是否为RESP3协议
+//	@return []byte
+func appendReply(b []byte, v interface{}, resp3 bool) []byte {
+	switch v := v.(type) {
+	case nil:
+		return appendNull(b, resp3)
+	case respNullArray:
+		if resp3 {
+			return appendNull(b, resp3)
+		}
+		return append(b, "*-1\r\n"...)
+	case error:
+		return redcon.AppendError(b, errorMessage(v))
+	case redcon.SimpleString:
+		return redcon.AppendString(b, string(v))
+	case redcon.SimpleInt:
+		return redcon.AppendInt(b, int64(v))
+	case int:
+		return redcon.AppendInt(b, int64(v))
+	case int64:
+		return redcon.AppendInt(b, v)
+	case uint32:
+		return redcon.AppendInt(b, int64(v))
+	case uint64:
+		return redcon.AppendUint(b, v)
+	case bool:
+		if resp3 {
+			if v {
+				return append(b, "#t\r\n"...)
+			}
+			return append(b, "#f\r\n"...)
+		}
+		if v {
+			return redcon.AppendInt(b, 1)
+		}
+		return redcon.AppendInt(b, 0)
+	case float64:
+		if resp3 {
+			b = append(b, ',')
+			b = append(b, formatFloat(v)...)
+			return append(b, '\r', '\n')
+		}
+		return redcon.AppendBulkString(b, formatFloat(v))
+	case string:
+		return redcon.AppendBulkString(b, v)
+	case []byte:
+		if v == nil {
+			return appendNull(b, resp3)
+		}
+		return redcon.AppendBulk(b, v)
+	case []string:
+		b = redcon.AppendArray(b, len(v))
+		for _, s := range v {
+			b = redcon.AppendBulkString(b, s)
+		}
+		return b
+	case [][]byte:
+		b = redcon.AppendArray(b, len(v))
+		for _, e := range v {
+			b = redcon.AppendBulk(b, e)
+		}
+		return b
+	case []interface{}:
+		return appendElements(redcon.AppendArray(b, countElements(v, resp3)), v, resp3)
+	case respSet:
+		if resp3 {
+			return appendElements(appendAggregate(b, '~', len(v)), v, resp3)
+		}
+		return appendElements(redcon.AppendArray(b, len(v)), v, resp3)
+	case respMap:
+		if resp3 {
+			return appendElements(appendAggregate(b, '%', len(v)/2), v, resp3)
+		}
+		return appendElements(redcon.AppendArray(b, len(v)), v, resp3)
+	case respPush:
+		if resp3 {
+			return appendElements(appendAggregate(b, '>', len(v)), v, resp3)
+		}
+		return appendElements(redcon.AppendArray(b, len(v)), v, resp3)
+	case multiReply:
+		for _, reply := range v {
+			b = appendReply(b, reply, resp3)
+		}
+		return b
+	case respPair:
+		// 只有作为数组的元素时才会展开，单独出现时视为两个元素的数组
+		return appendElements(redcon.AppendArray(b, 2), v[:], resp3)
+	default:
+		return redcon.AppendAny(b, v)
+	}
+}
+
+// countElements RESP2下respPair展开为两个元素
+func countElements(elements []interface{}, resp3 bool) int {
+	n := len(elements)
+	if resp3 {
+		return n
+	}
+	for _, e := range elements {
+		if _, ok := e.(respPair); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func appendElements(b []byte, elements []interface{}, resp3 bool) []byte {
+	for _, e := range elements {
+		if pair, ok := e.(respPair); ok && !resp3 {
+			b = appendReply(b, pair[0], resp3)
+			b = appendReply(b, pair[1], resp3)
+			continue
+		}
+		b = appendReply(b, e, resp3)
+	}
+	return b
+}
+
+// writeReply 按客户端协商的协议版本写入回复
+func writeReply(conn redcon.Conn, cli *BitcaskClient, v interface{}) {
+	conn.WriteRaw(appendReply(nil, v, cli.resp3()))
+}
+
+// bulkOrNil 值为nil时回复null，而不是空字符串
+func bulkOrNil(value []byte) interface{} {
+	if value == nil {
+		return nil
+	}
+	return value
+}
+
+// bulkArray 元素为nil时回复null
+func bulkArray(values [][]byte) []interface{} {
+	result := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		result = append(result, bulkOrNil(v))
+	}
+	return result
+}
+
+func boolInt(b bool) redcon.SimpleInt {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/tidwall/redcon"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =========================Lua脚本=============================================================
+// 脚本编译后以SHA1缓存，每次执行创建新的Lua虚拟机，只加载base、table、string、math库
+// 脚本与EXEC一样持有事务锁的写锁执行，写入暂存在事务的写批次中，执行结束后统一提交，期间不会与其他客户端的命令交错
+// 与redis一致，脚本出错时已执行的写入不会回滚；脚本中的阻塞命令不阻塞，事务与订阅相关的命令不能执行
+// 脚本执行期间其他命令都在等待，执行超过lua-time-limit或被SCRIPT KILL终止时放弃尚未提交的写入，在EXEC中执行时已执行的写入仍随EXEC提交
+// redis.call与redis.pcall通过命令表执行命令，回复按RESP2的类型转换为Lua的值
+
+var (
+	errNoScript             = errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	errNegativeNumKeys      = errors.New("ERR Number of keys can't be negative")
+	errTooManyKeys          = errors.New("ERR Number of keys can't be greater than number of args")
+	errScriptArgType        = errors.New("ERR Lua redis lib command arguments must be strings or integers")
+	errScriptNoArgs         = errors.New("ERR Please specify at least one argument for this redis lib call")
+	errUnknownScriptCommand = errors.New("ERR Unknown Redis command called from script")
+	errNoScriptCommand      = errors.New("ERR This Redis command is not allowed from script")
+	errScriptTimeout        = errors.New("BUSY Script exceeded lua-time-limit and was aborted")
+	errScriptKilled         = errors.New("ERR Script killed by user with SCRIPT KILL")
+	errNoScriptRunning      = errors.New("NOTBUSY No scripts in execution right now.")
+)
+
+func newCompileScriptError(err error) error {
+	return fmt.Errorf("ERR Error compiling script (new function): %s", strings.TrimSpace(err.Error()))
+}
+
+// scriptCache 以SHA1缓存编译后的脚本，并记录正在执行的脚本供SCRIPT KILL终止
+type scriptCache struct {
+	mu      sync.RWMutex
+	scripts map[string]*lua.FunctionProto
+
+	running context.CancelFunc // 正在执行的脚本，没有时为nil
+	killed  bool               // 正在执行的脚本是否被SCRIPT KILL终止
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{scripts: make(map[string]*lua.FunctionProto)}
+}
+
+func scriptSHA(body []byte) string {
+	sum := sha1.Sum(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// load 编译脚本并缓存，已缓存时直接返回
+func (c *scriptCache) load(body []byte) (string, *lua.FunctionProto, error) {
+	sha := scriptSHA(body)
+	if proto := c.get(sha); proto != nil {
+		return sha, proto, nil
+	}
+
+	chunk, err := parse.Parse(bytes.NewReader(body), "user_script")
+	if err != nil {
+		return "", nil, newCompileScriptError(err)
+	}
+	proto, err := lua.Compile(chunk, "user_script")
+	if err != nil {
+		return "", nil, newCompileScriptError(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts[sha] = proto
+	return sha, proto, nil
+}
+
+// get 按SHA1查找脚本，不区分大小写，不存在时返回nil
+func (c *scriptCache) get(sha string) *lua.FunctionProto {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.scripts[strings.ToLower(sha)]
+}
+
+func (c *scriptCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts = make(map[string]*lua.FunctionProto)
+}
+
+// start 登记一个开始执行的脚本，timeout为0时不限制执行时间，返回脚本使用的context与执行结束时调用的函数
+// 执行结束时返回脚本被终止的原因，未被终止时为nil
+func (c *scriptCache) start(timeout time.Duration) (context.Context, func() error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	}
+
+	c.mu.Lock()
+	c.running, c.killed = cancel, false
+	c.mu.Unlock()
+
+	return ctx, func() error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.running = nil
+		cancel()
+		if c.killed {
+			return errScriptKilled
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return errScriptTimeout
+		}
+		return nil
+	}
+}
+
+// kill 终止正在执行的脚本，没有脚本在执行时返回false
+func (c *scriptCache) kill() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running == nil {
+		return false
+	}
+	c.killed = true
+	c.running()
+	return true
+}
+
+// parseScriptArgs 解析 numkeys key... arg...，返回KEYS与ARGV
+func parseScriptArgs(args [][]byte) ([][]byte, [][]byte, error) {
+	numKeys, err := parseInt(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if numKeys < 0 {
+		return nil, nil, errNegativeNumKeys
+	}
+	if numKeys > int64(len(args)-1) {
+		return nil, nil, errTooManyKeys
+	}
+	return args[1 : 1+numKeys], args[1+numKeys:], nil
+}
+
+// evalKeys EVAL与EVALSHA的key位于numkeys之后
+func evalKeys(args [][]byte) [][]byte {
+	keys, _, err := parseScriptArgs(args[2:])
+	if err != nil {
+		return nil
+	}
+	return keys
+}
+
+// eval EVAL script numkeys key... arg...，脚本同时加入缓存
+func eval(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	keys, argv, err := parseScriptArgs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	_, proto, err := cli.server.scripts.load(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return cli.runScript(proto, keys, argv)
+}
+
+// evalsha EVALSHA sha1 numkeys key... arg...
+func evalsha(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	keys, argv, err := parseScriptArgs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	proto := cli.server.scripts.get(string(args[0]))
+	if proto == nil {
+		return nil, errNoScript
+	}
+	return cli.runScript(proto, keys, argv)
+}
+
+// scriptCommand SCRIPT LOAD script | EXISTS sha1... | FLUSH [ASYNC|SYNC] | KILL
+func scriptCommand(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	scripts := cli.server.scripts
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "load":
+		if len(args) != 2 {
+			return nil, newWrongNumberOfArgsError("script|load")
+		}
+		sha, _, err := scripts.load(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return sha, nil
+	case "exists":
+		if len(args) < 2 {
+			return nil, newWrongNumberOfArgsError("script|exists")
+		}
+		result := make([]interface{}, 0, len(args)-1)
+		for _, sha := range args[1:] {
+			result = append(result, boolInt(scripts.get(string(sha)) != nil))
+		}
+		return result, nil
+	case "flush":
+		if err := parseFlushMode(args[1:]); err != nil {
+			return nil, err
+		}
+		scripts.flush()
+		return redcon.SimpleString("OK"), nil
+	case "kill":
+		// SCRIPT不持有事务锁，脚本执行期间仍可以执行
+		if len(args) != 1 {
+			return nil, newWrongNumberOfArgsError("script|kill")
+		}
+		if !scripts.kill() {
+			return nil, errNoScriptRunning
+		}
+		return redcon.SimpleString("OK"), nil
+	default:
+		return nil, newUnknownSubcommandError("script", sub)
+	}
+}
+
+// runScript
+//
+//	@Description: 持有事务锁的写锁执行脚本并提交写入，在EXEC中执行时直接使用EXEC的事务
+//	@receiver cli
+//	@param proto  编译后的脚本
+//	@param keys  KEYS
+//	@param argv  ARGV
+//	@return interface{}
+//	@return error
+func (cli *BitcaskClient) runScript(proto *lua.FunctionProto, keys, argv [][]byte) (interface{}, error) {
+	if cli.tx != nil {
+		return cli.callScript(proto, keys, argv)
+	}
+
+	svr := cli.server
+	svr.txLock.Lock()
+	defer svr.txLock.Unlock()
+
+	cli.tx = newTransaction(svr)
+	defer func() {
+		cli.tx = nil
+	}()
+	res, err := cli.callScript(proto, keys, argv)
+	if err == errScriptTimeout || err == errScriptKilled {
+		return nil, err
+	}
+	// 脚本出错时已执行的写入同样提交
+	if commitErr := cli.tx.commit(); commitErr != nil {
+		return nil, commitErr
+	}
+	// 脚本的写入提交之后再为阻塞的客户端执行命令
+	svr.serveBlocked(func(int) bool { return true })
+	return res, err
+}
+
+// callScript 在新的Lua虚拟机中执行脚本，脚本中的SELECT不影响客户端选择的数据库
+// 执行超过lua-time-limit或被SCRIPT KILL终止时返回errScriptTimeout或errScriptKilled
+func (cli *BitcaskClient) callScript(proto *lua.FunctionProto, keys, argv [][]byte) (interface{}, error) {
+	defer func(index int) {
+		cli.dbIndex = index
+	}(cli.dbIndex)
+
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+	ctx, finish := cli.server.scripts.start(cli.server.luaTimeLimit())
+	L.SetContext(ctx)
+	openScriptLibs(L)
+	L.SetGlobal("KEYS", luaArray(L, keys))
+	L.SetGlobal("ARGV", luaArray(L, argv))
+	L.SetGlobal("redis", cli.redisLib(L))
+
+	L.Push(L.NewFunctionFromProto(proto))
+	err := L.PCall(0, 1, nil)
+	if stopped := finish(); stopped != nil {
+		return nil, stopped
+	}
+	if err != nil {
+		return nil, scriptError(err)
+	}
+	res := luaToReply(L.Get(-1))
+	if err, ok := res.(error); ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+// openScriptLibs 只加载不访问文件与系统的库
+func openScriptLibs(L *lua.LState) {
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+}
+
+// scriptError 脚本中未捕获的redis.call错误原样回复，其他错误加上ERR前缀
+func scriptError(err error) error {
+	if apiErr, ok := err.(*lua.ApiError); ok {
+		if tbl, ok := apiErr.Object.(*lua.LTable); ok {
+			if msg, ok := tbl.RawGetString("err").(lua.LString); ok {
+				return errors.New(string(msg))
+			}
+		}
+		return fmt.Errorf("ERR Error running script: %s", apiErr.Object.String())
+	}
+	return fmt.Errorf("ERR Error running script: %v", err)
+}
+
+func luaArray(L *lua.LState, values [][]byte) *lua.LTable {
+	tbl := L.CreateTable(len(values), 0)
+	for _, v := range values {
+		tbl.Append(lua.LString(v))
+	}
+	return tbl
+}
+
+// redisLib 脚本中的redis表
+func (cli *BitcaskClient) redisLib(L *lua.LState) *lua.LTable {
+	lib := L.NewTable()
+	L.SetFuncs(lib, map[string]lua.LGFunction{
+		"call": func(L *lua.LState) int {
+			return cli.luaCall(L, true)
+		},
+		"pcall": func(L *lua.LState) int {
+			return cli.luaCall(L, false)
+		},
+		"error_reply": func(L *lua.LState) int {
+			L.Push(luaReplyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(luaReplyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(scriptSHA([]byte(L.CheckString(1)))))
+			return 1
+		},
+	})
+	return lib
+}
+
+// luaReplyTable 状态回复与错误回复在Lua中表示为只有ok或err字段的表
+func luaReplyTable(L *lua.LState, field, msg string) *lua.LTable {
+	tbl := L.NewTable()
+	tbl.RawSetString(field, lua.LString(msg))
+	return tbl
+}
+
+// luaCall
+//
+//	@Description: redis.call与redis.pcall，命令出错时call抛出错误，pcall返回错误回复
+//	@receiver cli
+//	@param L
+//	@param raise  是否抛出错误
+//	@return int
+func (cli *BitcaskClient) luaCall(L *lua.LState, raise bool) int {
+	res, err := cli.scriptCall(L)
+	if err != nil {
+		reply := luaReplyTable(L, "err", errorMessage(err))
+		if raise {
+			L.Error(reply, 1)
+			return 0
+		}
+		L.Push(reply)
+		return 1
+	}
+	L.Push(replyToLua(L, res))
+	return 1
+}
+
+// scriptCall 以Lua函数的参数为命令执行，检查ACL权限，不检查集群与订阅状态
+func (cli *BitcaskClient) scriptCall(L *lua.LState) (interface{}, error) {
+	n := L.GetTop()
+	if n == 0 {
+		return nil, errScriptNoArgs
+	}
+	args := make([][]byte, 0, n)
+	for i := 1; i <= n; i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString:
+			args = append(args, []byte(v))
+		case lua.LNumber:
+			args = append(args, []byte(v.String()))
+		default:
+			return nil, errScriptArgType
+		}
+	}
+
+	command := strings.ToLower(string(args[0]))
+	redisCmd, ok := supportedCommands[command]
+	if !ok {
+		return nil, errUnknownScriptCommand
+	}
+	if redisCmd.hasFlag(flagNoScript) {
+		return nil, errNoScriptCommand
+	}
+	if !redisCmd.checkArity(args) {
+		return nil, newWrongNumberOfArgsError(command)
+	}
+	if err := cli.checkPermission(redisCmd, args); err != nil {
+		return nil, err
+	}
+	return cli.execute(redisCmd, args)
+}
+
+// replyToLua 命令的回复按RESP2编码后转换为Lua的值
+func replyToLua(L *lua.LState, res interface{}) lua.LValue {
+	_, resp := redcon.ReadNextRESP(appendReply(nil, res, false))
+	return respToLua(L, resp)
+}
+
+// respToLua 与redis一致，null转换为false，状态与错误转换为带ok或err字段的表
+func respToLua(L *lua.LState, resp redcon.RESP) lua.LValue {
+	switch resp.Type {
+	case redcon.Integer:
+		return lua.LNumber(resp.Int())
+	case redcon.String:
+		return luaReplyTable(L, "ok", resp.String())
+	case redcon.Error:
+		return luaReplyTable(L, "err", resp.String())
+	case redcon.Bulk:
+		if resp.Data == nil {
+			return lua.LFalse
+		}
+		return lua.LString(resp.Data)
+	case redcon.Array:
+		if resp.Count < 0 {
+			return lua.LFalse
+		}
+		tbl := L.CreateTable(resp.Count, 0)
+		resp.ForEach(func(e redcon.RESP) bool {
+			tbl.Append(respToLua(L, e))
+			return true
+		})
+		return tbl
+	}
+	return lua.LFalse
+}
+
+// luaToReply
+//
+//	@Description: 脚本的返回值转换为回复，数字截断为整数，false与nil回复null，true回复1
+//	表中有err字段时回复错误，有ok字段时回复状态，否则回复数组，遇到第一个nil截止
+//	@param v
+//	@return interface{}
+func luaToReply(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LString:
+		return []byte(v)
+	case lua.LNumber:
+		return int64(v)
+	case lua.LBool:
+		if v {
+			return int64(1)
+		}
+		return nil
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return errors.New(string(msg))
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			return redcon.SimpleString(msg)
+		}
+		result := make([]interface{}, 0, v.Len())
+		for i := 1; ; i++ {
+			e := v.RawGetInt(i)
+			if e == lua.LNil {
+				break
+			}
+			result = append(result, luaToReply(e))
+		}
+		return result
+	}
+	return nil
+}
+
+// luaTimeLimit 脚本的最长执行时间，为0时不限制
+func (svr *BitcaskServer) luaTimeLimit() time.Duration {
+	svr.configLock.RLock()
+	defer svr.configLock.RUnlock()
+	return time.Duration(svr.config.luaTimeLimit) * time.Millisecond
+}
@@ -1,62 +1,409 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"github.com/tidwall/redcon"
+	"kv-db-lab/cluster"
+	"kv-db-lab/constant"
 	"kv-db-lab/model"
 	"kv-db-lab/redis"
 	"kv-db-lab/storage"
 	"log"
+	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
-const addr = "127.0.0.1:6380"
-
 type BitcaskServer struct {
-	dbs    map[int]*redis.RedisDataStructure
-	server *redcon.Server
-	mu     sync.RWMutex
+	dbs       map[int]*redis.RedisDataStructure // 已打开的逻辑数据库，其余的在首次使用时打开
+	server    *redcon.Server
+	tlsServer *redcon.Server // 配置了tls-addr时处理TLS连接，否则为nil
+	node      *cluster.Node  // 集群模式下的raft节点，单机模式为nil
+	mu        sync.RWMutex
+
+	opts      model.Options // 0号数据库的引擎配置，其他数据库只替换目录
+	databases int
+
+	// config 启动时的配置，运行期间可以通过CONFIG SET修改其中的部分配置项
+	config     *serverConfig
+	configLock sync.RWMutex
+
+	clients      map[*BitcaskClient]struct{} // 已连接的客户端，由mu保护
+	shutdownOnce sync.Once
+
+	lastClientID int64 // 最近分配的客户端ID
+
+	// txLock 普通命令执行期间持有读锁，EXEC持有写锁，保证事务中的命令不与其他命令交错
+	txLock sync.RWMutex
+	// dbLocks 写命令执行期间持有所在数据库的锁，使读-改-写的命令依次执行，下标为数据库编号
+	dbLocks   []sync.Mutex
+	watchLock sync.Mutex
+	watchers  map[watchKey]map[*BitcaskClient]struct{} // WATCH了各个key的客户端
+
+	pubsub         *pubsubHub
+	keyspaceEvents atomic.Value // *keyspaceEvents
+
+	blocking *blockingKeys // BLPOP等阻塞命令等待的key
+	scripts  *scriptCache  // EVAL与SCRIPT LOAD加载的Lua脚本
+	acl      *aclUsers     // ACL用户，新连接以default用户的身份执行命令
+	cursors  *scanCursors  // SCAN返回的游标
+}
+
+// newBitcaskServer 打开0号数据库，其他数据库在首次使用时打开
+func newBitcaskServer(cfg *serverConfig) (*BitcaskServer, error) {
+	if cfg.databases < 1 {
+		return nil, constant.ErrEmptyParam
+	}
+	svr := &BitcaskServer{
+		dbs:       make(map[int]*redis.RedisDataStructure),
+		opts:      cfg.opts,
+		databases: cfg.databases,
+		dbLocks:   make([]sync.Mutex, cfg.databases),
+		config:    cfg,
+		clients:   make(map[*BitcaskClient]struct{}),
+		watchers:  make(map[watchKey]map[*BitcaskClient]struct{}),
+		pubsub:    newPubsubHub(),
+		blocking:  newBlockingKeys(),
+		scripts:   newScriptCache(),
+		cursors:   new(scanCursors),
+	}
+	if err := svr.setKeyspaceEvents(cfg.notifyKeyspaceEvents); err != nil {
+		return nil, err
+	}
+	// 与redis一致，配置了ACL文件时default用户的密码只能在文件中配置
+	if cfg.requirepass != "" && cfg.aclFile != "" {
+		return nil, errRequirePassFile
+	}
+	acl, err := newACLUsers(cfg.aclFile)
+	if err != nil {
+		return nil, err
+	}
+	svr.acl = acl
+	if cfg.requirepass != "" {
+		svr.acl.setRequirePass(cfg.requirepass)
+	}
+	if _, err := svr.openDB(0); err != nil {
+		return nil, err
+	}
+	return svr, nil
 }
 
 func main() {
+	cfg, err := parseConfig(os.Args[0], os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	// 打开 Redis 数据结构服务
-	opts := model.DefaultOptions
-	dir := "./../redis_test_file"
-	opts.DirPath = dir
-	db, err := storage.OpenWithOptions(opts)
-	redisDataStructure, err := redis.NewRedisDateStructure(db)
+	bitcaskServer, err := newBitcaskServer(cfg)
 	if err != nil {
-		panic(err)
+		log.Fatalln(err)
 	}
 
-	// 初始化 BitcaskServer
-	bitcaskServer := &BitcaskServer{
-		dbs: make(map[int]*redis.RedisDataStructure),
+	// 集群模式：写操作经raft复制，从节点将命令转发给leader，只有0号数据库参与复制
+	if cfg.nodeID != "" {
+		if err = bitcaskServer.startCluster(); err != nil {
+			bitcaskServer.shutdown()
+			log.Fatalln(err)
+		}
 	}
-	bitcaskServer.dbs[0] = redisDataStructure
 
-	// 初始化一个 Redis 服务端
-	bitcaskServer.server = redcon.NewServer(addr, execClientCommand, bitcaskServer.accept, bitcaskServer.close)
-	bitcaskServer.listen()
-}
+	// 初始化一个 Redis 服务端，收到SIGINT或SIGTERM后停止服务
+	bitcaskServer.server = redcon.NewServer(cfg.addr, execClientCommand, bitcaskServer.accept, bitcaskServer.close)
+	bitcaskServer.server.SetIdleClose(cfg.timeout)
+	if cfg.tlsAddr != "" {
+		addr, err := bitcaskServer.startTLS()
+		if err != nil {
+			bitcaskServer.shutdown()
+			log.Fatalln(err)
+		}
+		log.Println("accepting tls connections on", addr)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("received %s, shutting down", sig)
+		bitcaskServer.shutdown()
+	}()
 
-func (svr *BitcaskServer) listen() {
 	log.Println("bitcask server running, ready to accept connections.")
-	_ = svr.server.ListenAndServe()
+	err = bitcaskServer.server.ListenAndServe()
+	// 监听失败时同样需要关闭已打开的数据库，被信号停止时等待shutdown完成
+	bitcaskServer.shutdown()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("bitcask server stopped.")
+}
+
+// startCluster 启动raft节点，指定了join时加入已有的集群
+func (svr *BitcaskServer) startCluster() error {
+	cfg := svr.config
+	clusterOpts := *model.DefaultClusterOptions
+	clusterOpts.NodeID = cfg.nodeID
+	clusterOpts.RaftAddr = cfg.raftAddr
+	clusterOpts.RaftDir = cfg.raftDir
+	clusterOpts.Bootstrap = cfg.bootstrap
+	clusterOpts.Meta = map[string]string{constant.ClusterMetaRedis: cfg.addr}
+	node, err := cluster.NewNode(svr.dbs[0].Engine(), &clusterOpts)
+	if err != nil {
+		return err
+	}
+	svr.node = node
+	if cfg.join != "" {
+		if err = svr.joinCluster(node.RaftAddr()); err != nil {
+			return err
+		}
+	}
+	if err = node.WaitLeader(10 * time.Second); err != nil {
+		log.Println("cluster has no leader yet:", err)
+	}
+	return nil
+}
+
+// dbDir 返回逻辑数据库的目录，0号数据库使用配置的目录
+func (svr *BitcaskServer) dbDir(index int) string {
+	if index == 0 {
+		return svr.opts.DirPath
+	}
+	return fmt.Sprintf("%s-db%d", svr.opts.DirPath, index)
 }
 
+// openDB 打开逻辑数据库，调用方需持有写锁或处于初始化阶段
+func (svr *BitcaskServer) openDB(index int) (*redis.RedisDataStructure, error) {
+	if rds, ok := svr.dbs[index]; ok {
+		return rds, nil
+	}
+	opts := svr.opts
+	opts.DirPath = svr.dbDir(index)
+	db, err := storage.OpenWithOptions(&opts)
+	if err != nil {
+		return nil, err
+	}
+	rds, err := redis.NewRedisDateStructure(db)
+	if err != nil {
+		return nil, err
+	}
+	rds.SetNotifier(func(class redis.EventClass, event string, key []byte) {
+		svr.keyChanged(rds, class, event, key)
+	})
+	svr.dbs[index] = rds
+	return rds, nil
+}
+
+// keyChanged 数据库中的key被修改，唤醒阻塞在该key上的客户端并发布keyspace通知
+// 后台删除过期的key时不经过命令，由这里使WATCH了该key的事务失效
+func (svr *BitcaskServer) keyChanged(rds *redis.RedisDataStructure, class redis.EventClass, event string, key []byte) {
+	if index, ok := svr.indexOf(rds); ok {
+		if class == redis.EventExpired {
+			svr.touchKeys(index, [][]byte{key})
+		}
+		if !svr.blocking.empty() {
+			svr.blocking.signal(index, key)
+		}
+	}
+	svr.notifyKeyspaceEvent(rds, class, event, key)
+}
+
+// indexOf 返回数据库当前的编号，SWAPDB之后编号随之交换
+func (svr *BitcaskServer) indexOf(rds *redis.RedisDataStructure) (int, bool) {
+	svr.mu.RLock()
+	defer svr.mu.RUnlock()
+	for i, db := range svr.dbs {
+		if db == rds {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// database 返回逻辑数据库，未打开时打开
+func (svr *BitcaskServer) database(index int) (*redis.RedisDataStructure, error) {
+	if index < 0 || index >= svr.databases {
+		return nil, errDBIndexOutOfRange
+	}
+	svr.mu.RLock()
+	rds, ok := svr.dbs[index]
+	svr.mu.RUnlock()
+	if ok {
+		return rds, nil
+	}
+
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	return svr.openDB(index)
+}
+
+// lockWrite
+//
+//	@Description: 持有写命令所在数据库的锁，返回释放锁的函数，调用方需已持有txLock的读锁
+//	SWAPDB、FLUSHALL与MOVE会访问其他数据库，按编号顺序持有所有数据库的锁
+//	@receiver svr
+//	@param redisCmd
+//	@param index  客户端当前选择的数据库
+//	@return func()
+func (svr *BitcaskServer) lockWrite(redisCmd *redisCommand, index int) func() {
+	if locksAllDBs(redisCmd) {
+		for i := range svr.dbLocks {
+			svr.dbLocks[i].Lock()
+		}
+		return func() {
+			for i := range svr.dbLocks {
+				svr.dbLocks[i].Unlock()
+			}
+		}
+	}
+	svr.dbLocks[index].Lock()
+	return svr.dbLocks[index].Unlock
+}
+
+// locksAllDBs 执行期间需要持有所有数据库的锁的写命令
+func locksAllDBs(redisCmd *redisCommand) bool {
+	switch redisCmd.name {
+	case "swapdb", "flushall", "move":
+		return true
+	}
+	return false
+}
+
+// swapDB 交换两个逻辑数据库，所有客户端立即看到交换后的数据
+func (svr *BitcaskServer) swapDB(i, j int) error {
+	if i < 0 || i >= svr.databases || j < 0 || j >= svr.databases {
+		return errDBIndexOutOfRange
+	}
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	a, err := svr.openDB(i)
+	if err != nil {
+		return err
+	}
+	b, err := svr.openDB(j)
+	if err != nil {
+		return err
+	}
+	svr.dbs[i], svr.dbs[j] = b, a
+	return nil
+}
+
+// existingDatabases 返回已打开或已有数据目录的逻辑数据库
+func (svr *BitcaskServer) existingDatabases() ([]*redis.RedisDataStructure, error) {
+	var result []*redis.RedisDataStructure
+	for i := 0; i < svr.databases; i++ {
+		svr.mu.RLock()
+		_, ok := svr.dbs[i]
+		svr.mu.RUnlock()
+		if !ok {
+			if _, err := os.Stat(svr.dbDir(i)); err != nil {
+				continue
+			}
+		}
+		rds, err := svr.database(i)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rds)
+	}
+	return result, nil
+}
+
+// shutdown
+//
+//	@Description: 停止服务，只执行一次，并发调用时等待第一次调用完成
+//	停止监听并断开所有连接，唤醒阻塞的客户端，等待连接上正在执行的命令结束后关闭数据库
+func (svr *BitcaskServer) shutdown() {
+	svr.shutdownOnce.Do(func() {
+		if svr.server != nil {
+			_ = svr.server.Close()
+		}
+		if svr.tlsServer != nil {
+			_ = svr.tlsServer.Close()
+		}
+		svr.pubsub.closeDetached()
+		svr.blocking.close()
+
+		svr.configLock.RLock()
+		timeout := svr.config.shutdownTimeout
+		svr.configLock.RUnlock()
+		if !svr.waitClients(timeout) {
+			log.Println("shutdown timeout, some clients are still connected")
+		}
+		// 持有事务锁的写锁后不再有命令在执行，之后也不会再释放
+		svr.txLock.Lock()
+
+		if svr.node != nil {
+			_ = svr.node.Close()
+		}
+		svr.mu.Lock()
+		defer svr.mu.Unlock()
+		for _, db := range svr.dbs {
+			_ = db.Close()
+		}
+	})
+}
+
+// waitClients 等待所有客户端断开，超时返回false
+func (svr *BitcaskServer) waitClients(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		svr.mu.RLock()
+		n := len(svr.clients)
+		svr.mu.RUnlock()
+		if n == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// removeClient 客户端断开后不再计入连接数
+func (svr *BitcaskServer) removeClient(cli *BitcaskClient) {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	delete(svr.clients, cli)
+}
+
+// accept 连接数达到maxclients时回复错误并断开
 func (svr *BitcaskServer) accept(conn redcon.Conn) bool {
+	maxClients := svr.maxClients()
 	cli := new(BitcaskClient)
 	svr.mu.Lock()
 	defer svr.mu.Unlock()
+	if len(svr.clients) >= maxClients {
+		conn.WriteError("ERR max number of clients reached")
+		return false
+	}
+	svr.clients[cli] = struct{}{}
 	cli.server = svr
 	cli.db = svr.dbs[0]
+	cli.dbIndex = 0
+	cli.proto = 2
+	cli.user = defaultUserName
+	cli.authenticated = svr.acl.autoLogin()
+	cli.netConn = conn.NetConn()
+	svr.lastClientID++
+	cli.id = svr.lastClientID
 	conn.SetContext(cli)
 	return true
 }
 
+// close 连接断开时只释放该连接的资源，数据库在服务停止时关闭
+// 脱离redcon的连接同样会回调，其资源在serveDetached退出时释放
 func (svr *BitcaskServer) close(conn redcon.Conn, err error) {
-	for _, db := range svr.dbs {
-		_ = db.Close()
+	if cli, ok := conn.Context().(*BitcaskClient); ok && !cli.detached {
+		cli.closeLeaderConn()
+		svr.unwatch(cli)
+		svr.removeClient(cli)
 	}
 }
 
@@ -0,0 +1,1458 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/redcon"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startTestServer 在随机端口启动服务，返回连接到该服务的客户端
+func startTestServer(t *testing.T) redigo.Conn {
+	conn, err := redigo.Dial("tcp", startTestServerAddr(t))
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+// startTestServerAddr 在随机端口启动服务，返回监听的地址
+func startTestServerAddr(t *testing.T) string {
+	_, addr := newTestServer(t)
+	return addr
+}
+
+// newTestServer 在随机端口启动服务，返回服务端以及监听的地址
+func newTestServer(t *testing.T) (*BitcaskServer, string) {
+	return newTestServerWithConfig(t, nil)
+}
+
+// newTestServerWithConfig 启动服务之前由configure修改配置，配置了node-id时以集群模式启动
+func newTestServerWithConfig(t *testing.T, configure func(cfg *serverConfig)) (*BitcaskServer, string) {
+	dir, err := os.MkdirTemp("", "bitcask-redis-server")
+	assert.Nil(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	cfg := defaultServerConfig()
+	cfg.addr = ln.Addr().String()
+	cfg.opts.DirPath = dir
+	cfg.raftDir = dir + "-raft"
+	if configure != nil {
+		configure(cfg)
+	}
+	svr, err := newBitcaskServer(cfg)
+	assert.Nil(t, err)
+	if cfg.nodeID != "" {
+		assert.Nil(t, svr.startCluster())
+	}
+
+	svr.server = redcon.NewServer(ln.Addr().String(), execClientCommand, svr.accept, svr.close)
+	go func() {
+		_ = svr.server.Serve(ln)
+	}()
+
+	t.Cleanup(func() {
+		svr.shutdown()
+		for i := 0; i < svr.databases; i++ {
+			_ = os.RemoveAll(svr.dbDir(i))
+		}
+		_ = os.RemoveAll(cfg.raftDir)
+	})
+	return svr, ln.Addr().String()
+}
+
+func TestServer_Command(t *testing.T) {
+	conn := startTestServer(t)
+
+	n, err := redigo.Int(conn.Do("COMMAND", "COUNT"))
+	assert.Nil(t, err)
+	assert.Equal(t, len(commandTable), n)
+
+	all, err := redigo.Values(conn.Do("COMMAND"))
+	assert.Nil(t, err)
+	assert.Equal(t, len(commandTable), len(all))
+
+	names, err := redigo.Strings(conn.Do("COMMAND", "LIST"))
+	assert.Nil(t, err)
+	assert.Contains(t, names, "xreadgroup")
+	assert.Contains(t, names, "geosearch")
+
+	infos, err := redigo.Values(conn.Do("COMMAND", "INFO", "get", "not-exist", "MSET"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(infos))
+	get, err := redigo.Values(infos[0], nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, len(get))
+	assert.Equal(t, "get", string(get[0].([]byte)))
+	assert.Equal(t, int64(2), get[1])
+	flags, _ := redigo.Strings(get[2], nil)
+	assert.Equal(t, []string{flagReadonly, flagFast}, flags)
+	assert.Equal(t, []interface{}{int64(1), int64(1), int64(1)}, get[3:6])
+	assert.Nil(t, infos[1])
+	mset, _ := redigo.Values(infos[2], nil)
+	assert.Equal(t, []interface{}{int64(1), int64(-1), int64(2)}, mset[3:6])
+
+	keys, err := redigo.Strings(conn.Do("COMMAND", "GETKEYS", "MSET", "a", "1", "b", "2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, keys)
+	keys, err = redigo.Strings(conn.Do("COMMAND", "GETKEYS", "XREADGROUP", "GROUP", "g", "c", "COUNT", "1", "STREAMS", "s1", "s2", ">", ">"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"s1", "s2"}, keys)
+	keys, err = redigo.Strings(conn.Do("COMMAND", "GETKEYS", "XGROUP", "CREATE", "s", "g", "$"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"s"}, keys)
+	_, err = conn.Do("COMMAND", "GETKEYS", "PING")
+	assert.NotNil(t, err)
+
+	// 参数个数不对与不支持的命令
+	_, err = conn.Do("GET")
+	assert.NotNil(t, err)
+	_, err = conn.Do("SET", "k")
+	assert.NotNil(t, err)
+	_, err = conn.Do("NOT-A-COMMAND")
+	assert.NotNil(t, err)
+	_, err = conn.Do("COMMAND", "FOO")
+	assert.NotNil(t, err)
+}
+
+func TestServer_Generic(t *testing.T) {
+	conn := startTestServer(t)
+
+	pong, err := redigo.String(conn.Do("PING"))
+	assert.Nil(t, err)
+	assert.Equal(t, "PONG", pong)
+	echo, err := redigo.String(conn.Do("ECHO", "hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", echo)
+
+	_, err = conn.Do("SET", "k1", "v1")
+	assert.Nil(t, err)
+	_, err = conn.Do("HSET", "h1", "f", "v")
+	assert.Nil(t, err)
+
+	n, err := redigo.Int(conn.Do("EXISTS", "k1", "h1", "k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	typ, err := redigo.String(conn.Do("TYPE", "h1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hash", typ)
+	typ, err = redigo.String(conn.Do("TYPE", "k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, "none", typ)
+
+	ok, err := redigo.Bool(conn.Do("EXPIRE", "k1", 100))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ttl, err := redigo.Int(conn.Do("TTL", "k1"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= 100)
+	ok, err = redigo.Bool(conn.Do("PERSIST", "k1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ttl, err = redigo.Int(conn.Do("TTL", "k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, -1, ttl)
+
+	_, err = conn.Do("RENAME", "k1", "k3")
+	assert.Nil(t, err)
+	keys, err := redigo.Strings(conn.Do("KEYS", "*"))
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"k3", "h1"}, keys)
+
+	// SCAN的游标对应上次遍历到的key
+	var scanned []string
+	cursor := "0"
+	for {
+		reply, err := redigo.Values(conn.Do("SCAN", cursor, "COUNT", 1))
+		assert.Nil(t, err)
+		cursor, _ = redigo.String(reply[0], nil)
+		keys, _ := redigo.Strings(reply[1], nil)
+		scanned = append(scanned, keys...)
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(t, []string{"h1", "k3"}, scanned)
+	_, err = conn.Do("SCAN", "12345")
+	assert.EqualError(t, err, "ERR invalid cursor")
+
+	n, err = redigo.Int(conn.Do("DEL", "k3", "h1", "k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	v, err := conn.Do("GET", "k3")
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+}
+
+func TestServer_String(t *testing.T) {
+	conn := startTestServer(t)
+
+	_, err := conn.Do("MSET", "a", "1", "b", "2")
+	assert.Nil(t, err)
+	values, err := redigo.Values(conn.Do("MGET", "a", "x", "b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{[]byte("1"), nil, []byte("2")}, values)
+
+	n, err := redigo.Int(conn.Do("INCRBY", "a", 10))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, n)
+	f, err := redigo.Float64(conn.Do("INCRBYFLOAT", "b", "0.5"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2.5, f)
+	_, err = conn.Do("INCR", "b")
+	assert.NotNil(t, err)
+
+	n, err = redigo.Int(conn.Do("APPEND", "s", "hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	s, err := redigo.String(conn.Do("GETRANGE", "s", 1, 3))
+	assert.Nil(t, err)
+	assert.Equal(t, "ell", s)
+
+	ok, err := redigo.Bool(conn.Do("SETNX", "s", "x"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	old, err := redigo.String(conn.Do("GETDEL", "s"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", old)
+
+	n, err = redigo.Int(conn.Do("SETBIT", "bits", 7, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	n, err = redigo.Int(conn.Do("BITCOUNT", "bits"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	n, err = redigo.Int(conn.Do("BITPOS", "bits", 1))
+	assert.Nil(t, err)
+	assert.Equal(t, 7, n)
+
+	ok, err = redigo.Bool(conn.Do("PFADD", "hll", "a", "b", "c"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	n, err = redigo.Int(conn.Do("PFCOUNT", "hll"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func TestServer_Hash(t *testing.T) {
+	conn := startTestServer(t)
+
+	n, err := redigo.Int(conn.Do("HSET", "h", "f1", "v1", "f2", "v2"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	v, err := redigo.String(conn.Do("HGET", "h", "f1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", v)
+	values, err := redigo.Values(conn.Do("HMGET", "h", "f2", "f3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{[]byte("v2"), nil}, values)
+
+	all, err := redigo.StringMap(conn.Do("HGETALL", "h"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"f1": "v1", "f2": "v2"}, all)
+
+	n, err = redigo.Int(conn.Do("HINCRBY", "h", "cnt", 3))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	n, err = redigo.Int(conn.Do("HDEL", "h", "f1", "f3"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	n, err = redigo.Int(conn.Do("HLEN", "h"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	ok, err := redigo.Bool(conn.Do("HEXISTS", "h", "f1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestServer_List(t *testing.T) {
+	conn := startTestServer(t)
+
+	n, err := redigo.Int(conn.Do("RPUSH", "l", "a", "b", "c"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	n, err = redigo.Int(conn.Do("LPUSH", "l", "z"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	values, err := redigo.Strings(conn.Do("LRANGE", "l", 0, -1))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"z", "a", "b", "c"}, values)
+
+	v, err := redigo.String(conn.Do("LPOP", "l"))
+	assert.Nil(t, err)
+	assert.Equal(t, "z", v)
+	v, err = redigo.String(conn.Do("RPOP", "l"))
+	assert.Nil(t, err)
+	assert.Equal(t, "c", v)
+	v, err = redigo.String(conn.Do("LMOVE", "l", "l2", "LEFT", "RIGHT"))
+	assert.Nil(t, err)
+	assert.Equal(t, "a", v)
+	n, err = redigo.Int(conn.Do("LLEN", "l"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	reply, err := conn.Do("LPOP", "not-exist")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+}
+
+func TestServer_Set(t *testing.T) {
+	conn := startTestServer(t)
+
+	n, err := redigo.Int(conn.Do("SADD", "s1", "a", "b", "c", "a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	_, err = conn.Do("SADD", "s2", "b", "c", "d")
+	assert.Nil(t, err)
+
+	ok, err := redigo.Bool(conn.Do("SISMEMBER", "s1", "a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	inter, err := redigo.Strings(conn.Do("SINTER", "s1", "s2"))
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"b", "c"}, inter)
+	n, err = redigo.Int(conn.Do("SUNIONSTORE", "s3", "s1", "s2"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+
+	n, err = redigo.Int(conn.Do("SREM", "s1", "a", "x"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	n, err = redigo.Int(conn.Do("SCARD", "s1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestServer_ZSet(t *testing.T) {
+	conn := startTestServer(t)
+
+	n, err := redigo.Int(conn.Do("ZADD", "z", 1, "a", 2, "b", 3, "c"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	score, err := redigo.Float64(conn.Do("ZSCORE", "z", "b"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(2), score)
+	reply, err := conn.Do("ZSCORE", "z", "x")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+
+	members, err := redigo.Strings(conn.Do("ZRANGE", "z", 0, -1, "WITHSCORES"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "1", "b", "2", "c", "3"}, members)
+	members, err = redigo.Strings(conn.Do("ZRANGEBYSCORE", "z", "(1", "+inf", "LIMIT", 0, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b"}, members)
+	n, err = redigo.Int(conn.Do("ZCOUNT", "z", "-inf", "(3"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	n, err = redigo.Int(conn.Do("ZRANK", "z", "c"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = redigo.Int(conn.Do("ZREM", "z", "a", "x"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	members, err = redigo.Strings(conn.Do("ZPOPMAX", "z"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c", "3"}, members)
+}
+
+func TestServer_Geo(t *testing.T) {
+	conn := startTestServer(t)
+
+	n, err := redigo.Int(conn.Do("GEOADD", "sicily", 13.361389, 38.115556, "Palermo", 15.087269, 37.502669, "Catania"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	dist, err := redigo.Float64(conn.Do("GEODIST", "sicily", "Palermo", "Catania", "km"))
+	assert.Nil(t, err)
+	assert.InDelta(t, 166.2742, dist, 0.01)
+
+	positions, err := redigo.Values(conn.Do("GEOPOS", "sicily", "Palermo", "NonExisting"))
+	assert.Nil(t, err)
+	pos, err := redigo.Float64s(positions[0], nil)
+	assert.Nil(t, err)
+	assert.InDelta(t, 13.361389, pos[0], 0.0001)
+	assert.Nil(t, positions[1])
+
+	hashes, err := redigo.Strings(conn.Do("GEOHASH", "sicily", "Palermo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sqc8b49rny0"}, hashes)
+
+	members, err := redigo.Strings(conn.Do("GEOSEARCH", "sicily", "FROMLONLAT", 15, 37, "BYRADIUS", 200, "km", "ASC"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Catania", "Palermo"}, members)
+	results, err := redigo.Values(conn.Do("GEOSEARCH", "sicily", "FROMMEMBER", "Palermo", "BYBOX", 100, 100, "km", "WITHDIST"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results))
+	item, _ := redigo.Strings(results[0], nil)
+	assert.Equal(t, []string{"Palermo", "0.0000"}, item)
+}
+
+func TestServer_Stream(t *testing.T) {
+	conn := startTestServer(t)
+
+	id, err := redigo.String(conn.Do("XADD", "s", "1-1", "f", "v1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "1-1", id)
+	_, err = conn.Do("XADD", "s", "MAXLEN", "=", 10, "2-1", "f", "v2")
+	assert.Nil(t, err)
+	reply, err := conn.Do("XADD", "not-exist", "NOMKSTREAM", "*", "f", "v")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+
+	n, err := redigo.Int(conn.Do("XLEN", "s"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	entries, err := redigo.Values(conn.Do("XRANGE", "s", "-", "+", "COUNT", 1))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	entry, _ := redigo.Values(entries[0], nil)
+	assert.Equal(t, "1-1", string(entry[0].([]byte)))
+	fields, _ := redigo.Strings(entry[1], nil)
+	assert.Equal(t, []string{"f", "v1"}, fields)
+
+	_, err = conn.Do("XGROUP", "CREATE", "s", "g", "0")
+	assert.Nil(t, err)
+	_, err = conn.Do("XGROUP", "CREATE", "s", "g", "0")
+	assert.NotNil(t, err)
+	streams, err := redigo.Values(conn.Do("XREADGROUP", "GROUP", "g", "c1", "COUNT", 10, "STREAMS", "s", ">"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(streams))
+	stream, _ := redigo.Values(streams[0], nil)
+	assert.Equal(t, "s", string(stream[0].([]byte)))
+	read, _ := redigo.Values(stream[1], nil)
+	assert.Equal(t, 2, len(read))
+	reply, err = conn.Do("XREADGROUP", "GROUP", "g", "c1", "STREAMS", "s", ">")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+
+	summary, err := redigo.Values(conn.Do("XPENDING", "s", "g"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), summary[0])
+	assert.Equal(t, "1-1", string(summary[1].([]byte)))
+	n, err = redigo.Int(conn.Do("XACK", "s", "g", "1-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	pending, err := redigo.Values(conn.Do("XPENDING", "s", "g", "-", "+", 10, "c1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pending))
+	detail, _ := redigo.Values(pending[0], nil)
+	assert.Equal(t, "2-1", string(detail[0].([]byte)))
+	assert.Equal(t, int64(1), detail[3])
+
+	n, err = redigo.Int(conn.Do("XTRIM", "s", "MINID", "2"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	n, err = redigo.Int(conn.Do("XDEL", "s", "2-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestServer_Select(t *testing.T) {
+	addr := startTestServerAddr(t)
+	conn1, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn1.Close()
+	conn2, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn2.Close()
+
+	// 选择的数据库只对当前连接生效
+	_, err = conn1.Do("SELECT", 3)
+	assert.Nil(t, err)
+	_, err = conn1.Do("SET", "k", "v3")
+	assert.Nil(t, err)
+	reply, err := conn2.Do("GET", "k")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+
+	// swapdb 后其他连接立即看到交换后的数据
+	_, err = conn2.Do("SWAPDB", 0, 3)
+	assert.Nil(t, err)
+	v, err := redigo.String(conn2.Do("GET", "k"))
+	assert.Nil(t, err)
+	assert.Equal(t, "v3", v)
+	reply, err = conn1.Do("GET", "k")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+
+	// flushall 清空所有数据库
+	_, err = conn1.Do("FLUSHALL")
+	assert.Nil(t, err)
+	n, err := redigo.Int(conn2.Do("DBSIZE"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestServer_Watch(t *testing.T) {
+	addr := startTestServerAddr(t)
+	conn1, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn1.Close()
+	conn2, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn2.Close()
+
+	_, err = conn1.Do("SET", "counter", 1)
+	assert.Nil(t, err)
+
+	// 其他连接修改WATCH的key后EXEC放弃执行
+	_, err = conn1.Do("WATCH", "counter")
+	assert.Nil(t, err)
+	_, err = conn2.Do("INCR", "counter")
+	assert.Nil(t, err)
+	_, err = conn1.Do("MULTI")
+	assert.Nil(t, err)
+	_, err = conn1.Do("INCRBY", "counter", 10)
+	assert.Nil(t, err)
+	reply, err := conn1.Do("EXEC")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+
+	// 修改其他数据库中的同名key不影响
+	_, err = conn1.Do("WATCH", "counter")
+	assert.Nil(t, err)
+	_, err = conn2.Do("SELECT", 1)
+	assert.Nil(t, err)
+	_, err = conn2.Do("SET", "counter", 100)
+	assert.Nil(t, err)
+	_, err = conn1.Do("MULTI")
+	assert.Nil(t, err)
+	_, err = conn1.Do("INCRBY", "counter", 10)
+	assert.Nil(t, err)
+	values, err := redigo.Values(conn1.Do("EXEC"))
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{int64(12)}, values)
+
+	// FLUSHDB使WATCH了该数据库的事务失效
+	_, err = conn2.Do("WATCH", "counter")
+	assert.Nil(t, err)
+	_, err = conn1.Do("SELECT", 1)
+	assert.Nil(t, err)
+	_, err = conn1.Do("FLUSHDB")
+	assert.Nil(t, err)
+	_, err = conn2.Do("MULTI")
+	assert.Nil(t, err)
+	_, err = conn2.Do("GET", "counter")
+	assert.Nil(t, err)
+	reply, err = conn2.Do("EXEC")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+}
+
+func TestServer_TransactionEvents(t *testing.T) {
+	svr, addr := newTestServer(t)
+	assert.Nil(t, svr.setKeyspaceEvents("KA"))
+	conn, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// 后台删除过期的key同样使WATCH的事务失效
+	_, err = conn.Do("SET", "temp", "v", "PX", 50)
+	assert.Nil(t, err)
+	_, err = conn.Do("WATCH", "temp")
+	assert.Nil(t, err)
+	db, err := svr.database(0)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return db.Stat().ExpiredKeys == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	_, err = conn.Do("MULTI")
+	assert.Nil(t, err)
+	_, err = conn.Do("SET", "other", "v")
+	assert.Nil(t, err)
+	reply, err := conn.Do("EXEC")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+
+	c, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	sub := redigo.PubSubConn{Conn: c}
+	defer sub.Close()
+	assert.Nil(t, sub.PSubscribe("__keyspace@0__:*"))
+	assert.Equal(t, redigo.Subscription{Kind: "psubscribe", Channel: "__keyspace@0__:*", Count: 1}, sub.Receive())
+
+	// 提交之后发布事务中的通知
+	_, err = conn.Do("MULTI")
+	assert.Nil(t, err)
+	_, err = conn.Do("SET", "a", "v")
+	assert.Nil(t, err)
+	_, err = conn.Do("EXEC")
+	assert.Nil(t, err)
+	assert.Equal(t, redigo.Message{Channel: "__keyspace@0__:a", Pattern: "__keyspace@0__:*", Data: []byte("set")}, sub.Receive())
+
+	// 提交失败的写入不发布通知
+	svr.txLock.Lock()
+	tx := newTransaction(svr)
+	assert.Nil(t, tx.view(db).Set([]byte("failed"), []byte("v"), 0))
+	assert.Nil(t, tx.batches[0].Expect([]byte("failed"), []byte("other")))
+	assert.Equal(t, constant.ErrConditionFailed, tx.commit())
+	svr.txLock.Unlock()
+	_, err = conn.Do("SET", "marker", "v")
+	assert.Nil(t, err)
+	assert.Equal(t, redigo.Message{Channel: "__keyspace@0__:marker", Pattern: "__keyspace@0__:*", Data: []byte("set")}, sub.Receive())
+}
+
+func TestServer_PubSub(t *testing.T) {
+	svr, addr := newTestServer(t)
+	assert.Nil(t, svr.setKeyspaceEvents("Kg$"))
+	pub, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer pub.Close()
+	c, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	sub := redigo.PubSubConn{Conn: c}
+	defer sub.Close()
+
+	assert.Nil(t, sub.Subscribe("news"))
+	assert.Nil(t, sub.PSubscribe("__keyspace@0__:*"))
+	assert.Equal(t, redigo.Subscription{Kind: "subscribe", Channel: "news", Count: 1}, sub.Receive())
+	assert.Equal(t, redigo.Subscription{Kind: "psubscribe", Channel: "__keyspace@0__:*", Count: 2}, sub.Receive())
+
+	n, err := redigo.Int(pub.Do("PUBLISH", "news", "hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, redigo.Message{Channel: "news", Data: []byte("hello")}, sub.Receive())
+	n, err = redigo.Int(pub.Do("PUBLISH", "other", "hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// 只通知配置中的分类
+	_, err = pub.Do("SET", "k", "v")
+	assert.Nil(t, err)
+	_, err = pub.Do("LPUSH", "list", "a")
+	assert.Nil(t, err)
+	_, err = pub.Do("DEL", "k")
+	assert.Nil(t, err)
+	assert.Equal(t, redigo.Message{Channel: "__keyspace@0__:k", Pattern: "__keyspace@0__:*", Data: []byte("set")}, sub.Receive())
+	assert.Equal(t, redigo.Message{Channel: "__keyspace@0__:k", Pattern: "__keyspace@0__:*", Data: []byte("del")}, sub.Receive())
+
+	// 订阅期间的PING回复数组
+	assert.Nil(t, sub.Ping(""))
+	assert.Equal(t, redigo.Pong{}, sub.Receive())
+
+	// 断开连接后取消订阅
+	assert.Nil(t, sub.Close())
+	assert.Eventually(t, func() bool {
+		n, err = redigo.Int(pub.Do("PUBLISH", "news", "hello"))
+		return err == nil && n == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.True(t, svr.pubsub.empty())
+
+	assert.NotNil(t, svr.setKeyspaceEvents("KX"))
+	events, err := parseKeyspaceEvents("EKgA")
+	assert.Nil(t, err)
+	assert.Equal(t, "AKE", events.String())
+}
+
+func TestServer_Blocking(t *testing.T) {
+	svr, addr := newTestServer(t)
+	dial := func() redigo.Conn {
+		c, err := redigo.Dial("tcp", addr)
+		assert.Nil(t, err)
+		return c
+	}
+	// blockedOn 等待n个客户端阻塞在key上
+	blockedOn := func(key string, n int) {
+		assert.Eventually(t, func() bool {
+			svr.blocking.mu.Lock()
+			defer svr.blocking.mu.Unlock()
+			return len(svr.blocking.waiters[watchKey{key: key}]) == n
+		}, time.Second, 5*time.Millisecond)
+	}
+	c := dial()
+	defer c.Close()
+
+	// 超时后回复null
+	start := time.Now()
+	reply, err := c.Do("BLPOP", "list", "0.1")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	assert.True(t, svr.blocking.empty())
+
+	// 已有数据时直接弹出
+	_, err = c.Do("RPUSH", "list2", "a")
+	assert.Nil(t, err)
+	values, err := redigo.Strings(c.Do("BRPOP", "list", "list2", "0"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"list2", "a"}, values)
+
+	// 按阻塞的顺序唤醒
+	type popped struct {
+		name   string
+		values []string
+	}
+	results := make(chan popped, 2)
+	for _, name := range []string{"first", "second"} {
+		conn := dial()
+		defer conn.Close()
+		go func(name string, conn redigo.Conn) {
+			values, err := redigo.Strings(conn.Do("BLPOP", "list", "1"))
+			assert.Nil(t, err)
+			results <- popped{name: name, values: values}
+		}(name, conn)
+		blockedOn("list", map[string]int{"first": 1, "second": 2}[name])
+	}
+	_, err = c.Do("RPUSH", "list", "a", "b")
+	assert.Nil(t, err)
+	got := make(map[string][]string)
+	for i := 0; i < 2; i++ {
+		res := <-results
+		got[res.name] = res.values
+	}
+	assert.Equal(t, map[string][]string{"first": {"list", "a"}, "second": {"list", "b"}}, got)
+
+	// BLMOVE等待source被写入
+	other := dial()
+	defer other.Close()
+	moved := make(chan string, 1)
+	go func() {
+		value, err := redigo.String(other.Do("BLMOVE", "src", "dst", "RIGHT", "LEFT", "1"))
+		assert.Nil(t, err)
+		moved <- value
+	}()
+	blockedOn("src", 1)
+	_, err = c.Do("LPUSH", "src", "x")
+	assert.Nil(t, err)
+	assert.Equal(t, "x", <-moved)
+	values, err = redigo.Strings(c.Do("LRANGE", "dst", "0", "-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"x"}, values)
+
+	// BZPOPMIN等待zset被写入
+	zpopped := make(chan []string, 1)
+	go func() {
+		values, err := redigo.Strings(other.Do("BZPOPMIN", "zset", "1"))
+		assert.Nil(t, err)
+		zpopped <- values
+	}()
+	blockedOn("zset", 1)
+	_, err = c.Do("ZADD", "zset", "2", "b", "1", "a")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"zset", "a", "1"}, <-zpopped)
+
+	// 事务中不阻塞
+	assert.Nil(t, c.Send("MULTI"))
+	assert.Nil(t, c.Send("BLPOP", "empty", "0"))
+	assert.Nil(t, c.Send("BRPOPLPUSH", "empty", "dst", "0"))
+	reply, err = c.Do("EXEC")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{nil, nil}, reply)
+
+	_, err = c.Do("BLPOP", "list", "-1")
+	assert.EqualError(t, err, "ERR timeout is negative")
+	_, err = c.Do("BLPOP", "list", "abc")
+	assert.EqualError(t, err, "ERR timeout is not a float or out of range")
+}
+
+func TestServer_BlockingHandoff(t *testing.T) {
+	svr, addr := newTestServer(t)
+	dial := func() redigo.Conn {
+		c, err := redigo.Dial("tcp", addr)
+		assert.Nil(t, err)
+		return c
+	}
+	blockedOn := func(key string, n int) {
+		assert.Eventually(t, func() bool {
+			svr.blocking.mu.Lock()
+			defer svr.blocking.mu.Unlock()
+			return len(svr.blocking.waiters[watchKey{key: key}]) == n
+		}, time.Second, 5*time.Millisecond)
+	}
+	c := dial()
+	defer c.Close()
+
+	// 连接断开后取消登记
+	gone := dial()
+	assert.Nil(t, gone.Send("BLPOP", "list", "0"))
+	assert.Nil(t, gone.Flush())
+	blockedOn("list", 1)
+	assert.Nil(t, gone.Close())
+	blockedOn("list", 0)
+
+	// 先阻塞的客户端断开后，数据交给之后仍在等待的客户端
+	gone = dial()
+	assert.Nil(t, gone.Send("BLPOP", "list", "0"))
+	assert.Nil(t, gone.Flush())
+	blockedOn("list", 1)
+	live := dial()
+	defer live.Close()
+	popped := make(chan []string, 1)
+	go func() {
+		values, err := redigo.Strings(live.Do("BLPOP", "list", "1"))
+		assert.Nil(t, err)
+		popped <- values
+	}()
+	blockedOn("list", 2)
+	assert.Nil(t, gone.Close())
+	_, err := c.Do("RPUSH", "list", "a")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"list", "a"}, <-popped)
+	n, err := redigo.Int(c.Do("LLEN", "list"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// 写入后数据先交给等待的客户端，紧随其后的LPOP拿不到
+	go func() {
+		values, err := redigo.Strings(live.Do("BLPOP", "list", "1"))
+		assert.Nil(t, err)
+		popped <- values
+	}()
+	blockedOn("list", 1)
+	assert.Nil(t, c.Send("RPUSH", "list", "b"))
+	assert.Nil(t, c.Send("LPOP", "list"))
+	assert.Nil(t, c.Flush())
+	_, err = c.Receive()
+	assert.Nil(t, err)
+	reply, err := c.Receive()
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+	assert.Equal(t, []string{"list", "b"}, <-popped)
+	assert.True(t, svr.blocking.empty())
+}
+
+func TestServer_ConcurrentWrites(t *testing.T) {
+	addr := startTestServerAddr(t)
+	// parallel 在n个连接上并发执行fn
+	parallel := func(n int, fn func(i int, conn redigo.Conn)) {
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			conn, err := redigo.Dial("tcp", addr)
+			assert.Nil(t, err)
+			defer conn.Close()
+			wg.Add(1)
+			go func(i int, conn redigo.Conn) {
+				defer wg.Done()
+				<-start
+				fn(i, conn)
+			}(i, conn)
+		}
+		close(start)
+		wg.Wait()
+	}
+	conn, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// 并发修改同一个key的元数据时不丢失写入
+	parallel(50, func(i int, c redigo.Conn) {
+		_, err := c.Do("HSET", "hash", "f"+strconv.Itoa(i), i)
+		assert.Nil(t, err)
+		_, err = c.Do("SADD", "set", i)
+		assert.Nil(t, err)
+		_, err = c.Do("LPUSH", "list", i)
+		assert.Nil(t, err)
+	})
+	for _, cmd := range [][]interface{}{{"HLEN", "hash"}, {"SCARD", "set"}, {"LLEN", "list"}} {
+		n, err := redigo.Int(conn.Do(cmd[0].(string), cmd[1:]...))
+		assert.Nil(t, err)
+		assert.Equal(t, 50, n, cmd[0])
+	}
+
+	// 每个元素只被弹出一次
+	var mu sync.Mutex
+	popped := make(map[string]int)
+	parallel(60, func(i int, c redigo.Conn) {
+		values, err := redigo.Strings(c.Do("BLPOP", "list", "0.2"))
+		if err == redigo.ErrNil {
+			return
+		}
+		assert.Nil(t, err)
+		mu.Lock()
+		popped[values[1]]++
+		mu.Unlock()
+	})
+	assert.Equal(t, 50, len(popped))
+	for element, n := range popped {
+		assert.Equal(t, 1, n, element)
+	}
+}
+
+func TestServer_Scripting(t *testing.T) {
+	addr := startTestServerAddr(t)
+	dial := func() redigo.Conn {
+		c, err := redigo.Dial("tcp", addr)
+		assert.Nil(t, err)
+		return c
+	}
+	c := dial()
+	defer c.Close()
+
+	// 脚本中读取后写入不会与其他客户端交错
+	const incr = "local v = tonumber(redis.call('get', KEYS[1]) or '0'); return redis.call('set', KEYS[1], v + 1)"
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		conn := dial()
+		defer conn.Close()
+		wg.Add(1)
+		go func(conn redigo.Conn) {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				_, err := conn.Do("EVAL", incr, 1, "counter")
+				assert.Nil(t, err)
+			}
+		}(conn)
+	}
+	wg.Wait()
+	n, err := redigo.Int(c.Do("GET", "counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, 200, n)
+
+	// 事务中执行脚本
+	assert.Nil(t, c.Send("MULTI"))
+	assert.Nil(t, c.Send("EVAL", incr, 1, "counter"))
+	assert.Nil(t, c.Send("EVAL", "return redis.call('get', KEYS[1])", 1, "counter"))
+	values, err := redigo.Strings(c.Do("EXEC"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"OK", "201"}, values)
+
+	// 脚本的写入使WATCH了该key的事务失效
+	other := dial()
+	defer other.Close()
+	_, err = other.Do("WATCH", "counter")
+	assert.Nil(t, err)
+	_, err = c.Do("EVAL", incr, 1, "counter")
+	assert.Nil(t, err)
+	assert.Nil(t, other.Send("MULTI"))
+	assert.Nil(t, other.Send("GET", "counter"))
+	reply, err := other.Do("EXEC")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+
+	// 脚本出错时已执行的写入不回滚
+	_, err = c.Do("EVAL", "redis.call('set', 'a', '1'); return redis.call('lpush', 'a', 'x')", 0)
+	assert.EqualError(t, err, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	value, err := redigo.String(c.Do("GET", "a"))
+	assert.Nil(t, err)
+	assert.Equal(t, "1", value)
+}
+
+func TestServer_ScriptTimeout(t *testing.T) {
+	_, addr := newTestServerWithConfig(t, func(cfg *serverConfig) {
+		cfg.luaTimeLimit = 200
+	})
+	c, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer c.Close()
+
+	// 超过lua-time-limit的脚本被终止，尚未提交的写入被放弃，之后服务正常响应
+	_, err = c.Do("EVAL", "redis.call('set', 'looped', '1'); while true do end", 0)
+	assert.EqualError(t, err, errScriptTimeout.Error())
+	_, err = c.Do("EVAL", "while true do pcall(function() while true do end end) end", 0)
+	assert.EqualError(t, err, errScriptTimeout.Error())
+	reply, err := c.Do("GET", "looped")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+
+	// 不限制执行时间时由其他客户端通过SCRIPT KILL终止
+	_, err = c.Do("CONFIG", "SET", "lua-time-limit", "0")
+	assert.Nil(t, err)
+	_, err = c.Do("SCRIPT", "KILL")
+	assert.EqualError(t, err, errNoScriptRunning.Error())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Do("EVAL", "while true do end", 0)
+		done <- err
+	}()
+	killer, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer killer.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err = killer.Do("SCRIPT", "KILL")
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, err)
+	select {
+	case err := <-done:
+		assert.EqualError(t, err, errScriptKilled.Error())
+	case <-time.After(5 * time.Second):
+		t.Fatal("script was not killed")
+	}
+	pong, err := redigo.String(killer.Do("PING"))
+	assert.Nil(t, err)
+	assert.Equal(t, "PONG", pong)
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig("test", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, defaultServerConfig(), cfg)
+
+	// 命令行参数覆盖配置文件中的值
+	f, err := os.CreateTemp("", "bitcask-redis-conf")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`# 注释
+addr 127.0.0.1:7380
+dir "/tmp/bitcask data"
+databases 4
+data-file-size 4096
+sync-writes no
+index-type ART
+merge-ratio 0.3
+maxclients 100
+timeout 30
+notify-keyspace-events EKgA
+`)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	cfg, err = parseConfig("test", []string{"-config", f.Name(), "-databases", "8", "-bootstrap"})
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:7380", cfg.addr)
+	assert.Equal(t, "/tmp/bitcask data", cfg.opts.DirPath)
+	assert.Equal(t, 8, cfg.databases)
+	assert.Equal(t, int64(4096), cfg.opts.DataFileSize)
+	assert.False(t, cfg.opts.SyncWrites)
+	assert.Equal(t, model.ART, cfg.opts.Index)
+	assert.Equal(t, float32(0.3), cfg.opts.DateFileMergeRatio)
+	assert.Equal(t, 100, cfg.maxClients)
+	assert.Equal(t, 30*time.Second, cfg.timeout)
+	assert.Equal(t, "AKE", cfg.notifyKeyspaceEvents)
+	assert.True(t, cfg.bootstrap)
+
+	_, err = parseConfig("test", []string{"-databases", "0"})
+	assert.NotNil(t, err)
+	_, err = parseConfig("test", []string{"-config", "/nonexistent/bitcask.conf"})
+	assert.NotNil(t, err)
+}
+
+func TestServer_Config(t *testing.T) {
+	svr, addr := newTestServer(t)
+	conn, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	values, err := redigo.StringMap(conn.Do("CONFIG", "GET", "max*", "DATABASES"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"maxclients": "10000", "databases": "16"}, values)
+
+	_, err = conn.Do("CONFIG", "SET", "maxclients", "1", "notify-keyspace-events", "KEA")
+	assert.Nil(t, err)
+	values, err = redigo.StringMap(conn.Do("CONFIG", "GET", "maxclients", "notify-keyspace-events"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"maxclients": "1", "notify-keyspace-events": "AKE"}, values)
+	events, _ := svr.keyspaceEvents.Load().(*keyspaceEvents)
+	assert.Equal(t, "AKE", events.String())
+
+	// 任一配置项出错时全部不修改
+	_, err = conn.Do("CONFIG", "SET", "maxclients", "3", "dir", "/tmp")
+	assert.EqualError(t, err, "ERR CONFIG SET failed (possibly related to argument 'dir') - can't set immutable config")
+	_, err = conn.Do("CONFIG", "SET", "timeout", "abc")
+	assert.EqualError(t, err, "ERR CONFIG SET failed (possibly related to argument 'timeout') - argument couldn't be parsed into an integer")
+	_, err = conn.Do("CONFIG", "SET", "nosuchconfig", "1")
+	assert.EqualError(t, err, "ERR Unknown option or number of arguments for CONFIG SET - 'nosuchconfig'")
+	values, err = redigo.StringMap(conn.Do("CONFIG", "GET", "maxclients"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"maxclients": "1"}, values)
+
+	// 连接数达到maxclients后拒绝新的连接
+	other, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer other.Close()
+	_, err = other.Do("PING")
+	assert.EqualError(t, err, "ERR max number of clients reached")
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	svr, addr := newTestServer(t)
+	conn, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Do("SET", "k", "v")
+	assert.Nil(t, err)
+
+	// 阻塞的客户端在停止服务时被唤醒
+	blocked, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer blocked.Close()
+	done := make(chan struct{})
+	go func() {
+		_, _ = blocked.Do("BLPOP", "list", "0")
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		return !svr.blocking.empty()
+	}, time.Second, 5*time.Millisecond)
+
+	svr.shutdown()
+	<-done
+	svr.mu.RLock()
+	assert.Empty(t, svr.clients)
+	svr.mu.RUnlock()
+	_, err = conn.Do("PING")
+	assert.NotNil(t, err)
+	_, err = redigo.Dial("tcp", addr)
+	assert.NotNil(t, err)
+	// 数据库已关闭，再次调用不会重复关闭
+	assert.NotNil(t, svr.dbs[0].Engine().Close())
+	svr.shutdown()
+}
+
+func TestServer_Auth(t *testing.T) {
+	_, addr := newTestServerWithConfig(t, func(cfg *serverConfig) {
+		cfg.requirepass = "secret"
+	})
+	conn, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// 认证之前只能执行AUTH与HELLO
+	_, err = conn.Do("GET", "k")
+	assert.EqualError(t, err, "NOAUTH Authentication required.")
+	_, err = conn.Do("HELLO", "3")
+	assert.EqualError(t, err, errHelloNoAuth.Error())
+	_, err = conn.Do("AUTH", "wrong")
+	assert.EqualError(t, err, "WRONGPASS invalid username-password pair or user is disabled.")
+	reply, err := redigo.String(conn.Do("AUTH", "secret"))
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", reply)
+	_, err = conn.Do("SET", "k", "v")
+	assert.Nil(t, err)
+
+	// HELLO AUTH 同时完成认证与协议协商
+	other, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer other.Close()
+	_, err = other.Do("HELLO", "2", "AUTH", "default", "secret")
+	assert.Nil(t, err)
+	value, err := redigo.String(other.Do("GET", "k"))
+	assert.Nil(t, err)
+	assert.Equal(t, "v", value)
+
+	// 清空requirepass后新连接不需要认证
+	_, err = conn.Do("CONFIG", "SET", "requirepass", "")
+	assert.Nil(t, err)
+	noauth, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer noauth.Close()
+	_, err = noauth.Do("GET", "k")
+	assert.Nil(t, err)
+	_, err = noauth.Do("AUTH", "secret")
+	assert.EqualError(t, err, errAuthNoPassword.Error())
+}
+
+func TestServer_ClusterAuth(t *testing.T) {
+	clusterConfig := func(nodeID, join string) func(cfg *serverConfig) {
+		return func(cfg *serverConfig) {
+			cfg.nodeID = nodeID
+			cfg.raftAddr = "127.0.0.1:0"
+			cfg.bootstrap = join == ""
+			cfg.join = join
+			cfg.requirepass = "secret"
+			cfg.masterAuth = "secret"
+		}
+	}
+	leader, leaderAddr := newTestServerWithConfig(t, clusterConfig("node1", ""))
+	assert.True(t, leader.node.IsLeader())
+	follower, followerAddr := newTestServerWithConfig(t, clusterConfig("node2", leaderAddr))
+	assert.False(t, follower.node.IsLeader())
+
+	// 从节点以masterauth认证后转发命令，客户端在从节点上同样需要认证
+	conn, err := redigo.Dial("tcp", followerAddr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Do("SET", "k", "v")
+	assert.True(t, strings.HasPrefix(err.Error(), "NOAUTH"))
+	_, err = conn.Do("AUTH", "secret")
+	assert.Nil(t, err)
+	reply, err := redigo.String(conn.Do("SET", "k", "v"))
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", reply)
+	value, err := redigo.String(conn.Do("GET", "k"))
+	assert.Nil(t, err)
+	assert.Equal(t, "v", value)
+
+	// 修改后的masterauth对新建立的leader连接生效，密码错误时转发失败
+	_, err = conn.Do("CONFIG", "SET", "masterauth", "wrong")
+	assert.Nil(t, err)
+	other, err := redigo.Dial("tcp", followerAddr, redigo.DialPassword("secret"))
+	assert.Nil(t, err)
+	defer other.Close()
+	_, err = other.Do("GET", "k")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "WRONGPASS")
+}
+
+func TestServer_ACL(t *testing.T) {
+	_, addr := newTestServer(t)
+	admin, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer admin.Close()
+
+	_, err = admin.Do("ACL", "SETUSER", "alice", "on", ">pw", "~cache:*", "+@read", "+set", "-keys")
+	assert.Nil(t, err)
+	_, err = admin.Do("ACL", "SETUSER", "alice", "+nosuchcommand")
+	assert.EqualError(t, err, "ERR Error in ACL SETUSER modifier '+nosuchcommand': Unknown command or category name in ACL")
+	_, err = admin.Do("ACL", "SETUSER", "bob", ">pw", "bad")
+	assert.EqualError(t, err, "ERR Error in ACL SETUSER modifier 'bad': Syntax error")
+	users, err := redigo.Strings(admin.Do("ACL", "USERS"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"alice", "default"}, users)
+	list, err := redigo.Strings(admin.Do("ACL", "LIST"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"user alice on #" + hashPassword([]byte("pw")) + " ~cache:* -@all +@read +set -keys",
+		"user default on nopass ~* +@all",
+	}, list)
+
+	conn, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Do("AUTH", "alice", "wrong")
+	assert.NotNil(t, err)
+	_, err = conn.Do("AUTH", "alice", "pw")
+	assert.Nil(t, err)
+	whoami, err := redigo.String(conn.Do("ACL", "WHOAMI"))
+	assert.EqualError(t, err, "NOPERM User alice has no permissions to run the 'acl' command")
+	assert.Equal(t, "", whoami)
+
+	// 命令与key均需要有权限
+	_, err = conn.Do("SET", "cache:1", "v")
+	assert.Nil(t, err)
+	_, err = conn.Do("SET", "other", "v")
+	assert.EqualError(t, err, "NOPERM No permissions to access a key")
+	value, err := redigo.String(conn.Do("GET", "cache:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "v", value)
+	_, err = conn.Do("DEL", "cache:1")
+	assert.EqualError(t, err, "NOPERM User alice has no permissions to run the 'del' command")
+	_, err = conn.Do("KEYS", "*")
+	assert.EqualError(t, err, "NOPERM User alice has no permissions to run the 'keys' command")
+	// 脚本中执行的命令同样检查权限
+	_, err = admin.Do("ACL", "SETUSER", "alice", "+eval")
+	assert.Nil(t, err)
+	value, err = redigo.String(conn.Do("EVAL", "return redis.call('GET', KEYS[1])", "1", "cache:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "v", value)
+	_, err = conn.Do("EVAL", "return redis.call('DEL', KEYS[1])", "1", "cache:1")
+	assert.ErrorContains(t, err, "NOPERM User alice has no permissions to run the 'del' command")
+	_, err = conn.Do("EVAL", "return redis.call('GET', 'other')", "0")
+	assert.ErrorContains(t, err, "NOPERM No permissions to access a key")
+
+	// 没有权限的命令使事务被放弃
+	_, err = conn.Do("MULTI")
+	assert.EqualError(t, err, "NOPERM User alice has no permissions to run the 'multi' command")
+
+	// 修改权限后立即生效，用户被删除后断开连接
+	_, err = admin.Do("ACL", "SETUSER", "alice", "+acl")
+	assert.Nil(t, err)
+	whoami, err = redigo.String(conn.Do("ACL", "WHOAMI"))
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", whoami)
+	_, err = admin.Do("ACL", "DELUSER", "default")
+	assert.EqualError(t, err, "ERR The 'default' user cannot be removed")
+	n, err := redigo.Int(admin.Do("ACL", "DELUSER", "alice", "nobody"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	_, err = conn.Do("GET", "cache:1")
+	assert.NotNil(t, err)
+
+	// 禁用default用户后新连接需要以其他用户认证
+	_, err = admin.Do("ACL", "SETUSER", "default", "off")
+	assert.Nil(t, err)
+	_, err = admin.Do("PING")
+	assert.Nil(t, err)
+	other, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer other.Close()
+	_, err = other.Do("PING")
+	assert.EqualError(t, err, "NOAUTH Authentication required.")
+}
+
+func TestServer_ACLFile(t *testing.T) {
+	f, err := os.CreateTemp("", "bitcask-users-acl")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`# 注释
+user default on >secret ~* +@all
+user reader on >pw ~* -@all +@read
+`)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	cfg := defaultServerConfig()
+	cfg.aclFile, cfg.requirepass = f.Name(), "secret"
+	_, err = newBitcaskServer(cfg)
+	assert.Equal(t, errRequirePassFile, err)
+
+	_, addr := newTestServerWithConfig(t, func(cfg *serverConfig) {
+		cfg.aclFile = f.Name()
+	})
+	conn, err := redigo.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Do("PING")
+	assert.EqualError(t, err, "NOAUTH Authentication required.")
+	_, err = conn.Do("AUTH", "reader", "pw")
+	assert.Nil(t, err)
+	_, err = conn.Do("GET", "k")
+	assert.Nil(t, err)
+	_, err = conn.Do("SET", "k", "v")
+	assert.NotNil(t, err)
+
+	// SAVE写入当前的用户，LOAD重新加载文件中的用户
+	_, err = conn.Do("AUTH", "secret")
+	assert.Nil(t, err)
+	_, err = conn.Do("ACL", "SETUSER", "writer", "on", "nopass", "~*", "+@write")
+	assert.Nil(t, err)
+	_, err = conn.Do("ACL", "SAVE")
+	assert.Nil(t, err)
+	_, err = conn.Do("ACL", "DELUSER", "writer")
+	assert.Nil(t, err)
+	_, err = conn.Do("ACL", "LOAD")
+	assert.Nil(t, err)
+	users, err := redigo.Strings(conn.Do("ACL", "USERS"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"default", "reader", "writer"}, users)
+	data, err := os.ReadFile(f.Name())
+	assert.Nil(t, err)
+	list, err := redigo.Strings(conn.Do("ACL", "LIST"))
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Join(list, "\n")+"\n", string(data))
+
+	// 文件有误时不修改用户
+	assert.Nil(t, os.WriteFile(f.Name(), []byte("user writer on +nosuchcommand\n"), 0644))
+	_, err = conn.Do("ACL", "LOAD")
+	assert.NotNil(t, err)
+	users, err = redigo.Strings(conn.Do("ACL", "USERS"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"default", "reader", "writer"}, users)
+}
+
+// testCert 测试用的证书与私钥
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert 生成由parent签发的证书，parent为nil时生成自签名的CA证书
+func newTestCert(t *testing.T, serial int64, parent *testCert, isServer bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "bitcask test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	signer, signerKey := template, key
+	switch {
+	case parent == nil:
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	case isServer:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	default:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// writePEM 将证书与私钥写入dir中的 name.crt 与 name.key
+func (c *testCert) writePEM(t *testing.T, dir, name string) (string, string) {
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestServer_TLS(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-redis-tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCert(t, 1, nil, false)
+	serverCert := newTestCert(t, 2, ca, true)
+	clientCert := newTestCert(t, 3, ca, false)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	certFile, keyFile := serverCert.writePEM(t, dir, "server")
+
+	svr, addr := newTestServerWithConfig(t, func(cfg *serverConfig) {
+		cfg.requirepass = "secret"
+		cfg.tlsAddr = "127.0.0.1:0"
+		cfg.tlsCertFile = certFile
+		cfg.tlsKeyFile = keyFile
+		cfg.tlsCACertFile = caFile
+	})
+	tlsAddr, err := svr.startTLS()
+	assert.Nil(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientConfig := &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{clientCert.tlsCertificate()},
+	}
+	conn, err := redigo.Dial("tcp", tlsAddr.String(), redigo.DialUseTLS(true), redigo.DialTLSConfig(clientConfig))
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Do("GET", "k")
+	assert.EqualError(t, err, "NOAUTH Authentication required.")
+	_, err = conn.Do("AUTH", "secret")
+	assert.Nil(t, err)
+	_, err = conn.Do("SET", "k", "v")
+	assert.Nil(t, err)
+
+	// 普通连接与TLS连接访问同一份数据
+	plain, err := redigo.Dial("tcp", addr, redigo.DialPassword("secret"))
+	assert.Nil(t, err)
+	defer plain.Close()
+	value, err := redigo.String(plain.Do("GET", "k"))
+	assert.Nil(t, err)
+	assert.Equal(t, "v", value)
+
+	// tls-auth-clients为yes时没有客户端证书的连接被拒绝
+	noCert, err := redigo.Dial("tcp", tlsAddr.String(), redigo.DialUseTLS(true),
+		redigo.DialTLSConfig(&tls.Config{RootCAs: roots}), redigo.DialPassword("secret"))
+	if err == nil {
+		_, err = noCert.Do("PING")
+		_ = noCert.Close()
+	}
+	assert.NotNil(t, err)
+
+	// 缺少证书时无法监听
+	_, err = newTLSConfig(&serverConfig{tlsAddr: "127.0.0.1:0"})
+	assert.Equal(t, errTLSNoCert, err)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"github.com/tidwall/redcon"
+	"kv-db-lab/constant"
+)
+
+// sadd 返回新增的成员数量
+func sadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	var added int
+	for _, member := range args[1:] {
+		ok, err := cli.db.SAdd(args[0], member)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			added++
+		}
+	}
+	return redcon.SimpleInt(added), nil
+}
+
+// srem 返回删除的成员数量
+func srem(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	var removed int
+	for _, member := range args[1:] {
+		ok, err := cli.db.SRem(args[0], member)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return redcon.SimpleInt(removed), nil
+}
+
+func sismember(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	ok, err := cli.db.SIsMember(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return boolInt(ok), nil
+}
+
+// setReply RESP3下以集合类型回复
+func setReply(members [][]byte, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := make(respSet, 0, len(members))
+	for _, m := range members {
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+func smembers(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return setReply(cli.db.SMembers(args[0]))
+}
+
+func scard(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	n, err := cli.db.SCard(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+// randomMembersGeneric 未指定count时返回单个成员，否则返回数组
+func randomMembersGeneric(cmd string, args [][]byte, allowNegative bool, fn func(key []byte, count int) ([][]byte, error)) (interface{}, error) {
+	if len(args) > 2 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+	if len(args) == 1 {
+		members, err := fn(args[0], 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(members) == 0 {
+			return nil, nil
+		}
+		return members[0], nil
+	}
+
+	count, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 && !allowNegative {
+		return nil, constant.ErrIndexOutOfRange
+	}
+	return fn(args[0], int(count))
+}
+
+func spop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return randomMembersGeneric("spop", args, false, cli.db.SPop)
+}
+
+func srandmember(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return randomMembersGeneric("srandmember", args, true, cli.db.SRandMember)
+}
+
+func smove(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	ok, err := cli.db.SMove(args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return boolInt(ok), nil
+}
+
+func sinter(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return setReply(cli.db.SInter(args...))
+}
+
+func sunion(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return setReply(cli.db.SUnion(args...))
+}
+
+func sdiff(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return setReply(cli.db.SDiff(args...))
+}
+
+func storeGeneric(args [][]byte, fn func(destination []byte, keys ...[]byte) (uint32, error)) (interface{}, error) {
+	n, err := fn(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func sinterstore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return storeGeneric(args, cli.db.SInterStore)
+}
+
+func sunionstore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return storeGeneric(args, cli.db.SUnionStore)
+}
+
+func sdiffstore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return storeGeneric(args, cli.db.SDiffStore)
+}
+
+func sscan(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	cursor, err := parseCursor(args[1])
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseScanOptions(args[2:], false)
+	if err != nil {
+		return nil, err
+	}
+	members, next, err := cli.db.SScan(args[0], cursor, opts.match, opts.count)
+	if err != nil {
+		return nil, err
+	}
+	return scanReply(next, members), nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"github.com/tidwall/redcon"
+	"kv-db-lab/redis"
+	"strconv"
+	"time"
+)
+
+// streamEntriesReply 每条消息回复为 [id, [field value ...]]，已删除的消息fields为null
+func streamEntriesReply(entries []*redis.StreamEntry) []interface{} {
+	result := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		var fields interface{}
+		if entry.Fields != nil {
+			fields = entry.Fields
+		}
+		result = append(result, []interface{}{entry.ID.String(), fields})
+	}
+	return result
+}
+
+// parseStreamTrim 读取 MAXLEN|MINID [=|~] threshold [LIMIT count]，下一个参数不是MAXLEN或MINID时返回nil
+func parseStreamTrim(p *argParser) *redis.StreamTrim {
+	var byMinID bool
+	switch {
+	case p.tryOption("maxlen"):
+	case p.tryOption("minid"):
+		byMinID = true
+	default:
+		return nil
+	}
+	if !p.tryOption("=") {
+		p.tryOption("~")
+	}
+
+	trim := &redis.StreamTrim{}
+	if byMinID {
+		arg := p.next()
+		if p.err() != nil {
+			return nil
+		}
+		id, err := redis.ParseStreamID(arg, 0)
+		if err != nil {
+			p.fail(err)
+			return nil
+		}
+		trim.MinID = &id
+	} else {
+		trim.MaxLen = p.nextInt()
+	}
+	if p.tryOption("limit") {
+		trim.Limit = p.nextInt()
+	}
+	return trim
+}
+
+// xadd key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func xadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	opts := &redis.XAddOptions{}
+	p := newArgParser(args[1:])
+	opts.NoMkStream = p.tryOption("nomkstream")
+	opts.Trim = parseStreamTrim(p)
+	id := p.next()
+	fields := p.rest()
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return nil, newWrongNumberOfArgsError("xadd")
+	}
+
+	streamID, err := cli.db.XAdd(args[0], id, fields, opts)
+	if err != nil {
+		return nil, err
+	}
+	return streamID.String(), nil
+}
+
+func xlen(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	n, err := cli.db.XLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+// parseStreamCount 解析可选的 COUNT n，未指定时返回-1
+func parseStreamCount(args [][]byte) (int64, error) {
+	switch {
+	case len(args) == 0:
+		return -1, nil
+	case len(args) == 2 && isArg(args[0], "count"):
+		return parseInt(args[1])
+	}
+	return 0, errSyntax
+}
+
+// xrange key start end [COUNT count]
+func xrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	start, err := redis.ParseStreamRangeID(args[1], false)
+	if err != nil {
+		return nil, err
+	}
+	end, err := redis.ParseStreamRangeID(args[2], true)
+	if err != nil {
+		return nil, err
+	}
+	count, err := parseStreamCount(args[3:])
+	if err != nil {
+		return nil, err
+	}
+	entries, err := cli.db.XRange(args[0], start, end, count)
+	if err != nil {
+		return nil, err
+	}
+	return streamEntriesReply(entries), nil
+}
+
+// xrevrange key end start [COUNT count]
+func xrevrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	end, err := redis.ParseStreamRangeID(args[1], true)
+	if err != nil {
+		return nil, err
+	}
+	start, err := redis.ParseStreamRangeID(args[2], false)
+	if err != nil {
+		return nil, err
+	}
+	count, err := parseStreamCount(args[3:])
+	if err != nil {
+		return nil, err
+	}
+	entries, err := cli.db.XRevRange(args[0], end, start, count)
+	if err != nil {
+		return nil, err
+	}
+	return streamEntriesReply(entries), nil
+}
+
+func parseStreamIDs(args [][]byte) ([]redis.StreamID, error) {
+	ids := make([]redis.StreamID, 0, len(args))
+	for _, arg := range args {
+		id, err := redis.ParseStreamID(arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func xdel(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	ids, err := parseStreamIDs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	n, err := cli.db.XDel(args[0], ids...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+// xtrim key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func xtrim(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	p := newArgParser(args[1:])
+	trim := parseStreamTrim(p)
+	if p.more() || trim == nil {
+		p.unknown()
+	}
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	n, err := cli.db.XTrim(args[0], trim)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+// xgroup CREATE key group id|$ [MKSTREAM] | SETID key group id|$ | DESTROY key group |
+// CREATECONSUMER key group consumer | DELCONSUMER key group consumer
+func xgroup(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	sub := args[0]
+	switch {
+	case isArg(sub, "create") && (len(args) == 4 || len(args) == 5):
+		mkStream := len(args) == 5
+		if mkStream && !isArg(args[4], "mkstream") {
+			return nil, errSyntax
+		}
+		if err := cli.db.XGroupCreate(args[1], args[2], args[3], mkStream); err != nil {
+			return nil, err
+		}
+		return redcon.SimpleString("OK"), nil
+	case isArg(sub, "setid") && len(args) == 4:
+		if err := cli.db.XGroupSetID(args[1], args[2], args[3]); err != nil {
+			return nil, err
+		}
+		return redcon.SimpleString("OK"), nil
+	case isArg(sub, "destroy") && len(args) == 3:
+		ok, err := cli.db.XGroupDestroy(args[1], args[2])
+		if err != nil {
+			return nil, err
+		}
+		return boolInt(ok), nil
+	case isArg(sub, "createconsumer") && len(args) == 4:
+		ok, err := cli.db.XGroupCreateConsumer(args[1], args[2], args[3])
+		if err != nil {
+			return nil, err
+		}
+		return boolInt(ok), nil
+	case isArg(sub, "delconsumer") && len(args) == 4:
+		n, err := cli.db.XGroupDelConsumer(args[1], args[2], args[3])
+		if err != nil {
+			return nil, err
+		}
+		return redcon.SimpleInt(n), nil
+	}
+	return nil, newUnknownSubcommandError("xgroup", string(sub))
+}
+
+// xgroupKeys 子命令之后的第一个参数为key，args包含命令名
+func xgroupKeys(args [][]byte) [][]byte {
+	if len(args) < 3 {
+		return nil
+	}
+	return args[2:3]
+}
+
+// streamsIndex 返回STREAMS之后第一个参数的位置，不存在时返回-1
+func streamsIndex(args [][]byte) int {
+	for i, arg := range args {
+		if isArg(arg, "streams") {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// xreadgroupKeys STREAMS之后前一半的参数为key，args包含命令名
+func xreadgroupKeys(args [][]byte) [][]byte {
+	i := streamsIndex(args)
+	if i < 0 || (len(args)-i)%2 != 0 {
+		return nil
+	}
+	return args[i : i+(len(args)-i)/2]
+}
+
+// xreadgroup GROUP group consumer [COUNT count] [NOACK] STREAMS key [key ...] id [id ...]
+func xreadgroup(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	p := newArgParser(args)
+	if !p.tryOption("group") {
+		p.unknown()
+	}
+	group, consumer := p.next(), p.next()
+
+	count := int64(-1)
+	var noAck bool
+	for p.more() && !p.tryOption("streams") {
+		switch {
+		case p.tryOption("count"):
+			count = p.nextInt()
+		case p.tryOption("noack"):
+			noAck = true
+		default:
+			p.unknown()
+		}
+	}
+	rest := p.rest()
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	if len(rest) == 0 || len(rest)%2 != 0 {
+		return nil, errSyntax
+	}
+	keys, ids := rest[:len(rest)/2], rest[len(rest)/2:]
+
+	// RESP2下回复 [[key, entries] ...]，RESP3下回复 {key: entries}
+	var result []interface{}
+	for i, key := range keys {
+		entries, err := cli.db.XReadGroup(group, consumer, key, ids[i], count, noAck)
+		if err != nil {
+			return nil, err
+		}
+		// 与redis一致，读取新消息时跳过没有消息的key
+		if len(entries) == 0 && string(ids[i]) == ">" {
+			continue
+		}
+		if cli.resp3() {
+			result = append(result, key, streamEntriesReply(entries))
+		} else {
+			result = append(result, []interface{}{key, streamEntriesReply(entries)})
+		}
+	}
+	switch {
+	case len(result) == 0:
+		return respNullArray{}, nil
+	case cli.resp3():
+		return respMap(result), nil
+	}
+	return result, nil
+}
+
+func xack(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	ids, err := parseStreamIDs(args[2:])
+	if err != nil {
+		return nil, err
+	}
+	n, err := cli.db.XAck(args[0], args[1], ids...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+// xpending key group [[IDLE min-idle-time] start end count [consumer]]
+func xpending(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	key, group := args[0], args[1]
+	if len(args) == 2 {
+		summary, err := cli.db.XPending(key, group)
+		if err != nil {
+			return nil, err
+		}
+		if summary.Count == 0 {
+			return []interface{}{redcon.SimpleInt(0), nil, nil, respNullArray{}}, nil
+		}
+		consumers := make([]interface{}, 0, len(summary.Consumers))
+		for _, c := range summary.Consumers {
+			// 与redis一致，消费者的待确认数量以字符串回复
+			consumers = append(consumers, []interface{}{c.Name, strconv.FormatInt(c.Count, 10)})
+		}
+		return []interface{}{redcon.SimpleInt(summary.Count), summary.Min.String(), summary.Max.String(), consumers}, nil
+	}
+
+	p := newArgParser(args[2:])
+	var minIdle time.Duration
+	if p.tryOption("idle") {
+		minIdle = time.Duration(p.nextInt()) * time.Millisecond
+	}
+	startArg, endArg, count := p.next(), p.next(), p.nextInt()
+	var consumer []byte
+	if p.more() {
+		consumer = p.next()
+	}
+	if p.more() {
+		p.unknown()
+	}
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	start, err := redis.ParseStreamRangeID(startArg, false)
+	if err != nil {
+		return nil, err
+	}
+	end, err := redis.ParseStreamRangeID(endArg, true)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := cli.db.XPendingRange(key, group, start, end, count, consumer, minIdle)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, []interface{}{
+			entry.ID.String(),
+			entry.Consumer,
+			redcon.SimpleInt(entry.Idle.Milliseconds()),
+			redcon.SimpleInt(entry.Deliveries),
+		})
+	}
+	return result, nil
+}
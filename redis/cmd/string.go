@@ -0,0 +1,249 @@
+package main
+
+import (
+	"github.com/tidwall/redcon"
+	"kv-db-lab/redis"
+	"math"
+	"time"
+)
+
+// set key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT timestamp|KEEPTTL]
+func set(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	opts := &redis.SetOptions{}
+	var hasExpire bool
+	p := newArgParser(args[2:])
+	for p.more() {
+		switch {
+		case opts.Condition == redis.SetAlways && p.tryOption("nx"):
+			opts.Condition = redis.SetIfNotExist
+		case opts.Condition == redis.SetAlways && p.tryOption("xx"):
+			opts.Condition = redis.SetIfExist
+		case !opts.Get && p.tryOption("get"):
+			opts.Get = true
+		case !hasExpire && p.tryOption("keepttl"):
+			opts.KeepTTL, hasExpire = true, true
+		case !hasExpire && p.tryOption("ex"):
+			opts.ExpireAt, hasExpire = expireAtNano(p, time.Second, false), true
+		case !hasExpire && p.tryOption("px"):
+			opts.ExpireAt, hasExpire = expireAtNano(p, time.Millisecond, false), true
+		case !hasExpire && p.tryOption("exat"):
+			opts.ExpireAt, hasExpire = expireAtNano(p, time.Second, true), true
+		case !hasExpire && p.tryOption("pxat"):
+			opts.ExpireAt, hasExpire = expireAtNano(p, time.Millisecond, true), true
+		default:
+			p.unknown()
+		}
+	}
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+
+	old, ok, err := cli.db.SetWithOptions(args[0], args[1], opts)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case opts.Get:
+		return bulkOrNil(old), nil
+	case !ok:
+		return nil, nil
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+// expireAtNano 读取SET的过期时间参数，转换为以纳秒为单位的时间点
+func expireAtNano(p *argParser, unit time.Duration, absolute bool) int64 {
+	n := p.nextInt()
+	if p.err() != nil {
+		return 0
+	}
+	if n <= 0 || n > math.MaxInt64/int64(unit) {
+		p.fail(errInvalidSetExpire)
+		return 0
+	}
+	if absolute {
+		return n * int64(unit)
+	}
+	now := time.Now().UnixNano()
+	if n*int64(unit) > math.MaxInt64-now {
+		p.fail(errInvalidSetExpire)
+		return 0
+	}
+	return now + n*int64(unit)
+}
+
+func get(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.db.Get(args[0])
+}
+
+func setnx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	ok, err := cli.db.SetNX(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return boolInt(ok), nil
+}
+
+func setex(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	seconds, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if err = cli.db.SetEX(args[0], args[2], seconds); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func psetex(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	milliseconds, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if err = cli.db.PSetEX(args[0], args[2], milliseconds); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func getset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	old, err := cli.db.GetSet(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return bulkOrNil(old), nil
+}
+
+func getdel(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	old, err := cli.db.GetDel(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return bulkOrNil(old), nil
+}
+
+func parseKeyValues(cmd string, args [][]byte) ([]*redis.KeyValue, error) {
+	if len(args)%2 != 0 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+	keyValues := make([]*redis.KeyValue, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keyValues = append(keyValues, &redis.KeyValue{Key: args[i], Value: args[i+1]})
+	}
+	return keyValues, nil
+}
+
+func mset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	keyValues, err := parseKeyValues("mset", args)
+	if err != nil {
+		return nil, err
+	}
+	if err = cli.db.MSet(keyValues); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func msetnx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	keyValues, err := parseKeyValues("msetnx", args)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := cli.db.MSetNX(keyValues)
+	if err != nil {
+		return nil, err
+	}
+	return boolInt(ok), nil
+}
+
+func mget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	values, err := cli.db.MGet(args...)
+	if err != nil {
+		return nil, err
+	}
+	return bulkArray(values), nil
+}
+
+func appendCommand(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	n, err := cli.db.Append(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func strlen(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	n, err := cli.db.StrLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func getrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	start, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	return cli.db.GetRange(args[0], start, end)
+}
+
+func setrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	offset, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	n, err := cli.db.SetRange(args[0], offset, args[2])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func incrGeneric(key []byte, increment []byte, fn func(key []byte, v int64) (int64, error)) (interface{}, error) {
+	v := int64(1)
+	if increment != nil {
+		var err error
+		if v, err = parseInt(increment); err != nil {
+			return nil, err
+		}
+	}
+	n, err := fn(key, v)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func incr(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return incrGeneric(args[0], nil, cli.db.IncrBy)
+}
+
+func decr(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return incrGeneric(args[0], nil, cli.db.DecrBy)
+}
+
+func incrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return incrGeneric(args[0], args[1], cli.db.IncrBy)
+}
+
+func decrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return incrGeneric(args[0], args[1], cli.db.DecrBy)
+}
+
+func incrbyfloat(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	increment, err := parseFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	v, err := cli.db.IncrByFloat(args[0], increment)
+	if err != nil {
+		return nil, err
+	}
+	// 与redis一致，结果以字符串回复
+	return formatFloat(v), nil
+}
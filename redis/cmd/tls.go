@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/tidwall/redcon"
+	"log"
+	"net"
+	"os"
+)
+
+// =========================TLS=============================================================
+// 配置了tls-addr时在该地址上额外监听TLS连接，与普通连接共用命令的处理与客户端数量限制
+// 配置了tls-ca-cert-file时按tls-auth-clients验证客户端证书
+
+// tlsClientAuthTypes tls-auth-clients的取值，与redis一致
+var tlsClientAuthTypes = map[string]tls.ClientAuthType{
+	"yes":      tls.RequireAndVerifyClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"no":       tls.NoClientCert,
+}
+
+var (
+	errTLSNoCert    = errors.New("tls-cert-file and tls-key-file are required when tls-addr is set")
+	errTLSInvalidCA = errors.New("tls-ca-cert-file contains no valid certificate")
+)
+
+// newTLSConfig 读取服务端证书与CA证书
+func newTLSConfig(cfg *serverConfig) (*tls.Config, error) {
+	if cfg.tlsCertFile == "" || cfg.tlsKeyFile == "" {
+		return nil, errTLSNoCert
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.tlsCertFile, cfg.tlsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.tlsCACertFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(cfg.tlsCACertFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errTLSInvalidCA
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tlsClientAuthTypes[cfg.tlsAuthClients]
+	return tlsConfig, nil
+}
+
+// startTLS 在tls-addr上监听TLS连接并在后台处理，返回实际监听的地址
+func (svr *BitcaskServer) startTLS() (net.Addr, error) {
+	cfg := svr.config
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := tls.Listen("tcp", cfg.tlsAddr, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	svr.tlsServer = redcon.NewServer(ln.Addr().String(), execClientCommand, svr.accept, svr.close)
+	svr.tlsServer.SetIdleClose(cfg.timeout)
+	go func() {
+		if err := svr.tlsServer.Serve(ln); err != nil {
+			log.Println("tls listener stopped:", err)
+		}
+	}()
+	return ln.Addr(), nil
+}
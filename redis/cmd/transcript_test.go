@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 对照testdata中的transcript验证服务端的回复
+// 以 > 开头的行为发送的命令，参数以空格分隔，含空格的参数使用双引号；
+// 之后直到下一条命令之前的行为期望的原始RESP回复，每行省略末尾的\r\n，可以包含多个回复；以 # 开头的行为注释
+
+type transcriptStep struct {
+	line    int
+	args    []string
+	replies []string
+}
+
+func parseTranscript(t *testing.T, path string) []*transcriptStep {
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+
+	var steps []*transcriptStep
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "> "):
+			args, err := splitTranscriptArgs(line[2:])
+			assert.Nil(t, err, "%s:%d", path, i+1)
+			steps = append(steps, &transcriptStep{line: i + 1, args: args})
+		default:
+			if len(steps) == 0 {
+				t.Fatalf("%s:%d: reply before command", path, i+1)
+			}
+			last := steps[len(steps)-1]
+			last.replies = append(last.replies, line)
+		}
+	}
+	return steps
+}
+
+// splitTranscriptArgs 以空格分隔参数，双引号内的参数按go的字符串字面量解析
+func splitTranscriptArgs(s string) ([]string, error) {
+	var args []string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] != '"' {
+			arg, rest, _ := strings.Cut(s, " ")
+			args = append(args, arg)
+			s = rest
+			continue
+		}
+		quoted, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		arg, _ := strconv.Unquote(quoted)
+		args = append(args, arg)
+		s = s[len(quoted):]
+	}
+	return args, nil
+}
+
+func writeCommand(conn net.Conn, args []string) error {
+	buf := fmt.Appendf(nil, "*%d\r\n", len(args))
+	for _, arg := range args {
+		buf = fmt.Appendf(buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := conn.Write(buf)
+	return err
+}
+
+// readReply 读取一个完整的回复，按行返回原始的RESP内容
+func readReply(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	lines := []string{line}
+	if line == "" {
+		return lines, nil
+	}
+
+	n, _ := strconv.Atoi(line[1:])
+	switch line[0] {
+	case '$':
+		if n < 0 {
+			return lines, nil
+		}
+		payload := make([]byte, n+2)
+		if _, err = io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+		return append(lines, string(payload[:n])), nil
+	case '%':
+		n *= 2
+	case '*', '~', '>':
+	default:
+		return lines, nil
+	}
+	for i := 0; i < n; i++ {
+		elements, err := readReply(r)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, elements...)
+	}
+	return lines, nil
+}
+
+func TestServer_Transcripts(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, paths)
+
+	for _, path := range paths {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			conn, err := net.Dial("tcp", startTestServerAddr(t))
+			assert.Nil(t, err)
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+
+			for _, step := range parseTranscript(t, path) {
+				assert.Nil(t, writeCommand(conn, step.args))
+				var replies []string
+				// 订阅多个频道等命令会产生多个回复，推送的消息也跟在回复之后，回复不足时等待超时
+				_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				for len(replies) < len(step.replies) {
+					reply, err := readReply(r)
+					if !assert.Nil(t, err, "%s:%d %v", path, step.line, step.args) {
+						return
+					}
+					replies = append(replies, reply...)
+				}
+				assert.Equal(t, step.replies, replies, "%s:%d %v", path, step.line, step.args)
+			}
+		})
+	}
+}
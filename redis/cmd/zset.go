@@ -0,0 +1,224 @@
+package main
+
+import (
+	"github.com/tidwall/redcon"
+	"kv-db-lab/redis"
+	"math"
+)
+
+// zadd 返回新增的成员数量
+func zadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args)%2 != 1 {
+		return nil, errSyntax
+	}
+	members := make([]*redis.ZMember, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		score, err := parseFloat(args[i])
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &redis.ZMember{Member: args[i+1], Score: score})
+	}
+
+	var added int
+	for _, m := range members {
+		ok, err := cli.db.ZAdd(args[0], m.Score, m.Member)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			added++
+		}
+	}
+	return redcon.SimpleInt(added), nil
+}
+
+func zincrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	increment, err := parseFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return cli.db.ZIncrBy(args[0], increment, args[2])
+}
+
+func zscore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return cli.db.ZScore(args[0], args[1])
+}
+
+// zrem 返回删除的成员数量
+func zrem(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	var removed int
+	for _, member := range args[1:] {
+		ok, err := cli.db.ZRem(args[0], member)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return redcon.SimpleInt(removed), nil
+}
+
+// zmembersReply 回复成员，withScores为true时RESP2下成员与score交替排列，RESP3下每个成员为 [member, score]
+func zmembersReply(members []*redis.ZMember, withScores bool) interface{} {
+	result := make([]interface{}, 0, len(members))
+	for _, m := range members {
+		if withScores {
+			result = append(result, respPair{m.Member, m.Score})
+		} else {
+			result = append(result, m.Member)
+		}
+	}
+	return result
+}
+
+func zrangeGeneric(args [][]byte, fn func(key []byte, start, stop int64) ([]*redis.ZMember, error)) (interface{}, error) {
+	start, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	var withScores bool
+	switch {
+	case len(args) == 4 && isArg(args[3], "withscores"):
+		withScores = true
+	case len(args) > 3:
+		return nil, errSyntax
+	}
+
+	members, err := fn(args[0], start, stop)
+	if err != nil {
+		return nil, err
+	}
+	return zmembersReply(members, withScores), nil
+}
+
+func zrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zrangeGeneric(args, cli.db.ZRange)
+}
+
+func zrevrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zrangeGeneric(args, cli.db.ZRevRange)
+}
+
+// parseScoreBound 解析score区间的端点，支持 -inf +inf 以及以 ( 开头的开区间
+func parseScoreBound(arg []byte) (float64, bool, error) {
+	var exclusive bool
+	if len(arg) > 0 && arg[0] == '(' {
+		exclusive = true
+		arg = arg[1:]
+	}
+	switch {
+	case isArg(arg, "-inf"):
+		return math.Inf(-1), exclusive, nil
+	case isArg(arg, "+inf"), isArg(arg, "inf"):
+		return math.Inf(1), exclusive, nil
+	}
+	v, err := parseFloat(arg)
+	if err != nil {
+		return 0, false, errInvalidScoreRange
+	}
+	return v, exclusive, nil
+}
+
+func parseScoreRange(minArg, maxArg []byte) (*redis.ScoreRange, error) {
+	rng := &redis.ScoreRange{}
+	var err error
+	if rng.Min, rng.MinExclusive, err = parseScoreBound(minArg); err != nil {
+		return nil, err
+	}
+	if rng.Max, rng.MaxExclusive, err = parseScoreBound(maxArg); err != nil {
+		return nil, err
+	}
+	return rng, nil
+}
+
+// zrangebyscore key min max [WITHSCORES] [LIMIT offset count]
+func zrangebyscore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	rng, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	var withScores bool
+	offset, count := int64(0), int64(-1)
+	p := newArgParser(args[3:])
+	for p.more() {
+		switch {
+		case p.tryOption("withscores"):
+			withScores = true
+		case p.tryOption("limit"):
+			offset, count = p.nextInt(), p.nextInt()
+		default:
+			p.unknown()
+		}
+	}
+	if err = p.err(); err != nil {
+		return nil, err
+	}
+
+	// 与redis一致，offset为负数时返回空
+	if offset < 0 {
+		return []interface{}{}, nil
+	}
+	members, err := cli.db.ZRangeByScore(args[0], rng, offset, count)
+	if err != nil {
+		return nil, err
+	}
+	return zmembersReply(members, withScores), nil
+}
+
+func zcount(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	rng, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	n, err := cli.db.ZCount(args[0], rng)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func zrank(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	rank, err := cli.db.ZRank(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(rank), nil
+}
+
+func zpopGeneric(cmd string, args [][]byte, fn func(key []byte, count int64) ([]*redis.ZMember, error)) (interface{}, error) {
+	if len(args) > 2 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+	count := int64(1)
+	if len(args) == 2 {
+		var err error
+		if count, err = parseInt(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	members, err := fn(args[0], count)
+	if err != nil {
+		return nil, err
+	}
+	// 与redis一致，未指定count时RESP3下同样回复为 [member, score]
+	if len(args) == 1 && len(members) == 1 {
+		return respPair{members[0].Member, members[0].Score}, nil
+	}
+	return zmembersReply(members, true), nil
+}
+
+func zpopmin(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zpopGeneric("zpopmin", args, cli.db.ZPopMin)
+}
+
+func zpopmax(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zpopGeneric("zpopmax", args, cli.db.ZPopMax)
+}
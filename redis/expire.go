@@ -0,0 +1,149 @@
+package redis
+
+import (
+	"encoding/binary"
+	"github.com/sirupsen/logrus"
+	"kv-db-lab/constant"
+	"kv-db-lab/storage"
+	"sync/atomic"
+	"time"
+)
+
+// =========================过期索引=============================================================
+// 设置过期时间时同时写入过期索引 expireKeyPrefix | expireAt(大端8byte) | key => nil，索引按过期时间排列
+// 后台协程按顺序处理已到期的索引，只有key当前的过期时间与索引一致时才删除key，否则索引已失效直接移除
+// 因此修改或移除过期时间时无需删除旧的索引
+
+// expireKeyPrefix 过期索引的前缀
+var expireKeyPrefix = append(append([]byte{}, internalKeyPrefix...), "ex"...)
+
+const (
+	// expireCycleInterval 主动过期的执行间隔
+	expireCycleInterval = 100 * time.Millisecond
+
+	// expireCycleBatch 每批处理的到期索引数量
+	expireCycleBatch = 64
+
+	// expireCycleTimeLimit 单次主动过期的最长耗时，避免长时间占用引擎
+	expireCycleTimeLimit = 25 * time.Millisecond
+)
+
+func expireKey(key []byte, expire int64) []byte {
+	buf := make([]byte, len(expireKeyPrefix)+8+len(key))
+	copy(buf, expireKeyPrefix)
+	binary.BigEndian.PutUint64(buf[len(expireKeyPrefix):], uint64(expire))
+	copy(buf[len(expireKeyPrefix)+8:], key)
+	return buf
+}
+
+// rawExpire 返回元数据或string的value中记录的过期时间，不判断是否已过期
+func rawExpire(encValue []byte) int64 {
+	if len(encValue) == 0 {
+		return 0
+	}
+	if encValue[0] == String {
+		_, expire := decodeString(encValue)
+		return expire
+	}
+	return decodeMetaData(encValue).expire
+}
+
+// addExpireEntry 在写批次内登记过期索引
+func (rds *RedisDataStructure) addExpireEntry(wb *storage.WriteBatch, key []byte, expire int64) {
+	if expire != 0 {
+		_ = wb.Put(expireKey(key, expire), nil)
+	}
+}
+
+func (rds *RedisDataStructure) expireLoop() {
+	defer rds.wg.Done()
+
+	ticker := time.NewTicker(expireCycleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rds.closeCh:
+			return
+		case <-ticker.C:
+		}
+
+		// 集群的follower不能写入，由leader删除后同步过来
+		if _, err := rds.activeExpireCycle(); err != nil && err != constant.ErrNotLeader {
+			logrus.Warnf("redis active expire failed,err:%v", err)
+		}
+	}
+}
+
+// activeExpireCycle 分批删除已到期的key，直到没有到期的索引或超出耗时限制，返回删除的key数量
+func (rds *RedisDataStructure) activeExpireCycle() (int, error) {
+	start := time.Now()
+	var total int
+	for {
+		select {
+		case <-rds.closeCh:
+			return total, nil
+		default:
+		}
+
+		n, more, err := rds.expireBatch()
+		total += n
+		if err != nil || !more || time.Since(start) > expireCycleTimeLimit {
+			return total, err
+		}
+	}
+}
+
+// expireBatch 处理一批已到期的索引，返回删除的key数量以及是否可能还有到期的索引
+func (rds *RedisDataStructure) expireBatch() (int, bool, error) {
+	now := time.Now().UnixNano()
+
+	var entries [][]byte
+	err := rds.iteratePrefix(expireKeyPrefix, func(suffix []byte, _ func() ([]byte, error)) (bool, error) {
+		if int64(binary.BigEndian.Uint64(suffix[:8])) > now {
+			return false, nil
+		}
+		entries = append(entries, suffix)
+		return len(entries) < expireCycleBatch, nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	var deleted int
+	for _, entry := range entries {
+		ok, err := rds.expireEntry(int64(binary.BigEndian.Uint64(entry[:8])), entry[8:])
+		if err != nil {
+			return deleted, false, err
+		}
+		if ok {
+			deleted++
+		}
+	}
+	return deleted, len(entries) == expireCycleBatch, nil
+}
+
+// expireEntry 处理一条到期的索引，返回是否删除了key
+func (rds *RedisDataStructure) expireEntry(expire int64, key []byte) (bool, error) {
+	// 只在key的过期时间仍与索引一致时删除，数据部分的回收任务与索引的删除在同一批次内提交
+	var deleted bool
+	err := rds.updateWithGC(key, func(old []byte) ([]byte, error) {
+		deleted = old != nil && rawExpire(old) == expire
+		if !deleted {
+			return nil, errUnchanged
+		}
+		return nil, nil
+	}, func(wb *storage.WriteBatch) {
+		_ = wb.Delete(expireKey(key, expire))
+	})
+	if err == errUnchanged {
+		err = rds.db.Delete(expireKey(key, expire))
+	}
+	if err != nil {
+		return false, err
+	}
+	if deleted {
+		atomic.AddUint64(&rds.expiredKeys, 1)
+		rds.notify(EventExpired, "expired", key)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,145 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"kv-db-lab/storage"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRedisDataStructure_ActiveExpire(t *testing.T) {
+	rds := openTestRds(t)
+	assert.Nil(t, rds.Set([]byte("str"), []byte("v"), time.Millisecond))
+	assert.Nil(t, rds.Set([]byte("live"), []byte("v"), time.Hour))
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	_, err := rds.PExpire([]byte("hash"), 1)
+	assert.Nil(t, err)
+
+	// 移除过期时间后旧的索引失效
+	assert.Nil(t, rds.PSetEX([]byte("persist"), []byte("v"), 50))
+	ok, err := rds.Persist([]byte("persist"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	time.Sleep(60 * time.Millisecond)
+
+	// 后台协程可能已经处理了一部分，以统计的数量为准
+	_, err = rds.activeExpireCycle()
+	assert.Nil(t, err)
+	_, err = rds.collectGarbage()
+	assert.Nil(t, err)
+
+	keys, err := rds.Keys([]byte("*"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"live", "persist"}, toStrings(keys))
+	// 只剩下两个string以及live的过期索引
+	assert.Equal(t, 3, len(rds.engine.GetAllKeys()))
+	assert.Equal(t, uint64(2), rds.Stat().ExpiredKeys)
+	assert.Equal(t, uint64(1), rds.Stat().CollectedEntries)
+}
+
+func TestRedisDataStructure_ExpiredKeyChangesType(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("key")
+	assert.Nil(t, rds.PSetEX(key, []byte("v"), 1))
+	time.Sleep(5 * time.Millisecond)
+
+	// 过期的string视为不存在，可以直接作为hash使用
+	ok, err := rds.HSet(key, []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// 旧的过期索引不会删除新的hash
+	_, err = rds.activeExpireCycle()
+	assert.Nil(t, err)
+	value, err := rds.HGet(key, []byte("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+}
+
+func TestRedisDataStructure_ExpireSurvivesRestart(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-redis-expire")
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	db, err := storage.OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	rds, err := NewRedisDateStructure(db)
+	assert.Nil(t, err)
+	_, _ = rds.SAdd([]byte("set"), []byte("m"))
+	_, err = rds.Expire([]byte("set"), 1)
+	assert.Nil(t, err)
+	assert.Nil(t, rds.Close())
+
+	db, err = storage.OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	rds, err = NewRedisDateStructure(db)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+	}()
+
+	// 重启后由后台协程删除key并回收数据部分
+	assert.Eventually(t, func() bool {
+		return len(db.GetAllKeys()) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestRedisDataStructure_ExpiredKeyMissing(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-redis-expire")
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	db, err := storage.OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	// 不启动后台协程，数据的变化只可能来自读取
+	rds := &RedisDataStructure{engine: db, db: db, background: &background{gcNotify: make(chan struct{}, 1), closeCh: make(chan struct{})}}
+	defer func() {
+		_ = rds.Close()
+	}()
+
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	_, err = rds.PExpire([]byte("hash"), 1)
+	assert.Nil(t, err)
+	assert.Nil(t, rds.PSetEX([]byte("str"), []byte("v"), 1))
+	// 成员被全部删除的hash
+	_, _ = rds.HSet([]byte("empty"), []byte("f"), []byte("v"))
+	_, _ = rds.HDel([]byte("empty"), []byte("f"))
+	time.Sleep(5 * time.Millisecond)
+	keys := len(db.GetAllKeys())
+
+	// 各类读取都视为不存在，且不写入任何数据
+	for _, key := range []string{"hash", "str", "empty"} {
+		_, err = rds.Get([]byte(key))
+		assert.Equal(t, constant.ErrNotExist, err, key)
+		_, err = rds.Type([]byte(key))
+		assert.Equal(t, constant.ErrNotExist, err, key)
+		n, err := rds.LLen([]byte(key))
+		assert.Nil(t, err, key)
+		assert.Equal(t, uint32(0), n, key)
+		exists, err := rds.Exists([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, exists, key)
+	}
+	assert.Equal(t, keys, len(db.GetAllKeys()))
+	assert.Equal(t, uint64(0), rds.Stat().ExpiredKeys)
+
+	// 可以直接作为其他类型写入
+	for _, key := range []string{"hash", "empty"} {
+		_, err = rds.LPush([]byte(key), []byte("a"))
+		assert.Nil(t, err, key)
+	}
+	typ, err := rds.Type([]byte("empty"))
+	assert.Nil(t, err)
+	assert.Equal(t, "list", TypeName(typ))
+}
@@ -0,0 +1,201 @@
+package redis
+
+import (
+	"bytes"
+	"github.com/sirupsen/logrus"
+	"kv-db-lab/constant"
+	"kv-db-lab/storage"
+	"sync/atomic"
+	"time"
+)
+
+// =========================数据部分的回收=============================================================
+// 删除、过期或以新版本号覆盖非string类型的key时，只需修改元数据，旧的 key | version 下的数据部分登记为回收任务
+// 回收任务格式 gcKeyPrefix | key | version => nil，由后台协程分批删除，删除后的空间由Merge回收
+
+// gcKeyPrefix 回收任务的前缀
+var gcKeyPrefix = append(append([]byte{}, internalKeyPrefix...), "gc"...)
+
+const (
+	// gcBatchSize 每个批次删除的数据数量
+	gcBatchSize = 512
+
+	// gcInterval 后台协程检查回收任务的间隔
+	gcInterval = time.Second
+)
+
+func gcKey(prefix []byte) []byte {
+	return append(append([]byte{}, gcKeyPrefix...), prefix...)
+}
+
+// staleDataPrefix 若encValue为非string类型的元数据，返回其数据部分的前缀，否则返回nil
+func staleDataPrefix(key, encValue []byte) []byte {
+	if len(encValue) == 0 || encValue[0] == String {
+		return nil
+	}
+	return dataPrefix(key, decodeMetaData(encValue))
+}
+
+// addGCTask 在写批次内登记key原有数据部分的回收任务，与删除或覆盖元数据一同提交
+func (rds *RedisDataStructure) addGCTask(wb *storage.WriteBatch, key, encValue []byte) {
+	if prefix := staleDataPrefix(key, encValue); prefix != nil {
+		_ = wb.Put(gcKey(prefix), nil)
+	}
+}
+
+// updateWithGC
+//
+//	@Description: 与db.Update一致的读-改-写，以读到的值作为写批次的提交条件，提交前key被修改时重新执行fn
+//	新的值不再使用原有的数据部分时(删除或以新版本号覆盖)，回收任务与新的值在同一批次内提交
+//	@receiver rds
+//	@param key
+//	@param fn  入参为当前值(不存在时为nil)，返回新值；返回nil表示删除该key，返回error则放弃本次更新
+//	@param extra  fn返回后向批次追加其他写入，如过期索引，可以为nil
+//	@return error
+func (rds *RedisDataStructure) updateWithGC(key []byte, fn func(old []byte) ([]byte, error), extra func(wb *storage.WriteBatch)) error {
+	for {
+		old, err := rds.db.Get(key)
+		if err != nil && err != constant.ErrNotExist {
+			return err
+		}
+		newValue, err := fn(old)
+		if err != nil {
+			return err
+		}
+		if newValue == nil && old == nil {
+			return nil
+		}
+
+		wb := rds.newWriteBatch(4)
+		if err = wb.Expect(key, old); err != nil {
+			return err
+		}
+		if newValue == nil {
+			_ = wb.Delete(key)
+		} else {
+			_ = wb.Put(key, newValue)
+		}
+		stale := staleDataPrefix(key, old)
+		if stale != nil && bytes.Equal(staleDataPrefix(key, newValue), stale) {
+			stale = nil
+		}
+		if stale != nil {
+			_ = wb.Put(gcKey(stale), nil)
+		}
+		if extra != nil {
+			extra(wb)
+		}
+
+		err = wb.Commit()
+		if err == constant.ErrConditionFailed {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if stale != nil {
+			rds.notifyGC()
+		}
+		return nil
+	}
+}
+
+func (rds *RedisDataStructure) notifyGC() {
+	select {
+	case rds.gcNotify <- struct{}{}:
+	default:
+	}
+}
+
+func (rds *RedisDataStructure) gcLoop() {
+	defer rds.wg.Done()
+
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rds.closeCh:
+			return
+		case <-rds.gcNotify:
+		case <-ticker.C:
+		}
+
+		// 集群的follower不能写入，由leader回收后同步过来
+		if _, err := rds.collectGarbage(); err != nil && err != constant.ErrNotLeader {
+			logrus.Warnf("redis gc failed,err:%v", err)
+		}
+	}
+}
+
+// pendingGCPrefixes 返回所有等待回收的数据部分前缀
+func (rds *RedisDataStructure) pendingGCPrefixes() (map[string]struct{}, error) {
+	prefixes := make(map[string]struct{})
+	err := rds.iteratePrefix(gcKeyPrefix, func(suffix []byte, _ func() ([]byte, error)) (bool, error) {
+		prefixes[string(suffix)] = struct{}{}
+		return true, nil
+	})
+	return prefixes, err
+}
+
+// collectGarbage 处理当前所有的回收任务，返回删除的数据数量
+func (rds *RedisDataStructure) collectGarbage() (int, error) {
+	rds.gcLock.Lock()
+	defer rds.gcLock.Unlock()
+
+	var prefixes [][]byte
+	err := rds.iteratePrefix(gcKeyPrefix, func(suffix []byte, _ func() ([]byte, error)) (bool, error) {
+		prefixes = append(prefixes, suffix)
+		return true, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var total int
+	for _, prefix := range prefixes {
+		n, err := rds.collectPrefix(prefix)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// collectPrefix 分批删除prefix下的数据，最后一个批次内同时移除回收任务
+func (rds *RedisDataStructure) collectPrefix(prefix []byte) (int, error) {
+	var total int
+	for {
+		select {
+		case <-rds.closeCh:
+			return total, nil
+		default:
+		}
+
+		keys := make([][]byte, 0, gcBatchSize)
+		err := rds.iteratePrefix(prefix, func(suffix []byte, _ func() ([]byte, error)) (bool, error) {
+			keys = append(keys, append(append([]byte{}, prefix...), suffix...))
+			return len(keys) < gcBatchSize, nil
+		})
+		if err != nil {
+			return total, err
+		}
+
+		wb := rds.newWriteBatch(len(keys) + 1)
+		for _, key := range keys {
+			_ = wb.Delete(key)
+		}
+		finished := len(keys) < gcBatchSize
+		if finished {
+			_ = wb.Delete(gcKey(prefix))
+		}
+		if err = wb.Commit(); err != nil {
+			return total, err
+		}
+		total += len(keys)
+		atomic.AddUint64(&rds.collectedEntries, uint64(len(keys)))
+		if finished {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/model"
+	"kv-db-lab/storage"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRedisDataStructure_DelCollectsData(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("hash")
+	for i := 0; i < gcBatchSize*2+10; i++ {
+		_, err := rds.HSet(key, []byte(fmt.Sprintf("field-%04d", i)), []byte("v"))
+		assert.Nil(t, err)
+	}
+	_, _ = rds.ZAdd([]byte("zset"), 1, []byte("m"))
+	_, _ = rds.SAdd([]byte("set"), []byte("m"))
+
+	assert.Nil(t, rds.Del(key))
+	assert.Nil(t, rds.Del([]byte("zset")))
+	// 以string覆盖set
+	assert.Nil(t, rds.Set([]byte("set"), []byte("v"), 0))
+
+	_, err := rds.collectGarbage()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(rds.engine.GetAllKeys()))
+
+	// 重新写入同名的hash不受影响
+	_, _ = rds.HSet(key, []byte("f"), []byte("v"))
+	value, err := rds.HGet(key, []byte("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+}
+
+func TestRedisDataStructure_ExpiredDataCollected(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("list")
+	pushAll(t, rds, key, "a", "b", "c")
+	ok, err := rds.PExpire(key, 1)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	time.Sleep(5 * time.Millisecond)
+
+	// 以新的版本号覆盖过期的list
+	pushAll(t, rds, key, "d")
+	_, err = rds.collectGarbage()
+	assert.Nil(t, err)
+	// 旧的过期索引失效后被移除
+	n, err := rds.activeExpireCycle()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, []string{"d"}, lrangeAll(t, rds, key))
+	assert.Equal(t, 2, len(rds.engine.GetAllKeys()))
+
+	// 修改过期时间为过去的时间直接删除
+	_, _ = rds.SAdd([]byte("set"), []byte("m"))
+	_, err = rds.PExpireAt([]byte("set"), 1)
+	assert.Nil(t, err)
+	_, err = rds.collectGarbage()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rds.engine.GetAllKeys()))
+}
+
+func TestRedisDataStructure_GCSurvivesRestart(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-redis-gc")
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	db, err := storage.OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	// 不启动后台协程，模拟回收前进程退出
+	rds := &RedisDataStructure{engine: db, db: db, background: &background{gcNotify: make(chan struct{}, 1), closeCh: make(chan struct{})}}
+	for i := 0; i < 10; i++ {
+		_, _ = rds.SAdd([]byte("set"), []byte(fmt.Sprintf("m-%d", i)))
+	}
+	assert.Nil(t, rds.Del([]byte("set")))
+	assert.Equal(t, 11, len(db.GetAllKeys()))
+	assert.Nil(t, rds.Close())
+
+	db, err = storage.OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	rds, err = NewRedisDateStructure(db)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+	}()
+	assert.Eventually(t, func() bool {
+		return len(db.GetAllKeys()) == 0
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestRedisDataStructure_GCTaskWithOverwrite(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-redis-gc")
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	db, err := storage.OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	// 不启动后台协程，回收任务只能由覆盖时的写入登记
+	rds := &RedisDataStructure{engine: db, db: db, background: &background{gcNotify: make(chan struct{}, 1), closeCh: make(chan struct{})}}
+	defer func() {
+		_ = rds.Close()
+	}()
+
+	expiredHash := func(key string) []byte {
+		_, err := rds.HSet([]byte(key), []byte("f"), []byte("v"))
+		assert.Nil(t, err)
+		encValue, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		_, err = rds.PExpire([]byte(key), 1)
+		assert.Nil(t, err)
+		return gcKey(staleDataPrefix([]byte(key), encValue))
+	}
+	setTask := expiredHash("set")
+	appendTask := expiredHash("append")
+	hsetTask := expiredHash("hset")
+	time.Sleep(5 * time.Millisecond)
+
+	// 读取已过期的key不写入任何数据
+	keys := len(db.GetAllKeys())
+	value, err := rds.HGet([]byte("hset"), []byte("f"))
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+	_, err = rds.HLen([]byte("set"))
+	assert.Nil(t, err)
+	assert.Equal(t, keys, len(db.GetAllKeys()))
+
+	// 覆盖已过期的hash时，回收任务与新的值一同写入
+	_, ok, err := rds.SetWithOptions([]byte("set"), []byte("v"), &SetOptions{})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = rds.Append([]byte("append"), []byte("v"))
+	assert.Nil(t, err)
+	_, err = rds.HSet([]byte("hset"), []byte("g"), []byte("v"))
+	assert.Nil(t, err)
+	for _, task := range [][]byte{setTask, appendTask, hsetTask} {
+		_, err = db.Get(task)
+		assert.Nil(t, err)
+	}
+
+	_, err = rds.collectGarbage()
+	assert.Nil(t, err)
+	_, err = rds.activeExpireCycle()
+	assert.Nil(t, err)
+	// 两个string、新的hash的元数据与field
+	assert.Equal(t, 4, len(db.GetAllKeys()))
+}
@@ -1,21 +1,681 @@
 package redis
 
 import (
-	"errors"
+	"bytes"
+	"encoding/binary"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"kv-db-lab/pkg"
+	"kv-db-lab/storage"
+	"math"
+	"math/rand"
+	"time"
 )
 
+// Del 删除key，非string类型的数据部分登记为回收任务，与元数据的删除在同一批次内提交
 func (rds *RedisDataStructure) Del(key []byte) error {
-	return rds.db.Delete(key)
+	encValue, err := rds.db.Get(key)
+	if err == constant.ErrNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if encValue[0] == String {
+		err = rds.db.Delete(key)
+	} else {
+		wb := rds.newWriteBatch(2)
+		_ = wb.Delete(key)
+		rds.addGCTask(wb, key, encValue)
+		if err = wb.Commit(); err == nil {
+			rds.notifyGC()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// 已过期的key视为不存在，不产生事件
+	if _, _, exist := decodeKeyInfo(encValue); exist {
+		rds.notify(EventGeneric, "del", key)
+	}
+	return nil
 }
 
+// Type 返回key的类型，不存在或已过期时返回ErrNotExist
 func (rds *RedisDataStructure) Type(key []byte) (dataType, error) {
-	value, err := rds.db.Get(key)
+	encValue, err := rds.db.Get(key)
+	if err != nil && err != constant.ErrNotExist {
+		return 0, err
+	}
+	typ, _, exist := decodeKeyInfo(encValue)
+	if !exist {
+		return 0, constant.ErrNotExist
+	}
+	return typ, nil
+}
+
+// typeNames 与redis TYPE命令一致的类型名称
+var typeNames = map[dataType]string{
+	String:      "string",
+	List:        "list",
+	Hash:        "hash",
+	Set:         "set",
+	Zset:        "zset",
+	Bitmap:      "bitmap",
+	HyperLogLog: "hyperloglog",
+	Stream:      "stream",
+}
+
+// TypeName 返回数据类型的名称，未知类型返回none
+func TypeName(typ dataType) string {
+	if name, ok := typeNames[typ]; ok {
+		return name
+	}
+	return "none"
+}
+
+// decodeKeyInfo
+//
+//	@Description: 解析元数据或string的value，得到key的类型与过期时间
+//	@param encValue
+//	@return dataType
+//	@return int64  过期时间，为0时不过期
+//	@return bool  是否存在，已过期或成员为空的key视为不存在
+func decodeKeyInfo(encValue []byte) (dataType, int64, bool) {
+	if len(encValue) == 0 {
+		return 0, 0, false
+	}
+
+	typ := encValue[0]
+	// 与redis一致，stream的元素被全部删除后仍然存在
+	if typ != String && typ != Stream && decodeMetaData(encValue).size == 0 {
+		return 0, 0, false
+	}
+	expire := rawExpire(encValue)
+
+	if expire != 0 && expire <= time.Now().UnixNano() {
+		return 0, 0, false
+	}
+	return typ, expire, true
+}
+
+// dataPrefix 非string类型数据部分的公共前缀 key | version
+func dataPrefix(key []byte, meta *metaData) []byte {
+	buf := make([]byte, len(key)+8)
+	copy(buf, key)
+	binary.LittleEndian.PutUint64(buf[len(key):], uint64(meta.version))
+	return buf
+}
+
+// scanKeys
+//
+//	@Description: 按顺序遍历所有存在的key，跳过各类型的数据部分以及已过期的key
+//	数据部分的key以 key | version 为前缀，但key没有长度前缀，数据部分不一定紧跟在元数据之后，
+//	如 tags 的数据部分可能排在 tags:count 之后。以同一前缀开头的key在遍历中是连续的一段，
+//	因此只在遍历越过该段之后才移除前缀；已删除但尚未回收的数据部分没有元数据，按回收任务跳过
+//	@receiver rds
+//	@param fn  返回false时停止遍历
+//	@return error
+func (rds *RedisDataStructure) scanKeys(fn func(key []byte, typ dataType) bool) error {
+	return rds.scanKeysAfter(nil, fn)
+}
+
+// scanKeysAfter
+//
+//	@Description: 从after之后继续按顺序遍历存在的key，after为nil时从头遍历
+//	after之后仍未越过的数据部分只可能属于after的真前缀，从这些前缀的元数据恢复遍历的状态
+//	@receiver rds
+//	@param after  上次遍历到的key
+//	@param fn  返回false时停止遍历
+//	@return error
+func (rds *RedisDataStructure) scanKeysAfter(after []byte, fn func(key []byte, typ dataType) bool) error {
+	stale, err := rds.pendingGCPrefixes()
+	if err != nil {
+		return err
+	}
+	// 元数据已遍历过、数据部分的范围尚未越过的前缀，前缀可能嵌套，如 tags 与 tags:count 均为hash
+	prefixes, err := rds.activePrefixes(after)
+	if err != nil {
+		return err
+	}
+
+	iter := rds.db.NewIterate(model.DefaultIteratorOptions)
+	defer iter.Close()
+
+	if after == nil {
+		iter.Rewind()
+	} else {
+		iter.Seek(after)
+		if iter.Valid() && bytes.Equal(iter.Key(), after) {
+			iter.Next()
+		}
+	}
+	for ; iter.Valid(); iter.Next() {
+		key := iter.Key()
+		if bytes.HasPrefix(key, internalKeyPrefix) {
+			continue
+		}
+		active := prefixes[:0]
+		for _, prefix := range prefixes {
+			if bytes.HasPrefix(key, prefix) || bytes.Compare(key, prefix) < 0 {
+				active = append(active, prefix)
+			}
+		}
+		prefixes = active
+		if isDataKey(key, prefixes, stale) {
+			continue
+		}
+
+		encValue, err := iter.Value()
+		if err != nil {
+			return err
+		}
+		// 元数据与string的value均以类型开头，不会为空
+		if len(encValue) == 0 {
+			continue
+		}
+		key = append([]byte{}, key...)
+		if encValue[0] != String {
+			prefixes = append(prefixes, dataPrefix(key, decodeMetaData(encValue)))
+		}
+
+		typ, _, exist := decodeKeyInfo(encValue)
+		if !exist {
+			continue
+		}
+		if !fn(key, typ) {
+			break
+		}
+	}
+	return nil
+}
+
+// activePrefixes 返回遍历到after时尚未越过的数据部分前缀，即after的真前缀中非string类型的数据前缀
+func (rds *RedisDataStructure) activePrefixes(after []byte) ([][]byte, error) {
+	var prefixes [][]byte
+	for n := 1; n < len(after); n++ {
+		encValue, err := rds.db.Get(after[:n])
+		if err == constant.ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(encValue) == 0 || encValue[0] == String {
+			continue
+		}
+		prefix := dataPrefix(after[:n], decodeMetaData(encValue))
+		// 排在after之前且不是after前缀的数据部分已经越过
+		if bytes.HasPrefix(after, prefix) || bytes.Compare(after, prefix) < 0 {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes, nil
+}
+
+// isDataKey key是否属于某个元数据的数据部分，或者属于等待回收的数据部分
+func isDataKey(key []byte, prefixes [][]byte, stale map[string]struct{}) bool {
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	// 回收任务的前缀为 key | version，至少8个字节
+	for n := 8; n < len(key) && len(stale) > 0; n++ {
+		if _, ok := stale[string(key[:n])]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Exists 返回keys中存在的数量，重复的key重复计数
+func (rds *RedisDataStructure) Exists(keys ...[]byte) (int, error) {
+	var count int
+	for _, key := range keys {
+		encValue, err := rds.db.Get(key)
+		if err == constant.ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if _, _, exist := decodeKeyInfo(encValue); exist {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// updateExpire
+//
+//	@Description: 原子地修改key的过期时间，新的过期时间不晚于当前时间时删除key
+//	@receiver rds
+//	@param key
+//	@param event  修改后通知的事件名，删除key时通知del
+//	@param fn  入参为当前的过期时间，返回新的过期时间以及是否修改
+//	@return bool  是否修改
+//	@return error
+func (rds *RedisDataStructure) updateExpire(key []byte, event string, fn func(expire int64) (int64, bool)) (bool, error) {
+	var deleted bool
+	var newExpire int64
+	// 新的过期索引以及删除时数据部分的回收任务与修改在同一批次内提交
+	err := rds.updateWithGC(key, func(old []byte) ([]byte, error) {
+		_, expire, exist := decodeKeyInfo(old)
+		if !exist {
+			return nil, errUnchanged
+		}
+		var ok bool
+		newExpire, ok = fn(expire)
+		if !ok {
+			return nil, errUnchanged
+		}
+
+		deleted = newExpire != 0 && newExpire <= time.Now().UnixNano()
+		if deleted {
+			return nil, nil
+		}
+		if old[0] == String {
+			value, _ := decodeString(old)
+			return encodeString(value, newExpire), nil
+		}
+		meta := decodeMetaData(old)
+		meta.expire = newExpire
+		return meta.encode(), nil
+	}, func(wb *storage.WriteBatch) {
+		if !deleted {
+			rds.addExpireEntry(wb, key, newExpire)
+		}
+	})
+	if err == errUnchanged {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if deleted {
+		event = "del"
+	}
+	rds.notify(EventGeneric, event, key)
+	return true, nil
+}
+
+// Expire 设置以秒为单位的过期时间，返回key是否存在
+func (rds *RedisDataStructure) Expire(key []byte, seconds int64) (bool, error) {
+	return rds.expireAfter(key, seconds, time.Second)
+}
+
+// PExpire 设置以毫秒为单位的过期时间，返回key是否存在
+func (rds *RedisDataStructure) PExpire(key []byte, milliseconds int64) (bool, error) {
+	return rds.expireAfter(key, milliseconds, time.Millisecond)
+}
+
+func (rds *RedisDataStructure) expireAfter(key []byte, n int64, unit time.Duration) (bool, error) {
+	now := time.Now().UnixNano()
+	if n > (math.MaxInt64-now)/int64(unit) || n < -now/int64(unit) {
+		return false, constant.ErrInvalidExpire
+	}
+	return rds.expireAtNano(key, now+n*int64(unit))
+}
+
+// ExpireAt 设置以unix时间戳(秒)表示的过期时间，返回key是否存在
+func (rds *RedisDataStructure) ExpireAt(key []byte, timestamp int64) (bool, error) {
+	if timestamp > math.MaxInt64/int64(time.Second) {
+		return false, constant.ErrInvalidExpire
+	}
+	if timestamp < 0 {
+		timestamp = 0
+	}
+	return rds.expireAtNano(key, timestamp*int64(time.Second))
+}
+
+// PExpireAt 设置以unix时间戳(毫秒)表示的过期时间，返回key是否存在
+func (rds *RedisDataStructure) PExpireAt(key []byte, timestamp int64) (bool, error) {
+	if timestamp > math.MaxInt64/int64(time.Millisecond) {
+		return false, constant.ErrInvalidExpire
+	}
+	if timestamp < 0 {
+		timestamp = 0
+	}
+	return rds.expireAtNano(key, timestamp*int64(time.Millisecond))
+}
+
+func (rds *RedisDataStructure) expireAtNano(key []byte, expire int64) (bool, error) {
+	// 与redis一致，过去的时间直接删除key；过期时间0表示不过期，这里同样视为过去的时间
+	if expire <= 0 {
+		expire = 1
+	}
+	return rds.updateExpire(key, "expire", func(int64) (int64, bool) {
+		return expire, true
+	})
+}
+
+// Persist 移除过期时间，返回是否移除
+func (rds *RedisDataStructure) Persist(key []byte) (bool, error) {
+	return rds.updateExpire(key, "persist", func(expire int64) (int64, bool) {
+		return 0, expire != 0
+	})
+}
+
+// TTL 返回以秒为单位的剩余过期时间，key不存在时为-2，未设置过期时间时为-1
+func (rds *RedisDataStructure) TTL(key []byte) (int64, error) {
+	ttl, err := rds.PTTL(key)
+	if err != nil || ttl < 0 {
+		return ttl, err
+	}
+	// 与redis一致四舍五入
+	return (ttl + 500) / 1000, nil
+}
+
+// PTTL 返回以毫秒为单位的剩余过期时间，key不存在时为-2，未设置过期时间时为-1
+func (rds *RedisDataStructure) PTTL(key []byte) (int64, error) {
+	encValue, err := rds.db.Get(key)
+	if err != nil && err != constant.ErrNotExist {
+		return 0, err
+	}
+
+	_, expire, exist := decodeKeyInfo(encValue)
+	if !exist {
+		return -2, nil
+	}
+	if expire == 0 {
+		return -1, nil
+	}
+	return (expire - time.Now().UnixNano()) / int64(time.Millisecond), nil
+}
+
+// Rename 将key重命名为newKey，newKey已存在时被覆盖，key不存在时返回ErrNotExist
+func (rds *RedisDataStructure) Rename(key, newKey []byte) error {
+	_, err := rds.rename(key, newKey, false)
+	return err
+}
+
+// RenameNX newKey不存在时才重命名，返回是否重命名
+func (rds *RedisDataStructure) RenameNX(key, newKey []byte) (bool, error) {
+	return rds.rename(key, newKey, true)
+}
+
+// rename
+//
+//	@Description: 数据部分以相同的版本号迁移到newKey下，与元数据的修改在同一批次内提交
+//	@receiver rds
+//	@param key
+//	@param newKey
+//	@param nx  为true时newKey存在则不重命名
+//	@return bool
+//	@return error
+func (rds *RedisDataStructure) rename(key, newKey []byte, nx bool) (bool, error) {
+	encValue, err := rds.db.Get(key)
+	if err == constant.ErrNotExist {
+		return false, constant.ErrNotExist
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, _, exist := decodeKeyInfo(encValue); !exist {
+		return false, constant.ErrNotExist
+	}
+
+	dstValue, err := rds.db.Get(newKey)
+	if err != nil && err != constant.ErrNotExist {
+		return false, err
+	}
+	_, _, dstExist := decodeKeyInfo(dstValue)
+	if bytes.Equal(key, newKey) {
+		return !nx, nil
+	}
+	if nx && dstExist {
+		return false, nil
+	}
+
+	var deletes [][]byte
+	var puts []*KeyValue
+	var newPrefix []byte
+
+	// 迁移key的数据部分
+	if encValue[0] != String {
+		meta := decodeMetaData(encValue)
+		prefix := dataPrefix(key, meta)
+		newPrefix = dataPrefix(newKey, meta)
+		err = rds.iteratePrefix(prefix, func(suffix []byte, getValue func() ([]byte, error)) (bool, error) {
+			value, err := getValue()
+			if err != nil {
+				return false, err
+			}
+			deletes = append(deletes, append(append([]byte{}, prefix...), suffix...))
+			puts = append(puts, &KeyValue{Key: append(append([]byte{}, newPrefix...), suffix...), Value: value})
+			return true, nil
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	wb := rds.newWriteBatch(len(deletes) + len(puts) + 4)
+	for _, k := range deletes {
+		_ = wb.Delete(k)
+	}
+	_ = wb.Delete(key)
+	for _, kv := range puts {
+		_ = wb.Put(kv.Key, kv.Value)
+	}
+	_ = wb.Put(newKey, encValue)
+	rds.addExpireEntry(wb, newKey, rawExpire(encValue))
+	// newKey原有的数据部分交给后台回收，版本号相同时数据已被覆盖
+	if !bytes.Equal(staleDataPrefix(newKey, dstValue), newPrefix) {
+		rds.addGCTask(wb, newKey, dstValue)
+	}
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	rds.notifyGC()
+	rds.notify(EventGeneric, "rename_from", key)
+	rds.notify(EventGeneric, "rename_to", newKey)
+	return true, nil
+}
+
+// Keys 返回所有匹配pattern的key
+func (rds *RedisDataStructure) Keys(pattern []byte) ([][]byte, error) {
+	result := make([][]byte, 0)
+	err := rds.scanKeys(func(key []byte, _ dataType) bool {
+		if pkg.GlobMatch(pattern, key) {
+			result = append(result, key)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Scan
+//
+//	@Description: 基于游标分批遍历所有key，游标为上次遍历到的key，从该key之后继续
+//	遍历期间一直存在的key都会被返回，key的增删不影响之后的位置
+//	@receiver rds
+//	@param after  上次返回的游标，首次传nil
+//	@param match  glob格式，为空时不过滤
+//	@param count  本次最多遍历的key数量，小于等于0时为10
+//	@param keyType  类型名称，与TYPE命令一致，为空时不过滤
+//	@return [][]byte
+//	@return []byte  下次调用的游标，为nil时表示遍历结束
+//	@return error
+func (rds *RedisDataStructure) Scan(after []byte, match []byte, count int, keyType string) ([][]byte, []byte, error) {
+	if count <= 0 {
+		count = 10
+	}
+
+	result := make([][]byte, 0)
+	var scanned int
+	var last []byte
+	var more bool
+	err := rds.scanKeysAfter(after, func(key []byte, typ dataType) bool {
+		if scanned == count {
+			more = true
+			return false
+		}
+		scanned++
+		last = key
+
+		if len(match) > 0 && !pkg.GlobMatch(match, key) {
+			return true
+		}
+		if keyType != "" && TypeName(typ) != keyType {
+			return true
+		}
+		result = append(result, key)
+		return true
+	})
 	if err != nil {
-		return 8, err
+		return nil, nil, err
+	}
+
+	if !more {
+		return result, nil, nil
 	}
-	if value == nil {
-		return 8, errors.New("value is empty")
+	return result, last, nil
+}
+
+// RandomKey 随机返回一个存在的key，没有key时返回nil
+func (rds *RedisDataStructure) RandomKey() ([]byte, error) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// 蓄水池抽样
+	var result []byte
+	var n int
+	err := rds.scanKeys(func(key []byte, _ dataType) bool {
+		n++
+		if r.Intn(n) == 0 {
+			result = key
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
+	return result, nil
+}
+
+// DBSize 返回存在的key数量，需要遍历所有key并跳过数据部分与已过期的key，时间复杂度为O(N)
+func (rds *RedisDataStructure) DBSize() (int64, error) {
+	var n int64
+	err := rds.scanKeys(func(_ []byte, _ dataType) bool {
+		n++
+		return true
+	})
+	return n, err
+}
+
+// FlushDB 删除所有数据，包括各类型的数据部分以及内部的过期与回收索引
+func (rds *RedisDataStructure) FlushDB() error {
+	// 与回收过程互斥，避免回收时写入已删除的数据
+	rds.gcLock.Lock()
+	defer rds.gcLock.Unlock()
+
+	var keys [][]byte
+	err := rds.iteratePrefix(nil, func(key []byte, _ func() ([]byte, error)) (bool, error) {
+		keys = append(keys, key)
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	batchSize := int(model.DefaultWriteBatchOptions.MaxBatchSize)
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > batchSize {
+			n = batchSize
+		}
+		wb := rds.newWriteBatch(n)
+		for _, key := range keys[:n] {
+			_ = wb.Delete(key)
+		}
+		if err := wb.Commit(); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
 
-	return value[0], nil
+// Move
+//
+//	@Description: 将key连同数据部分迁移到另一个数据库，先写入dst再从当前数据库删除
+//	@receiver rds
+//	@param key
+//	@param dst
+//	@return bool  key不存在或dst中已存在该key时返回false
+//	@return error
+func (rds *RedisDataStructure) Move(key []byte, dst *RedisDataStructure) (bool, error) {
+	if rds == dst {
+		return false, nil
+	}
+	encValue, err := rds.db.Get(key)
+	if err == constant.ErrNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, _, exist := decodeKeyInfo(encValue); !exist {
+		return false, nil
+	}
+
+	dstValue, err := dst.db.Get(key)
+	if err != nil && err != constant.ErrNotExist {
+		return false, err
+	}
+	if _, _, exist := decodeKeyInfo(dstValue); exist {
+		return false, nil
+	}
+
+	// 数据部分保持相同的key
+	var data []*KeyValue
+	var prefix []byte
+	if encValue[0] != String {
+		prefix = dataPrefix(key, decodeMetaData(encValue))
+		err = rds.iteratePrefix(prefix, func(suffix []byte, getValue func() ([]byte, error)) (bool, error) {
+			value, err := getValue()
+			if err != nil {
+				return false, err
+			}
+			data = append(data, &KeyValue{Key: append(append([]byte{}, prefix...), suffix...), Value: value})
+			return true, nil
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	wb := dst.newWriteBatch(len(data) + 3)
+	for _, kv := range data {
+		_ = wb.Put(kv.Key, kv.Value)
+	}
+	_ = wb.Put(key, encValue)
+	dst.addExpireEntry(wb, key, rawExpire(encValue))
+	// dst中已过期的同名key的数据部分交给后台回收，版本号相同时数据已被覆盖
+	if !bytes.Equal(staleDataPrefix(key, dstValue), prefix) {
+		dst.addGCTask(wb, key, dstValue)
+	}
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	dst.notifyGC()
+
+	wb = rds.newWriteBatch(len(data) + 2)
+	for _, kv := range data {
+		_ = wb.Delete(kv.Key)
+	}
+	_ = wb.Delete(key)
+	if expire := rawExpire(encValue); expire != 0 {
+		_ = wb.Delete(expireKey(key, expire))
+	}
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	rds.notify(EventGeneric, "move_from", key)
+	dst.notify(EventGeneric, "move_to", key)
+	return true, nil
 }
@@ -0,0 +1,321 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"testing"
+	"time"
+)
+
+func TestRedisDataStructure_Expire_TTL(t *testing.T) {
+	rds := openTestRds(t)
+	str, hash := []byte("str"), []byte("hash")
+	assert.Nil(t, rds.Set(str, []byte("v"), 0))
+	_, _ = rds.HSet(hash, []byte("f"), []byte("v"))
+
+	ttl, err := rds.TTL(str)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), ttl)
+	ttl, err = rds.TTL([]byte("missing"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), ttl)
+
+	ok, err := rds.Expire(str, 100)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ttl, err = rds.TTL(str)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), ttl)
+	value, err := rds.Get(str)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+
+	ok, err = rds.PExpire(hash, 50000)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	pttl, err := rds.PTTL(hash)
+	assert.Nil(t, err)
+	assert.True(t, pttl > 49000 && pttl <= 50000)
+	// 修改过期时间后hash仍可正常读写
+	value, err = rds.HGet(hash, []byte("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+
+	ok, err = rds.Persist(hash)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.Persist(hash)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ttl, _ = rds.TTL(hash)
+	assert.Equal(t, int64(-1), ttl)
+
+	ok, err = rds.ExpireAt(str, time.Now().Add(time.Hour).Unix())
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ttl, _ = rds.TTL(str)
+	assert.True(t, ttl > 3590 && ttl <= 3600)
+
+	// 过去的时间直接删除
+	ok, err = rds.PExpireAt(str, 1)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	n, err := rds.Exists(str)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	ok, err = rds.Expire([]byte("missing"), 10)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = rds.PExpire(hash, 1)
+	assert.Nil(t, err)
+	time.Sleep(5 * time.Millisecond)
+	n, _ = rds.Exists(hash, hash)
+	assert.Equal(t, 0, n)
+}
+
+func TestRedisDataStructure_Rename(t *testing.T) {
+	rds := openTestRds(t)
+	_, _ = rds.HSet([]byte("h1"), []byte("a"), []byte("1"))
+	_, _ = rds.HSet([]byte("h1"), []byte("b"), []byte("2"))
+	_, _ = rds.SAdd([]byte("s1"), []byte("m"))
+	_, _ = rds.ZAdd([]byte("z1"), 1.5, []byte("m"))
+
+	assert.Nil(t, rds.Rename([]byte("h1"), []byte("h2")))
+	fieldValues, err := rds.HGetAll([]byte("h2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []*FieldValue{
+		{Field: []byte("a"), Value: []byte("1")},
+		{Field: []byte("b"), Value: []byte("2")},
+	}, fieldValues)
+	n, _ := rds.Exists([]byte("h1"))
+	assert.Equal(t, 0, n)
+
+	// 覆盖已存在的set，原有的成员被一并删除
+	assert.Nil(t, rds.Rename([]byte("z1"), []byte("s1")))
+	score, err := rds.ZScore([]byte("s1"), []byte("m"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1.5, score)
+
+	ok, err := rds.RenameNX([]byte("h2"), []byte("s1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.RenameNX([]byte("h2"), []byte("h3"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	assert.Equal(t, constant.ErrNotExist, rds.Rename([]byte("missing"), []byte("x")))
+
+	// 元数据外只剩下hash的两个field与zset的两条数据
+	_, err = rds.collectGarbage()
+	assert.Nil(t, err)
+	assert.Equal(t, 2+2+2, len(rds.engine.GetAllKeys()))
+}
+
+func TestRedisDataStructure_Keys_Scan(t *testing.T) {
+	rds := openTestRds(t)
+	assert.Nil(t, rds.Set([]byte("user:1"), []byte("a"), 0))
+	assert.Nil(t, rds.Set([]byte("user:2"), []byte("b"), 0))
+	_, _ = rds.HSet([]byte("user:3"), []byte("f"), []byte("v"))
+	_, _ = rds.RPush([]byte("queue"), []byte("x"))
+	_, _ = rds.SAdd([]byte("tags"), []byte("t"))
+	assert.Nil(t, rds.PSetEX([]byte("user:4"), []byte("d"), 1))
+	// 成员为空的hash视为不存在
+	_, _ = rds.HSet([]byte("empty"), []byte("f"), []byte("v"))
+	_, _ = rds.HDel([]byte("empty"), []byte("f"))
+	time.Sleep(5 * time.Millisecond)
+
+	keys, err := rds.Keys([]byte("*"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"queue", "tags", "user:1", "user:2", "user:3"}, toStrings(keys))
+	keys, err = rds.Keys([]byte("user:[12]"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user:1", "user:2"}, toStrings(keys))
+
+	var all []string
+	var cursor []byte
+	for {
+		keys, next, err := rds.Scan(cursor, nil, 2, "")
+		assert.Nil(t, err)
+		all = append(all, toStrings(keys)...)
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, []string{"queue", "tags", "user:1", "user:2", "user:3"}, all)
+
+	// 两次调用之间删除已返回的key、在游标之前写入key，之后的key既不遗漏也不重复
+	keys, cursor, err = rds.Scan(nil, nil, 2, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"queue", "tags"}, toStrings(keys))
+	assert.Nil(t, rds.Del([]byte("queue")))
+	assert.Nil(t, rds.Set([]byte("a"), []byte("a"), 0))
+	keys, cursor, err = rds.Scan(cursor, nil, 100, "")
+	assert.Nil(t, err)
+	assert.Nil(t, cursor)
+	assert.Equal(t, []string{"user:1", "user:2", "user:3"}, toStrings(keys))
+
+	keys, next, err := rds.Scan(nil, []byte("user:*"), 100, "string")
+	assert.Nil(t, err)
+	assert.Nil(t, next)
+	assert.Equal(t, []string{"user:1", "user:2"}, toStrings(keys))
+
+	key, err := rds.RandomKey()
+	assert.Nil(t, err)
+	assert.Contains(t, []string{"a", "tags", "user:1", "user:2", "user:3"}, string(key))
+
+	n, err := rds.Exists([]byte("user:1"), []byte("user:1"), []byte("user:4"), []byte("tags"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+}
+
+// 数据部分没有长度前缀，key与以key开头的其他key交错排列时数据部分不能被当作用户的key
+func TestRedisDataStructure_Keys_SharedPrefix(t *testing.T) {
+	rds := openTestRds(t)
+	_, err := rds.SAdd([]byte("tags"), []byte("a"))
+	assert.Nil(t, err)
+	assert.Nil(t, rds.Set([]byte("tags:count"), []byte("1"), 0))
+
+	// 构造一个排在 tags 与其数据部分之间的key，保证数据部分不紧跟在元数据之后
+	encValue, err := rds.db.Get([]byte("tags"))
+	assert.Nil(t, err)
+	prefix := dataPrefix([]byte("tags"), decodeMetaData(encValue))
+	between := append([]byte("tags"), prefix[len("tags")]-1, 'x')
+	if prefix[len("tags")] == 0 {
+		between = []byte("tags\x00")
+	}
+	_, err = rds.HSet(between, []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+	// 已删除但尚未回收的数据部分同样不是用户的key
+	_, err = rds.SAdd([]byte("tags:old"), []byte("a"))
+	assert.Nil(t, err)
+	assert.Nil(t, rds.Del([]byte("tags:old")))
+
+	keys, err := rds.Keys([]byte("*"))
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"tags", "tags:count", string(between)}, toStrings(keys))
+	n, err := rds.DBSize()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), n)
+	key, err := rds.RandomKey()
+	assert.Nil(t, err)
+	assert.Contains(t, []string{"tags", "tags:count", string(between)}, string(key))
+
+	// 游标落在 tags 与其数据部分之间时，继续遍历仍跳过 tags 的数据部分
+	var all []string
+	var cursor []byte
+	for {
+		keys, next, err := rds.Scan(cursor, nil, 1, "")
+		assert.Nil(t, err)
+		all = append(all, toStrings(keys)...)
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	assert.ElementsMatch(t, []string{"tags", "tags:count", string(between)}, all)
+}
+
+func TestRedisDataStructure_DBSize_FlushDB(t *testing.T) {
+	rds := openTestRds(t)
+	assert.Nil(t, rds.Set([]byte("str"), []byte("v"), 0))
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	_, _ = rds.ZAdd([]byte("zset"), 1, []byte("m"))
+	assert.Nil(t, rds.PSetEX([]byte("expired"), []byte("v"), 1))
+	time.Sleep(5 * time.Millisecond)
+
+	n, err := rds.DBSize()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), n)
+
+	assert.Nil(t, rds.FlushDB())
+	n, err = rds.DBSize()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+	// 数据部分与内部索引同样被删除
+	assert.Equal(t, 0, len(rds.engine.GetAllKeys()))
+
+	_, _ = rds.HSet([]byte("hash"), []byte("f2"), []byte("v2"))
+	fields, err := rds.HKeys([]byte("hash"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("f2")}, fields)
+}
+
+func TestRedisDataStructure_Move(t *testing.T) {
+	src, dst := openTestRds(t), openTestRds(t)
+	hash := []byte("hash")
+	_, _ = src.HSet(hash, []byte("f"), []byte("v"))
+	_, err := src.Expire(hash, 100)
+	assert.Nil(t, err)
+
+	ok, err := src.Move(hash, dst)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	n, err := src.Exists(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	value, err := dst.HGet(hash, []byte("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+	ttl, err := dst.TTL(hash)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0)
+	// 源数据库中不再残留数据部分
+	assert.Equal(t, 0, len(src.engine.GetAllKeys()))
+
+	// 不存在的key以及目标中已存在的key不迁移
+	ok, err = src.Move([]byte("missing"), dst)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, src.Set(hash, []byte("v"), 0))
+	ok, err = src.Move(hash, dst)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = src.Move(hash, src)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestRedisDataStructure_WithBatch(t *testing.T) {
+	rds := openTestRds(t)
+	assert.Nil(t, rds.Set([]byte("counter"), []byte("1"), 0))
+	_, err := rds.HSet([]byte("hash"), []byte("a"), []byte("1"))
+	assert.Nil(t, err)
+
+	opts := *model.DefaultWriteBatchOptions
+	wb := rds.Engine().NewWriteBatch(&opts)
+	tx := rds.WithBatch(wb, nil)
+
+	// 视图内可以读到尚未提交的写入
+	n, err := tx.IncrBy([]byte("counter"), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), n)
+	_, err = tx.HSet([]byte("hash"), []byte("b"), []byte("2"))
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Del([]byte("missing")))
+	fields, err := tx.HKeys([]byte("hash"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, fields)
+	size, err := tx.DBSize()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), size)
+
+	// 提交前原实例中不可见
+	value, err := rds.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+	length, err := rds.HLen([]byte("hash"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), length)
+
+	assert.Nil(t, wb.Commit())
+	value, err = rds.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("11"), value)
+	length, err = rds.HLen([]byte("hash"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), length)
+}
@@ -0,0 +1,93 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"testing"
+)
+
+func geoMembers(results []*GeoSearchResult) []string {
+	members := make([]string, 0, len(results))
+	for _, r := range results {
+		members = append(members, string(r.Member))
+	}
+	return members
+}
+
+func addSicily(t *testing.T, rds *RedisDataStructure, key []byte) {
+	added, err := rds.GeoAdd(key,
+		&GeoLocation{Member: []byte("Palermo"), Longitude: 13.361389, Latitude: 38.115556},
+		&GeoLocation{Member: []byte("Catania"), Longitude: 15.087269, Latitude: 37.502669},
+		&GeoLocation{Member: []byte("edge1"), Longitude: 12.758489, Latitude: 38.788135},
+		&GeoLocation{Member: []byte("edge2"), Longitude: 17.241510, Latitude: 38.788135},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), added)
+}
+
+func TestRedisDataStructure_GeoAdd_GeoPos(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("Sicily")
+	addSicily(t, rds, key)
+
+	locations, err := rds.GeoPos(key, []byte("Palermo"), []byte("missing"))
+	assert.Nil(t, err)
+	assert.InDelta(t, 13.361389, locations[0].Longitude, 1e-5)
+	assert.InDelta(t, 38.115556, locations[0].Latitude, 1e-5)
+	assert.Nil(t, locations[1])
+
+	dist, err := rds.GeoDist(key, []byte("Palermo"), []byte("Catania"))
+	assert.Nil(t, err)
+	assert.InDelta(t, 166274.1516, dist, 0.01)
+	_, err = rds.GeoDist(key, []byte("Palermo"), []byte("missing"))
+	assert.Equal(t, constant.ErrNotExist, err)
+
+	hashes, err := rds.GeoHash(key, []byte("Palermo"), []byte("Catania"), []byte("missing"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("sqc8b49rny0"), []byte("sqdtr74hyu0"), nil}, hashes)
+
+	// 更新位置不计入新增数量
+	added, err := rds.GeoAdd(key, &GeoLocation{Member: []byte("Palermo"), Longitude: 13.4, Latitude: 38.1})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), added)
+
+	_, err = rds.GeoAdd(key, &GeoLocation{Member: []byte("pole"), Longitude: 0, Latitude: 89})
+	assert.Equal(t, constant.ErrInvalidGeo, err)
+	typ, _ := rds.Type(key)
+	assert.Equal(t, Zset, typ)
+}
+
+func TestRedisDataStructure_GeoSearch(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("Sicily")
+	addSicily(t, rds, key)
+
+	results, err := rds.GeoSearch(key, &GeoSearchQuery{Longitude: 15, Latitude: 37, Radius: 200 * 1000, Sort: GeoSortAsc})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Catania", "Palermo"}, geoMembers(results))
+	assert.InDelta(t, 56441.3, results[0].Dist, 0.1)
+	assert.InDelta(t, 190442.4, results[1].Dist, 0.1)
+
+	results, err = rds.GeoSearch(key, &GeoSearchQuery{Longitude: 15, Latitude: 37, Width: 400 * 1000, Height: 400 * 1000, Sort: GeoSortAsc})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Catania", "Palermo", "edge2", "edge1"}, geoMembers(results))
+
+	// 指定数量时默认按距离从近到远
+	results, err = rds.GeoSearch(key, &GeoSearchQuery{Member: []byte("Palermo"), Radius: 400 * 1000, Count: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Palermo", "edge1"}, geoMembers(results))
+	assert.Equal(t, float64(0), results[0].Dist)
+
+	results, err = rds.GeoSearch(key, &GeoSearchQuery{Member: []byte("Palermo"), Radius: 1000 * 1000, Sort: GeoSortDesc})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"edge2", "Catania", "edge1", "Palermo"}, geoMembers(results))
+
+	results, err = rds.GeoSearch(key, &GeoSearchQuery{Longitude: 15, Latitude: 37, Radius: 10 * 1000})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(results))
+
+	_, err = rds.GeoSearch(key, &GeoSearchQuery{Member: []byte("missing"), Radius: 1000})
+	assert.Equal(t, constant.ErrNotExist, err)
+	_, err = rds.GeoSearch(key, &GeoSearchQuery{Longitude: 15, Latitude: 37})
+	assert.Equal(t, constant.ErrEmptyParam, err)
+}
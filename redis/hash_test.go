@@ -0,0 +1,129 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"testing"
+)
+
+func TestRedisDataStructure_HGetAll(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("hash")
+
+	added, err := rds.HMSet(key, []*FieldValue{
+		{Field: []byte("b"), Value: []byte("2")},
+		{Field: []byte("a"), Value: []byte("1")},
+		{Field: []byte("b"), Value: []byte("3")},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), added)
+	ok, err := rds.HSetNX(key, []byte("a"), []byte("x"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.HSetNX(key, []byte("c"), []byte("4"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	fieldValues, err := rds.HGetAll(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []*FieldValue{
+		{Field: []byte("a"), Value: []byte("1")},
+		{Field: []byte("b"), Value: []byte("3")},
+		{Field: []byte("c"), Value: []byte("4")},
+	}, fieldValues)
+
+	fields, err := rds.HKeys(key)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, fields)
+	values, err := rds.HVals(key)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("3"), []byte("4")}, values)
+
+	length, err := rds.HLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), length)
+
+	exist, err := rds.HExists(key, []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, exist)
+	exist, err = rds.HExists(key, []byte("z"))
+	assert.Nil(t, err)
+	assert.False(t, exist)
+
+	values, err = rds.HMGet(key, []byte("c"), []byte("z"), []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("4"), nil, []byte("1")}, values)
+
+	// 其他hash的数据不会被遍历到
+	_, _ = rds.HSet([]byte("hash2"), []byte("a"), []byte("x"))
+	fields, err = rds.HKeys(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(fields))
+}
+
+func TestRedisDataStructure_HIncrBy(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("hash")
+
+	v, err := rds.HIncrBy(key, []byte("n"), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), v)
+	v, err = rds.HIncrBy(key, []byte("n"), -7)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), v)
+
+	f, err := rds.HIncrByFloat(key, []byte("n"), 0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, -1.5, f)
+	value, err := rds.HGet(key, []byte("n"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("-1.5"), value)
+
+	_, err = rds.HIncrBy(key, []byte("n"), 1)
+	assert.Equal(t, constant.ErrNotInteger, err)
+
+	_, _ = rds.HSet(key, []byte("s"), []byte("abc"))
+	_, err = rds.HIncrByFloat(key, []byte("s"), 1)
+	assert.Equal(t, constant.ErrNotFloat, err)
+
+	_, _ = rds.HSet(key, []byte("max"), []byte("9223372036854775807"))
+	_, err = rds.HIncrBy(key, []byte("max"), 1)
+	assert.Equal(t, constant.ErrIncrOverflow, err)
+
+	length, err := rds.HLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), length)
+}
+
+func TestRedisDataStructure_HScan(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("hash")
+	for i := 0; i < 25; i++ {
+		_, err := rds.HSet(key, []byte(fmt.Sprintf("field-%02d", i)), []byte("v"))
+		assert.Nil(t, err)
+	}
+
+	seen := make(map[string]bool)
+	var cursor uint64
+	var rounds int
+	for {
+		fieldValues, next, err := rds.HScan(key, cursor, nil, 10)
+		assert.Nil(t, err)
+		for _, fv := range fieldValues {
+			seen[string(fv.Field)] = true
+		}
+		rounds++
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, 25, len(seen))
+	assert.Equal(t, 3, rounds)
+
+	fieldValues, next, err := rds.HScan(key, 0, []byte("field-1?"), 100)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), next)
+	assert.Equal(t, 10, len(fieldValues))
+}
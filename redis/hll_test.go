@@ -0,0 +1,86 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"math"
+	"testing"
+)
+
+func assertCardinality(t *testing.T, expected int, actual int64) {
+	assert.True(t, math.Abs(float64(actual)-float64(expected)) <= float64(expected)*0.03,
+		"expected about %d, actual %d", expected, actual)
+}
+
+func TestRedisDataStructure_PFAdd_PFCount(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("hll")
+
+	// 不带元素时创建key
+	ok, err := rds.PFAdd(key)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	count, err := rds.PFCount(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+
+	ok, err = rds.PFAdd(key, []byte("a"), []byte("b"), []byte("c"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.PFAdd(key, []byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	count, _ = rds.PFCount(key)
+	assert.Equal(t, int64(3), count)
+
+	// 稀疏格式
+	for i := 0; i < 500; i++ {
+		_, err = rds.PFAdd(key, []byte(fmt.Sprintf("user-%d", i)))
+		assert.Nil(t, err)
+	}
+	buf, err := rds.db.Get(dataPrefix(key, mustMeta(t, rds, key)))
+	assert.Nil(t, err)
+	assert.Equal(t, hllEncodingSparse, buf[0])
+	count, _ = rds.PFCount(key)
+	assertCardinality(t, 503, count)
+
+	// 转为稠密格式
+	elements := make([][]byte, 0, 20000)
+	for i := 500; i < 20000; i++ {
+		elements = append(elements, []byte(fmt.Sprintf("user-%d", i)))
+	}
+	_, err = rds.PFAdd(key, elements...)
+	assert.Nil(t, err)
+	buf, _ = rds.db.Get(dataPrefix(key, mustMeta(t, rds, key)))
+	assert.Equal(t, hllEncodingDense, buf[0])
+	count, _ = rds.PFCount(key)
+	assertCardinality(t, 20003, count)
+
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	_, err = rds.PFAdd([]byte("hash"), []byte("a"))
+	assert.Equal(t, constant.ErrWrongTypeOp, err)
+}
+
+func TestRedisDataStructure_PFMerge(t *testing.T) {
+	rds := openTestRds(t)
+	for i := 0; i < 3000; i++ {
+		_, _ = rds.PFAdd([]byte("h1"), []byte(fmt.Sprintf("e-%d", i)))
+	}
+	for i := 2000; i < 5000; i++ {
+		_, _ = rds.PFAdd([]byte("h2"), []byte(fmt.Sprintf("e-%d", i)))
+	}
+
+	count, err := rds.PFCount([]byte("h1"), []byte("h2"), []byte("missing"))
+	assert.Nil(t, err)
+	assertCardinality(t, 5000, count)
+
+	assert.Nil(t, rds.PFMerge([]byte("dest"), []byte("h1"), []byte("h2")))
+	merged, err := rds.PFCount([]byte("dest"))
+	assert.Nil(t, err)
+	assert.Equal(t, count, merged)
+
+	typ, err := rds.Type([]byte("dest"))
+	assert.Nil(t, err)
+	assert.Equal(t, HyperLogLog, typ)
+}
@@ -0,0 +1,123 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"testing"
+)
+
+func lrangeAll(t *testing.T, rds *RedisDataStructure, key []byte) []string {
+	elements, err := rds.LRange(key, 0, -1)
+	assert.Nil(t, err)
+	result := make([]string, 0, len(elements))
+	for _, e := range elements {
+		result = append(result, string(e))
+	}
+	return result
+}
+
+func pushAll(t *testing.T, rds *RedisDataStructure, key []byte, elements ...string) {
+	for _, e := range elements {
+		_, err := rds.RPush(key, []byte(e))
+		assert.Nil(t, err)
+	}
+}
+
+func TestRedisDataStructure_LRange_LIndex_LSet(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("list")
+	pushAll(t, rds, key, "b", "c")
+	_, _ = rds.LPush(key, []byte("a"))
+
+	assert.Equal(t, []string{"a", "b", "c"}, lrangeAll(t, rds, key))
+	elements, err := rds.LRange(key, -2, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("c")}, elements)
+
+	length, err := rds.LLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), length)
+
+	element, err := rds.LIndex(key, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), element)
+	element, err = rds.LIndex(key, 3)
+	assert.Nil(t, err)
+	assert.Nil(t, element)
+
+	assert.Nil(t, rds.LSet(key, 1, []byte("B")))
+	assert.Equal(t, constant.ErrIndexOutOfRange, rds.LSet(key, 5, []byte("x")))
+	assert.Equal(t, constant.ErrNotExist, rds.LSet([]byte("missing"), 0, []byte("x")))
+	assert.Equal(t, []string{"a", "B", "c"}, lrangeAll(t, rds, key))
+}
+
+func TestRedisDataStructure_LTrim_LRem_LInsert(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("list")
+	pushAll(t, rds, key, "x", "a", "b", "a", "c", "a", "y")
+
+	assert.Nil(t, rds.LTrim(key, 1, -2))
+	assert.Equal(t, []string{"a", "b", "a", "c", "a"}, lrangeAll(t, rds, key))
+
+	removed, err := rds.LRem(key, -1, []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), removed)
+	assert.Equal(t, []string{"a", "b", "a", "c"}, lrangeAll(t, rds, key))
+
+	removed, err = rds.LRem(key, 0, []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), removed)
+	assert.Equal(t, []string{"b", "c"}, lrangeAll(t, rds, key))
+
+	length, err := rds.LInsert(key, true, []byte("c"), []byte("b2"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), length)
+	length, err = rds.LInsert(key, false, []byte("c"), []byte("d"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), length)
+	length, err = rds.LInsert(key, false, []byte("missing"), []byte("d"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), length)
+	assert.Equal(t, []string{"b", "b2", "c", "d"}, lrangeAll(t, rds, key))
+
+	assert.Nil(t, rds.LTrim(key, 5, 10))
+	assert.Equal(t, []string{}, lrangeAll(t, rds, key))
+
+	// 元数据外只剩下元数据本身，删除与弹出的元素没有残留在磁盘上
+	pushAll(t, rds, key, "p", "q")
+	_, _ = rds.LPop(key)
+	_, _ = rds.RPop(key)
+	assert.Equal(t, 1, len(rds.engine.GetAllKeys()))
+}
+
+func TestRedisDataStructure_LMove(t *testing.T) {
+	rds := openTestRds(t)
+	src, dst := []byte("src"), []byte("dst")
+	pushAll(t, rds, src, "a", "b", "c")
+
+	element, err := rds.RPopLPush(src, dst)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), element)
+	element, err = rds.LMove(src, dst, true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), element)
+	assert.Equal(t, []string{"b"}, lrangeAll(t, rds, src))
+	assert.Equal(t, []string{"c", "a"}, lrangeAll(t, rds, dst))
+
+	// 同一个list内旋转
+	element, err = rds.LMove(dst, dst, true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), element)
+	assert.Equal(t, []string{"a", "c"}, lrangeAll(t, rds, dst))
+
+	_, _ = rds.LPop(src)
+	element, err = rds.LMove(src, dst, true, true)
+	assert.Nil(t, err)
+	assert.Nil(t, element)
+
+	_, err = rds.ZAdd([]byte("zset"), 1, []byte("m"))
+	assert.Nil(t, err)
+	_, err = rds.LMove(dst, []byte("zset"), true, true)
+	assert.Equal(t, constant.ErrWrongTypeOp, err)
+	assert.Equal(t, []string{"a", "c"}, lrangeAll(t, rds, dst))
+}
@@ -4,17 +4,24 @@ import (
 	"encoding/binary"
 	"kv-db-lab/constant"
 	"kv-db-lab/pkg"
+	"kv-db-lab/storage"
 	"math"
 	"time"
 )
 
 type metaData struct {
-	dataType byte   // 数据类型
-	expire   int64  // 过期时间
-	version  int64  // 版本号
-	size     uint32 //数据量
-	head     uint64 // list数据结构的元数据
-	tail     uint64 // list数据结构的元数据
+	dataType byte     // 数据类型
+	expire   int64    // 过期时间
+	version  int64    // 版本号
+	size     uint32   //数据量
+	head     uint64   // list数据结构的元数据
+	tail     uint64   // list数据结构的元数据
+	encoding byte     // zset数据结构的编码版本
+	length   int64    // bitmap数据结构的字节长度
+	lastID   StreamID // stream数据结构中最大的ID
+
+	// stale 查找时已过期的原有元数据，写入新的元数据时在同一批次内登记其数据部分的回收
+	stale []byte
 }
 
 const metaDataSize = 1 + binary.MaxVarintLen64*2 + binary.MaxVarintLen32
@@ -22,11 +29,28 @@ const extraListDataSize = binary.MaxVarintLen64 * 2
 
 const initialListMark uint64 = math.MaxUint64 / 2
 
+const (
+	// zsetEncodingLegacy 旧版本的zset，score以十进制字符串存储，无法按score范围遍历
+	zsetEncodingLegacy byte = iota
+
+	// zsetEncodingOrdered score以保序的二进制编码存储
+	zsetEncodingOrdered
+)
+
 func (md *metaData) encode() []byte {
 	var size = metaDataSize
 	if md.dataType == List {
 		size += extraListDataSize
 	}
+	if md.dataType == Zset {
+		size++
+	}
+	if md.dataType == Bitmap {
+		size += binary.MaxVarintLen64
+	}
+	if md.dataType == Stream {
+		size += binary.MaxVarintLen64 * 2
+	}
 
 	buf := make([]byte, size)
 	buf[0] = md.dataType
@@ -40,6 +64,20 @@ func (md *metaData) encode() []byte {
 		idx += binary.PutVarint(buf[idx:], int64(md.tail))
 	}
 
+	if md.dataType == Zset {
+		buf[idx] = md.encoding
+		idx++
+	}
+
+	if md.dataType == Bitmap {
+		idx += binary.PutVarint(buf[idx:], md.length)
+	}
+
+	if md.dataType == Stream {
+		idx += binary.PutUvarint(buf[idx:], md.lastID.Ms)
+		idx += binary.PutUvarint(buf[idx:], md.lastID.Seq)
+	}
+
 	return buf[:idx]
 }
 
@@ -63,6 +101,24 @@ func decodeMetaData(buf []byte) *metaData {
 		tail, n = binary.Varint(buf[idx:])
 	}
 
+	// 旧版本的zset元数据没有编码字段
+	var encoding = zsetEncodingLegacy
+	if dataType == Zset && idx < len(buf) {
+		encoding = buf[idx]
+	}
+
+	var length int64
+	if dataType == Bitmap {
+		length, _ = binary.Varint(buf[idx:])
+	}
+
+	var lastID StreamID
+	if dataType == Stream {
+		lastID.Ms, n = binary.Uvarint(buf[idx:])
+		idx += n
+		lastID.Seq, _ = binary.Uvarint(buf[idx:])
+	}
+
 	return &metaData{
 		dataType: dataType,
 		expire:   expire,
@@ -70,6 +126,9 @@ func decodeMetaData(buf []byte) *metaData {
 		size:     uint32(size),
 		head:     uint64(head),
 		tail:     uint64(tail),
+		encoding: encoding,
+		length:   length,
+		lastID:   lastID,
 	}
 }
 
@@ -93,15 +152,16 @@ func (rds *RedisDataStructure) findMetaData(key []byte, metaDataType dataType) (
 
 	if err == constant.ErrNotExist {
 		exist = false
+	} else if expire := rawExpire(metaBuf); expire != 0 && expire <= time.Now().UnixNano() {
+		// 已过期的key无论何种类型都视为不存在，查找时不写入，原有的数据部分由写入新的元数据时回收
+		exist = false
 	} else {
-		// 校验操作是否合法
+		// 校验操作是否合法，与TYPE等命令一致，成员为空的其他类型同样视为不存在
 		meta = decodeMetaData(metaBuf)
 		if meta.dataType != metaDataType {
-			return nil, constant.ErrWrongTypeOp
-		}
-
-		// 查看数据是否过期
-		if meta.expire != 0 && meta.expire < time.Now().UnixNano() {
+			if _, _, live := decodeKeyInfo(metaBuf); live {
+				return nil, constant.ErrWrongTypeOp
+			}
 			exist = false
 		}
 	}
@@ -120,10 +180,20 @@ func (rds *RedisDataStructure) findMetaData(key []byte, metaDataType dataType) (
 			meta.head = initialListMark
 			meta.tail = initialListMark
 		}
+		if metaDataType == Zset {
+			meta.encoding = zsetEncodingOrdered
+		}
+		meta.stale = metaBuf
 	}
 	return meta, nil
 }
 
+// putMeta 在写批次内写入元数据，覆盖已过期的key时一同登记原有数据部分的回收
+func (rds *RedisDataStructure) putMeta(wb *storage.WriteBatch, key []byte, meta *metaData) {
+	_ = wb.Put(key, meta.encode())
+	rds.addGCTask(wb, key, meta.stale)
+}
+
 // hash数据结构存储到文件时的key
 type hashInternalKey struct {
 	key     []byte
@@ -203,6 +273,12 @@ func (lk *listInternalKey) encode() []byte {
 	return buf
 }
 
+// zset数据部分的key在key|version之后用一个字节区分两类数据，使按score排序的数据连续存放
+const (
+	zsetMemberTag byte = iota
+	zsetScoreTag
+)
+
 type ZsetInternalKey struct {
 	key     []byte
 	version int64
@@ -210,7 +286,58 @@ type ZsetInternalKey struct {
 	score   float64
 }
 
+// prefix key | version | tag
+func (zk *ZsetInternalKey) prefix(tag byte) []byte {
+	buf := make([]byte, len(zk.key)+8+1)
+
+	// key
+	var index = 0
+	copy(buf[index:index+len(zk.key)], zk.key)
+	index += len(zk.key)
+
+	// version
+	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(zk.version))
+	index += 8
+
+	// tag
+	buf[index] = tag
+
+	return buf
+}
+
+// encodeWithMember key | version | memberTag | member
 func (zk *ZsetInternalKey) encodeWithMember() []byte {
+	return append(zk.prefix(zsetMemberTag), zk.member...)
+}
+
+// encodeWithScore key | version | scoreTag | score | member，score为保序编码，相同score按member排序
+func (zk *ZsetInternalKey) encodeWithScore() []byte {
+	buf := append(zk.prefix(zsetScoreTag), pkg.Float64ToOrderedBytes(zk.score)...)
+	return append(buf, zk.member...)
+}
+
+// DecodeZsetWithScore (key)key | version | scoreTag | score | member =>(value)NULL
+func DecodeZsetWithScore(encKey []byte, zsetKey []byte) *ZsetInternalKey {
+	//从version开始解码
+	index := len(zsetKey)
+
+	version := binary.LittleEndian.Uint64(encKey[index : index+8])
+	index += 8 + 1
+
+	// score
+	score := pkg.FloatFromOrderedBytes(encKey[index : index+8])
+	index += 8
+
+	return &ZsetInternalKey{
+		key:     zsetKey,
+		version: int64(version),
+		member:  encKey[index:],
+		score:   score,
+	}
+}
+
+// encodeLegacyWithMember 旧版本的数据格式：key | version | member
+func (zk *ZsetInternalKey) encodeLegacyWithMember() []byte {
 	buf := make([]byte, len(zk.key)+len(zk.member)+8)
 
 	// key
@@ -228,7 +355,8 @@ func (zk *ZsetInternalKey) encodeWithMember() []byte {
 	return buf
 }
 
-func (zk *ZsetInternalKey) encodeWithScore() []byte {
+// encodeLegacyWithScore 旧版本的数据格式：key | version | score(十进制字符串) | member | member size
+func (zk *ZsetInternalKey) encodeLegacyWithScore() []byte {
 	scoreBuf := pkg.Float64ToBytes(zk.score)
 	buf := make([]byte, len(zk.key)+len(zk.member)+len(scoreBuf)+8+4)
 
@@ -254,33 +382,3 @@ func (zk *ZsetInternalKey) encodeWithScore() []byte {
 
 	return buf
 }
-
-// DecodeZsetWithScore (key)key |version | score | member |member size =>(value)NULL
-func DecodeZsetWithScore(encKey []byte, zsetKey []byte) *ZsetInternalKey {
-	//从version开始解码
-	index := len(zsetKey)
-	// 需要长度从右到左编码
-	L := len(encKey)
-
-	version := binary.LittleEndian.Uint64(encKey[index : index+8])
-	index += 8
-
-	rightIndex := L
-	// memberSize
-	memberSize := binary.LittleEndian.Uint32(encKey[rightIndex-4 : rightIndex])
-	rightIndex -= 4
-
-	// member
-	member := encKey[rightIndex-int(memberSize) : rightIndex]
-	rightIndex -= int(memberSize)
-
-	// score
-	score := encKey[index:rightIndex]
-
-	return &ZsetInternalKey{
-		key:     zsetKey,
-		version: int64(version),
-		member:  member,
-		score:   pkg.FloatFromBytes(score),
-	}
-}
@@ -0,0 +1,45 @@
+package redis
+
+// =========================key变更通知=============================================================
+// 修改或过期key时按redis keyspace通知的事件名回调通知函数，由上层决定是否以及如何发布
+
+// EventClass 事件的分类，取值为redis notify-keyspace-events配置中对应的字符
+type EventClass byte
+
+const (
+	EventGeneric EventClass = 'g' // DEL、EXPIRE、RENAME等与类型无关的命令
+	EventString  EventClass = '$'
+	EventList    EventClass = 'l'
+	EventSet     EventClass = 's'
+	EventHash    EventClass = 'h'
+	EventZset    EventClass = 'z'
+	EventExpired EventClass = 'x' // key过期被删除
+	EventStream  EventClass = 't'
+)
+
+// Notifier 接收key变更事件，event与redis的事件名一致，如set、lpush、expired
+type Notifier func(class EventClass, event string, key []byte)
+
+// SetNotifier 设置key变更的通知函数，为nil时不再通知，WithBatch返回的视图共享同一个通知函数
+// 通知函数可能在后台协程中被调用，不能阻塞，也不能再读写该实例
+func (rds *RedisDataStructure) SetNotifier(fn Notifier) {
+	rds.notifier.Store(fn)
+}
+
+// notify 通知key的变更，未设置通知函数时忽略
+func (rds *RedisDataStructure) notify(class EventClass, event string, key []byte) {
+	fn := rds.viewNotifier
+	if fn == nil {
+		fn, _ = rds.notifier.Load().(Notifier)
+	}
+	if fn != nil {
+		fn(class, event, key)
+	}
+}
+
+// notifyEmptied 集合类型的key被删空时与redis一致通知del
+func (rds *RedisDataStructure) notifyEmptied(key []byte, meta *metaData) {
+	if meta.size == 0 {
+		rds.notify(EventGeneric, "del", key)
+	}
+}
@@ -0,0 +1,75 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder 记录收到的事件，过期事件来自后台协程，需要加锁
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *eventRecorder) notify(class EventClass, event string, key []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, string(class)+" "+event+" "+string(key))
+}
+
+func (r *eventRecorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func TestRedisDataStructure_Notify(t *testing.T) {
+	rds := openTestRds(t)
+	recorder := &eventRecorder{}
+	rds.SetNotifier(recorder.notify)
+
+	assert.Nil(t, rds.Set([]byte("str"), []byte("1"), 0))
+	_, err := rds.IncrBy([]byte("str"), 2)
+	assert.Nil(t, err)
+	_, err = rds.GetDel([]byte("str"))
+	assert.Nil(t, err)
+	// 不存在的key不产生事件
+	assert.Nil(t, rds.Del([]byte("str")))
+	assert.Equal(t, []string{"$ set str", "$ incrby str", "g del str"}, recorder.take())
+
+	_, _ = rds.LPush([]byte("list"), []byte("a"))
+	_, _ = rds.RPush([]byte("list"), []byte("b"))
+	_, _ = rds.LMove([]byte("list"), []byte("dst"), true, false)
+	_, _ = rds.RPop([]byte("list"))
+	assert.Equal(t, []string{
+		"l lpush list", "l rpush list", "l lpop list", "l rpush dst", "l rpop list", "g del list",
+	}, recorder.take())
+
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	_, _ = rds.HDel([]byte("hash"), []byte("f"))
+	_, _ = rds.SAdd([]byte("set"), []byte("m"))
+	_, _ = rds.ZAdd([]byte("zset"), 1, []byte("m"))
+	// 分数未变化时不写入
+	_, _ = rds.ZAdd([]byte("zset"), 1, []byte("m"))
+	assert.Equal(t, []string{"h hset hash", "h hdel hash", "g del hash", "s sadd set", "z zadd zset"}, recorder.take())
+
+	_, _ = rds.Expire([]byte("set"), 100)
+	_, _ = rds.Persist([]byte("set"))
+	assert.Nil(t, rds.Rename([]byte("set"), []byte("set2")))
+	assert.Equal(t, []string{"g expire set", "g persist set", "g rename_from set", "g rename_to set2"}, recorder.take())
+
+	// 到期后由后台删除并通知expired
+	_, _ = rds.PExpire([]byte("zset"), 10)
+	assert.Equal(t, []string{"g expire zset"}, recorder.take())
+	var events []string
+	assert.Eventually(t, func() bool {
+		_, _ = rds.activeExpireCycle()
+		events = append(events, recorder.take()...)
+		return len(events) > 0
+	}, time.Second, 20*time.Millisecond)
+	assert.Equal(t, []string{"x expired zset"}, events)
+}
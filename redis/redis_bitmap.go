@@ -0,0 +1,411 @@
+package redis
+
+import (
+	"encoding/binary"
+	"kv-db-lab/constant"
+	"math/bits"
+	"time"
+)
+
+// =========================Bitmap 数据结构=============================================================
+//元数据格式key =>| type |expire |version | size | length
+//数据部分格式key |version |chunk(大端8byte) => 分块数据
+// size为分块的数量，length为整个bitmap的字节长度，修改一个bit只需重写所在的分块
+// SETBIT对已存在的string仍按string修改，其余的bit操作同时支持string与bitmap
+
+// bitmapChunkSize 每个分块的字节数
+const bitmapChunkSize = 1024
+
+// BitOperation BITOP的运算类型
+type BitOperation byte
+
+const (
+	BitOpAnd BitOperation = iota
+	BitOpOr
+	BitOpXor
+	BitOpNot
+)
+
+func bitmapChunkKey(key []byte, meta *metaData, chunk int64) []byte {
+	return binary.BigEndian.AppendUint64(dataPrefix(key, meta), uint64(chunk))
+}
+
+// bitSource 按分块读取string或bitmap
+type bitSource struct {
+	length int64
+
+	// string类型的值
+	value []byte
+
+	// bitmap类型的key与元数据
+	key  []byte
+	meta *metaData
+}
+
+// openBitSource 读取key的类型与长度，不存在时长度为0，非string与bitmap的类型返回ErrWrongTypeOp
+func (rds *RedisDataStructure) openBitSource(key []byte) (*bitSource, error) {
+	encValue, err := rds.db.Get(key)
+	if err != nil && err != constant.ErrNotExist {
+		return nil, err
+	}
+
+	typ, _, exist := decodeKeyInfo(encValue)
+	if !exist {
+		return &bitSource{}, nil
+	}
+	switch typ {
+	case String:
+		value, _ := decodeString(encValue)
+		return &bitSource{length: int64(len(value)), value: value}, nil
+	case Bitmap:
+		meta := decodeMetaData(encValue)
+		return &bitSource{length: meta.length, key: key, meta: meta}, nil
+	default:
+		return nil, constant.ErrWrongTypeOp
+	}
+}
+
+// bitChunk 返回第index个分块，长度可能小于bitmapChunkSize，不存在时返回nil
+func (rds *RedisDataStructure) bitChunk(src *bitSource, index int64) ([]byte, error) {
+	start := index * bitmapChunkSize
+	if start >= src.length {
+		return nil, nil
+	}
+
+	if src.meta == nil {
+		end := start + bitmapChunkSize
+		if end > src.length {
+			end = src.length
+		}
+		return src.value[start:end], nil
+	}
+
+	chunk, err := rds.db.Get(bitmapChunkKey(src.key, src.meta, index))
+	if err == constant.ErrNotExist {
+		return nil, nil
+	}
+	return chunk, err
+}
+
+// iterateBytes 按顺序遍历字节下标在[start, end]之间的数据，缺失的部分以0填充，fn返回false时停止
+func (rds *RedisDataStructure) iterateBytes(src *bitSource, start, end int64, fn func(pos int64, b []byte) bool) error {
+	for c := start / bitmapChunkSize; c <= end/bitmapChunkSize; c++ {
+		chunk, err := rds.bitChunk(src, c)
+		if err != nil {
+			return err
+		}
+
+		base := c * bitmapChunkSize
+		lo, hi := int64(0), int64(bitmapChunkSize-1)
+		if start > base {
+			lo = start - base
+		}
+		if end < base+hi {
+			hi = end - base
+		}
+		if int64(len(chunk)) <= hi {
+			chunk = growString(chunk, int(hi+1))
+		}
+		if !fn(base+lo, chunk[lo:hi+1]) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// SetBit
+//
+//	@Description: 设置offset处的bit，与redis一致每个字节的最高位在前，不足的部分以0填充
+//	key不存在时创建bitmap，已存在的string按string修改
+//	@receiver rds
+//	@param key
+//	@param offset
+//	@param bit  只能为0或1
+//	@return byte  原来的bit
+//	@return error
+func (rds *RedisDataStructure) SetBit(key []byte, offset uint64, bit byte) (byte, error) {
+	if bit > 1 {
+		return 0, constant.ErrNotBit
+	}
+	if offset >= maxStringSize*8 {
+		return 0, constant.ErrStringTooLong
+	}
+
+	src, err := rds.openBitSource(key)
+	if err != nil {
+		return 0, err
+	}
+	if src.value != nil {
+		return rds.setStringBit(key, offset, bit)
+	}
+
+	meta, err := rds.findMetaData(key, Bitmap)
+	if err != nil {
+		return 0, err
+	}
+	byteIndex := int64(offset / 8)
+	chunkIndex := byteIndex / bitmapChunkSize
+	chunkKey := bitmapChunkKey(key, meta, chunkIndex)
+
+	chunk, err := rds.db.Get(chunkKey)
+	if err != nil && err != constant.ErrNotExist {
+		return 0, err
+	}
+	exist := err == nil
+
+	pos := int(byteIndex % bitmapChunkSize)
+	newChunk := growString(chunk, pos+1)
+	old := setBitInByte(newChunk, pos, offset, bit)
+
+	wb := rds.newWriteBatch(2)
+	_ = wb.Put(chunkKey, newChunk)
+	if !exist {
+		meta.size++
+	}
+	if byteIndex+1 > meta.length {
+		meta.length = byteIndex + 1
+	}
+	rds.putMeta(wb, key, meta)
+	if err = wb.Commit(); err != nil {
+		return 0, err
+	}
+	rds.notify(EventString, "setbit", key)
+	return old, nil
+}
+
+// setBitInByte 修改buf[pos]中offset对应的bit，返回原来的bit
+func setBitInByte(buf []byte, pos int, offset uint64, bit byte) byte {
+	mask := byte(0x80) >> (offset % 8)
+	var old byte
+	if buf[pos]&mask != 0 {
+		old = 1
+	}
+	if bit == 1 {
+		buf[pos] |= mask
+	} else {
+		buf[pos] &^= mask
+	}
+	return old
+}
+
+// setStringBit 原子地修改string中的bit
+func (rds *RedisDataStructure) setStringBit(key []byte, offset uint64, bit byte) (byte, error) {
+	var old byte
+	err := rds.updateString(key, "setbit", func(current []byte, expire int64, _ bool) ([]byte, int64, error) {
+		byteIndex := int(offset / 8)
+		newValue := growString(current, byteIndex+1)
+		old = setBitInByte(newValue, byteIndex, offset, bit)
+		return newValue, expire, nil
+	})
+	return old, err
+}
+
+// GetBit 返回offset处的bit，超出长度时为0
+func (rds *RedisDataStructure) GetBit(key []byte, offset uint64) (byte, error) {
+	src, err := rds.openBitSource(key)
+	if err != nil {
+		return 0, err
+	}
+	byteIndex := int64(offset / 8)
+	if byteIndex >= src.length {
+		return 0, nil
+	}
+
+	chunk, err := rds.bitChunk(src, byteIndex/bitmapChunkSize)
+	if err != nil {
+		return 0, err
+	}
+	pos := byteIndex % bitmapChunkSize
+	if pos < int64(len(chunk)) && chunk[pos]&(byte(0x80)>>(offset%8)) != 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// BitCount 统计字节下标在[start, end]之间值为1的bit数量，负数表示从末尾倒数
+func (rds *RedisDataStructure) BitCount(key []byte, start, end int64) (int, error) {
+	src, err := rds.openBitSource(key)
+	if err != nil {
+		return 0, err
+	}
+	start, end, ok := stringRange(src.length, start, end)
+	if !ok {
+		return 0, nil
+	}
+
+	var count int
+	err = rds.iterateBytes(src, start, end, func(_ int64, b []byte) bool {
+		for _, v := range b {
+			count += bits.OnesCount8(v)
+		}
+		return true
+	})
+	return count, err
+}
+
+// BitPos
+//
+//	@Description: 返回字节下标在[start, end]之间第一个值为bit的位置
+//	@receiver rds
+//	@param key
+//	@param bit  只能为0或1
+//	@param start
+//	@param end
+//	@param hasEnd  是否指定了end，与redis一致，未指定end且查找0时，全为1则返回末尾之后的位置
+//	@return int64  不存在时返回-1
+//	@return error
+func (rds *RedisDataStructure) BitPos(key []byte, bit byte, start, end int64, hasEnd bool) (int64, error) {
+	if bit > 1 {
+		return 0, constant.ErrNotBit
+	}
+	src, err := rds.openBitSource(key)
+	if err != nil {
+		return 0, err
+	}
+	if src.length == 0 {
+		if bit == 1 {
+			return -1, nil
+		}
+		return 0, nil
+	}
+	if !hasEnd {
+		end = -1
+	}
+	start, end, ok := stringRange(src.length, start, end)
+	if !ok {
+		return -1, nil
+	}
+
+	var found int64 = -1
+	err = rds.iterateBytes(src, start, end, func(pos int64, b []byte) bool {
+		for i, v := range b {
+			if bit == 0 {
+				v = ^v
+			}
+			if v != 0 {
+				found = (pos+int64(i))*8 + int64(bits.LeadingZeros8(v))
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil || found >= 0 {
+		return found, err
+	}
+
+	if bit == 0 && !hasEnd {
+		return (end + 1) * 8, nil
+	}
+	return -1, nil
+}
+
+// BitOp
+//
+//	@Description: 对多个key按位运算，结果以bitmap写入destination，长度为最长的源key的长度
+//	全为0的分块不写入，destination原有的数据部分登记回收
+//	@receiver rds
+//	@param op
+//	@param destination
+//	@param keys  BitOpNot时只能有一个
+//	@return int64  结果的字节长度
+//	@return error
+func (rds *RedisDataStructure) BitOp(op BitOperation, destination []byte, keys ...[]byte) (int64, error) {
+	if len(keys) == 0 || (op == BitOpNot && len(keys) != 1) {
+		return 0, constant.ErrEmptyParam
+	}
+
+	srcs := make([]*bitSource, 0, len(keys))
+	var length int64
+	for _, key := range keys {
+		src, err := rds.openBitSource(key)
+		if err != nil {
+			return 0, err
+		}
+		srcs = append(srcs, src)
+		if src.length > length {
+			length = src.length
+		}
+	}
+
+	old, err := rds.db.Get(destination)
+	if err != nil && err != constant.ErrNotExist {
+		return 0, err
+	}
+	chunks := (length + bitmapChunkSize - 1) / bitmapChunkSize
+	wb := rds.newWriteBatch(int(chunks) + 2)
+	rds.addGCTask(wb, destination, old)
+	defer rds.notifyGC()
+
+	// 结果为空时与redis一致删除destination
+	if length == 0 {
+		_ = wb.Delete(destination)
+		if err = wb.Commit(); err != nil {
+			return 0, err
+		}
+		if old != nil {
+			rds.notify(EventGeneric, "del", destination)
+		}
+		return 0, nil
+	}
+
+	meta := &metaData{
+		dataType: Bitmap,
+		version:  time.Now().UnixNano(),
+		length:   length,
+	}
+	for c := int64(0); c < chunks; c++ {
+		size := length - c*bitmapChunkSize
+		if size > bitmapChunkSize {
+			size = bitmapChunkSize
+		}
+
+		result := make([]byte, size)
+		for i, src := range srcs {
+			chunk, err := rds.bitChunk(src, c)
+			if err != nil {
+				return 0, err
+			}
+			chunk = growString(chunk, int(size))
+			if i == 0 {
+				copy(result, chunk)
+				if op == BitOpNot {
+					for j := range result {
+						result[j] = ^result[j]
+					}
+				}
+				continue
+			}
+			for j := range result {
+				switch op {
+				case BitOpAnd:
+					result[j] &= chunk[j]
+				case BitOpOr:
+					result[j] |= chunk[j]
+				case BitOpXor:
+					result[j] ^= chunk[j]
+				}
+			}
+		}
+
+		// 最后一个分块总是写入，保证元数据的size不为0
+		if c == chunks-1 || !isZero(result) {
+			_ = wb.Put(bitmapChunkKey(destination, meta, c), result)
+			meta.size++
+		}
+	}
+	rds.putMeta(wb, destination, meta)
+	if err = wb.Commit(); err != nil {
+		return 0, err
+	}
+	rds.notify(EventString, "set", destination)
+	return length, nil
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
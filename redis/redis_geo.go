@@ -0,0 +1,261 @@
+package redis
+
+import (
+	"kv-db-lab/constant"
+	"kv-db-lab/pkg"
+	"math"
+	"sort"
+	"strings"
+)
+
+// =========================Geo=============================================================
+// 与redis一致，geo直接使用zset存储，经纬度编码为52bit的geohash作为score
+// 相邻的位置geohash的前缀相同，查询时估算合适的精度，只需按score区间扫描中心及周围共9个格子
+
+// GeoLocation 成员及其经纬度
+type GeoLocation struct {
+	Member    []byte
+	Longitude float64
+	Latitude  float64
+}
+
+// GeoSearchResult GEOSEARCH的结果，Dist为与中心的距离，单位米
+type GeoSearchResult struct {
+	GeoLocation
+	Dist float64
+	Hash uint64
+}
+
+// GeoSort GEOSEARCH结果的排序方式
+type GeoSort byte
+
+const (
+	GeoSortNone GeoSort = iota
+	GeoSortAsc
+	GeoSortDesc
+)
+
+// GeoSearchQuery
+//
+//	@Description: GEOSEARCH的查询条件，Radius大于0时按半径查询，否则按Width与Height的矩形查询，单位均为米
+type GeoSearchQuery struct {
+	// Member 以成员的位置为中心，为nil时使用Longitude与Latitude
+	Member    []byte
+	Longitude float64
+	Latitude  float64
+
+	Radius float64
+	Width  float64
+	Height float64
+
+	Sort GeoSort
+	// Count 返回的最大数量，为0时不限制
+	Count int64
+	// Any 找到Count个结果后立即返回，不保证是最近的
+	Any bool
+}
+
+// geoUnits 距离单位与米的换算
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"ft": 0.3048,
+	"mi": 1609.34,
+}
+
+// GeoUnitToMeters 返回距离单位对应的米数，不区分大小写
+func GeoUnitToMeters(unit []byte) (float64, error) {
+	if v, ok := geoUnits[strings.ToLower(string(unit))]; ok {
+		return v, nil
+	}
+	return 0, constant.ErrInvalidGeoUnit
+}
+
+// geoScore 将经纬度编码为zset的score
+func geoScore(lon, lat float64) float64 {
+	return float64(pkg.GeoHashEncode(lon, lat, pkg.GeoLatMin, pkg.GeoLatMax, pkg.GeoStepMax))
+}
+
+// geoDecodeScore 返回score对应格子的中心点
+func geoDecodeScore(score float64) (float64, float64) {
+	return pkg.GeoHashDecode(uint64(score), pkg.GeoStepMax).Center()
+}
+
+// GeoAdd 添加或更新成员的位置，返回新添加的成员数量，任一经纬度不合法时不写入
+func (rds *RedisDataStructure) GeoAdd(key []byte, locations ...*GeoLocation) (int64, error) {
+	for _, loc := range locations {
+		if !pkg.ValidGeoCoordinate(loc.Longitude, loc.Latitude) {
+			return 0, constant.ErrInvalidGeo
+		}
+	}
+
+	var added int64
+	for _, loc := range locations {
+		ok, err := rds.ZAdd(key, geoScore(loc.Longitude, loc.Latitude), loc.Member)
+		if err != nil {
+			return added, err
+		}
+		if ok {
+			added++
+		}
+	}
+	return added, nil
+}
+
+// GeoPos 返回成员的经纬度，不存在的成员对应nil
+func (rds *RedisDataStructure) GeoPos(key []byte, members ...[]byte) ([]*GeoLocation, error) {
+	result := make([]*GeoLocation, 0, len(members))
+	for _, member := range members {
+		score, err := rds.ZScore(key, member)
+		if err == constant.ErrNotExist {
+			result = append(result, nil)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		lon, lat := geoDecodeScore(score)
+		result = append(result, &GeoLocation{Member: member, Longitude: lon, Latitude: lat})
+	}
+	return result, nil
+}
+
+// GeoDist 返回两个成员之间的距离，单位米，任一成员不存在时返回ErrNotExist
+func (rds *RedisDataStructure) GeoDist(key, member1, member2 []byte) (float64, error) {
+	locations, err := rds.GeoPos(key, member1, member2)
+	if err != nil {
+		return 0, err
+	}
+	if locations[0] == nil || locations[1] == nil {
+		return 0, constant.ErrNotExist
+	}
+	return pkg.GeoDistance(locations[0].Longitude, locations[0].Latitude, locations[1].Longitude, locations[1].Latitude), nil
+}
+
+// GeoHash 返回成员标准的11位geohash字符串，不存在的成员对应nil
+func (rds *RedisDataStructure) GeoHash(key []byte, members ...[]byte) ([][]byte, error) {
+	locations, err := rds.GeoPos(key, members...)
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]byte, 0, len(locations))
+	for _, loc := range locations {
+		if loc == nil {
+			result = append(result, nil)
+			continue
+		}
+		result = append(result, []byte(pkg.GeoHashString(loc.Longitude, loc.Latitude)))
+	}
+	return result, nil
+}
+
+// GeoSearch
+//
+//	@Description: 返回在圆形或矩形范围内的成员
+//	@receiver rds
+//	@param key
+//	@param query
+//	@return []*GeoSearchResult
+//	@return error  中心成员不存在时返回ErrNotExist
+func (rds *RedisDataStructure) GeoSearch(key []byte, query *GeoSearchQuery) ([]*GeoSearchResult, error) {
+	if query.Radius <= 0 && (query.Width <= 0 || query.Height <= 0) {
+		return nil, constant.ErrEmptyParam
+	}
+
+	lon, lat := query.Longitude, query.Latitude
+	if query.Member != nil {
+		locations, err := rds.GeoPos(key, query.Member)
+		if err != nil {
+			return nil, err
+		}
+		if locations[0] == nil {
+			return nil, constant.ErrNotExist
+		}
+		lon, lat = locations[0].Longitude, locations[0].Latitude
+	} else if !pkg.ValidGeoCoordinate(lon, lat) {
+		return nil, constant.ErrInvalidGeo
+	}
+
+	// 与redis一致，指定了数量但未指定ANY时按距离从近到远返回
+	sortBy := query.Sort
+	if query.Count > 0 && !query.Any && sortBy == GeoSortNone {
+		sortBy = GeoSortAsc
+	}
+
+	result := make([]*GeoSearchResult, 0)
+	for _, cell := range geoSearchCells(lon, lat, query) {
+		shift := 2 * (pkg.GeoStepMax - cell.Step)
+		rng := &ScoreRange{
+			Min:          float64(cell.Hash << shift),
+			Max:          float64((cell.Hash + 1) << shift),
+			MaxExclusive: true,
+		}
+		var done bool
+		err := rds.zscanByScore(key, rng, func(member []byte, score float64) bool {
+			pointLon, pointLat := geoDecodeScore(score)
+			dist := pkg.GeoDistance(lon, lat, pointLon, pointLat)
+			if query.Radius > 0 && dist > query.Radius {
+				return true
+			}
+			if query.Radius <= 0 && !pkg.GeoInBox(lon, lat, query.Width, query.Height, pointLon, pointLat) {
+				return true
+			}
+			result = append(result, &GeoSearchResult{
+				GeoLocation: GeoLocation{Member: member, Longitude: pointLon, Latitude: pointLat},
+				Dist:        dist,
+				Hash:        uint64(score),
+			})
+			done = query.Any && int64(len(result)) >= query.Count
+			return !done
+		})
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+
+	switch sortBy {
+	case GeoSortAsc:
+		sort.SliceStable(result, func(i, j int) bool { return result[i].Dist < result[j].Dist })
+	case GeoSortDesc:
+		sort.SliceStable(result, func(i, j int) bool { return result[i].Dist > result[j].Dist })
+	}
+	if query.Count > 0 && int64(len(result)) > query.Count {
+		result = result[:query.Count]
+	}
+	return result, nil
+}
+
+// geoSearchCells 估算geohash的精度，使中心所在格子及其周围的8个格子能覆盖整个查询范围
+func geoSearchCells(lon, lat float64, query *GeoSearchQuery) []*pkg.GeoArea {
+	width, height := query.Width, query.Height
+	radius := query.Radius
+	if radius > 0 {
+		width, height = radius*2, radius*2
+	} else {
+		// 矩形外接圆的半径
+		radius = math.Hypot(width/2, height/2)
+	}
+	lonMin, lonMax, latMin, latMax := pkg.GeoBoundingBox(lon, lat, width, height)
+
+	step := pkg.GeoEstimateStep(radius, lat)
+	for ; step > 1; step-- {
+		center := pkg.GeoHashDecode(pkg.GeoHashEncode(lon, lat, pkg.GeoLatMin, pkg.GeoLatMax, step), step)
+		cellWidth := center.LonMax - center.LonMin
+		cellHeight := center.LatMax - center.LatMin
+		if lonMin >= center.LonMin-cellWidth && lonMax <= center.LonMax+cellWidth &&
+			latMin >= center.LatMin-cellHeight && latMax <= center.LatMax+cellHeight {
+			break
+		}
+	}
+
+	hash := pkg.GeoHashEncode(lon, lat, pkg.GeoLatMin, pkg.GeoLatMax, step)
+	neighbors := pkg.GeoHashNeighbors(hash, step)
+	cells := make([]*pkg.GeoArea, 0, len(neighbors))
+	for _, h := range neighbors {
+		cells = append(cells, &pkg.GeoArea{Hash: h, Step: step})
+	}
+	return cells
+}
@@ -3,6 +3,9 @@ package redis
 import (
 	"kv-db-lab/constant"
 	"kv-db-lab/model"
+	"kv-db-lab/pkg"
+	"math"
+	"strconv"
 )
 
 // =========================Hash 数据结构=============================================================
@@ -37,12 +40,13 @@ func (rds *RedisDataStructure) HSet(key, field, value []byte) (bool, error) {
 	// 不存在则更新元数据
 	if !exist {
 		meta.size++
-		_ = wb.Put(key, meta.encode())
+		rds.putMeta(wb, key, meta)
 	}
 	_ = wb.Put(encKey, value)
 	if err = wb.Commit(); err != nil {
 		return false, err
 	}
+	rds.notify(EventHash, "hset", key)
 	return !exist, nil
 }
 
@@ -90,12 +94,330 @@ func (rds *RedisDataStructure) HDel(key, field []byte) (bool, error) {
 		// 批量写入保证原子性
 		wb := rds.db.NewWriteBatch(model.DefaultWriteBatchOptions)
 		meta.size--
-		_ = wb.Put(key, meta.encode())
+		rds.putMeta(wb, key, meta)
 		_ = wb.Delete(encKey)
 		if err = wb.Commit(); err != nil {
 			return false, err
 		}
+		rds.notify(EventHash, "hdel", key)
+		rds.notifyEmptied(key, meta)
 	}
 
 	return exist, nil
 }
+
+// FieldValue hash中的一个field及其value
+type FieldValue struct {
+	Field []byte
+	Value []byte
+}
+
+// hashPrefix hash数据部分的公共前缀 key | version
+func hashPrefix(key []byte, meta *metaData) []byte {
+	hk := &hashInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	return hk.encode()
+}
+
+// hashScan 按field顺序遍历hash
+func (rds *RedisDataStructure) hashScan(key []byte, fn func(field []byte, getValue func() ([]byte, error)) (bool, error)) error {
+	meta, err := rds.findMetaData(key, Hash)
+	if err != nil {
+		return err
+	}
+	if meta.size == 0 {
+		return nil
+	}
+	return rds.iteratePrefix(hashPrefix(key, meta), fn)
+}
+
+// HGetAll 返回hash的全部field与value
+func (rds *RedisDataStructure) HGetAll(key []byte) ([]*FieldValue, error) {
+	result := make([]*FieldValue, 0)
+	err := rds.hashScan(key, func(field []byte, getValue func() ([]byte, error)) (bool, error) {
+		value, err := getValue()
+		if err != nil {
+			return false, err
+		}
+		result = append(result, &FieldValue{Field: field, Value: value})
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// HKeys 返回hash的全部field
+func (rds *RedisDataStructure) HKeys(key []byte) ([][]byte, error) {
+	result := make([][]byte, 0)
+	err := rds.hashScan(key, func(field []byte, _ func() ([]byte, error)) (bool, error) {
+		result = append(result, field)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// HVals 返回hash的全部value
+func (rds *RedisDataStructure) HVals(key []byte) ([][]byte, error) {
+	fieldValues, err := rds.HGetAll(key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]byte, 0, len(fieldValues))
+	for _, fv := range fieldValues {
+		result = append(result, fv.Value)
+	}
+	return result, nil
+}
+
+// HLen 返回hash的field数量
+func (rds *RedisDataStructure) HLen(key []byte) (uint32, error) {
+	meta, err := rds.findMetaData(key, Hash)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// HExists 判断field是否存在
+func (rds *RedisDataStructure) HExists(key, field []byte) (bool, error) {
+	meta, err := rds.findMetaData(key, Hash)
+	if err != nil {
+		return false, err
+	}
+	if meta.size == 0 {
+		return false, nil
+	}
+
+	hk := &hashInternalKey{
+		key:     key,
+		version: meta.version,
+		field:   field,
+	}
+	if _, err = rds.db.Get(hk.encode()); err == constant.ErrNotExist {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// HMSet
+//
+//	@Description: 在同一批次内写入多个field
+//	@receiver rds
+//	@param key
+//	@param fieldValues
+//	@return uint32  新增的field数量
+//	@return error
+func (rds *RedisDataStructure) HMSet(key []byte, fieldValues []*FieldValue) (uint32, error) {
+	meta, err := rds.findMetaData(key, Hash)
+	if err != nil {
+		return 0, err
+	}
+
+	wb := rds.newWriteBatch(len(fieldValues) + 1)
+	var added uint32
+	seen := make(map[string]struct{}, len(fieldValues))
+	for _, fv := range fieldValues {
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   fv.Field,
+		}
+		encKey := hk.encode()
+
+		// 同一批次中重复的field只计数一次
+		if _, ok := seen[string(fv.Field)]; !ok {
+			seen[string(fv.Field)] = struct{}{}
+			if _, err = rds.db.Get(encKey); err == constant.ErrNotExist {
+				added++
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		_ = wb.Put(encKey, fv.Value)
+	}
+	if added > 0 {
+		meta.size += added
+		rds.putMeta(wb, key, meta)
+	}
+	if err = wb.Commit(); err != nil {
+		return 0, err
+	}
+	rds.notify(EventHash, "hset", key)
+	return added, nil
+}
+
+// HMGet 返回多个field的value，不存在的field对应nil
+func (rds *RedisDataStructure) HMGet(key []byte, fields ...[]byte) ([][]byte, error) {
+	meta, err := rds.findMetaData(key, Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([][]byte, len(fields))
+	if meta.size == 0 {
+		return result, nil
+	}
+	for i, field := range fields {
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   field,
+		}
+		value, err := rds.db.Get(hk.encode())
+		if err != nil && err != constant.ErrNotExist {
+			return nil, err
+		}
+		result[i] = value
+	}
+	return result, nil
+}
+
+// HSetNX field不存在时才写入，返回是否写入
+func (rds *RedisDataStructure) HSetNX(key, field, value []byte) (bool, error) {
+	exist, err := rds.HExists(key, field)
+	if err != nil || exist {
+		return false, err
+	}
+	return rds.HSet(key, field, value)
+}
+
+// HIncrBy 将field的值加上increment，field不存在时视为0，返回新的值
+func (rds *RedisDataStructure) HIncrBy(key, field []byte, increment int64) (int64, error) {
+	var result int64
+	err := rds.hashUpdate(key, field, "hincrby", func(old []byte) ([]byte, error) {
+		var current int64
+		if old != nil {
+			v, err := strconv.ParseInt(string(old), 10, 64)
+			if err != nil {
+				return nil, constant.ErrNotInteger
+			}
+			current = v
+		}
+		if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+			return nil, constant.ErrIncrOverflow
+		}
+		result = current + increment
+		return []byte(strconv.FormatInt(result, 10)), nil
+	})
+	return result, err
+}
+
+// HIncrByFloat 将field的值加上浮点数increment，field不存在时视为0，返回新的值
+func (rds *RedisDataStructure) HIncrByFloat(key, field []byte, increment float64) (float64, error) {
+	var result float64
+	err := rds.hashUpdate(key, field, "hincrbyfloat", func(old []byte) ([]byte, error) {
+		var current float64
+		if old != nil {
+			v, err := strconv.ParseFloat(string(old), 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, constant.ErrNotFloat
+			}
+			current = v
+		}
+		result = current + increment
+		if math.IsNaN(result) || math.IsInf(result, 0) {
+			return nil, constant.ErrIncrOverflow
+		}
+		return pkg.Float64ToBytes(result), nil
+	})
+	return result, err
+}
+
+// hashUpdate 读取field当前的值(不存在时为nil)，由fn计算新值后与元数据在同一批次内写入，写入后通知event
+func (rds *RedisDataStructure) hashUpdate(key, field []byte, event string, fn func(old []byte) ([]byte, error)) error {
+	meta, err := rds.findMetaData(key, Hash)
+	if err != nil {
+		return err
+	}
+
+	hk := &hashInternalKey{
+		key:     key,
+		version: meta.version,
+		field:   field,
+	}
+	encKey := hk.encode()
+
+	var exist = true
+	old, err := rds.db.Get(encKey)
+	if err == constant.ErrNotExist || meta.size == 0 {
+		exist = false
+		old = nil
+	} else if err != nil {
+		return err
+	}
+
+	value, err := fn(old)
+	if err != nil {
+		return err
+	}
+
+	wb := rds.newWriteBatch(2)
+	if !exist {
+		meta.size++
+		rds.putMeta(wb, key, meta)
+	}
+	_ = wb.Put(encKey, value)
+	if err = wb.Commit(); err != nil {
+		return err
+	}
+	rds.notify(EventHash, event, key)
+	return nil
+}
+
+// HScan
+//
+//	@Description: 基于游标分批遍历hash，游标为按field顺序已遍历的数量
+//	@receiver rds
+//	@param key
+//	@param cursor  首次传0
+//	@param match  glob格式，为空时不过滤
+//	@param count  本次最多遍历的field数量，小于等于0时为10
+//	@return []*FieldValue
+//	@return uint64  下次调用的游标，为0时表示遍历结束
+//	@return error
+func (rds *RedisDataStructure) HScan(key []byte, cursor uint64, match []byte, count int) ([]*FieldValue, uint64, error) {
+	if count <= 0 {
+		count = 10
+	}
+
+	result := make([]*FieldValue, 0)
+	var index, scanned uint64
+	var more bool
+	err := rds.hashScan(key, func(field []byte, getValue func() ([]byte, error)) (bool, error) {
+		if index < cursor {
+			index++
+			return true, nil
+		}
+		if scanned == uint64(count) {
+			more = true
+			return false, nil
+		}
+		index++
+		scanned++
+
+		if len(match) > 0 && !pkg.GlobMatch(match, field) {
+			return true, nil
+		}
+		value, err := getValue()
+		if err != nil {
+			return false, err
+		}
+		result = append(result, &FieldValue{Field: field, Value: value})
+		return true, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !more {
+		return result, 0, nil
+	}
+	return result, index, nil
+}
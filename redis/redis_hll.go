@@ -0,0 +1,235 @@
+package redis
+
+import (
+	"encoding/binary"
+	"kv-db-lab/constant"
+	"kv-db-lab/pkg"
+	"math"
+	"math/bits"
+)
+
+// =========================HyperLogLog 数据结构=============================================================
+//元数据格式key =>| type |expire |version | size
+//数据部分格式key |version => encoding | registers
+// 与redis一致使用16384个寄存器，非0的寄存器较少时以稀疏格式 (index(大端2byte) | value)... 存储，否则以每个寄存器1byte的稠密格式存储
+
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+
+	// hllSparseMaxBytes 稀疏格式的最大字节数，超过后转为稠密格式
+	hllSparseMaxBytes = 3000
+
+	// hllAlphaInf 与redis一致的估算常数 0.5/ln2
+	hllAlphaInf = 0.721347520444481703680
+
+	hllSeed = 0xadc83b19
+)
+
+const (
+	hllEncodingSparse byte = iota
+	hllEncodingDense
+)
+
+// hllPatLen 返回元素对应的寄存器下标以及哈希值中连续0的数量加1
+func hllPatLen(element []byte) (int, byte) {
+	hash := pkg.MurmurHash64A(element, hllSeed)
+	index := int(hash & (hllRegisters - 1))
+	hash >>= hllP
+	// 保证最多计数到hllQ+1
+	hash |= 1 << hllQ
+	return index, byte(bits.TrailingZeros64(hash) + 1)
+}
+
+// encodeHLL 根据非0寄存器的数量选择稀疏或稠密格式
+func encodeHLL(registers []byte) []byte {
+	var nonZero int
+	for _, v := range registers {
+		if v != 0 {
+			nonZero++
+		}
+	}
+
+	if nonZero*3 > hllSparseMaxBytes {
+		buf := make([]byte, 1+hllRegisters)
+		buf[0] = hllEncodingDense
+		copy(buf[1:], registers)
+		return buf
+	}
+
+	buf := make([]byte, 1, 1+nonZero*3)
+	buf[0] = hllEncodingSparse
+	for i, v := range registers {
+		if v != 0 {
+			buf = binary.BigEndian.AppendUint16(buf, uint16(i))
+			buf = append(buf, v)
+		}
+	}
+	return buf
+}
+
+// decodeHLL 解码为稠密的寄存器数组
+func decodeHLL(buf []byte) []byte {
+	registers := make([]byte, hllRegisters)
+	if len(buf) == 0 {
+		return registers
+	}
+	if buf[0] == hllEncodingDense {
+		copy(registers, buf[1:])
+		return registers
+	}
+	for i := 1; i+3 <= len(buf); i += 3 {
+		registers[binary.BigEndian.Uint16(buf[i:])] = buf[i+2]
+	}
+	return registers
+}
+
+// loadHLL 读取key的寄存器，key不存在时寄存器全为0
+func (rds *RedisDataStructure) loadHLL(key []byte) ([]byte, *metaData, error) {
+	meta, err := rds.findMetaData(key, HyperLogLog)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta.size == 0 {
+		return decodeHLL(nil), meta, nil
+	}
+
+	buf, err := rds.db.Get(dataPrefix(key, meta))
+	if err != nil && err != constant.ErrNotExist {
+		return nil, nil, err
+	}
+	return decodeHLL(buf), meta, nil
+}
+
+// saveHLL 在同一批次内写入寄存器与元数据，与redis一致PFADD与PFMERGE均通知pfadd
+func (rds *RedisDataStructure) saveHLL(key []byte, meta *metaData, registers []byte) error {
+	meta.size = 1
+	wb := rds.newWriteBatch(2)
+	_ = wb.Put(dataPrefix(key, meta), encodeHLL(registers))
+	rds.putMeta(wb, key, meta)
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+	rds.notify(EventString, "pfadd", key)
+	return nil
+}
+
+// PFAdd 添加元素，返回估算的基数是否可能发生变化，key不存在时即使没有元素也会创建
+func (rds *RedisDataStructure) PFAdd(key []byte, elements ...[]byte) (bool, error) {
+	registers, meta, err := rds.loadHLL(key)
+	if err != nil {
+		return false, err
+	}
+
+	updated := meta.size == 0
+	for _, element := range elements {
+		index, count := hllPatLen(element)
+		if count > registers[index] {
+			registers[index] = count
+			updated = true
+		}
+	}
+	if !updated {
+		return false, nil
+	}
+	if err = rds.saveHLL(key, meta, registers); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PFCount 返回估算的基数，多个key时返回并集的基数
+func (rds *RedisDataStructure) PFCount(keys ...[]byte) (int64, error) {
+	registers, err := rds.unionHLL(keys...)
+	if err != nil {
+		return 0, err
+	}
+	return hllCount(registers), nil
+}
+
+// PFMerge 将多个key的并集合并到destination
+func (rds *RedisDataStructure) PFMerge(destination []byte, keys ...[]byte) error {
+	registers, meta, err := rds.loadHLL(destination)
+	if err != nil {
+		return err
+	}
+	union, err := rds.unionHLL(keys...)
+	if err != nil {
+		return err
+	}
+	for i, v := range union {
+		if v > registers[i] {
+			registers[i] = v
+		}
+	}
+	return rds.saveHLL(destination, meta, registers)
+}
+
+// unionHLL 每个寄存器取最大值
+func (rds *RedisDataStructure) unionHLL(keys ...[]byte) ([]byte, error) {
+	union := make([]byte, hllRegisters)
+	for _, key := range keys {
+		registers, _, err := rds.loadHLL(key)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range registers {
+			if v > union[i] {
+				union[i] = v
+			}
+		}
+	}
+	return union, nil
+}
+
+// hllCount 与redis一致的基数估算，参考Otmar Ertl的改进算法，不需要对小基数和大基数单独修正
+func hllCount(registers []byte) int64 {
+	var histogram [hllQ + 2]int
+	for _, v := range registers {
+		histogram[v]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return int64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package redis
 
-import "kv-db-lab/model"
+import (
+	"bytes"
+	"kv-db-lab/constant"
+)
 
 // ======================= List 数据结构 =================================================================================
 // 元数据存储格式key => type |expire |version |size |head |tail
 // 数据部分：key |version| index =>value
+// 元素连续存放在[head, tail)区间内，两端push/pop只需移动head/tail，中间插入删除需要移动元素保持连续
 
 func (rds *RedisDataStructure) LPush(key, element []byte) (uint32, error) {
 	return rds.pushInner(key, element, true)
@@ -22,6 +26,61 @@ func (rds *RedisDataStructure) RPop(key []byte) ([]byte, error) {
 	return rds.popInner(key, false)
 }
 
+// listElementKey 第i个位置(绝对位置)的元素的key
+func listElementKey(key []byte, meta *metaData, i uint64) []byte {
+	lk := &listInternalKey{
+		key:     key,
+		version: meta.version,
+		index:   i,
+	}
+	return lk.encode()
+}
+
+// listIndex 将redis下标(负数表示从末尾倒数)转换为绝对位置，越界时返回false
+func listIndex(meta *metaData, index int64) (uint64, bool) {
+	if index < 0 {
+		index += int64(meta.size)
+	}
+	if index < 0 || index >= int64(meta.size) {
+		return 0, false
+	}
+	return meta.head + uint64(index), true
+}
+
+// listRange 与redis一致处理[start, stop]的负数下标与越界，区间为空时返回false
+func listRange(meta *metaData, start, stop int64) (int64, int64, bool) {
+	size := int64(meta.size)
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start > stop || start >= size {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+// listElements 读取[head, tail)内的全部元素
+func (rds *RedisDataStructure) listElements(key []byte, meta *metaData) ([][]byte, error) {
+	elements := make([][]byte, 0, meta.size)
+	for i := meta.head; i < meta.tail; i++ {
+		element, err := rds.db.Get(listElementKey(key, meta, i))
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
+
 // list的push方法
 func (rds *RedisDataStructure) pushInner(key, element []byte, isLeft bool) (uint32, error) {
 	// 查找元数据
@@ -42,23 +101,24 @@ func (rds *RedisDataStructure) pushInner(key, element []byte, isLeft bool) (uint
 	}
 
 	// 更新元数据和数据部分
-	wb := rds.db.NewWriteBatch(model.DefaultWriteBatchOptions)
+	wb := rds.newWriteBatch(2)
 	meta.size++
 	if isLeft {
 		meta.head--
 	} else {
 		meta.tail++
 	}
-	_ = wb.Put(key, meta.encode())
+	rds.putMeta(wb, key, meta)
 	_ = wb.Put(lk.encode(), element)
 	if err = wb.Commit(); err != nil {
 		return 0, err
 	}
+	rds.notify(EventList, listEvent("push", isLeft), key)
 
 	return meta.size, nil
 }
 
-// list的公共pop方法,pop不需要对数据删除，因为在push时会进行覆盖
+// list的公共pop方法，弹出的元素与元数据在同一批次内删除和更新
 func (rds *RedisDataStructure) popInner(key []byte, isLeft bool) ([]byte, error) {
 	// 查找元数据
 	meta, err := rds.findMetaData(key, List)
@@ -85,16 +145,328 @@ func (rds *RedisDataStructure) popInner(key []byte, isLeft bool) ([]byte, error)
 		return nil, err
 	}
 
-	// 更新元数据
+	// 更新元数据并删除弹出的元素
 	meta.size--
 	if isLeft {
 		meta.head++
 	} else {
 		meta.tail--
 	}
-	if err = rds.db.Put(key, meta.encode()); err != nil {
+	wb := rds.newWriteBatch(2)
+	_ = wb.Delete(lk.encode())
+	rds.putMeta(wb, key, meta)
+	if err = wb.Commit(); err != nil {
+		return nil, err
+	}
+	rds.notify(EventList, listEvent("pop", isLeft), key)
+	rds.notifyEmptied(key, meta)
+
+	return element, nil
+}
+
+// LLen 返回list的长度
+func (rds *RedisDataStructure) LLen(key []byte) (uint32, error) {
+	meta, err := rds.findMetaData(key, List)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// LIndex 返回下标处的元素，越界时返回nil
+func (rds *RedisDataStructure) LIndex(key []byte, index int64) ([]byte, error) {
+	meta, err := rds.findMetaData(key, List)
+	if err != nil {
+		return nil, err
+	}
+	i, ok := listIndex(meta, index)
+	if !ok {
+		return nil, nil
+	}
+	return rds.db.Get(listElementKey(key, meta, i))
+}
+
+// LRange 返回下标在[start, stop]之间的元素，负数表示从末尾倒数
+func (rds *RedisDataStructure) LRange(key []byte, start, stop int64) ([][]byte, error) {
+	meta, err := rds.findMetaData(key, List)
+	if err != nil {
+		return nil, err
+	}
+	start, stop, ok := listRange(meta, start, stop)
+	if !ok {
+		return [][]byte{}, nil
+	}
+
+	elements := make([][]byte, 0, stop-start+1)
+	for i := meta.head + uint64(start); i <= meta.head+uint64(stop); i++ {
+		element, err := rds.db.Get(listElementKey(key, meta, i))
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
+
+// LSet 修改下标处的元素，key不存在时返回ErrNotExist，越界时返回ErrIndexOutOfRange
+func (rds *RedisDataStructure) LSet(key []byte, index int64, element []byte) error {
+	meta, err := rds.findMetaData(key, List)
+	if err != nil {
+		return err
+	}
+	if meta.size == 0 {
+		return constant.ErrNotExist
+	}
+	i, ok := listIndex(meta, index)
+	if !ok {
+		return constant.ErrIndexOutOfRange
+	}
+	if err = rds.db.Put(listElementKey(key, meta, i), element); err != nil {
+		return err
+	}
+	rds.notify(EventList, "lset", key)
+	return nil
+}
+
+// LTrim 只保留下标在[start, stop]之间的元素，区间外的元素在同一批次内删除
+func (rds *RedisDataStructure) LTrim(key []byte, start, stop int64) error {
+	meta, err := rds.findMetaData(key, List)
+	if err != nil {
+		return err
+	}
+	if meta.size == 0 {
+		return nil
+	}
+
+	start, stop, ok := listRange(meta, start, stop)
+	newHead, newTail := meta.head+uint64(start), meta.head+uint64(stop)+1
+	if !ok {
+		newHead, newTail = meta.tail, meta.tail
+	}
+
+	wb := rds.newWriteBatch(int(meta.size) + 1)
+	for i := meta.head; i < meta.tail; i++ {
+		if i < newHead || i >= newTail {
+			_ = wb.Delete(listElementKey(key, meta, i))
+		}
+	}
+	meta.head, meta.tail = newHead, newTail
+	meta.size = uint32(newTail - newHead)
+	rds.putMeta(wb, key, meta)
+	if err = wb.Commit(); err != nil {
+		return err
+	}
+	rds.notify(EventList, "ltrim", key)
+	rds.notifyEmptied(key, meta)
+	return nil
+}
+
+// LRem
+//
+//	@Description: 删除与element相等的元素，count>0时从头开始删除count个，count<0时从尾开始删除|count|个，count=0时全部删除
+//	@receiver rds
+//	@param key
+//	@param count
+//	@param element
+//	@return uint32  删除的数量
+//	@return error
+func (rds *RedisDataStructure) LRem(key []byte, count int64, element []byte) (uint32, error) {
+	meta, err := rds.findMetaData(key, List)
+	if err != nil {
+		return 0, err
+	}
+	if meta.size == 0 {
+		return 0, nil
+	}
+	elements, err := rds.listElements(key, meta)
+	if err != nil {
+		return 0, err
+	}
+
+	// 标记需要删除的元素
+	removed := make([]bool, len(elements))
+	var removedNum int64
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	for n := 0; n < len(elements); n++ {
+		i := n
+		if count < 0 {
+			i = len(elements) - 1 - n
+		}
+		if bytes.Equal(elements[i], element) {
+			removed[i] = true
+			removedNum++
+			if limit != 0 && removedNum == limit {
+				break
+			}
+		}
+	}
+	if removedNum == 0 {
+		return 0, nil
+	}
+
+	// 剩余元素从head开始重新连续存放，多出的尾部位置删除
+	remain := make([][]byte, 0, len(elements)-int(removedNum))
+	for i, e := range elements {
+		if !removed[i] {
+			remain = append(remain, e)
+		}
+	}
+	if err = rds.rewriteList(key, meta, elements, remain); err != nil {
+		return 0, err
+	}
+	rds.notify(EventList, "lrem", key)
+	rds.notifyEmptied(key, meta)
+	return uint32(removedNum), nil
+}
+
+// LInsert
+//
+//	@Description: 在第一个与pivot相等的元素之前或之后插入element
+//	@receiver rds
+//	@param key
+//	@param before
+//	@param pivot
+//	@param element
+//	@return int64  插入后的长度，key不存在时为0，找不到pivot时为-1
+//	@return error
+func (rds *RedisDataStructure) LInsert(key []byte, before bool, pivot, element []byte) (int64, error) {
+	meta, err := rds.findMetaData(key, List)
+	if err != nil {
+		return 0, err
+	}
+	if meta.size == 0 {
+		return 0, nil
+	}
+	elements, err := rds.listElements(key, meta)
+	if err != nil {
+		return 0, err
+	}
+
+	pos := -1
+	for i, e := range elements {
+		if bytes.Equal(e, pivot) {
+			pos = i
+			break
+		}
+	}
+	if pos == -1 {
+		return -1, nil
+	}
+	if !before {
+		pos++
+	}
+
+	newElements := make([][]byte, 0, len(elements)+1)
+	newElements = append(newElements, elements[:pos]...)
+	newElements = append(newElements, element)
+	newElements = append(newElements, elements[pos:]...)
+	if err = rds.rewriteList(key, meta, elements, newElements); err != nil {
+		return 0, err
+	}
+	rds.notify(EventList, "linsert", key)
+	return int64(meta.size), nil
+}
+
+// rewriteList 将list的元素从head开始重新连续写入，只写入位置上发生变化的元素
+func (rds *RedisDataStructure) rewriteList(key []byte, meta *metaData, oldElements, newElements [][]byte) error {
+	wb := rds.newWriteBatch(len(oldElements) + len(newElements) + 1)
+	for i, e := range newElements {
+		if i < len(oldElements) && bytes.Equal(oldElements[i], e) {
+			continue
+		}
+		_ = wb.Put(listElementKey(key, meta, meta.head+uint64(i)), e)
+	}
+	for i := len(newElements); i < len(oldElements); i++ {
+		_ = wb.Delete(listElementKey(key, meta, meta.head+uint64(i)))
+	}
+	meta.size = uint32(len(newElements))
+	meta.tail = meta.head + uint64(len(newElements))
+	rds.putMeta(wb, key, meta)
+	return wb.Commit()
+}
+
+// LMove
+//
+//	@Description: 原子地从source的一端弹出元素并push到destination的一端，source与destination可以相同
+//	@receiver rds
+//	@param source
+//	@param destination
+//	@param srcLeft  是否从source的左端弹出
+//	@param dstLeft  是否push到destination的左端
+//	@return []byte  移动的元素，source为空时返回nil
+//	@return error
+func (rds *RedisDataStructure) LMove(source, destination []byte, srcLeft, dstLeft bool) ([]byte, error) {
+	srcMeta, err := rds.findMetaData(source, List)
+	if err != nil {
 		return nil, err
 	}
+	if srcMeta.size == 0 {
+		return nil, nil
+	}
+
+	// 先校验destination的类型，避免弹出后无法写入
+	dstMeta := srcMeta
+	if !bytes.Equal(source, destination) {
+		if dstMeta, err = rds.findMetaData(destination, List); err != nil {
+			return nil, err
+		}
+	}
 
+	srcIndex := srcMeta.tail - 1
+	if srcLeft {
+		srcIndex = srcMeta.head
+	}
+	srcKey := listElementKey(source, srcMeta, srcIndex)
+	element, err := rds.db.Get(srcKey)
+	if err != nil {
+		return nil, err
+	}
+
+	wb := rds.newWriteBatch(4)
+	_ = wb.Delete(srcKey)
+	srcMeta.size--
+	if srcLeft {
+		srcMeta.head++
+	} else {
+		srcMeta.tail--
+	}
+
+	var dstIndex uint64
+	if dstLeft {
+		dstMeta.head--
+		dstIndex = dstMeta.head
+	} else {
+		dstIndex = dstMeta.tail
+		dstMeta.tail++
+	}
+	dstMeta.size++
+	_ = wb.Put(listElementKey(destination, dstMeta, dstIndex), element)
+
+	rds.putMeta(wb, source, srcMeta)
+	if dstMeta != srcMeta {
+		rds.putMeta(wb, destination, dstMeta)
+	}
+	if err = wb.Commit(); err != nil {
+		return nil, err
+	}
+	rds.notify(EventList, listEvent("pop", srcLeft), source)
+	rds.notify(EventList, listEvent("push", dstLeft), destination)
+	rds.notifyEmptied(source, srcMeta)
 	return element, nil
 }
+
+// listEvent 返回list两端操作的事件名，如lpush、rpop
+func listEvent(op string, isLeft bool) string {
+	if isLeft {
+		return "l" + op
+	}
+	return "r" + op
+}
+
+// RPopLPush 从source右端弹出元素并push到destination左端
+func (rds *RedisDataStructure) RPopLPush(source, destination []byte) ([]byte, error) {
+	return rds.LMove(source, destination, false, true)
+}
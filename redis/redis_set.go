@@ -1,8 +1,13 @@
 package redis
 
 import (
+	"bytes"
 	"kv-db-lab/constant"
 	"kv-db-lab/model"
+	"kv-db-lab/pkg"
+	"math/rand"
+	"sort"
+	"time"
 )
 
 // ======================= Set 数据结构 =================================================================
@@ -28,11 +33,12 @@ func (rds *RedisDataStructure) SAdd(key, member []byte) (bool, error) {
 		// 不存在的话则更新
 		wb := rds.db.NewWriteBatch(model.DefaultWriteBatchOptions)
 		meta.size++
-		_ = wb.Put(key, meta.encode())
+		rds.putMeta(wb, key, meta)
 		_ = wb.Put(sk.encode(), nil)
 		if err = wb.Commit(); err != nil {
 			return false, err
 		}
+		rds.notify(EventSet, "sadd", key)
 		ok = true
 	}
 
@@ -88,10 +94,381 @@ func (rds *RedisDataStructure) SRem(key, member []byte) (bool, error) {
 	// 更新
 	wb := rds.db.NewWriteBatch(model.DefaultWriteBatchOptions)
 	meta.size--
-	_ = wb.Put(key, meta.encode())
+	rds.putMeta(wb, key, meta)
 	_ = wb.Delete(sk.encode())
 	if err = wb.Commit(); err != nil {
 		return false, err
 	}
+	rds.notify(EventSet, "srem", key)
+	rds.notifyEmptied(key, meta)
 	return true, nil
 }
+
+// setMembers 按顺序返回set的全部成员
+func (rds *RedisDataStructure) setMembers(key []byte) ([][]byte, *metaData, error) {
+	meta, err := rds.findMetaData(key, Set)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	members := make([][]byte, 0, meta.size)
+	if meta.size == 0 {
+		return members, meta, nil
+	}
+	err = rds.iteratePrefix(dataPrefix(key, meta), func(suffix []byte, _ func() ([]byte, error)) (bool, error) {
+		// suffix为 member | member size
+		members = append(members, suffix[:len(suffix)-4])
+		return true, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return members, meta, nil
+}
+
+// SMembers 返回set的全部成员
+func (rds *RedisDataStructure) SMembers(key []byte) ([][]byte, error) {
+	members, _, err := rds.setMembers(key)
+	return members, err
+}
+
+// SCard 返回set的成员数量
+func (rds *RedisDataStructure) SCard(key []byte) (uint32, error) {
+	meta, err := rds.findMetaData(key, Set)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// SPop 随机删除并返回count个成员
+func (rds *RedisDataStructure) SPop(key []byte, count int) ([][]byte, error) {
+	members, meta, err := rds.setMembers(key)
+	if err != nil {
+		return nil, err
+	}
+	if count <= 0 || len(members) == 0 {
+		return [][]byte{}, nil
+	}
+	if count > len(members) {
+		count = len(members)
+	}
+
+	// 随机打乱后取前count个
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	r.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	popped := members[:count]
+
+	wb := rds.newWriteBatch(count + 1)
+	for _, member := range popped {
+		sk := &setInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+		}
+		_ = wb.Delete(sk.encode())
+	}
+	meta.size -= uint32(count)
+	rds.putMeta(wb, key, meta)
+	if err = wb.Commit(); err != nil {
+		return nil, err
+	}
+	rds.notify(EventSet, "spop", key)
+	rds.notifyEmptied(key, meta)
+	return popped, nil
+}
+
+// SRandMember 随机返回成员，count>0时返回不重复的最多count个，count<0时返回|count|个且可能重复
+func (rds *RedisDataStructure) SRandMember(key []byte, count int) ([][]byte, error) {
+	members, _, err := rds.setMembers(key)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 || len(members) == 0 {
+		return [][]byte{}, nil
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if count < 0 {
+		result := make([][]byte, 0, -count)
+		for i := 0; i < -count; i++ {
+			result = append(result, members[r.Intn(len(members))])
+		}
+		return result, nil
+	}
+
+	if count > len(members) {
+		count = len(members)
+	}
+	r.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	return members[:count], nil
+}
+
+// SMove 原子地将member从source移到destination，返回是否移动
+func (rds *RedisDataStructure) SMove(source, destination, member []byte) (bool, error) {
+	srcMeta, err := rds.findMetaData(source, Set)
+	if err != nil {
+		return false, err
+	}
+	dstMeta, err := rds.findMetaData(destination, Set)
+	if err != nil {
+		return false, err
+	}
+	if srcMeta.size == 0 {
+		return false, nil
+	}
+
+	srcKey := (&setInternalKey{key: source, version: srcMeta.version, member: member}).encode()
+	if _, err = rds.db.Get(srcKey); err == constant.ErrNotExist {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if bytes.Equal(source, destination) {
+		return true, nil
+	}
+
+	dstKey := (&setInternalKey{key: destination, version: dstMeta.version, member: member}).encode()
+	wb := rds.newWriteBatch(4)
+	_ = wb.Delete(srcKey)
+	srcMeta.size--
+	rds.putMeta(wb, source, srcMeta)
+	var added bool
+	if dstMeta.size == 0 {
+		added = true
+	} else if _, err = rds.db.Get(dstKey); err == constant.ErrNotExist {
+		added = true
+	} else if err != nil {
+		return false, err
+	}
+	if added {
+		_ = wb.Put(dstKey, nil)
+		dstMeta.size++
+		rds.putMeta(wb, destination, dstMeta)
+	}
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	rds.notify(EventSet, "srem", source)
+	rds.notifyEmptied(source, srcMeta)
+	if added {
+		rds.notify(EventSet, "sadd", destination)
+	}
+	return true, nil
+}
+
+// setOp 集合运算类型
+type setOp byte
+
+const (
+	setInter setOp = iota
+	setUnion
+	setDiff
+)
+
+// storeEvent 返回将运算结果写入destination时通知的事件名
+func (op setOp) storeEvent() string {
+	switch op {
+	case setInter:
+		return "sinterstore"
+	case setUnion:
+		return "sunionstore"
+	default:
+		return "sdiffstore"
+	}
+}
+
+// setCompute 对多个set进行交、并、差运算，结果按成员顺序返回
+func (rds *RedisDataStructure) setCompute(op setOp, keys ...[]byte) ([][]byte, error) {
+	if len(keys) == 0 {
+		return nil, constant.ErrEmptyParam
+	}
+
+	first, _, err := rds.setMembers(keys[0])
+	if err != nil {
+		return nil, err
+	}
+	// 以第一个set为基础，按顺序与其余set运算
+	result := make(map[string]struct{}, len(first))
+	for _, member := range first {
+		result[string(member)] = struct{}{}
+	}
+
+	for _, key := range keys[1:] {
+		members, _, err := rds.setMembers(key)
+		if err != nil {
+			return nil, err
+		}
+		other := make(map[string]struct{}, len(members))
+		for _, member := range members {
+			other[string(member)] = struct{}{}
+		}
+
+		switch op {
+		case setInter:
+			for member := range result {
+				if _, ok := other[member]; !ok {
+					delete(result, member)
+				}
+			}
+		case setUnion:
+			for member := range other {
+				result[member] = struct{}{}
+			}
+		case setDiff:
+			for member := range other {
+				delete(result, member)
+			}
+		}
+	}
+
+	members := make([][]byte, 0, len(result))
+	for member := range result {
+		members = append(members, []byte(member))
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return bytes.Compare(members[i], members[j]) < 0
+	})
+	return members, nil
+}
+
+// SInter 返回所有set的交集
+func (rds *RedisDataStructure) SInter(keys ...[]byte) ([][]byte, error) {
+	return rds.setCompute(setInter, keys...)
+}
+
+// SUnion 返回所有set的并集
+func (rds *RedisDataStructure) SUnion(keys ...[]byte) ([][]byte, error) {
+	return rds.setCompute(setUnion, keys...)
+}
+
+// SDiff 返回第一个set与其余set的差集
+func (rds *RedisDataStructure) SDiff(keys ...[]byte) ([][]byte, error) {
+	return rds.setCompute(setDiff, keys...)
+}
+
+// SInterStore 将交集写入destination，返回结果的数量
+func (rds *RedisDataStructure) SInterStore(destination []byte, keys ...[]byte) (uint32, error) {
+	return rds.setComputeStore(setInter, destination, keys...)
+}
+
+// SUnionStore 将并集写入destination，返回结果的数量
+func (rds *RedisDataStructure) SUnionStore(destination []byte, keys ...[]byte) (uint32, error) {
+	return rds.setComputeStore(setUnion, destination, keys...)
+}
+
+// SDiffStore 将差集写入destination，返回结果的数量
+func (rds *RedisDataStructure) SDiffStore(destination []byte, keys ...[]byte) (uint32, error) {
+	return rds.setComputeStore(setDiff, destination, keys...)
+}
+
+// setComputeStore
+//
+//	@Description: 计算结果后覆盖destination，destination原有数据的回收任务与新结果在同一批次内提交
+//	@receiver rds
+//	@param op
+//	@param destination  与redis一致，原有的数据无论何种类型都会被覆盖
+//	@param keys
+//	@return uint32
+//	@return error
+func (rds *RedisDataStructure) setComputeStore(op setOp, destination []byte, keys ...[]byte) (uint32, error) {
+	members, err := rds.setCompute(op, keys...)
+	if err != nil {
+		return 0, err
+	}
+
+	// destination原有的数据部分登记回收，结果以新的版本号写入
+	old, err := rds.db.Get(destination)
+	if err != nil && err != constant.ErrNotExist {
+		return 0, err
+	}
+	wb := rds.newWriteBatch(len(members) + 2)
+	rds.addGCTask(wb, destination, old)
+	defer rds.notifyGC()
+
+	// 结果为空时与redis一致删除destination
+	if len(members) == 0 {
+		_ = wb.Delete(destination)
+		if err = wb.Commit(); err != nil {
+			return 0, err
+		}
+		if old != nil {
+			rds.notify(EventGeneric, "del", destination)
+		}
+		return 0, nil
+	}
+
+	meta := &metaData{
+		dataType: Set,
+		version:  time.Now().UnixNano(),
+		size:     uint32(len(members)),
+	}
+	for _, member := range members {
+		_ = wb.Put((&setInternalKey{key: destination, version: meta.version, member: member}).encode(), nil)
+	}
+	rds.putMeta(wb, destination, meta)
+	if err = wb.Commit(); err != nil {
+		return 0, err
+	}
+	rds.notify(EventSet, op.storeEvent(), destination)
+	return meta.size, nil
+}
+
+// SScan
+//
+//	@Description: 基于游标分批遍历set，游标为按成员顺序已遍历的数量
+//	@receiver rds
+//	@param key
+//	@param cursor  首次传0
+//	@param match  glob格式，为空时不过滤
+//	@param count  本次最多遍历的成员数量，小于等于0时为10
+//	@return [][]byte
+//	@return uint64  下次调用的游标，为0时表示遍历结束
+//	@return error
+func (rds *RedisDataStructure) SScan(key []byte, cursor uint64, match []byte, count int) ([][]byte, uint64, error) {
+	if count <= 0 {
+		count = 10
+	}
+	meta, err := rds.findMetaData(key, Set)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([][]byte, 0)
+	if meta.size == 0 {
+		return result, 0, nil
+	}
+
+	var index, scanned uint64
+	var more bool
+	err = rds.iteratePrefix(dataPrefix(key, meta), func(suffix []byte, _ func() ([]byte, error)) (bool, error) {
+		if index < cursor {
+			index++
+			return true, nil
+		}
+		if scanned == uint64(count) {
+			more = true
+			return false, nil
+		}
+		index++
+		scanned++
+
+		member := suffix[:len(suffix)-4]
+		if len(match) == 0 || pkg.GlobMatch(match, member) {
+			result = append(result, member)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !more {
+		return result, 0, nil
+	}
+	return result, index, nil
+}
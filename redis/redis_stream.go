@@ -0,0 +1,953 @@
+package redis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"math"
+	"strconv"
+	"time"
+)
+
+// =========================Stream 数据结构=============================================================
+//元数据格式key =>| type |expire |version | size | lastID
+//数据部分格式:
+//  1.消息 key |version |entryTag |ms(大端8byte) |seq(大端8byte) => 字段数量 | (长度 | field | 长度 | value)...
+//  2.消费者组 key |version |groupTag |group => lastDeliveredID
+//  3.待确认消息 key |version |pendingTag |len(group)(大端4byte) |group |ID => 投递时间 | 投递次数 | consumer
+//  4.消费者 key |version |consumerTag |len(group)(大端4byte) |group |consumer => 最近活跃时间
+// ID以大端编码，消息按ID顺序存储，范围查询直接定位到起始ID
+// size为消息数量，与redis一致删除全部消息后stream仍然存在
+
+const (
+	streamEntryTag byte = iota
+	streamGroupTag
+	streamPendingTag
+	streamConsumerTag
+)
+
+// streamIDSize ID编码后的长度
+const streamIDSize = 16
+
+// StreamID stream消息的ID，由毫秒时间戳与序号组成
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	// MinStreamID 最小的ID，即XRANGE中的 -
+	MinStreamID = StreamID{}
+
+	// MaxStreamID 最大的ID，即XRANGE中的 +
+	MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare 比较两个ID的大小
+func (id StreamID) Compare(other StreamID) int {
+	switch {
+	case id.Ms < other.Ms:
+		return -1
+	case id.Ms > other.Ms:
+		return 1
+	case id.Seq < other.Seq:
+		return -1
+	case id.Seq > other.Seq:
+		return 1
+	}
+	return 0
+}
+
+// Next 返回比id大的最小ID，id为最大ID时返回false
+func (id StreamID) Next() (StreamID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return StreamID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// Prev 返回比id小的最大ID，id为最小ID时返回false
+func (id StreamID) Prev() (StreamID, bool) {
+	if id.Seq > 0 {
+		return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+func (id StreamID) encode() []byte {
+	buf := make([]byte, streamIDSize)
+	binary.BigEndian.PutUint64(buf, id.Ms)
+	binary.BigEndian.PutUint64(buf[8:], id.Seq)
+	return buf
+}
+
+func decodeStreamID(buf []byte) StreamID {
+	return StreamID{
+		Ms:  binary.BigEndian.Uint64(buf),
+		Seq: binary.BigEndian.Uint64(buf[8:]),
+	}
+}
+
+// ParseStreamID
+//
+//	@Description: 解析 ms-seq 格式的ID，省略seq时使用defaultSeq
+//	@param s
+//	@param defaultSeq  XRANGE的起点为0，终点为最大值
+//	@return StreamID
+//	@return error
+func ParseStreamID(s []byte, defaultSeq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := bytes.Cut(s, []byte("-"))
+	ms, err := strconv.ParseUint(string(msPart), 10, 64)
+	if err != nil {
+		return StreamID{}, constant.ErrInvalidStreamID
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: defaultSeq}, nil
+	}
+	seq, err := strconv.ParseUint(string(seqPart), 10, 64)
+	if err != nil {
+		return StreamID{}, constant.ErrInvalidStreamID
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// ParseStreamRangeID
+//
+//	@Description: 解析XRANGE与XPENDING的区间端点，支持 - 、+ 以及以 ( 开头的开区间
+//	@param s
+//	@param isEnd  是否为区间的终点
+//	@return StreamID
+//	@return error  开区间越过最小或最大ID时返回ErrInvalidStreamID
+func ParseStreamRangeID(s []byte, isEnd bool) (StreamID, error) {
+	switch string(s) {
+	case "-":
+		return MinStreamID, nil
+	case "+":
+		return MaxStreamID, nil
+	}
+
+	exclusive := len(s) > 0 && s[0] == '('
+	if exclusive {
+		s = s[1:]
+	}
+	var defaultSeq uint64
+	if isEnd {
+		defaultSeq = math.MaxUint64
+	}
+	id, err := ParseStreamID(s, defaultSeq)
+	if err != nil || !exclusive {
+		return id, err
+	}
+
+	var ok bool
+	if isEnd {
+		id, ok = id.Prev()
+	} else {
+		id, ok = id.Next()
+	}
+	if !ok {
+		return StreamID{}, constant.ErrInvalidStreamID
+	}
+	return id, nil
+}
+
+// StreamEntry stream中的一条消息，Fields为field与value交替排列
+// 已被删除但仍在待确认列表中的消息Fields为nil
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte
+}
+
+// StreamTrim
+//
+//	@Description: 裁剪stream的条件，MinID不为nil时按ID裁剪，否则按长度裁剪
+type StreamTrim struct {
+	MaxLen int64
+	MinID  *StreamID
+	// Limit 最多删除的消息数量，为0时不限制
+	Limit int64
+}
+
+// XAddOptions XADD的可选参数
+type XAddOptions struct {
+	// NoMkStream key不存在时不创建stream
+	NoMkStream bool
+	// Trim 写入后裁剪stream，为nil时不裁剪
+	Trim *StreamTrim
+}
+
+// PendingSummary XPENDING的汇总信息
+type PendingSummary struct {
+	Count     int64
+	Min       StreamID
+	Max       StreamID
+	Consumers []*PendingConsumer
+}
+
+// PendingConsumer 消费者以及其待确认的消息数量
+type PendingConsumer struct {
+	Name  []byte
+	Count int64
+}
+
+// PendingEntry 一条待确认的消息
+type PendingEntry struct {
+	ID         StreamID
+	Consumer   []byte
+	Idle       time.Duration
+	Deliveries int64
+}
+
+// streamGroup 消费者组以及组内已投递的最大ID
+type streamGroup struct {
+	name          []byte
+	lastDelivered StreamID
+}
+
+func streamTagPrefix(key []byte, meta *metaData, tag byte) []byte {
+	return append(dataPrefix(key, meta), tag)
+}
+
+func streamEntryKey(key []byte, meta *metaData, id StreamID) []byte {
+	return append(streamTagPrefix(key, meta, streamEntryTag), id.encode()...)
+}
+
+func streamGroupKey(key []byte, meta *metaData, group []byte) []byte {
+	return append(streamTagPrefix(key, meta, streamGroupTag), group...)
+}
+
+// streamGroupPrefix 待确认消息或消费者的前缀 key |version |tag |len(group) |group
+func streamGroupPrefix(key []byte, meta *metaData, tag byte, group []byte) []byte {
+	buf := binary.BigEndian.AppendUint32(streamTagPrefix(key, meta, tag), uint32(len(group)))
+	return append(buf, group...)
+}
+
+func streamPendingKey(key []byte, meta *metaData, group []byte, id StreamID) []byte {
+	return append(streamGroupPrefix(key, meta, streamPendingTag, group), id.encode()...)
+}
+
+func streamConsumerKey(key []byte, meta *metaData, group, consumer []byte) []byte {
+	return append(streamGroupPrefix(key, meta, streamConsumerTag, group), consumer...)
+}
+
+func encodeStreamFields(fields [][]byte) []byte {
+	size := binary.MaxVarintLen32
+	for _, f := range fields {
+		size += binary.MaxVarintLen32 + len(f)
+	}
+	buf := make([]byte, 0, size)
+	buf = binary.AppendUvarint(buf, uint64(len(fields)))
+	for _, f := range fields {
+		buf = binary.AppendUvarint(buf, uint64(len(f)))
+		buf = append(buf, f...)
+	}
+	return buf
+}
+
+func decodeStreamFields(buf []byte) [][]byte {
+	count, idx := binary.Uvarint(buf)
+	fields := make([][]byte, 0, count)
+	for i := uint64(0); i < count; i++ {
+		size, n := binary.Uvarint(buf[idx:])
+		idx += n
+		fields = append(fields, append([]byte{}, buf[idx:idx+int(size)]...))
+		idx += int(size)
+	}
+	return fields
+}
+
+// pendingValue 待确认消息的value 投递时间(毫秒) | 投递次数 | consumer
+type pendingValue struct {
+	deliveryTime int64
+	deliveries   int64
+	consumer     []byte
+}
+
+func (pv *pendingValue) encode() []byte {
+	buf := make([]byte, 0, binary.MaxVarintLen64*2+len(pv.consumer))
+	buf = binary.AppendVarint(buf, pv.deliveryTime)
+	buf = binary.AppendVarint(buf, pv.deliveries)
+	return append(buf, pv.consumer...)
+}
+
+func decodePendingValue(buf []byte) *pendingValue {
+	deliveryTime, idx := binary.Varint(buf)
+	deliveries, n := binary.Varint(buf[idx:])
+	idx += n
+	return &pendingValue{
+		deliveryTime: deliveryTime,
+		deliveries:   deliveries,
+		consumer:     append([]byte{}, buf[idx:]...),
+	}
+}
+
+// findStream 查找stream的元数据，并返回stream是否存在
+func (rds *RedisDataStructure) findStream(key []byte) (*metaData, bool, error) {
+	encValue, err := rds.db.Get(key)
+	if err != nil && err != constant.ErrNotExist {
+		return nil, false, err
+	}
+
+	typ, _, exist := decodeKeyInfo(encValue)
+	if !exist {
+		// 由findMetaData校验类型以及回收过期的数据
+		meta, err := rds.findMetaData(key, Stream)
+		return meta, false, err
+	}
+	if typ != Stream {
+		return nil, false, constant.ErrWrongTypeOp
+	}
+	return decodeMetaData(encValue), true, nil
+}
+
+// findGroup 查找消费者组，不存在时返回ErrNoGroup
+func (rds *RedisDataStructure) findGroup(key []byte, meta *metaData, exist bool, group []byte) (*streamGroup, error) {
+	if !exist {
+		return nil, constant.ErrNoGroup
+	}
+	value, err := rds.db.Get(streamGroupKey(key, meta, group))
+	if err == constant.ErrNotExist {
+		return nil, constant.ErrNoGroup
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &streamGroup{name: group, lastDelivered: decodeStreamID(value)}, nil
+}
+
+// nextStreamID 根据XADD指定的ID生成新消息的ID
+// id为 * 时自动生成，为 ms-* 时自动生成序号，否则必须大于stream中最大的ID
+func nextStreamID(last StreamID, id []byte) (StreamID, error) {
+	if string(id) == "*" {
+		now := uint64(time.Now().UnixMilli())
+		if now > last.Ms {
+			return StreamID{Ms: now}, nil
+		}
+		next, ok := last.Next()
+		if !ok {
+			return StreamID{}, constant.ErrStreamIDTooSmall
+		}
+		return next, nil
+	}
+
+	if bytes.HasSuffix(id, []byte("-*")) {
+		ms, err := strconv.ParseUint(string(id[:len(id)-2]), 10, 64)
+		if err != nil {
+			return StreamID{}, constant.ErrInvalidStreamID
+		}
+		if ms > last.Ms {
+			return StreamID{Ms: ms}, nil
+		}
+		if ms < last.Ms || last.Seq == math.MaxUint64 {
+			return StreamID{}, constant.ErrStreamIDTooSmall
+		}
+		return StreamID{Ms: ms, Seq: last.Seq + 1}, nil
+	}
+
+	newID, err := ParseStreamID(id, 0)
+	if err != nil {
+		return StreamID{}, err
+	}
+	// 与redis一致，ID必须大于0-0
+	if newID.Compare(last) <= 0 || newID == MinStreamID {
+		return StreamID{}, constant.ErrStreamIDTooSmall
+	}
+	return newID, nil
+}
+
+// XAdd
+//
+//	@Description: 向stream末尾添加一条消息
+//	@receiver rds
+//	@param key
+//	@param id  * 表示自动生成，ms-* 表示自动生成序号
+//	@param fields  field与value交替排列
+//	@param opts  可以为nil
+//	@return StreamID  新消息的ID
+//	@return error  NoMkStream且key不存在时返回ErrNotExist
+func (rds *RedisDataStructure) XAdd(key, id []byte, fields [][]byte, opts *XAddOptions) (StreamID, error) {
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return StreamID{}, constant.ErrEmptyParam
+	}
+	if opts == nil {
+		opts = &XAddOptions{}
+	}
+
+	meta, exist, err := rds.findStream(key)
+	if err != nil {
+		return StreamID{}, err
+	}
+	if !exist && opts.NoMkStream {
+		return StreamID{}, constant.ErrNotExist
+	}
+	newID, err := nextStreamID(meta.lastID, id)
+	if err != nil {
+		return StreamID{}, err
+	}
+
+	meta.lastID = newID
+	meta.size++
+	wb := rds.newWriteBatch(2)
+	_ = wb.Put(streamEntryKey(key, meta, newID), encodeStreamFields(fields))
+	rds.putMeta(wb, key, meta)
+	if err = wb.Commit(); err != nil {
+		return StreamID{}, err
+	}
+	rds.notify(EventStream, "xadd", key)
+
+	if opts.Trim != nil {
+		if _, err = rds.streamTrim(key, meta, opts.Trim); err != nil {
+			return StreamID{}, err
+		}
+	}
+	return newID, nil
+}
+
+// XLen 返回stream中的消息数量
+func (rds *RedisDataStructure) XLen(key []byte) (int64, error) {
+	meta, _, err := rds.findStream(key)
+	if err != nil {
+		return 0, err
+	}
+	return int64(meta.size), nil
+}
+
+// XRange 按ID从小到大返回[start, end]之间的消息，count小于0时不限制数量
+func (rds *RedisDataStructure) XRange(key []byte, start, end StreamID, count int64) ([]*StreamEntry, error) {
+	return rds.xrange(key, start, end, count, false)
+}
+
+// XRevRange 按ID从大到小返回[start, end]之间的消息，count小于0时不限制数量
+func (rds *RedisDataStructure) XRevRange(key []byte, end, start StreamID, count int64) ([]*StreamEntry, error) {
+	return rds.xrange(key, start, end, count, true)
+}
+
+func (rds *RedisDataStructure) xrange(key []byte, start, end StreamID, count int64, reverse bool) ([]*StreamEntry, error) {
+	meta, _, err := rds.findStream(key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*StreamEntry, 0)
+	if count == 0 || meta.size == 0 || start.Compare(end) > 0 {
+		return result, nil
+	}
+
+	err = rds.iterateEntries(key, meta, start, end, reverse, func(entry *StreamEntry) bool {
+		result = append(result, entry)
+		return count < 0 || int64(len(result)) < count
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// iterateEntries 在存储索引上从区间的一端定位并按ID顺序遍历消息，fn返回false时停止
+func (rds *RedisDataStructure) iterateEntries(key []byte, meta *metaData, start, end StreamID, reverse bool,
+	fn func(entry *StreamEntry) bool) error {
+	prefix := streamTagPrefix(key, meta, streamEntryTag)
+	iter := rds.db.NewIterate(&model.IteratorOptions{Prefix: prefix, Reverse: reverse})
+	defer iter.Close()
+
+	seekID, stopID := start, end
+	if reverse {
+		seekID, stopID = end, start
+	}
+	for iter.Seek(append(append([]byte{}, prefix...), seekID.encode()...)); iter.Valid(); iter.Next() {
+		id := decodeStreamID(iter.Key()[len(prefix):])
+		if cmp := id.Compare(stopID); (!reverse && cmp > 0) || (reverse && cmp < 0) {
+			break
+		}
+		value, err := iter.Value()
+		if err != nil {
+			return err
+		}
+		if !fn(&StreamEntry{ID: id, Fields: decodeStreamFields(value)}) {
+			break
+		}
+	}
+	return nil
+}
+
+// XDel 删除指定ID的消息，返回实际删除的数量，不影响stream中最大的ID
+func (rds *RedisDataStructure) XDel(key []byte, ids ...StreamID) (int64, error) {
+	meta, exist, err := rds.findStream(key)
+	if err != nil || !exist {
+		return 0, err
+	}
+
+	wb := rds.newWriteBatch(len(ids) + 1)
+	deleted := make(map[StreamID]struct{}, len(ids))
+	for _, id := range ids {
+		if _, ok := deleted[id]; ok {
+			continue
+		}
+		entryKey := streamEntryKey(key, meta, id)
+		if _, err = rds.db.Get(entryKey); err == constant.ErrNotExist {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		_ = wb.Delete(entryKey)
+		deleted[id] = struct{}{}
+	}
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+
+	meta.size -= uint32(len(deleted))
+	rds.putMeta(wb, key, meta)
+	if err = wb.Commit(); err != nil {
+		return 0, err
+	}
+	rds.notify(EventStream, "xdel", key)
+	return int64(len(deleted)), nil
+}
+
+// XTrim 按长度或最小ID裁剪stream，返回删除的消息数量
+func (rds *RedisDataStructure) XTrim(key []byte, trim *StreamTrim) (int64, error) {
+	if trim == nil || trim.MaxLen < 0 || trim.Limit < 0 {
+		return 0, constant.ErrEmptyParam
+	}
+	meta, exist, err := rds.findStream(key)
+	if err != nil || !exist {
+		return 0, err
+	}
+	return rds.streamTrim(key, meta, trim)
+}
+
+// streamTrim 从最小的ID开始删除消息，直到满足裁剪条件或达到删除数量的上限
+func (rds *RedisDataStructure) streamTrim(key []byte, meta *metaData, trim *StreamTrim) (int64, error) {
+	var ids []StreamID
+	limit := int64(meta.size) - trim.MaxLen
+	if trim.MinID != nil {
+		limit = int64(meta.size)
+	}
+	if trim.Limit > 0 && trim.Limit < limit {
+		limit = trim.Limit
+	}
+	if limit <= 0 {
+		return 0, nil
+	}
+
+	err := rds.iterateEntries(key, meta, MinStreamID, MaxStreamID, false, func(entry *StreamEntry) bool {
+		if trim.MinID != nil && entry.ID.Compare(*trim.MinID) >= 0 {
+			return false
+		}
+		ids = append(ids, entry.ID)
+		return int64(len(ids)) < limit
+	})
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	wb := rds.newWriteBatch(len(ids) + 1)
+	for _, id := range ids {
+		_ = wb.Delete(streamEntryKey(key, meta, id))
+	}
+	meta.size -= uint32(len(ids))
+	rds.putMeta(wb, key, meta)
+	if err = wb.Commit(); err != nil {
+		return 0, err
+	}
+	rds.notify(EventStream, "xtrim", key)
+	return int64(len(ids)), nil
+}
+
+// parseGroupID 解析消费者组的起始ID，$ 表示stream中最大的ID
+func parseGroupID(meta *metaData, id []byte) (StreamID, error) {
+	if string(id) == "$" {
+		return meta.lastID, nil
+	}
+	return ParseStreamID(id, 0)
+}
+
+// XGroupCreate
+//
+//	@Description: 创建消费者组
+//	@receiver rds
+//	@param key
+//	@param group
+//	@param id  组内已投递的最大ID，$ 表示只消费之后添加的消息
+//	@param mkStream  key不存在时是否创建空的stream，否则返回ErrNotExist
+//	@return error  组已存在时返回ErrGroupExists
+func (rds *RedisDataStructure) XGroupCreate(key, group, id []byte, mkStream bool) error {
+	meta, exist, err := rds.findStream(key)
+	if err != nil {
+		return err
+	}
+	if !exist && !mkStream {
+		return constant.ErrNotExist
+	}
+	lastDelivered, err := parseGroupID(meta, id)
+	if err != nil {
+		return err
+	}
+
+	groupKey := streamGroupKey(key, meta, group)
+	if exist {
+		if _, err = rds.db.Get(groupKey); err == nil {
+			return constant.ErrGroupExists
+		} else if err != constant.ErrNotExist {
+			return err
+		}
+	}
+
+	wb := rds.newWriteBatch(2)
+	_ = wb.Put(groupKey, lastDelivered.encode())
+	if !exist {
+		rds.putMeta(wb, key, meta)
+	}
+	if err = wb.Commit(); err != nil {
+		return err
+	}
+	rds.notify(EventStream, "xgroup-create", key)
+	return nil
+}
+
+// XGroupSetID 修改消费者组已投递的最大ID
+func (rds *RedisDataStructure) XGroupSetID(key, group, id []byte) error {
+	meta, exist, err := rds.findStream(key)
+	if err != nil {
+		return err
+	}
+	if _, err = rds.findGroup(key, meta, exist, group); err != nil {
+		return err
+	}
+	lastDelivered, err := parseGroupID(meta, id)
+	if err != nil {
+		return err
+	}
+	if err = rds.db.Put(streamGroupKey(key, meta, group), lastDelivered.encode()); err != nil {
+		return err
+	}
+	rds.notify(EventStream, "xgroup-setid", key)
+	return nil
+}
+
+// XGroupDestroy 删除消费者组以及组内的待确认消息与消费者，返回组是否存在
+func (rds *RedisDataStructure) XGroupDestroy(key, group []byte) (bool, error) {
+	meta, exist, err := rds.findStream(key)
+	if err != nil {
+		return false, err
+	}
+	if _, err = rds.findGroup(key, meta, exist, group); err == constant.ErrNoGroup {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	keys := [][]byte{streamGroupKey(key, meta, group)}
+	for _, tag := range []byte{streamPendingTag, streamConsumerTag} {
+		prefix := streamGroupPrefix(key, meta, tag, group)
+		err = rds.iteratePrefix(prefix, func(suffix []byte, _ func() ([]byte, error)) (bool, error) {
+			keys = append(keys, append(append([]byte{}, prefix...), suffix...))
+			return true, nil
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	wb := rds.newWriteBatch(len(keys))
+	for _, k := range keys {
+		_ = wb.Delete(k)
+	}
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	rds.notify(EventStream, "xgroup-destroy", key)
+	return true, nil
+}
+
+// XGroupCreateConsumer 在消费者组内创建消费者，返回是否新创建
+func (rds *RedisDataStructure) XGroupCreateConsumer(key, group, consumer []byte) (bool, error) {
+	meta, exist, err := rds.findStream(key)
+	if err != nil {
+		return false, err
+	}
+	if _, err = rds.findGroup(key, meta, exist, group); err != nil {
+		return false, err
+	}
+
+	consumerKey := streamConsumerKey(key, meta, group, consumer)
+	if _, err = rds.db.Get(consumerKey); err == nil {
+		return false, nil
+	} else if err != constant.ErrNotExist {
+		return false, err
+	}
+	if err = rds.db.Put(consumerKey, binary.AppendVarint(nil, time.Now().UnixMilli())); err != nil {
+		return false, err
+	}
+	rds.notify(EventStream, "xgroup-createconsumer", key)
+	return true, nil
+}
+
+// XGroupDelConsumer 删除消费者以及其待确认的消息，返回删除的待确认消息数量
+func (rds *RedisDataStructure) XGroupDelConsumer(key, group, consumer []byte) (int64, error) {
+	meta, exist, err := rds.findStream(key)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = rds.findGroup(key, meta, exist, group); err != nil {
+		return 0, err
+	}
+
+	var pending [][]byte
+	err = rds.iteratePending(key, meta, group, func(id StreamID, pv *pendingValue) bool {
+		if bytes.Equal(pv.consumer, consumer) {
+			pending = append(pending, streamPendingKey(key, meta, group, id))
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	wb := rds.newWriteBatch(len(pending) + 1)
+	for _, k := range pending {
+		_ = wb.Delete(k)
+	}
+	_ = wb.Delete(streamConsumerKey(key, meta, group, consumer))
+	if err = wb.Commit(); err != nil {
+		return 0, err
+	}
+	rds.notify(EventStream, "xgroup-delconsumer", key)
+	return int64(len(pending)), nil
+}
+
+// iteratePending 按ID顺序遍历消费者组的待确认消息，fn返回false时停止
+func (rds *RedisDataStructure) iteratePending(key []byte, meta *metaData, group []byte, fn func(id StreamID, pv *pendingValue) bool) error {
+	prefix := streamGroupPrefix(key, meta, streamPendingTag, group)
+	return rds.iteratePrefix(prefix, func(suffix []byte, getValue func() ([]byte, error)) (bool, error) {
+		value, err := getValue()
+		if err != nil {
+			return false, err
+		}
+		return fn(decodeStreamID(suffix), decodePendingValue(value)), nil
+	})
+}
+
+// XReadGroup
+//
+//	@Description: 以消费者组中某个消费者的身份读取消息，消费者不存在时自动创建
+//	@receiver rds
+//	@param group
+//	@param consumer
+//	@param key
+//	@param id  > 表示读取组内从未投递过的消息并加入待确认列表，否则返回该消费者ID大于id的待确认消息
+//	@param count  小于等于0时不限制数量
+//	@param noAck  读取新消息时不加入待确认列表
+//	@return []*StreamEntry  待确认列表中已被删除的消息Fields为nil
+//	@return error
+func (rds *RedisDataStructure) XReadGroup(group, consumer, key, id []byte, count int64, noAck bool) ([]*StreamEntry, error) {
+	meta, exist, err := rds.findStream(key)
+	if err != nil {
+		return nil, err
+	}
+	g, err := rds.findGroup(key, meta, exist, group)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	consumerKey := streamConsumerKey(key, meta, group, consumer)
+	if string(id) != ">" {
+		start, err := ParseStreamID(id, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err = rds.db.Put(consumerKey, binary.AppendVarint(nil, now)); err != nil {
+			return nil, err
+		}
+		return rds.consumerHistory(key, meta, group, consumer, start, count)
+	}
+
+	result := make([]*StreamEntry, 0)
+	start, ok := g.lastDelivered.Next()
+	if ok && meta.size > 0 {
+		err = rds.iterateEntries(key, meta, start, MaxStreamID, false, func(entry *StreamEntry) bool {
+			result = append(result, entry)
+			return count <= 0 || int64(len(result)) < count
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	wb := rds.newWriteBatch(len(result) + 2)
+	_ = wb.Put(consumerKey, binary.AppendVarint(nil, now))
+	if len(result) > 0 {
+		_ = wb.Put(streamGroupKey(key, meta, group), result[len(result)-1].ID.encode())
+	}
+	if !noAck {
+		for _, entry := range result {
+			pv := &pendingValue{deliveryTime: now, deliveries: 1, consumer: consumer}
+			_ = wb.Put(streamPendingKey(key, meta, group, entry.ID), pv.encode())
+		}
+	}
+	if err = wb.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// consumerHistory 返回消费者ID大于等于start的待确认消息
+func (rds *RedisDataStructure) consumerHistory(key []byte, meta *metaData, group, consumer []byte, start StreamID, count int64) ([]*StreamEntry, error) {
+	var ids []StreamID
+	err := rds.iteratePending(key, meta, group, func(id StreamID, pv *pendingValue) bool {
+		if id.Compare(start) < 0 || !bytes.Equal(pv.consumer, consumer) {
+			return true
+		}
+		ids = append(ids, id)
+		return count <= 0 || int64(len(ids)) < count
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*StreamEntry, 0, len(ids))
+	for _, id := range ids {
+		entry := &StreamEntry{ID: id}
+		value, err := rds.db.Get(streamEntryKey(key, meta, id))
+		if err != nil && err != constant.ErrNotExist {
+			return nil, err
+		}
+		if err == nil {
+			entry.Fields = decodeStreamFields(value)
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+// XAck 确认消息，将其从消费者组的待确认列表中移除，返回确认的数量
+func (rds *RedisDataStructure) XAck(key, group []byte, ids ...StreamID) (int64, error) {
+	meta, exist, err := rds.findStream(key)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = rds.findGroup(key, meta, exist, group); err == constant.ErrNoGroup {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	wb := rds.newWriteBatch(len(ids))
+	acked := make(map[StreamID]struct{}, len(ids))
+	for _, id := range ids {
+		if _, ok := acked[id]; ok {
+			continue
+		}
+		pendingKey := streamPendingKey(key, meta, group, id)
+		if _, err = rds.db.Get(pendingKey); err == constant.ErrNotExist {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		_ = wb.Delete(pendingKey)
+		acked[id] = struct{}{}
+	}
+	if len(acked) == 0 {
+		return 0, nil
+	}
+	if err = wb.Commit(); err != nil {
+		return 0, err
+	}
+	return int64(len(acked)), nil
+}
+
+// XPending 返回消费者组待确认消息的汇总信息，消费者按名称排序
+func (rds *RedisDataStructure) XPending(key, group []byte) (*PendingSummary, error) {
+	meta, exist, err := rds.findStream(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = rds.findGroup(key, meta, exist, group); err != nil {
+		return nil, err
+	}
+
+	summary := &PendingSummary{Consumers: make([]*PendingConsumer, 0)}
+	counts := make(map[string]int64)
+	err = rds.iteratePending(key, meta, group, func(id StreamID, pv *pendingValue) bool {
+		if summary.Count == 0 {
+			summary.Min = id
+		}
+		summary.Max = id
+		summary.Count++
+		counts[string(pv.consumer)]++
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 消费者按名称顺序存储，与counts对照即可得到有序的结果
+	prefix := streamGroupPrefix(key, meta, streamConsumerTag, group)
+	err = rds.iteratePrefix(prefix, func(suffix []byte, _ func() ([]byte, error)) (bool, error) {
+		if n, ok := counts[string(suffix)]; ok {
+			summary.Consumers = append(summary.Consumers, &PendingConsumer{Name: suffix, Count: n})
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// XPendingRange
+//
+//	@Description: 返回ID在[start, end]之间的待确认消息
+//	@receiver rds
+//	@param key
+//	@param group
+//	@param start
+//	@param end
+//	@param count  小于等于0时不限制数量
+//	@param consumer  为nil时返回所有消费者的消息
+//	@param minIdle  只返回空闲时间不小于该值的消息
+//	@return []*PendingEntry
+//	@return error
+func (rds *RedisDataStructure) XPendingRange(key, group []byte, start, end StreamID, count int64, consumer []byte,
+	minIdle time.Duration) ([]*PendingEntry, error) {
+	meta, exist, err := rds.findStream(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = rds.findGroup(key, meta, exist, group); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	result := make([]*PendingEntry, 0)
+	err = rds.iteratePending(key, meta, group, func(id StreamID, pv *pendingValue) bool {
+		if id.Compare(start) < 0 {
+			return true
+		}
+		if id.Compare(end) > 0 {
+			return false
+		}
+		idle := time.Duration(now-pv.deliveryTime) * time.Millisecond
+		if (consumer != nil && !bytes.Equal(pv.consumer, consumer)) || idle < minIdle {
+			return true
+		}
+		result = append(result, &PendingEntry{ID: id, Consumer: pv.consumer, Idle: idle, Deliveries: pv.deliveries})
+		return count <= 0 || int64(len(result)) < count
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
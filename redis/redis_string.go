@@ -2,113 +2,550 @@ package redis
 
 import (
 	"encoding/binary"
-	"fmt"
 	"kv-db-lab/constant"
+	"kv-db-lab/pkg"
+	"kv-db-lab/storage"
+	"math"
+	"strconv"
 	"time"
 )
 
 //============================String============================
 
-// Set
-
 // value格式： type | expireTime | value
-
 //
-
 //	1byte    0~64byte  len(value)
 
-func (rds *RedisDataStructure) Set(key []byte, value []byte, ttl time.Duration) error {
+// maxStringSize 与redis一致，string最大为512MB
+const maxStringSize = 512 * 1024 * 1024
 
-	if value == nil {
+// KeyValue MSet等批量操作中的一个key及其value
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
 
-		return nil
+// encodeString 编码string的value：type | expire | payload
+func encodeString(value []byte, expire int64) []byte {
+	buf := make([]byte, 1+binary.MaxVarintLen64+len(value))
+	buf[0] = String
+	var index = 1
+	index += binary.PutVarint(buf[index:], expire)
+	copy(buf[index:], value)
+	return buf[:index+len(value)]
+}
 
-	}
+// decodeString 解码string的value，返回payload与过期时间
+func decodeString(encValue []byte) ([]byte, int64) {
+	var index = 1
+	expire, n := binary.Varint(encValue[index:])
+	index += n
+	return encValue[index:], expire
+}
 
-	// 编码value：type+expire+payload  int64+1 ===》 expiration+type
+// decodeLiveString
+//
+//	@Description: 解析key当前的值，不存在或已过期时exist为false
+//	@param encValue  从引擎中读到的值，不存在时为nil
+//	@return value
+//	@return expire
+//	@return exist
+//	@return error  存在的其他类型的key返回ErrWrongTypeOp
+func decodeLiveString(encValue []byte) ([]byte, int64, bool, error) {
+	// 与其他命令一致，已过期或成员为空的其他类型视为不存在
+	typ, expire, exist := decodeKeyInfo(encValue)
+	if !exist {
+		return nil, 0, false, nil
+	}
+	if typ != String {
+		return nil, 0, false, constant.ErrWrongTypeOp
+	}
+	value, _ := decodeString(encValue)
+	return value, expire, true, nil
+}
 
-	buf := make([]byte, binary.MaxVarintLen64+1)
+// getString 读取string的值，不存在或已过期时exist为false
+func (rds *RedisDataStructure) getString(key []byte) ([]byte, bool, error) {
+	encValue, err := rds.db.Get(key)
+	if err != nil && err != constant.ErrNotExist {
+		return nil, false, err
+	}
+	value, _, exist, err := decodeLiveString(encValue)
+	return value, exist, err
+}
 
-	buf[0] = String
+// updateString
+//
+//	@Description: 原子地读-改-写一个string
+//	@receiver rds
+//	@param key
+//	@param event  写入后通知的事件名，删除key时通知del
+//	@param fn  入参为当前的值与过期时间(不存在时exist为false)，返回新的值与过期时间；
+//	返回nil时删除key，返回errUnchanged时不写入
+//	@return error
+func (rds *RedisDataStructure) updateString(key []byte, event string, fn func(value []byte, expire int64, exist bool) ([]byte, int64, error)) error {
+	var deleted bool
+	// 覆盖已过期的非string类型时，其数据部分在同一批次内登记回收
+	err := rds.updateWithGC(key, func(old []byte) ([]byte, error) {
+		value, expire, exist, err := decodeLiveString(old)
+		if err != nil {
+			return nil, err
+		}
+		deleted = false
+		newValue, newExpire, err := fn(value, expire, exist)
+		if err != nil {
+			return nil, err
+		}
+		if newValue == nil {
+			deleted = true
+			return nil, nil
+		}
+		return encodeString(newValue, newExpire), nil
+	}, nil)
+	if err == errUnchanged {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if deleted {
+		rds.notify(EventGeneric, "del", key)
+	} else {
+		rds.notify(EventString, event, key)
+	}
+	return nil
+}
 
-	var index = 1
+// Set 写入string，ttl为0时不过期
+func (rds *RedisDataStructure) Set(key []byte, value []byte, ttl time.Duration) error {
+	if value == nil {
+		return nil
+	}
 
 	// 编码过期时间写入byte
-
 	var expireTime int64 = 0
-
 	if ttl != 0 {
-
 		expireTime = time.Now().Add(ttl).UnixNano()
-		// 过期事件添入timeWheel
-		rds.db.TimeWheel.AddTask("expire key:"+string(key), func() {
-			err := rds.db.Delete(key)
-			if err != nil {
-				panic("delete key failed,key:" + string(key))
-			}
-		}, time.Now().Add(ttl))
 	}
 
-	index += binary.PutVarint(buf[index:], expireTime)
-
-	encValue := make([]byte, index+len(value))
+	// 覆盖非string类型时，原有的数据部分与写入在同一批次内登记回收
+	old, err := rds.db.Get(key)
+	if err != nil && err != constant.ErrNotExist {
+		return err
+	}
+	if expireTime == 0 && staleDataPrefix(key, old) == nil {
+		// 调用存储接口进行写入
+		if err = rds.db.Put(key, encodeString(value, expireTime)); err != nil {
+			return err
+		}
+		rds.notify(EventString, "set", key)
+		return nil
+	}
 
-	copy(encValue[:index], buf[:index])
+	// 过期索引与数据在同一批次内写入
+	wb := rds.newWriteBatch(3)
+	_ = wb.Put(key, encodeString(value, expireTime))
+	rds.addExpireEntry(wb, key, expireTime)
+	rds.addGCTask(wb, key, old)
+	if err = wb.Commit(); err != nil {
+		return err
+	}
+	rds.notifyGC()
+	rds.notifySet(key, expireTime)
+	return nil
+}
 
-	copy(encValue[index:], value)
+// notifySet 通知写入string，与redis一致设置了过期时间时同时通知expire
+func (rds *RedisDataStructure) notifySet(key []byte, expire int64) {
+	rds.notify(EventString, "set", key)
+	if expire != 0 {
+		rds.notify(EventGeneric, "expire", key)
+	}
+}
 
-	// 调用存储接口进行写入
+// SetCondition SET的写入条件
+type SetCondition byte
 
-	return rds.db.Put(key, encValue)
+const (
+	SetAlways SetCondition = iota
+	// SetIfNotExist 对应NX
+	SetIfNotExist
+	// SetIfExist 对应XX
+	SetIfExist
+)
 
+// SetOptions
+//
+//	@Description: SET命令的可选参数
+type SetOptions struct {
+	Condition SetCondition
+	// ExpireAt 过期的时间点，单位纳秒，为0时不过期
+	ExpireAt int64
+	// KeepTTL 保留原有的过期时间，优先于ExpireAt
+	KeepTTL bool
+	// Get 需要返回旧的值，此时key为其他类型时返回ErrWrongTypeOp
+	Get bool
 }
 
-func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
-
-	encValue, err := rds.db.Get(key)
+// SetWithOptions
+//
+//	@Description: 按SET命令的语义写入string
+//	@receiver rds
+//	@param key
+//	@param value
+//	@param opts
+//	@return []byte  旧的值，不存在或为其他类型时为nil
+//	@return bool  是否写入，不满足NX或XX时为false
+//	@return error
+func (rds *RedisDataStructure) SetWithOptions(key, value []byte, opts *SetOptions) ([]byte, bool, error) {
+	// 条件的检查与写入在同一批次内完成，过期索引以及被覆盖的数据部分的回收任务一同提交
+	var current []byte
+	var expireTime int64
+	err := rds.updateWithGC(key, func(old []byte) ([]byte, error) {
+		oldValue, expire, exist, err := decodeLiveString(old)
+		current = oldValue
+		if err == constant.ErrWrongTypeOp {
+			if opts.Get {
+				return nil, err
+			}
+			// 未过期的其他类型同样视为已存在
+			exist = true
+		} else if err != nil {
+			return nil, err
+		}
 
+		if (opts.Condition == SetIfNotExist && exist) || (opts.Condition == SetIfExist && !exist) {
+			return nil, errUnchanged
+		}
+		expireTime = opts.ExpireAt
+		if opts.KeepTTL {
+			expireTime = expire
+		}
+		return encodeString(value, expireTime), nil
+	}, func(wb *storage.WriteBatch) {
+		// KEEPTTL保留的过期时间已有索引
+		if !opts.KeepTTL {
+			rds.addExpireEntry(wb, key, expireTime)
+		}
+	})
+	if err == errUnchanged {
+		return current, false, nil
+	}
 	if err != nil {
+		return nil, false, err
+	}
+	if opts.KeepTTL {
+		rds.notify(EventString, "set", key)
+	} else {
+		rds.notifySet(key, expireTime)
+	}
+	return current, true, nil
+}
 
+// Get 读取string，不存在或已过期时返回ErrNotExist，读取不写入任何数据，过期的key由后台协程删除
+func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
+	value, exist, err := rds.getString(key)
+	if err != nil {
 		return nil, err
+	}
+	if !exist {
+		return nil, constant.ErrNotExist
+	}
+	return value, nil
+}
 
+// SetNX key不存在时才写入，返回是否写入
+func (rds *RedisDataStructure) SetNX(key, value []byte) (bool, error) {
+	var ok bool
+	err := rds.updateString(key, "set", func(_ []byte, _ int64, exist bool) ([]byte, int64, error) {
+		if exist {
+			return nil, 0, errUnchanged
+		}
+		ok = true
+		return value, 0, nil
+	})
+	if err == constant.ErrWrongTypeOp {
+		// 其他类型的key同样视为已存在
+		return false, nil
 	}
+	return ok && err == nil, err
+}
 
-	// 对encValue进行解码
+// SetEX 写入string并设置以秒为单位的过期时间
+func (rds *RedisDataStructure) SetEX(key, value []byte, seconds int64) error {
+	if seconds <= 0 || seconds > math.MaxInt64/int64(time.Second) {
+		return constant.ErrInvalidExpire
+	}
+	return rds.Set(key, value, time.Duration(seconds)*time.Second)
+}
 
-	dataType := encValue[0]
+// PSetEX 写入string并设置以毫秒为单位的过期时间
+func (rds *RedisDataStructure) PSetEX(key, value []byte, milliseconds int64) error {
+	if milliseconds <= 0 || milliseconds > math.MaxInt64/int64(time.Millisecond) {
+		return constant.ErrInvalidExpire
+	}
+	return rds.Set(key, value, time.Duration(milliseconds)*time.Millisecond)
+}
 
-	fmt.Println(key)
+// GetSet 写入新的值并返回旧的值，key不存在时返回nil，新的值不再过期
+func (rds *RedisDataStructure) GetSet(key, value []byte) ([]byte, error) {
+	var old []byte
+	err := rds.updateString(key, "set", func(current []byte, _ int64, exist bool) ([]byte, int64, error) {
+		if exist {
+			old = current
+		}
+		return value, 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return old, nil
+}
 
-	if dataType != String {
+// GetDel 删除key并返回其值，key不存在时返回nil
+func (rds *RedisDataStructure) GetDel(key []byte) ([]byte, error) {
+	var old []byte
+	err := rds.updateString(key, "del", func(current []byte, _ int64, exist bool) ([]byte, int64, error) {
+		if !exist {
+			return nil, 0, errUnchanged
+		}
+		old = current
+		return nil, 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return old, nil
+}
 
-		return nil, constant.ErrWrongTypeOp
+// MSet 在同一批次内写入多个key
+func (rds *RedisDataStructure) MSet(keyValues []*KeyValue) error {
+	wb := rds.newWriteBatch(len(keyValues) * 2)
+	for _, kv := range keyValues {
+		old, err := rds.db.Get(kv.Key)
+		if err != nil && err != constant.ErrNotExist {
+			return err
+		}
+		if err = wb.Put(kv.Key, encodeString(kv.Value, 0)); err != nil {
+			return err
+		}
+		rds.addGCTask(wb, kv.Key, old)
+	}
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+	rds.notifyGC()
+	for _, kv := range keyValues {
+		rds.notify(EventString, "set", kv.Key)
+	}
+	return nil
+}
 
+// MGet 返回多个key的值，不存在或不是string的key对应nil
+func (rds *RedisDataStructure) MGet(keys ...[]byte) ([][]byte, error) {
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, _, err := rds.getString(key)
+		if err != nil && err != constant.ErrWrongTypeOp {
+			return nil, err
+		}
+		result[i] = value
 	}
+	return result, nil
+}
 
-	var index = 1
+// MSetNX
+//
+//	@Description: 所有key都不存在时才在同一批次内写入，批次以读到的各key的值作为提交条件，
+//	提交前有key被修改时重新检查
+//	@receiver rds
+//	@param keyValues
+//	@return bool  是否写入
+//	@return error
+func (rds *RedisDataStructure) MSetNX(keyValues []*KeyValue) (bool, error) {
+	for {
+		wb := rds.newWriteBatch(len(keyValues) * 2)
+		for _, kv := range keyValues {
+			old, err := rds.db.Get(kv.Key)
+			if err != nil && err != constant.ErrNotExist {
+				return false, err
+			}
+			_, _, exist, err := decodeLiveString(old)
+			if err == constant.ErrWrongTypeOp || exist {
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			if err = wb.Expect(kv.Key, old); err != nil {
+				return false, err
+			}
+			_ = wb.Put(kv.Key, encodeString(kv.Value, 0))
+			rds.addGCTask(wb, kv.Key, old)
+		}
 
-	expireTime, n := binary.Varint(encValue[index:])
+		err := wb.Commit()
+		if err == constant.ErrConditionFailed {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		rds.notifyGC()
+		for _, kv := range keyValues {
+			rds.notify(EventString, "set", kv.Key)
+		}
+		return true, nil
+	}
+}
 
-	index += n
+func (rds *RedisDataStructure) Append(key, value []byte) (int, error) {
+	var length int
+	err := rds.updateString(key, "append", func(current []byte, expire int64, _ bool) ([]byte, int64, error) {
+		if len(current)+len(value) > maxStringSize {
+			return nil, 0, constant.ErrStringTooLong
+		}
+		newValue := make([]byte, 0, len(current)+len(value))
+		newValue = append(append(newValue, current...), value...)
+		length = len(newValue)
+		return newValue, expire, nil
+	})
+	return length, err
+}
 
-	// 若数据已过期
+// StrLen 返回值的长度，key不存在时为0
+func (rds *RedisDataStructure) StrLen(key []byte) (int, error) {
+	value, _, err := rds.getString(key)
+	return len(value), err
+}
 
-	if expireTime != 0 && expireTime < time.Now().UnixNano() {
+// GetRange 返回值在[start, end]之间的部分，负数表示从末尾倒数
+func (rds *RedisDataStructure) GetRange(key []byte, start, end int64) ([]byte, error) {
+	value, _, err := rds.getString(key)
+	if err != nil {
+		return nil, err
+	}
 
-		err := rds.db.Delete(key)
+	start, end, ok := stringRange(int64(len(value)), start, end)
+	if !ok {
+		return []byte{}, nil
+	}
+	return value[start : end+1], nil
+}
 
-		if err != nil {
+// stringRange 与redis一致处理[start, end]的负数下标与越界，区间为空时返回false
+func stringRange(length, start, end int64) (int64, int64, bool) {
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	if length == 0 || start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
 
-			return nil, err
+// SetRange 从offset开始覆盖写入value，不足的部分以0填充，返回修改后的长度
+func (rds *RedisDataStructure) SetRange(key []byte, offset int64, value []byte) (int, error) {
+	if offset < 0 {
+		return 0, constant.ErrIndexOutOfRange
+	}
+	if offset+int64(len(value)) > maxStringSize {
+		return 0, constant.ErrStringTooLong
+	}
 
+	var length int
+	err := rds.updateString(key, "setrange", func(current []byte, expire int64, exist bool) ([]byte, int64, error) {
+		length = len(current)
+		// 与redis一致，value为空时不修改也不创建key
+		if len(value) == 0 {
+			return nil, 0, errUnchanged
 		}
 
-		return nil, constant.ErrExpireTime
+		newValue := growString(current, int(offset)+len(value))
+		copy(newValue[offset:], value)
+		length = len(newValue)
+		return newValue, expire, nil
+	})
+	return length, err
+}
 
+// growString 复制一份value，长度不足size时以0填充
+func growString(value []byte, size int) []byte {
+	if size < len(value) {
+		size = len(value)
 	}
+	buf := make([]byte, size)
+	copy(buf, value)
+	return buf
+}
 
-	value := encValue[index:]
+// Incr 将值加1，返回新的值
+func (rds *RedisDataStructure) Incr(key []byte) (int64, error) {
+	return rds.IncrBy(key, 1)
+}
 
-	return value, nil
+// Decr 将值减1，返回新的值
+func (rds *RedisDataStructure) Decr(key []byte) (int64, error) {
+	return rds.IncrBy(key, -1)
+}
 
+// DecrBy 将值减去decrement，返回新的值
+func (rds *RedisDataStructure) DecrBy(key []byte, decrement int64) (int64, error) {
+	if decrement == math.MinInt64 {
+		return 0, constant.ErrIncrOverflow
+	}
+	return rds.IncrBy(key, -decrement)
+}
+
+// IncrBy 将值加上increment，key不存在时视为0，保留原有的过期时间，返回新的值
+func (rds *RedisDataStructure) IncrBy(key []byte, increment int64) (int64, error) {
+	var result int64
+	err := rds.updateString(key, "incrby", func(current []byte, expire int64, exist bool) ([]byte, int64, error) {
+		var v int64
+		if exist {
+			var err error
+			if v, err = strconv.ParseInt(string(current), 10, 64); err != nil {
+				return nil, 0, constant.ErrNotInteger
+			}
+		}
+		if (increment > 0 && v > math.MaxInt64-increment) || (increment < 0 && v < math.MinInt64-increment) {
+			return nil, 0, constant.ErrIncrOverflow
+		}
+		result = v + increment
+		return []byte(strconv.FormatInt(result, 10)), expire, nil
+	})
+	return result, err
+}
+
+// IncrByFloat 将值加上浮点数increment，key不存在时视为0，保留原有的过期时间，返回新的值
+func (rds *RedisDataStructure) IncrByFloat(key []byte, increment float64) (float64, error) {
+	var result float64
+	err := rds.updateString(key, "incrbyfloat", func(current []byte, expire int64, exist bool) ([]byte, int64, error) {
+		var v float64
+		if exist {
+			var err error
+			v, err = strconv.ParseFloat(string(current), 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, 0, constant.ErrNotFloat
+			}
+		}
+		result = v + increment
+		if math.IsNaN(result) || math.IsInf(result, 0) {
+			return nil, 0, constant.ErrIncrOverflow
+		}
+		return pkg.Float64ToBytes(result), expire, nil
+	})
+	return result, err
 }
@@ -8,65 +8,215 @@ import (
 )
 
 // ======================= ZSet 数据结构 =======================
-// 元数据格式：key =>|type| expire |version | size
-// 数据格式分两部分存储：1：(key)key |version | score | member |member size =>(value)NULL
-//                   2:(key)key | version | member => (value) score
+// 元数据格式：key =>|type| expire |version | size | encoding
+// 数据格式分两部分存储：1：(key)key |version | scoreTag | score | member =>(value)NULL
+//                   2:(key)key | version | memberTag | member => (value) score
+// score均为保序的二进制编码，第1部分可按score顺序遍历
 
-func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+// ZMember zset中的一个成员
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+// ScoreRange
+//
+//	@Description: zset的score区间，Exclusive为true时不包含对应的端点
+type ScoreRange struct {
+	Min          float64
+	Max          float64
+	MinExclusive bool
+	MaxExclusive bool
+}
+
+func (r *ScoreRange) aboveMin(score float64) bool {
+	if r.MinExclusive {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+func (r *ScoreRange) belowMax(score float64) bool {
+	if r.MaxExclusive {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+// findZsetMetaData 查找zset的元数据，旧编码的zset在此时迁移为保序编码
+func (rds *RedisDataStructure) findZsetMetaData(key []byte) (*metaData, error) {
 	meta, err := rds.findMetaData(key, Zset)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	if meta.encoding == zsetEncodingLegacy {
+		return rds.migrateZset(key, meta)
+	}
+	return meta, nil
+}
+
+// migrateZset
+//
+//	@Description: 将旧编码的zset迁移为保序编码，新数据写在新的版本号下，旧数据在同一批次内删除
+//	@receiver rds
+//	@param key
+//	@param meta
+//	@return *metaData
+//	@return error
+func (rds *RedisDataStructure) migrateZset(key []byte, meta *metaData) (*metaData, error) {
+	newMeta := *meta
+	newMeta.version = time.Now().UnixNano()
+	newMeta.encoding = zsetEncodingOrdered
+	newMeta.size = 0
+
+	// 旧格式的两部分数据都以key | version为前缀，其中member部分的value为score，score部分的value为空
+	legacy := &ZsetInternalKey{key: key, version: meta.version}
+	prefix := legacy.encodeLegacyWithMember()
+
+	var members []*ZMember
+	var legacyKeys [][]byte
+	iter := rds.db.NewIterate(&model.IteratorOptions{Prefix: prefix})
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		encKey := append([]byte{}, iter.Key()...)
+		legacyKeys = append(legacyKeys, encKey)
+
+		value, err := iter.Value()
+		if err != nil {
+			iter.Close()
+			return nil, err
+		}
+		if len(value) == 0 {
+			continue
+		}
+		members = append(members, &ZMember{Member: encKey[len(prefix):], Score: pkg.FloatFromBytes(value)})
+	}
+	iter.Close()
+
+	wb := rds.newWriteBatch(len(legacyKeys) + 2*len(members) + 1)
+	for _, encKey := range legacyKeys {
+		_ = wb.Delete(encKey)
+	}
+	for _, m := range members {
+		zk := &ZsetInternalKey{key: key, version: newMeta.version, member: m.Member, score: m.Score}
+		_ = wb.Put(zk.encodeWithMember(), pkg.Float64ToOrderedBytes(m.Score))
+		_ = wb.Put(zk.encodeWithScore(), nil)
+		newMeta.size++
+	}
+	_ = wb.Put(key, newMeta.encode())
+	if err := wb.Commit(); err != nil {
+		return nil, err
 	}
+	return &newMeta, nil
+}
 
-	// 构造数据部分的key
+// zsetMemberScore 查询成员的score，成员不存在时返回ErrNotExist
+func (rds *RedisDataStructure) zsetMemberScore(key []byte, meta *metaData, member []byte) (float64, error) {
 	zk := &ZsetInternalKey{
 		key:     key,
 		version: meta.version,
-		score:   score,
 		member:  member,
 	}
-
-	var exist = true
-	// 查看是否已经存在
 	value, err := rds.db.Get(zk.encodeWithMember())
-	if err != nil && err != constant.ErrNotExist {
-		return false, err
-	}
-	if err == constant.ErrNotExist {
-		exist = false
+	if err != nil {
+		return 0, err
 	}
-	if exist {
-		if score == pkg.FloatFromBytes(value) {
-			return false, nil
-		}
+	return pkg.FloatFromOrderedBytes(value), nil
+}
+
+// zsetPut 写入成员的score，exist标识成员原本是否存在，存在时需删除按旧score排序的数据
+func (rds *RedisDataStructure) zsetPut(key []byte, meta *metaData, member []byte, oldScore float64, exist bool, score float64, event string) error {
+	zk := &ZsetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+		score:   score,
 	}
 
 	// 更新元数据和数据
 	wb := rds.db.NewWriteBatch(model.DefaultWriteBatchOptions)
 	if !exist {
 		meta.size++
-		_ = wb.Put(key, meta.encode())
+		rds.putMeta(wb, key, meta)
 	}
 	if exist {
 		oldKey := &ZsetInternalKey{
 			key:     key,
 			version: meta.version,
 			member:  member,
-			score:   pkg.FloatFromBytes(value),
+			score:   oldScore,
 		}
 		_ = wb.Delete(oldKey.encodeWithScore())
 	}
-	_ = wb.Put(zk.encodeWithMember(), pkg.Float64ToBytes(score))
+	_ = wb.Put(zk.encodeWithMember(), pkg.Float64ToOrderedBytes(score))
 	_ = wb.Put(zk.encodeWithScore(), nil)
-	if err = wb.Commit(); err != nil {
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+	rds.notify(EventZset, event, key)
+	return nil
+}
+
+func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	meta, err := rds.findZsetMetaData(key)
+	if err != nil {
+		return false, err
+	}
+
+	var exist = true
+	// 查看是否已经存在
+	oldScore, err := rds.zsetMemberScore(key, meta, member)
+	if err != nil && err != constant.ErrNotExist {
 		return false, err
 	}
+	if err == constant.ErrNotExist {
+		exist = false
+	}
+	if exist && score == oldScore {
+		return false, nil
+	}
 
+	if err = rds.zsetPut(key, meta, member, oldScore, exist, score, "zadd"); err != nil {
+		return false, err
+	}
 	return !exist, nil
 }
 
+// ZIncrBy
+//
+//	@Description: 为成员的score加上increment，成员不存在时视为0
+//	@receiver rds
+//	@param key
+//	@param increment
+//	@param member
+//	@return float64  新的score
+//	@return error
+func (rds *RedisDataStructure) ZIncrBy(key []byte, increment float64, member []byte) (float64, error) {
+	meta, err := rds.findZsetMetaData(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var exist = true
+	oldScore, err := rds.zsetMemberScore(key, meta, member)
+	if err != nil && err != constant.ErrNotExist {
+		return 0, err
+	}
+	if err == constant.ErrNotExist {
+		exist = false
+	}
+
+	score := oldScore + increment
+	if exist && score == oldScore {
+		return score, nil
+	}
+	if err = rds.zsetPut(key, meta, member, oldScore, exist, score, "zincr"); err != nil {
+		return 0, err
+	}
+	return score, nil
+}
+
 func (rds *RedisDataStructure) ZScore(key []byte, member []byte) (float64, error) {
-	meta, err := rds.findMetaData(key, Zset)
+	meta, err := rds.findZsetMetaData(key)
 	if err != nil {
 		return -1, err
 	}
@@ -74,23 +224,15 @@ func (rds *RedisDataStructure) ZScore(key []byte, member []byte) (float64, error
 		return -1, nil
 	}
 
-	// 构造数据部分的key
-	zk := &ZsetInternalKey{
-		key:     key,
-		version: meta.version,
-		member:  member,
-	}
-
-	value, err := rds.db.Get(zk.encodeWithMember())
+	score, err := rds.zsetMemberScore(key, meta, member)
 	if err != nil {
 		return -1, err
 	}
-
-	return pkg.FloatFromBytes(value), nil
+	return score, nil
 }
 
 func (rds *RedisDataStructure) ZRem(key, member []byte) (bool, error) {
-	meta, err := rds.findMetaData(key, Zset)
+	meta, err := rds.findZsetMetaData(key)
 	if err != nil {
 		return false, err
 	}
@@ -98,34 +240,228 @@ func (rds *RedisDataStructure) ZRem(key, member []byte) (bool, error) {
 		return false, nil
 	}
 
-	// 构造Zset一个数据部分的 key
-	zk := &ZsetInternalKey{
-		key:     key,
-		version: time.Now().UnixNano(),
-		member:  member,
-		score:   0,
-	}
-
-	// 查看数据是否存在
-	if _, err = rds.db.Get(zk.encodeWithMember()); err == constant.ErrNotExist {
+	// 查看数据是否存在，存在时还需根据它的score删除另一部分数据
+	score, err := rds.zsetMemberScore(key, meta, member)
+	if err == constant.ErrNotExist {
 		return false, nil
 	}
-
-	// 如果数据存在则还需查询它的score删除另一部分数据
-	score, err := rds.ZScore(key, member)
 	if err != nil {
 		return false, err
 	}
-	zk.score = score
+
+	// 构造Zset一个数据部分的 key
+	zk := &ZsetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+		score:   score,
+	}
 
 	// 更新删除操作
 	wb := rds.db.NewWriteBatch(model.DefaultWriteBatchOptions)
 	meta.size--
-	_ = wb.Put(key, meta.encode())
+	rds.putMeta(wb, key, meta)
 	_ = wb.Delete(zk.encodeWithMember())
 	_ = wb.Delete(zk.encodeWithScore())
 	if err = wb.Commit(); err != nil {
 		return false, err
 	}
+	rds.notify(EventZset, "zrem", key)
+	rds.notifyEmptied(key, meta)
 	return true, nil
 }
+
+// ZRange 按score从小到大返回排名在[start, stop]之间的成员，负数表示从末尾倒数
+func (rds *RedisDataStructure) ZRange(key []byte, start, stop int64) ([]*ZMember, error) {
+	return rds.zrangeByRank(key, start, stop, false)
+}
+
+// ZRevRange 按score从大到小返回排名在[start, stop]之间的成员，负数表示从末尾倒数
+func (rds *RedisDataStructure) ZRevRange(key []byte, start, stop int64) ([]*ZMember, error) {
+	return rds.zrangeByRank(key, start, stop, true)
+}
+
+func (rds *RedisDataStructure) zrangeByRank(key []byte, start, stop int64, reverse bool) ([]*ZMember, error) {
+	meta, err := rds.findZsetMetaData(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// 与redis一致处理负数下标与越界
+	size := int64(meta.size)
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start > stop || start >= size {
+		return []*ZMember{}, nil
+	}
+
+	result := make([]*ZMember, 0, stop-start+1)
+	iter := rds.newZsetIterator(key, meta, reverse)
+	defer iter.Close()
+
+	var rank int64
+	for iter.Rewind(); iter.Valid() && rank <= stop; iter.Next() {
+		if rank >= start {
+			result = append(result, &ZMember{Member: iter.Member(), Score: iter.Score()})
+		}
+		rank++
+	}
+	return result, nil
+}
+
+// ZRangeByScore
+//
+//	@Description: 按score从小到大返回score在区间内的成员
+//	@receiver rds
+//	@param key
+//	@param rng
+//	@param offset  跳过的成员数量
+//	@param count  返回的最大数量，小于0时不限制
+//	@return []*ZMember
+//	@return error
+func (rds *RedisDataStructure) ZRangeByScore(key []byte, rng *ScoreRange, offset, count int64) ([]*ZMember, error) {
+	result := make([]*ZMember, 0)
+	err := rds.zscanByScore(key, rng, func(member []byte, score float64) bool {
+		if offset > 0 {
+			offset--
+			return true
+		}
+		if count >= 0 && int64(len(result)) >= count {
+			return false
+		}
+		result = append(result, &ZMember{Member: member, Score: score})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ZCount 返回score在区间内的成员数量
+func (rds *RedisDataStructure) ZCount(key []byte, rng *ScoreRange) (int64, error) {
+	var count int64
+	err := rds.zscanByScore(key, rng, func(member []byte, score float64) bool {
+		count++
+		return true
+	})
+	return count, err
+}
+
+// zscanByScore 从区间下界开始按score顺序遍历成员，直到超出区间上界或fn返回false
+func (rds *RedisDataStructure) zscanByScore(key []byte, rng *ScoreRange, fn func(member []byte, score float64) bool) error {
+	meta, err := rds.findZsetMetaData(key)
+	if err != nil {
+		return err
+	}
+	if meta.size == 0 || rng.Min > rng.Max {
+		return nil
+	}
+
+	iter := rds.newZsetIterator(key, meta, false)
+	defer iter.Close()
+
+	for iter.SeekScore(rng.Min); iter.Valid(); iter.Next() {
+		score := iter.Score()
+		if !rng.aboveMin(score) {
+			continue
+		}
+		if !rng.belowMax(score) {
+			break
+		}
+		if !fn(iter.Member(), score) {
+			break
+		}
+	}
+	return nil
+}
+
+// ZRank 返回成员按score从小到大的排名(从0开始)，成员不存在时返回ErrNotExist
+func (rds *RedisDataStructure) ZRank(key, member []byte) (int64, error) {
+	meta, err := rds.findZsetMetaData(key)
+	if err != nil {
+		return -1, err
+	}
+	score, err := rds.zsetMemberScore(key, meta, member)
+	if err != nil {
+		return -1, err
+	}
+
+	// 排名即区间(-inf, score)内的成员数量加上相同score中排在该成员之前的数量
+	iter := rds.newZsetIterator(key, meta, false)
+	defer iter.Close()
+
+	var rank int64
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		if iter.Score() == score && string(iter.Member()) == string(member) {
+			return rank, nil
+		}
+		rank++
+	}
+	return -1, constant.ErrNotExist
+}
+
+// ZPopMin 删除并返回score最小的count个成员
+func (rds *RedisDataStructure) ZPopMin(key []byte, count int64) ([]*ZMember, error) {
+	return rds.zpop(key, count, false)
+}
+
+// ZPopMax 删除并返回score最大的count个成员
+func (rds *RedisDataStructure) ZPopMax(key []byte, count int64) ([]*ZMember, error) {
+	return rds.zpop(key, count, true)
+}
+
+func (rds *RedisDataStructure) zpop(key []byte, count int64, max bool) ([]*ZMember, error) {
+	meta, err := rds.findZsetMetaData(key)
+	if err != nil {
+		return nil, err
+	}
+	if meta.size == 0 || count <= 0 {
+		return []*ZMember{}, nil
+	}
+	if count > int64(meta.size) {
+		count = int64(meta.size)
+	}
+
+	result := make([]*ZMember, 0, count)
+	iter := rds.newZsetIterator(key, meta, max)
+	for iter.Rewind(); iter.Valid() && int64(len(result)) < count; iter.Next() {
+		result = append(result, &ZMember{Member: iter.Member(), Score: iter.Score()})
+	}
+	iter.Close()
+
+	// 在同一批次内删除两部分数据并更新元数据
+	wb := rds.newWriteBatch(2*len(result) + 1)
+	for _, m := range result {
+		zk := &ZsetInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  m.Member,
+			score:   m.Score,
+		}
+		_ = wb.Delete(zk.encodeWithMember())
+		_ = wb.Delete(zk.encodeWithScore())
+	}
+	meta.size -= uint32(len(result))
+	rds.putMeta(wb, key, meta)
+	if err = wb.Commit(); err != nil {
+		return nil, err
+	}
+	if max {
+		rds.notify(EventZset, "zpopmax", key)
+	} else {
+		rds.notify(EventZset, "zpopmin", key)
+	}
+	rds.notifyEmptied(key, meta)
+	return result, nil
+}
@@ -0,0 +1,172 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"testing"
+)
+
+func saddAll(t *testing.T, rds *RedisDataStructure, key []byte, members ...string) {
+	for _, m := range members {
+		_, err := rds.SAdd(key, []byte(m))
+		assert.Nil(t, err)
+	}
+}
+
+func toStrings(members [][]byte) []string {
+	result := make([]string, 0, len(members))
+	for _, m := range members {
+		result = append(result, string(m))
+	}
+	return result
+}
+
+func TestRedisDataStructure_SMembers(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("set")
+	saddAll(t, rds, key, "c", "a", "b", "a")
+	saddAll(t, rds, []byte("set2"), "x")
+
+	members, err := rds.SMembers(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, toStrings(members))
+	card, err := rds.SCard(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), card)
+
+	members, err = rds.SRandMember(key, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(members))
+	members, err = rds.SRandMember(key, -5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(members))
+	members, err = rds.SRandMember(key, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(members))
+
+	popped, err := rds.SPop(key, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(popped))
+	for _, m := range popped {
+		ok, err := rds.SIsMember(key, m)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+	card, err = rds.SCard(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), card)
+}
+
+func TestRedisDataStructure_SMove(t *testing.T) {
+	rds := openTestRds(t)
+	src, dst := []byte("src"), []byte("dst")
+	saddAll(t, rds, src, "a", "b")
+	saddAll(t, rds, dst, "b")
+
+	ok, err := rds.SMove(src, dst, []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.SMove(src, dst, []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.SMove(src, dst, []byte("z"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	members, _ := rds.SMembers(src)
+	assert.Equal(t, []string{}, toStrings(members))
+	members, _ = rds.SMembers(dst)
+	assert.Equal(t, []string{"a", "b"}, toStrings(members))
+
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	_, err = rds.SMove(dst, []byte("hash"), []byte("a"))
+	assert.Equal(t, constant.ErrWrongTypeOp, err)
+}
+
+func TestRedisDataStructure_SetAlgebra(t *testing.T) {
+	rds := openTestRds(t)
+	k1, k2, k3 := []byte("s1"), []byte("s2"), []byte("s3")
+	saddAll(t, rds, k1, "a", "b", "c", "d")
+	saddAll(t, rds, k2, "c", "d", "e")
+	saddAll(t, rds, k3, "d", "f")
+
+	members, err := rds.SInter(k1, k2, k3)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"d"}, toStrings(members))
+	members, err = rds.SUnion(k1, k2, k3)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f"}, toStrings(members))
+	members, err = rds.SDiff(k1, k2, []byte("missing"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, toStrings(members))
+
+	dst := []byte("dst")
+	saddAll(t, rds, dst, "old")
+	n, err := rds.SUnionStore(dst, k2, k3)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(4), n)
+	members, _ = rds.SMembers(dst)
+	assert.Equal(t, []string{"c", "d", "e", "f"}, toStrings(members))
+
+	// destination作为参数之一
+	n, err = rds.SInterStore(dst, dst, k1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), n)
+	members, _ = rds.SMembers(dst)
+	assert.Equal(t, []string{"c", "d"}, toStrings(members))
+
+	// 结果为空时删除destination
+	n, err = rds.SDiffStore(dst, dst, k1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), n)
+	_, err = rds.db.Get(dst)
+	assert.Equal(t, constant.ErrNotExist, err)
+
+	// 覆盖其他类型的key
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	n, err = rds.SDiffStore([]byte("hash"), k1, k2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), n)
+	members, err = rds.SMembers([]byte("hash"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, toStrings(members))
+
+	_, err = rds.SInter([]byte("hash"), []byte("missing-hash-key"))
+	assert.Nil(t, err)
+	_, _ = rds.HSet([]byte("hash2"), []byte("f"), []byte("v"))
+	_, err = rds.SUnion(k1, []byte("hash2"))
+	assert.Equal(t, constant.ErrWrongTypeOp, err)
+}
+
+func TestRedisDataStructure_SScan(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("set")
+	for i := 0; i < 25; i++ {
+		_, err := rds.SAdd(key, []byte(fmt.Sprintf("member-%02d", i)))
+		assert.Nil(t, err)
+	}
+
+	seen := make(map[string]bool)
+	var cursor uint64
+	var rounds int
+	for {
+		members, next, err := rds.SScan(key, cursor, nil, 10)
+		assert.Nil(t, err)
+		for _, m := range members {
+			seen[string(m)] = true
+		}
+		rounds++
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, 25, len(seen))
+	assert.Equal(t, 3, rounds)
+
+	members, next, err := rds.SScan(key, 0, []byte("member-2?"), 100)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), next)
+	assert.Equal(t, 5, len(members))
+}
@@ -0,0 +1,215 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"testing"
+	"time"
+)
+
+func fieldsOf(kv ...string) [][]byte {
+	fields := make([][]byte, 0, len(kv))
+	for _, s := range kv {
+		fields = append(fields, []byte(s))
+	}
+	return fields
+}
+
+func entryIDs(entries []*StreamEntry) []StreamID {
+	ids := make([]StreamID, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestRedisDataStructure_XAdd_XRange(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("events")
+
+	id1, err := rds.XAdd(key, []byte("1-1"), fieldsOf("a", "1"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, StreamID{Ms: 1, Seq: 1}, id1)
+	id2, err := rds.XAdd(key, []byte("1-*"), fieldsOf("b", "2"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, StreamID{Ms: 1, Seq: 2}, id2)
+	id3, err := rds.XAdd(key, []byte("5"), fieldsOf("c", "3", "d", ""), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, StreamID{Ms: 5}, id3)
+	id4, err := rds.XAdd(key, []byte("*"), fieldsOf("e", "4"), nil)
+	assert.Nil(t, err)
+	assert.True(t, id4.Compare(id3) > 0)
+
+	_, err = rds.XAdd(key, []byte("5-0"), fieldsOf("x", "y"), nil)
+	assert.Equal(t, constant.ErrStreamIDTooSmall, err)
+	_, err = rds.XAdd(key, []byte("abc"), fieldsOf("x", "y"), nil)
+	assert.Equal(t, constant.ErrInvalidStreamID, err)
+	_, err = rds.XAdd(key, []byte("*"), fieldsOf("x"), nil)
+	assert.Equal(t, constant.ErrEmptyParam, err)
+	_, err = rds.XAdd([]byte("new"), []byte("0-0"), fieldsOf("x", "y"), nil)
+	assert.Equal(t, constant.ErrStreamIDTooSmall, err)
+	_, err = rds.XAdd([]byte("new"), []byte("*"), fieldsOf("x", "y"), &XAddOptions{NoMkStream: true})
+	assert.Equal(t, constant.ErrNotExist, err)
+
+	n, err := rds.XLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), n)
+	typ, err := rds.Type(key)
+	assert.Nil(t, err)
+	assert.Equal(t, Stream, typ)
+
+	entries, err := rds.XRange(key, MinStreamID, MaxStreamID, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []StreamID{id1, id2, id3, id4}, entryIDs(entries))
+	assert.Equal(t, fieldsOf("c", "3", "d", ""), entries[2].Fields)
+
+	// 省略seq时终点包含该毫秒内所有的消息
+	end, err := ParseStreamRangeID([]byte("1"), true)
+	assert.Nil(t, err)
+	entries, _ = rds.XRange(key, MinStreamID, end, -1)
+	assert.Equal(t, []StreamID{id1, id2}, entryIDs(entries))
+
+	start, err := ParseStreamRangeID([]byte("(1-1"), false)
+	assert.Nil(t, err)
+	entries, _ = rds.XRange(key, start, MaxStreamID, 2)
+	assert.Equal(t, []StreamID{id2, id3}, entryIDs(entries))
+
+	entries, err = rds.XRevRange(key, MaxStreamID, MinStreamID, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []StreamID{id4, id3, id2}, entryIDs(entries))
+	entries, _ = rds.XRevRange(key, StreamID{Ms: 4}, StreamID{Ms: 1, Seq: 2}, -1)
+	assert.Equal(t, []StreamID{id2}, entryIDs(entries))
+
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	_, err = rds.XAdd([]byte("hash"), []byte("*"), fieldsOf("x", "y"), nil)
+	assert.Equal(t, constant.ErrWrongTypeOp, err)
+}
+
+func TestRedisDataStructure_XDel_XTrim(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("events")
+	for i := 0; i < 10; i++ {
+		_, err := rds.XAdd(key, []byte("*"), fieldsOf("i", "v"), nil)
+		assert.Nil(t, err)
+	}
+	all, _ := rds.XRange(key, MinStreamID, MaxStreamID, -1)
+
+	n, err := rds.XDel(key, all[0].ID, all[0].ID, StreamID{Ms: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+
+	n, err = rds.XTrim(key, &StreamTrim{MaxLen: 5})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), n)
+	entries, _ := rds.XRange(key, MinStreamID, MaxStreamID, -1)
+	assert.Equal(t, entryIDs(all[5:]), entryIDs(entries))
+
+	n, err = rds.XTrim(key, &StreamTrim{MinID: &all[8].ID})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), n)
+
+	// 写入时按长度裁剪
+	_, err = rds.XAdd(key, []byte("*"), fieldsOf("i", "v"), &XAddOptions{Trim: &StreamTrim{MaxLen: 2, Limit: 10}})
+	assert.Nil(t, err)
+	length, _ := rds.XLen(key)
+	assert.Equal(t, int64(2), length)
+
+	// 删除全部消息后stream仍然存在，且ID继续递增
+	_, err = rds.XTrim(key, &StreamTrim{MaxLen: 0})
+	assert.Nil(t, err)
+	exists, _ := rds.Exists(key)
+	assert.Equal(t, 1, exists)
+	_, err = rds.XAdd(key, []byte(all[9].ID.String()), fieldsOf("i", "v"), nil)
+	assert.Equal(t, constant.ErrStreamIDTooSmall, err)
+}
+
+func TestRedisDataStructure_XGroup(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("jobs")
+
+	err := rds.XGroupCreate(key, []byte("g"), []byte("$"), false)
+	assert.Equal(t, constant.ErrNotExist, err)
+	assert.Nil(t, rds.XGroupCreate(key, []byte("g"), []byte("$"), true))
+	assert.Equal(t, constant.ErrGroupExists, rds.XGroupCreate(key, []byte("g"), []byte("0"), false))
+	length, err := rds.XLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), length)
+
+	id1, _ := rds.XAdd(key, []byte("*"), fieldsOf("job", "1"), nil)
+	id2, _ := rds.XAdd(key, []byte("*"), fieldsOf("job", "2"), nil)
+	id3, _ := rds.XAdd(key, []byte("*"), fieldsOf("job", "3"), nil)
+
+	entries, err := rds.XReadGroup([]byte("g"), []byte("alice"), key, []byte(">"), 2, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []StreamID{id1, id2}, entryIDs(entries))
+	entries, err = rds.XReadGroup([]byte("g"), []byte("bob"), key, []byte(">"), 0, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []StreamID{id3}, entryIDs(entries))
+	entries, _ = rds.XReadGroup([]byte("g"), []byte("bob"), key, []byte(">"), 0, false)
+	assert.Equal(t, 0, len(entries))
+
+	summary, err := rds.XPending(key, []byte("g"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), summary.Count)
+	assert.Equal(t, id1, summary.Min)
+	assert.Equal(t, id3, summary.Max)
+	assert.Equal(t, []*PendingConsumer{{Name: []byte("alice"), Count: 2}, {Name: []byte("bob"), Count: 1}}, summary.Consumers)
+
+	// 读取消费者自己的待确认消息，已删除的消息Fields为nil
+	_, _ = rds.XDel(key, id1)
+	entries, err = rds.XReadGroup([]byte("g"), []byte("alice"), key, []byte("0"), 0, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []StreamID{id1, id2}, entryIDs(entries))
+	assert.Nil(t, entries[0].Fields)
+	assert.Equal(t, fieldsOf("job", "2"), entries[1].Fields)
+
+	pending, err := rds.XPendingRange(key, []byte("g"), MinStreamID, MaxStreamID, 10, []byte("alice"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pending))
+	assert.Equal(t, int64(1), pending[0].Deliveries)
+	pending, _ = rds.XPendingRange(key, []byte("g"), MinStreamID, MaxStreamID, 10, nil, time.Hour)
+	assert.Equal(t, 0, len(pending))
+
+	n, err := rds.XAck(key, []byte("g"), id1, id2, id2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+	n, _ = rds.XAck(key, []byte("missing"), id3)
+	assert.Equal(t, int64(0), n)
+
+	// 重置投递位置后可以再次读取
+	assert.Nil(t, rds.XGroupSetID(key, []byte("g"), []byte("0")))
+	entries, _ = rds.XReadGroup([]byte("g"), []byte("carol"), key, []byte(">"), 0, true)
+	assert.Equal(t, []StreamID{id2, id3}, entryIDs(entries))
+	summary, _ = rds.XPending(key, []byte("g"))
+	assert.Equal(t, int64(1), summary.Count)
+
+	created, err := rds.XGroupCreateConsumer(key, []byte("g"), []byte("dave"))
+	assert.Nil(t, err)
+	assert.True(t, created)
+	created, _ = rds.XGroupCreateConsumer(key, []byte("g"), []byte("dave"))
+	assert.False(t, created)
+	deleted, err := rds.XGroupDelConsumer(key, []byte("g"), []byte("bob"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	ok, err := rds.XGroupDestroy(key, []byte("g"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = rds.XReadGroup([]byte("g"), []byte("alice"), key, []byte(">"), 0, false)
+	assert.Equal(t, constant.ErrNoGroup, err)
+	_, err = rds.XPending(key, []byte("g"))
+	assert.Equal(t, constant.ErrNoGroup, err)
+}
+
+func TestRedisDataStructure_StreamGC(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("events")
+	_, _ = rds.XAdd(key, []byte("*"), fieldsOf("a", "1"), nil)
+	assert.Nil(t, rds.XGroupCreate(key, []byte("g"), []byte("0"), false))
+	_, _ = rds.XReadGroup([]byte("g"), []byte("c"), key, []byte(">"), 0, false)
+
+	assert.Nil(t, rds.Del(key))
+	_, err := rds.collectGarbage()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(rds.engine.GetAllKeys()))
+}
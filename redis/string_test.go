@@ -0,0 +1,309 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRedisDataStructure_SetNX_GetSet_GetDel(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("str")
+
+	ok, err := rds.SetNX(key, []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.SetNX(key, []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	old, err := rds.GetSet(key, []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), old)
+	old, err = rds.GetSet([]byte("missing"), []byte("x"))
+	assert.Nil(t, err)
+	assert.Nil(t, old)
+
+	value, err := rds.GetDel(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), value)
+	_, err = rds.Get(key)
+	assert.Equal(t, constant.ErrNotExist, err)
+	value, err = rds.GetDel(key)
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+
+	// 过期的key视为不存在
+	assert.Nil(t, rds.PSetEX(key, []byte("v"), 1))
+	time.Sleep(5 * time.Millisecond)
+	ok, err = rds.SetNX(key, []byte("new"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, constant.ErrInvalidExpire, rds.SetEX(key, []byte("v"), 0))
+
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	_, err = rds.GetSet([]byte("hash"), []byte("v"))
+	assert.Equal(t, constant.ErrWrongTypeOp, err)
+}
+
+func TestRedisDataStructure_SetWithOptions(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("str")
+
+	// XX在key不存在时不写入
+	_, ok, err := rds.SetWithOptions(key, []byte("a"), &SetOptions{Condition: SetIfExist})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	expireAt := time.Now().Add(time.Hour).UnixNano()
+	old, ok, err := rds.SetWithOptions(key, []byte("a"), &SetOptions{Condition: SetIfNotExist, ExpireAt: expireAt, Get: true})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, old)
+	ttl, err := rds.TTL(key)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 3500)
+
+	// KEEPTTL保留原有的过期时间
+	old, ok, err = rds.SetWithOptions(key, []byte("b"), &SetOptions{KeepTTL: true, Get: true})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), old)
+	ttl, err = rds.TTL(key)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 3500)
+	_, _, err = rds.SetWithOptions(key, []byte("c"), &SetOptions{})
+	assert.Nil(t, err)
+	ttl, err = rds.TTL(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), ttl)
+
+	// 其他类型的key视为已存在，GET时返回类型错误
+	hashKey := []byte("hash")
+	_, _ = rds.HSet(hashKey, []byte("f"), []byte("v"))
+	_, ok, err = rds.SetWithOptions(hashKey, []byte("v"), &SetOptions{Condition: SetIfNotExist})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, _, err = rds.SetWithOptions(hashKey, []byte("v"), &SetOptions{Get: true})
+	assert.Equal(t, constant.ErrWrongTypeOp, err)
+	_, ok, err = rds.SetWithOptions(hashKey, []byte("v"), &SetOptions{Condition: SetIfExist})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	value, err := rds.Get(hashKey)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+}
+
+func TestRedisDataStructure_SetWithOptions_Concurrent(t *testing.T) {
+	rds := openTestRds(t)
+
+	// 并发的SET NX只有一个成功，且最终的值来自成功的那一个
+	for round := 0; round < 20; round++ {
+		key := []byte("lock" + strconv.Itoa(round))
+		start := make(chan struct{})
+		var succeeded int32
+		var winner atomic.Value
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(value []byte) {
+				defer wg.Done()
+				<-start
+				_, ok, err := rds.SetWithOptions(key, value, &SetOptions{Condition: SetIfNotExist})
+				assert.Nil(t, err)
+				if ok {
+					atomic.AddInt32(&succeeded, 1)
+					winner.Store(value)
+				}
+			}([]byte(strconv.Itoa(i)))
+		}
+		close(start)
+		wg.Wait()
+		assert.Equal(t, int32(1), succeeded)
+		value, err := rds.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, winner.Load(), value)
+	}
+}
+
+func TestRedisDataStructure_MSet_MGet(t *testing.T) {
+	rds := openTestRds(t)
+	assert.Nil(t, rds.MSet([]*KeyValue{
+		{Key: []byte("k1"), Value: []byte("v1")},
+		{Key: []byte("k2"), Value: []byte("v2")},
+	}))
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+
+	values, err := rds.MGet([]byte("k1"), []byte("missing"), []byte("hash"), []byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v1"), nil, nil, []byte("v2")}, values)
+
+	ok, err := rds.MSetNX([]*KeyValue{
+		{Key: []byte("k3"), Value: []byte("v3")},
+		{Key: []byte("k1"), Value: []byte("x")},
+	})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = rds.Get([]byte("k3"))
+	assert.Equal(t, constant.ErrNotExist, err)
+
+	ok, err = rds.MSetNX([]*KeyValue{
+		{Key: []byte("k3"), Value: []byte("v3")},
+		{Key: []byte("k4"), Value: []byte("v4")},
+	})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	values, _ = rds.MGet([]byte("k3"), []byte("k4"))
+	assert.Equal(t, [][]byte{[]byte("v3"), []byte("v4")}, values)
+}
+
+func TestRedisDataStructure_MSetNX_Concurrent(t *testing.T) {
+	rds := openTestRds(t)
+
+	// 并发的MSETNX对同一批key只有一个成功，且各key的值来自同一次写入
+	for round := 0; round < 20; round++ {
+		k1, k2 := []byte("a"+strconv.Itoa(round)), []byte("b"+strconv.Itoa(round))
+		start := make(chan struct{})
+		var succeeded int32
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(value []byte) {
+				defer wg.Done()
+				<-start
+				ok, err := rds.MSetNX([]*KeyValue{{Key: k1, Value: value}, {Key: k2, Value: value}})
+				assert.Nil(t, err)
+				if ok {
+					atomic.AddInt32(&succeeded, 1)
+				}
+			}([]byte(strconv.Itoa(i)))
+		}
+		close(start)
+		wg.Wait()
+		assert.Equal(t, int32(1), succeeded)
+		values, err := rds.MGet(k1, k2)
+		assert.Nil(t, err)
+		assert.Equal(t, values[0], values[1])
+	}
+}
+
+func TestRedisDataStructure_Append_Range(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("str")
+
+	length, err := rds.Append(key, []byte("Hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, length)
+	length, err = rds.Append(key, []byte(" World"))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, length)
+	length, err = rds.StrLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, length)
+
+	value, err := rds.GetRange(key, 0, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Hello"), value)
+	value, err = rds.GetRange(key, -5, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("World"), value)
+	value, err = rds.GetRange(key, 20, 30)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{}, value)
+
+	length, err = rds.SetRange(key, 6, []byte("Redis"))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, length)
+	value, _ = rds.Get(key)
+	assert.Equal(t, []byte("Hello Redis"), value)
+
+	length, err = rds.SetRange([]byte("pad"), 3, []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, length)
+	value, _ = rds.Get([]byte("pad"))
+	assert.Equal(t, []byte{0, 0, 0, 'x'}, value)
+
+	length, err = rds.SetRange([]byte("empty"), 3, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, length)
+	_, err = rds.Get([]byte("empty"))
+	assert.Equal(t, constant.ErrNotExist, err)
+}
+
+func TestRedisDataStructure_Incr(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("counter")
+
+	v, err := rds.Incr(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), v)
+	v, err = rds.IncrBy(key, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), v)
+	v, err = rds.Decr(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), v)
+	v, err = rds.DecrBy(key, 20)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-10), v)
+
+	f, err := rds.IncrByFloat(key, 0.25)
+	assert.Nil(t, err)
+	assert.Equal(t, -9.75, f)
+	_, err = rds.Incr(key)
+	assert.Equal(t, constant.ErrNotInteger, err)
+
+	assert.Nil(t, rds.Set([]byte("max"), []byte("9223372036854775807"), 0))
+	_, err = rds.Incr([]byte("max"))
+	assert.Equal(t, constant.ErrIncrOverflow, err)
+
+	// 自增保留原有的过期时间
+	assert.Nil(t, rds.SetEX([]byte("ttl"), []byte("1"), 100))
+	_, err = rds.Incr([]byte("ttl"))
+	assert.Nil(t, err)
+	encValue, err := rds.db.Get([]byte("ttl"))
+	assert.Nil(t, err)
+	value, expire := decodeString(encValue)
+	assert.Equal(t, []byte("2"), value)
+	assert.True(t, expire > time.Now().UnixNano())
+}
+
+func TestRedisDataStructure_StringBits(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("bits")
+	// 已存在的string按string修改
+	assert.Nil(t, rds.Set(key, []byte{0}, 0))
+
+	old, err := rds.SetBit(key, 7, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0), old)
+	old, err = rds.SetBit(key, 7, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(1), old)
+	_, _ = rds.SetBit(key, 1, 1)
+	_, _ = rds.SetBit(key, 17, 1)
+
+	value, _ := rds.Get(key)
+	assert.Equal(t, []byte{0x40, 0x00, 0x40}, value)
+
+	bit, err := rds.GetBit(key, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(1), bit)
+	bit, err = rds.GetBit(key, 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0), bit)
+
+	count, err := rds.BitCount(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	count, err = rds.BitCount(key, 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+
+	_, err = rds.SetBit(key, 0, 2)
+	assert.Equal(t, constant.ErrNotBit, err)
+}
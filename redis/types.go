@@ -1,8 +1,12 @@
 package redis
 
 import (
+	"errors"
 	"kv-db-lab/constant"
+	"kv-db-lab/model"
 	"kv-db-lab/storage"
+	"sync"
+	"sync/atomic"
 )
 
 type dataType = byte
@@ -13,22 +17,154 @@ const (
 	Hash
 	Set
 	Zset
+	Bitmap
+	HyperLogLog
+	Stream
 )
 
+// errUnchanged 读-改-写时无需写入的标识
+var errUnchanged = errors.New("value unchanged")
+
+// internalKeyPrefix redis层内部使用的keyspace前缀，遍历用户的key时跳过
+var internalKeyPrefix = []byte(constant.InternalKeyPrefix)
+
+// kvStore redis层读写数据的接口，由存储引擎或事务中外部传入的写批次实现
+type kvStore interface {
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	Update(key []byte, fn func(old []byte) ([]byte, error)) error
+	DeleteIfEquals(key, expected []byte) (bool, error)
+	NewIterate(opts *model.IteratorOptions) *storage.Iterate
+	NewWriteBatch(opts *model.WriteBatchOptions) *storage.WriteBatch
+}
+
 // RedisDataStructure
 //
 //	@Description: 对redis常用数据结构以及相应API进行实现
 type RedisDataStructure struct {
-	db *storage.Engine
+	engine *storage.Engine
+	db     kvStore // 通常为engine，WithBatch返回的视图中为外部传入的写批次
+	// viewNotifier WithBatch的视图中接收变更通知，为nil时使用共享的通知函数
+	viewNotifier Notifier
+
+	*background
+}
+
+// background 后台回收与主动过期的状态以及变更通知，WithBatch返回的视图与原实例共享
+type background struct {
+	expiredKeys      uint64 // 已删除的过期key数量
+	collectedEntries uint64 // 已回收的数据部分数量
+
+	notifier atomic.Value // Notifier，后台协程运行期间也可以修改
+
+	gcNotify  chan struct{} // 有新的待回收数据时通知后台协程
+	gcLock    sync.Mutex    // 同一时间只有一个回收过程
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// RedisStat
+//
+//	@Description: redis层的一些指标信息
+type RedisStat struct {
+	*model.EngineStat
+	ExpiredKeys      uint64 // 主动过期以及读取时删除的过期key数量
+	CollectedEntries uint64 // 后台回收的数据部分数量
 }
 
 func NewRedisDateStructure(db *storage.Engine) (*RedisDataStructure, error) {
 	if db == nil {
 		return nil, constant.ErrEmptyParam
 	}
-	return &RedisDataStructure{db: db}, nil
+	rds := &RedisDataStructure{
+		engine: db,
+		db:     db,
+		background: &background{
+			gcNotify: make(chan struct{}, 1),
+			closeCh:  make(chan struct{}),
+		},
+	}
+
+	// 后台回收已删除或被覆盖的数据部分，启动时先处理上次未完成的任务
+	rds.wg.Add(2)
+	go rds.gcLoop()
+	rds.notifyGC()
+	// 后台删除已过期的key
+	go rds.expireLoop()
+	return rds, nil
 }
 
+// Stat 返回存储引擎以及redis层的指标信息
+func (rds *RedisDataStructure) Stat() *RedisStat {
+	return &RedisStat{
+		EngineStat:       rds.engine.Stat(),
+		ExpiredKeys:      atomic.LoadUint64(&rds.expiredKeys),
+		CollectedEntries: atomic.LoadUint64(&rds.collectedEntries),
+	}
+}
+
+// Close 停止后台协程后关闭存储引擎
 func (rds *RedisDataStructure) Close() error {
-	return rds.db.Close()
+	rds.closeOnce.Do(func() {
+		close(rds.closeCh)
+	})
+	rds.wg.Wait()
+	return rds.engine.Close()
+}
+
+// Engine 返回底层的存储引擎
+func (rds *RedisDataStructure) Engine() *storage.Engine {
+	return rds.engine
+}
+
+// WithBatch
+//
+//	@Description: 返回一个视图，视图中所有命令的写入都暂存在wb中，读取时可以看到wb中尚未提交的写入，
+//	由调用方提交wb，从而使多个命令的写入原子生效。视图不能关闭，也不运行后台任务
+//	@receiver rds
+//	@param wb  由Engine()创建的写批次
+//	@param notifier  接收视图中的变更通知，调用方可以暂存到wb提交之后再发布，为nil时使用共享的通知函数
+//	@return *RedisDataStructure
+func (rds *RedisDataStructure) WithBatch(wb *storage.WriteBatch, notifier Notifier) *RedisDataStructure {
+	return &RedisDataStructure{
+		engine:       rds.engine,
+		db:           wb,
+		viewNotifier: notifier,
+		background:   rds.background,
+	}
+}
+
+// newWriteBatch 创建一个写批次，批次上限不小于本次预计写入的数量
+func (rds *RedisDataStructure) newWriteBatch(size int) *storage.WriteBatch {
+	opts := *model.DefaultWriteBatchOptions
+	if uint(size) > opts.MaxBatchSize {
+		opts.MaxBatchSize = uint(size)
+	}
+	return rds.db.NewWriteBatch(&opts)
+}
+
+// iteratePrefix
+//
+//	@Description: 按key的顺序遍历以prefix为前缀的数据
+//	@receiver rds
+//	@param prefix
+//	@param fn  参数为去掉前缀后的key，value在调用getValue时才从磁盘读取，返回false时停止遍历
+//	@return error
+func (rds *RedisDataStructure) iteratePrefix(prefix []byte, fn func(suffix []byte, getValue func() ([]byte, error)) (bool, error)) error {
+	iter := rds.db.NewIterate(&model.IteratorOptions{Prefix: prefix})
+	defer iter.Close()
+
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		suffix := append([]byte{}, iter.Key()[len(prefix):]...)
+		ok, err := fn(suffix, iter.Value)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+	}
+	return nil
 }
@@ -1,21 +1,74 @@
 package redis
 
 import (
-	"kv-db-lab/index"
+	"kv-db-lab/model"
+	"kv-db-lab/pkg"
+	"kv-db-lab/storage"
 )
 
-// 需求： 设计一个针对zset数据结构的迭代器，由于kvDB存在磁盘中的数据是无序的，但是存在btree索引的key是天然有序的，zset的数据存储有两部分
-// 数据格式分两部分存储：1：(key)key |version | score | member |member size =>(value)NULL
-//                   2:(key)key | version | member => (value) score
-// 因为天然有序，btree库支持遍历数据，因此遍历到的internalKey是有序的，score越大，internalKey越大
-// 与之前设计的迭代器有所去别的是我需要添加进迭代器时进行一个校验，是否满足key|version这样一个前缀，若满足还需判断key长度是否为数据格式1的数据，再取出member，score
+// zset的数据分两部分存储，其中按score排序的部分：(key)key | version | scoreTag | score | member =>(value)NULL
+// score为保序编码，存储索引中的key天然有序，因此以key | version | scoreTag为前缀遍历索引即可按(score, member)的顺序拿到所有成员
 
-// 通过迭代器实现ZRange ZPopmax ZPopmin
-
-// todo: 实现zset的迭代器去进行pop，range相关操作
+// zsetIterator
+//
+//	@Description: 在存储索引上按score顺序遍历一个zset的成员
 type zsetIterator struct {
-	index.Iterator
+	iter    *storage.Iterate
+	key     []byte
+	prefix  []byte // key | version | scoreTag
+	reverse bool
+}
+
+func (rds *RedisDataStructure) newZsetIterator(key []byte, meta *metaData, reverse bool) *zsetIterator {
+	zk := &ZsetInternalKey{key: key, version: meta.version}
+	prefix := zk.prefix(zsetScoreTag)
+	return &zsetIterator{
+		iter:    rds.db.NewIterate(&model.IteratorOptions{Prefix: prefix, Reverse: reverse}),
+		key:     key,
+		prefix:  prefix,
+		reverse: reverse,
+	}
+}
+
+// Rewind 回到第一个成员，逆序时为score最大的成员
+func (zi *zsetIterator) Rewind() {
+	zi.iter.Rewind()
+}
+
+// SeekScore 正序时定位到第一个score大于等于该值的成员，逆序时定位到第一个score小于等于该值的成员
+func (zi *zsetIterator) SeekScore(score float64) {
+	seekKey := append(append([]byte{}, zi.prefix...), pkg.Float64ToOrderedBytes(score)...)
+	if zi.reverse {
+		// 相同score的成员排在score编码之后，逆序时需从下一个score编码开始向前找
+		if end := pkg.PrefixEnd(seekKey); end != nil {
+			seekKey = end
+		} else {
+			zi.iter.Rewind()
+			return
+		}
+	}
+	zi.iter.Seek(seekKey)
+}
+
+func (zi *zsetIterator) Next() {
+	zi.iter.Next()
+}
+
+func (zi *zsetIterator) Valid() bool {
+	return zi.iter.Valid()
+}
+
+// Member 当前成员
+func (zi *zsetIterator) Member() []byte {
+	member := DecodeZsetWithScore(zi.iter.Key(), zi.key).member
+	return append([]byte{}, member...)
+}
+
+// Score 当前成员的score
+func (zi *zsetIterator) Score() float64 {
+	return DecodeZsetWithScore(zi.iter.Key(), zi.key).score
+}
 
-	// 用于过滤的前缀信息
-	suffix string
+func (zi *zsetIterator) Close() {
+	zi.iter.Close()
 }
@@ -0,0 +1,161 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"kv-db-lab/pkg"
+	"kv-db-lab/storage"
+	"math"
+	"os"
+	"testing"
+)
+
+func openTestRds(t *testing.T) *RedisDataStructure {
+	dir, err := os.MkdirTemp("", "bitcask-redis")
+	assert.Nil(t, err)
+
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	db, err := storage.OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	rds, err := NewRedisDateStructure(db)
+	assert.Nil(t, err)
+
+	t.Cleanup(func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return rds
+}
+
+func zmembers(members []*ZMember) []string {
+	result := make([]string, 0, len(members))
+	for _, m := range members {
+		result = append(result, string(m.Member))
+	}
+	return result
+}
+
+func TestRedisDataStructure_ZRange(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("zset")
+
+	for member, score := range map[string]float64{"a": -3, "b": 1.5, "c": 10, "d": 2, "e": 1.5} {
+		ok, err := rds.ZAdd(key, score, []byte(member))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+	// 更新score后旧的排序数据被删除
+	ok, err := rds.ZAdd(key, 100, []byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	members, err := rds.ZRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "e", "d", "c", "a"}, zmembers(members))
+	assert.Equal(t, float64(100), members[4].Score)
+
+	members, err = rds.ZRevRange(key, 0, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "c"}, zmembers(members))
+
+	members, err = rds.ZRange(key, -2, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c", "a"}, zmembers(members))
+
+	members, err = rds.ZRange(key, 3, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(members))
+
+	members, err = rds.ZRangeByScore(key, &ScoreRange{Min: 1.5, Max: 10, MaxExclusive: true}, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "e", "d"}, zmembers(members))
+
+	members, err = rds.ZRangeByScore(key, &ScoreRange{Min: 1.5, Max: math.Inf(1), MinExclusive: true}, 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c"}, zmembers(members))
+
+	count, err := rds.ZCount(key, &ScoreRange{Min: math.Inf(-1), Max: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), count)
+
+	rank, err := rds.ZRank(key, []byte("d"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), rank)
+	_, err = rds.ZRank(key, []byte("x"))
+	assert.Equal(t, constant.ErrNotExist, err)
+}
+
+func TestRedisDataStructure_ZPop_ZIncrBy(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("zset")
+
+	score, err := rds.ZIncrBy(key, 2.5, []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2.5, score)
+	score, err = rds.ZIncrBy(key, -5, []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, -2.5, score)
+	_, _ = rds.ZAdd(key, 1, []byte("b"))
+	_, _ = rds.ZAdd(key, 7, []byte("c"))
+	_, _ = rds.ZAdd(key, 3, []byte("d"))
+
+	members, err := rds.ZPopMin(key, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, zmembers(members))
+	assert.Equal(t, -2.5, members[0].Score)
+
+	members, err = rds.ZPopMax(key, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c", "d"}, zmembers(members))
+
+	members, err = rds.ZRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(members))
+	_, err = rds.ZScore(key, []byte("c"))
+	assert.Nil(t, err)
+
+	ok, err := rds.ZAdd(key, 1, []byte("e"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.ZRem(key, []byte("e"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	count, err := rds.ZCount(key, &ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestRedisDataStructure_ZsetMigrateLegacy(t *testing.T) {
+	rds := openTestRds(t)
+	key := []byte("legacy")
+
+	// 按旧的格式写入：score为十进制字符串，元数据没有编码字段
+	meta := &metaData{dataType: Zset, version: 1, size: 3}
+	for member, score := range map[string]float64{"a": 10, "b": 9, "c": -1} {
+		zk := &ZsetInternalKey{key: key, version: meta.version, member: []byte(member), score: score}
+		assert.Nil(t, rds.db.Put(zk.encodeLegacyWithMember(), pkg.Float64ToBytes(score)))
+		assert.Nil(t, rds.db.Put(zk.encodeLegacyWithScore(), nil))
+	}
+	encMeta := meta.encode()
+	assert.Nil(t, rds.db.Put(key, encMeta[:len(encMeta)-1]))
+
+	// 第一次访问时迁移
+	members, err := rds.ZRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c", "b", "a"}, zmembers(members))
+
+	metaBuf, err := rds.db.Get(key)
+	assert.Nil(t, err)
+	newMeta := decodeMetaData(metaBuf)
+	assert.Equal(t, zsetEncodingOrdered, newMeta.encoding)
+	assert.Equal(t, uint32(3), newMeta.size)
+
+	// 旧数据已删除，只剩元数据与新格式的数据
+	assert.Equal(t, 1+3*2, len(rds.engine.GetAllKeys()))
+
+	score, err := rds.ZScore(key, []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(9), score)
+}
@@ -1,24 +1,76 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"kv-db-lab/cluster"
 	"kv-db-lab/constant"
 	"kv-db-lab/model"
 	"kv-db-lab/storage"
 	"log"
 	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	addr    = flag.String("addr", "localhost:8080", "http服务监听地址")
+	dirPath = flag.String("dir", model.DefaultOptions.DirPath, "数据目录")
+
+	// 集群相关参数，node-id为空时以单机模式运行
+	nodeID    = flag.String("node-id", "", "集群节点ID")
+	raftAddr  = flag.String("raft-addr", model.DefaultClusterOptions.RaftAddr, "Raft通信地址")
+	raftDir   = flag.String("raft-dir", model.DefaultClusterOptions.RaftDir, "Raft日志与快照目录")
+	bootstrap = flag.Bool("bootstrap", false, "以单节点身份初始化集群")
+	join      = flag.String("join", "", "加入集群时任一节点的http服务地址")
 )
 
 var db *storage.Engine
 
-func init() {
-	// 初始化 DB 实例
-	var err error
-	options := model.DefaultOptions
-	db, err = storage.OpenWithOptions(options)
-	if err != nil {
-		panic(fmt.Sprintf("failed to open db: %v", err))
+// 集群模式下的raft节点，单机模式为nil
+var node *cluster.Node
+
+// joinRequest 节点加入集群的请求
+type joinRequest struct {
+	ID       string `json:"id"`
+	RaftAddr string `json:"raft_addr"`
+	HTTPAddr string `json:"http_addr"`
+}
+
+// routeToLeader
+//
+//	@Description: 集群模式下从节点将请求重定向到leader，leader在处理读请求前确认线性一致
+//	@param handler
+//	@param read  是否为读请求
+//	@return http.HandlerFunc
+func routeToLeader(handler http.HandlerFunc, read bool) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if node == nil {
+			handler(writer, request)
+			return
+		}
+
+		if !node.IsLeader() {
+			_, meta, err := node.Leader()
+			if err != nil || meta[constant.ClusterMetaHTTP] == "" {
+				http.Error(writer, constant.ErrNoLeader.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			// 307保证重定向后请求方法与body不变
+			target := url.URL{Scheme: "http", Host: meta[constant.ClusterMetaHTTP], Path: request.URL.Path, RawQuery: request.URL.RawQuery}
+			http.Redirect(writer, request, target.String(), http.StatusTemporaryRedirect)
+			return
+		}
+
+		if read {
+			if err := node.LinearizableRead(); err != nil {
+				http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		handler(writer, request)
 	}
 }
 
@@ -92,6 +144,10 @@ func handleListKeys(writer http.ResponseWriter, request *http.Request) {
 	writer.Header().Set("Content-Type", "application/json")
 	var result []string
 	for _, k := range keys {
+		// 与redis层一致跳过内部使用的key，如集群记录的raft applied index
+		if bytes.HasPrefix(k, []byte(constant.InternalKeyPrefix)) {
+			continue
+		}
 		result = append(result, string(k))
 	}
 	_ = json.NewEncoder(writer).Encode(result)
@@ -108,14 +164,93 @@ func handleStat(writer http.ResponseWriter, request *http.Request) {
 	_ = json.NewEncoder(writer).Encode(stat)
 }
 
+func handleJoin(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if node == nil {
+		http.Error(writer, "cluster mode disabled", http.StatusBadRequest)
+		return
+	}
+
+	var req joinRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := node.Join(req.ID, req.RaftAddr, map[string]string{constant.ClusterMetaHTTP: req.HTTPAddr}); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		log.Printf("failed to join node %s: %v\n", req.ID, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode("OK")
+}
+
+// joinCluster 启动时请求集群将本节点加入，请求发往从节点时会被重定向到leader
+func joinCluster(joinAddr string, req *joinRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post("http://"+joinAddr+"/cluster/join", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("join cluster failed, status: %s", resp.Status)
+	}
+	return nil
+}
+
 func main() {
+	flag.Parse()
+
+	// 初始化 DB 实例
+	var err error
+	// 复制一份默认配置，不修改包级的DefaultOptions
+	options := *model.DefaultOptions
+	options.DirPath = *dirPath
+	db, err = storage.OpenWithOptions(&options)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open db: %v", err))
+	}
+
+	// 集群模式：写操作经raft复制，从节点将请求重定向到leader
+	if *nodeID != "" {
+		clusterOpts := *model.DefaultClusterOptions
+		clusterOpts.NodeID = *nodeID
+		clusterOpts.RaftAddr = *raftAddr
+		clusterOpts.RaftDir = *raftDir
+		clusterOpts.Bootstrap = *bootstrap
+		clusterOpts.Meta = map[string]string{constant.ClusterMetaHTTP: *addr}
+		if node, err = cluster.NewNode(db, &clusterOpts); err != nil {
+			panic(fmt.Sprintf("failed to start cluster node: %v", err))
+		}
+		if *join != "" {
+			req := &joinRequest{ID: *nodeID, RaftAddr: node.RaftAddr(), HTTPAddr: *addr}
+			if err := joinCluster(*join, req); err != nil {
+				panic(fmt.Sprintf("failed to join cluster: %v", err))
+			}
+		}
+		if err := node.WaitLeader(10 * time.Second); err != nil {
+			log.Printf("cluster has no leader yet: %v\n", err)
+		}
+	}
+
 	// 注册处理方法
-	http.HandleFunc("/bitcask/put", handlePut)
-	http.HandleFunc("/bitcask/get", handleGet)
-	http.HandleFunc("/bitcask/delete", handleDelete)
-	http.HandleFunc("/bitcask/listkeys", handleListKeys)
+	http.HandleFunc("/bitcask/put", routeToLeader(handlePut, false))
+	http.HandleFunc("/bitcask/get", routeToLeader(handleGet, true))
+	http.HandleFunc("/bitcask/delete", routeToLeader(handleDelete, false))
+	http.HandleFunc("/bitcask/listkeys", routeToLeader(handleListKeys, true))
 	http.HandleFunc("/bitcask/stat", handleStat)
+	http.HandleFunc("/cluster/join", routeToLeader(handleJoin, false))
 
 	// 启动 HTTP 服务
-	_ = http.ListenAndServe("localhost:8080", nil)
+	_ = http.ListenAndServe(*addr, nil)
 }
@@ -0,0 +1,277 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/sirupsen/logrus"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"kv-db-lab/storage"
+	"net"
+	"sync"
+	"time"
+)
+
+// Follower
+//
+//	@Description: 从节点，从主节点拉取变更并以相同的语义应用到本地引擎，对外只提供读接口
+//	同步位置与每个事件在同一批次内写入本地引擎，崩溃重启后不会重复应用事件
+type Follower struct {
+	engine     *storage.Engine
+	leaderAddr string
+	options    *model.ReplicationOptions
+
+	lock *sync.RWMutex
+	pos  *model.LogRecordPos // 已应用到的主节点日志位置
+	conn net.Conn
+
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewFollower
+//
+//	@Description: 创建从节点并在后台连接主节点，断线后自动重连
+//	@param engine  从节点本地的引擎，不应再被其他写入方使用
+//	@param leaderAddr
+//	@param options
+//	@return *Follower
+//	@return error
+func NewFollower(engine *storage.Engine, leaderAddr string, options *model.ReplicationOptions) (*Follower, error) {
+	if engine == nil {
+		return nil, constant.ErrEmptyParam
+	}
+	if options == nil {
+		options = model.DefaultReplicationOptions
+	}
+
+	pos, err := loadFollowerPos(engine)
+	if err != nil {
+		return nil, err
+	}
+
+	follower := &Follower{
+		engine:     engine,
+		leaderAddr: leaderAddr,
+		options:    options,
+		lock:       new(sync.RWMutex),
+		pos:        pos,
+		closed:     make(chan struct{}),
+	}
+
+	follower.wg.Add(1)
+	go follower.run()
+	return follower, nil
+}
+
+// Position 已应用到的主节点日志位置
+func (f *Follower) Position() model.LogRecordPos {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return *f.pos
+}
+
+func (f *Follower) Get(key []byte) ([]byte, error) {
+	return f.engine.Get(key)
+}
+
+func (f *Follower) NewIterate(opts *model.IteratorOptions) *storage.Iterate {
+	return f.engine.NewIterate(opts)
+}
+
+func (f *Follower) Fold(fn func(key []byte, value []byte) bool) error {
+	return f.engine.Fold(fn)
+}
+
+func (f *Follower) Stat() *model.EngineStat {
+	return f.engine.Stat()
+}
+
+// Close 断开与主节点的连接，不会关闭本地引擎
+func (f *Follower) Close() error {
+	close(f.closed)
+
+	f.lock.Lock()
+	if f.conn != nil {
+		_ = f.conn.Close()
+	}
+	f.lock.Unlock()
+
+	f.wg.Wait()
+	return nil
+}
+
+func (f *Follower) run() {
+	defer f.wg.Done()
+
+	for {
+		if err := f.sync(); err != nil {
+			logrus.Warn("replication: sync from leader failed,err:", err.Error())
+		}
+
+		select {
+		case <-f.closed:
+			return
+		case <-time.After(f.options.RetryInterval):
+		}
+	}
+}
+
+// sync 建立一次连接并持续应用主节点推送的数据，直到连接断开
+func (f *Follower) sync() error {
+	conn, err := net.Dial("tcp", f.leaderAddr)
+	if err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	select {
+	case <-f.closed:
+		f.lock.Unlock()
+		return conn.Close()
+	default:
+	}
+	f.conn = conn
+	pos := f.pos
+	f.lock.Unlock()
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	if err := writeFrame(writer, msgSubscribe, encodePos(pos)); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		typ, payload, err := readFrame(reader, maxFrameSize)
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case msgSnapshotBegin:
+			if err := f.resetEngine(); err != nil {
+				return err
+			}
+		case msgSnapshotEntry:
+			key, value, err := decodeSnapshotEntry(payload)
+			if err != nil {
+				return err
+			}
+			if isInternalKey(key) {
+				continue
+			}
+			if err := f.engine.Put(key, value); err != nil {
+				return err
+			}
+		case msgSnapshotEnd:
+			pos, err := decodePos(payload)
+			if err != nil {
+				return err
+			}
+			if err := f.saveSnapshotPos(pos); err != nil {
+				return err
+			}
+			if err := f.advance(writer, pos); err != nil {
+				return err
+			}
+		case msgEvent:
+			event, err := decodeEvent(payload)
+			if err != nil {
+				return err
+			}
+			if err := f.applyEvent(event); err != nil {
+				return err
+			}
+			if err := f.advance(writer, event.Next); err != nil {
+				return err
+			}
+		default:
+			return errInvalidMessage
+		}
+	}
+}
+
+// applyEvent 以与主节点相同的语义应用变更，变更与同步位置作为一个批次原子提交
+func (f *Follower) applyEvent(event *model.ChangeEvent) error {
+	wb := f.engine.NewWriteBatch(&model.WriteBatchOptions{
+		SyncWrite:    event.TransID != constant.NoneTransactionID || f.engine.Options().SyncWrites,
+		MaxBatchSize: uint(len(event.Records)) + 1,
+	})
+	for _, record := range event.Records {
+		// 主节点自身的内部数据(如其同步位置)只属于主节点
+		if isInternalKey(record.Key) {
+			continue
+		}
+
+		var err error
+		switch record.Status {
+		case constant.LogRecordDelete:
+			err = wb.Delete(record.Key)
+		case constant.LogRecordMerge:
+			err = wb.Merge(record.Key, record.Value)
+		default:
+			err = wb.Put(record.Key, record.Value)
+		}
+		if err != nil && err != constant.ErrNotExist {
+			return err
+		}
+	}
+	if err := wb.Put([]byte(constant.ReplicationPosKey), encodePos(event.Next)); err != nil {
+		return err
+	}
+	return wb.Commit()
+}
+
+// resetEngine 接收快照前清空本地数据
+func (f *Follower) resetEngine() error {
+	for _, key := range f.engine.GetAllKeys() {
+		if err := f.engine.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveSnapshotPos 快照数据落盘后再持久化续读位置，崩溃在此之前时重启后重新接收快照
+func (f *Follower) saveSnapshotPos(pos *model.LogRecordPos) error {
+	if err := f.engine.Sync(); err != nil {
+		return err
+	}
+	wb := f.engine.NewWriteBatch(&model.WriteBatchOptions{SyncWrite: true, MaxBatchSize: 1})
+	if err := wb.Put([]byte(constant.ReplicationPosKey), encodePos(pos)); err != nil {
+		return err
+	}
+	return wb.Commit()
+}
+
+// advance 更新已持久化的同步位置，同时向主节点确认
+func (f *Follower) advance(writer *bufio.Writer, pos *model.LogRecordPos) error {
+	f.lock.Lock()
+	f.pos = pos
+	f.lock.Unlock()
+
+	if err := writeFrame(writer, msgAck, encodePos(pos)); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func loadFollowerPos(engine *storage.Engine) (*model.LogRecordPos, error) {
+	buf, err := engine.Get([]byte(constant.ReplicationPosKey))
+	if err == constant.ErrNotExist {
+		return &model.LogRecordPos{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodePos(buf)
+}
+
+func isInternalKey(key []byte) bool {
+	return bytes.HasPrefix(key, []byte(constant.InternalKeyPrefix))
+}
@@ -0,0 +1,275 @@
+package replication
+
+import (
+	"bufio"
+	"github.com/sirupsen/logrus"
+	"io"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"kv-db-lab/storage"
+	"net"
+	"sync"
+	"time"
+)
+
+// Leader
+//
+//	@Description: 主节点，监听TCP连接，将数据文件中的记录从从节点请求的位置开始推送给从节点
+type Leader struct {
+	engine   *storage.Engine
+	listener net.Listener
+	options  *model.ReplicationOptions
+
+	lock      *sync.Mutex
+	followers map[string]*model.LogRecordPos // 从节点地址 -> 已确认应用到的位置
+
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewLeader
+//
+//	@Description: 在addr上监听从节点的连接，如127.0.0.1:0
+//	@param engine
+//	@param addr
+//	@param options
+//	@return *Leader
+//	@return error
+func NewLeader(engine *storage.Engine, addr string, options *model.ReplicationOptions) (*Leader, error) {
+	if engine == nil {
+		return nil, constant.ErrEmptyParam
+	}
+	if options == nil {
+		options = model.DefaultReplicationOptions
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	leader := &Leader{
+		engine:    engine,
+		listener:  listener,
+		options:   options,
+		lock:      new(sync.Mutex),
+		followers: make(map[string]*model.LogRecordPos),
+		closed:    make(chan struct{}),
+	}
+
+	leader.wg.Add(1)
+	go leader.serve()
+	return leader, nil
+}
+
+// Addr 主节点实际监听的地址
+func (l *Leader) Addr() string {
+	return l.listener.Addr().String()
+}
+
+// Followers 返回当前连接的从节点及其已确认的位置
+func (l *Leader) Followers() map[string]model.LogRecordPos {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	followers := make(map[string]model.LogRecordPos, len(l.followers))
+	for addr, pos := range l.followers {
+		followers[addr] = *pos
+	}
+	return followers
+}
+
+// Close 停止监听并断开所有从节点
+func (l *Leader) Close() error {
+	close(l.closed)
+	err := l.listener.Close()
+	l.wg.Wait()
+	return err
+}
+
+func (l *Leader) serve() {
+	defer l.wg.Done()
+
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			select {
+			case <-l.closed:
+				return
+			default:
+			}
+			logrus.Error("replication: accept failed,err:", err.Error())
+			continue
+		}
+
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+			if err := l.handleFollower(conn); err != nil && err != io.EOF {
+				logrus.Warn("replication: follower ", conn.RemoteAddr().String(), " disconnected,err:", err.Error())
+			}
+		}()
+	}
+}
+
+// handleFollower 处理单个从节点：必要时发送快照，之后持续推送变更事件
+func (l *Leader) handleFollower(conn net.Conn) error {
+	name := conn.RemoteAddr().String()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	// 主节点关闭时断开连接
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-l.closed:
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+
+	typ, payload, err := readFrame(reader, maxAckFrameSize)
+	if err != nil {
+		return err
+	}
+	if typ != msgSubscribe {
+		return errInvalidMessage
+	}
+	pos, err := decodePos(payload)
+	if err != nil {
+		return err
+	}
+
+	// 先订阅再读取数据文件，保证不会漏掉通知
+	watcher := l.engine.Watch(nil)
+	defer func() {
+		watcher.Close()
+	}()
+
+	changes, err := l.engine.ChangesSince(pos.FileID, pos.Offset)
+	if err == constant.ErrPositionCompacted || (err == nil && l.lagTooFar(pos)) {
+		pos, err = l.sendSnapshot(writer)
+		if err != nil {
+			return err
+		}
+		changes, err = l.engine.ChangesSince(pos.FileID, pos.Offset)
+	}
+	if err != nil {
+		return err
+	}
+
+	l.trackFollower(name, pos)
+
+	// 接收从节点的确认位置
+	ackErr := make(chan error, 1)
+	ackDone := make(chan struct{})
+	go func() {
+		defer close(ackDone)
+		ackErr <- l.receiveAcks(name, reader)
+	}()
+	// 先关闭连接并等待接收确认的协程退出，之后不会再登记新的位置，再取消登记
+	defer func() {
+		_ = conn.Close()
+		<-ackDone
+		l.untrackFollower(name)
+	}()
+
+	ticker := time.NewTicker(l.options.PollInterval)
+	defer ticker.Stop()
+	for {
+		// 读出所有新的变更并推送
+		for {
+			event, err := changes.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := writeFrame(writer, msgEvent, encodeEvent(event)); err != nil {
+				return err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+
+		select {
+		case <-l.closed:
+			return nil
+		case err := <-ackErr:
+			return err
+		case _, ok := <-watcher.Events():
+			// 订阅因消费过慢被关闭时重新订阅，数据仍从数据文件中读取
+			if !ok {
+				watcher = l.engine.Watch(nil)
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// lagTooFar 从节点落后过多的数据文件时改为发送快照
+func (l *Leader) lagTooFar(pos *model.LogRecordPos) bool {
+	end := l.engine.EndPosition()
+	return end.FileID > pos.FileID && end.FileID-pos.FileID > l.options.MaxLagFiles
+}
+
+// sendSnapshot 发送全量快照，返回快照对应的续读位置
+func (l *Leader) sendSnapshot(writer *bufio.Writer) (*model.LogRecordPos, error) {
+	if err := writeFrame(writer, msgSnapshotBegin, nil); err != nil {
+		return nil, err
+	}
+
+	var writeErr error
+	pos, err := l.engine.Snapshot(func(key []byte, value []byte) bool {
+		writeErr = writeFrame(writer, msgSnapshotEntry, encodeSnapshotEntry(key, value))
+		return writeErr == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if writeErr != nil {
+		return nil, writeErr
+	}
+
+	if err := writeFrame(writer, msgSnapshotEnd, encodePos(pos)); err != nil {
+		return nil, err
+	}
+	return pos, writer.Flush()
+}
+
+func (l *Leader) receiveAcks(name string, reader *bufio.Reader) error {
+	for {
+		typ, payload, err := readFrame(reader, maxAckFrameSize)
+		if err != nil {
+			return err
+		}
+		if typ != msgAck {
+			return errInvalidMessage
+		}
+		pos, err := decodePos(payload)
+		if err != nil {
+			return err
+		}
+		l.trackFollower(name, pos)
+	}
+}
+
+// trackFollower 记录从节点的位置，该位置之后的数据文件在其读完之前不允许merge
+func (l *Leader) trackFollower(name string, pos *model.LogRecordPos) {
+	l.lock.Lock()
+	l.followers[name] = pos
+	l.lock.Unlock()
+
+	l.engine.Retain(constant.ReplicationRetainPrefix+name, pos)
+}
+
+func (l *Leader) untrackFollower(name string) {
+	l.lock.Lock()
+	delete(l.followers, name)
+	l.lock.Unlock()
+
+	l.engine.Release(constant.ReplicationRetainPrefix + name)
+}
@@ -0,0 +1,216 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+)
+
+// 复制协议的消息帧
+/*
+type | payloadSize | payload
+1byte   uvarint       var
+*/
+type msgType = byte
+
+const (
+	msgSubscribe     msgType = iota + 1 // 从->主：从指定位置开始订阅
+	msgSnapshotBegin                    // 主->从：开始发送全量快照
+	msgSnapshotEntry                    // 主->从：快照中的一个key/value
+	msgSnapshotEnd                      // 主->从：快照结束，携带续读位置
+	msgEvent                            // 主->从：一个变更事件
+	msgAck                              // 从->主：已应用到的位置
+)
+
+const (
+	// maxFrameSize 单个消息帧payload的上限，按对端给出的长度分配内存前检查，避免一个错误的帧耗尽内存
+	maxFrameSize = 256 << 20
+
+	// maxAckFrameSize 从节点发送的订阅与确认消息只包含一个日志位置
+	maxAckFrameSize = 64
+)
+
+var (
+	errInvalidMessage = errors.New("invalid replication message")
+	errFrameTooLarge  = errors.New("replication message too large")
+)
+
+func writeFrame(w *bufio.Writer, typ msgType, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return errFrameTooLarge
+	}
+	header := make([]byte, 1+binary.MaxVarintLen64)
+	header[0] = typ
+	n := binary.PutUvarint(header[1:], uint64(len(payload)))
+	if _, err := w.Write(header[:1+n]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame 读取一个消息帧，payload超过limit时返回errFrameTooLarge
+func readFrame(r *bufio.Reader, limit uint64) (msgType, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size > limit {
+		return 0, nil, errFrameTooLarge
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return typ, payload, nil
+}
+
+// payload 编解码辅助
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) pos(pos *model.LogRecordPos) {
+	e.uvarint(uint64(pos.FileID))
+	e.varint(pos.Offset)
+}
+
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errInvalidMessage
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errInvalidMessage
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	size := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.buf)) < size {
+		d.err = errInvalidMessage
+		return nil
+	}
+	b := d.buf[:size]
+	d.buf = d.buf[size:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf) == 0 {
+		d.err = errInvalidMessage
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) pos() *model.LogRecordPos {
+	fileID := d.uvarint()
+	offset := d.varint()
+	return &model.LogRecordPos{FileID: uint(fileID), Offset: offset}
+}
+
+func encodePos(pos *model.LogRecordPos) []byte {
+	e := &encoder{}
+	e.pos(pos)
+	return e.buf
+}
+
+func decodePos(payload []byte) (*model.LogRecordPos, error) {
+	d := &decoder{buf: payload}
+	pos := d.pos()
+	return pos, d.err
+}
+
+func encodeSnapshotEntry(key, value []byte) []byte {
+	e := &encoder{}
+	e.bytes(key)
+	e.bytes(value)
+	return e.buf
+}
+
+func decodeSnapshotEntry(payload []byte) ([]byte, []byte, error) {
+	d := &decoder{buf: payload}
+	key := d.bytes()
+	value := d.bytes()
+	return key, value, d.err
+}
+
+// encodeEvent transID | next | recordNum | (status | key | value)...
+func encodeEvent(event *model.ChangeEvent) []byte {
+	e := &encoder{}
+	e.uvarint(event.TransID)
+	e.pos(event.Next)
+	e.uvarint(uint64(len(event.Records)))
+	for _, record := range event.Records {
+		e.buf = append(e.buf, byte(record.Status))
+		e.bytes(record.Key)
+		e.bytes(record.Value)
+	}
+	return e.buf
+}
+
+func decodeEvent(payload []byte) (*model.ChangeEvent, error) {
+	d := &decoder{buf: payload}
+	event := &model.ChangeEvent{
+		TransID: d.uvarint(),
+		Next:    d.pos(),
+	}
+	num := d.uvarint()
+	for i := uint64(0); i < num && d.err == nil; i++ {
+		record := &model.ChangeRecord{Status: constant.LogRecordStatus(d.byte())}
+		record.Key = d.bytes()
+		record.Value = d.bytes()
+		event.Records = append(event.Records, record)
+	}
+	return event, d.err
+}
@@ -0,0 +1,238 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"kv-db-lab/storage"
+	"os"
+	"testing"
+	"time"
+)
+
+var testReplicationOptions = &model.ReplicationOptions{
+	MaxLagFiles:   8,
+	PollInterval:  10 * time.Millisecond,
+	RetryInterval: 10 * time.Millisecond,
+}
+
+func openTestEngine(t *testing.T, dir string) *storage.Engine {
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	opts.DateFileMergeRatio = 0
+	opts.MergeOperator = model.Int64AddOperator{}
+	db, err := storage.OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	return db
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "bitcask-replication")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if fn() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met in time")
+}
+
+func hasValue(f *Follower, key, value string) func() bool {
+	return func() bool {
+		val, err := f.Get([]byte(key))
+		return err == nil && string(val) == value
+	}
+}
+
+func TestLeaderFollower(t *testing.T) {
+	leaderDB := openTestEngine(t, tempDir(t))
+	defer leaderDB.Close()
+
+	assert.Nil(t, leaderDB.Put([]byte("k1"), []byte("v1")))
+
+	leader, err := NewLeader(leaderDB, "127.0.0.1:0", testReplicationOptions)
+	assert.Nil(t, err)
+	defer leader.Close()
+
+	followerDir := tempDir(t)
+	followerDB := openTestEngine(t, followerDir)
+	follower, err := NewFollower(followerDB, leader.Addr(), testReplicationOptions)
+	assert.Nil(t, err)
+
+	waitFor(t, hasValue(follower, "k1", "v1"))
+
+	wb := leaderDB.NewWriteBatch(model.DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, wb.Delete([]byte("k1")))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, leaderDB.Apply([]byte("counter"), []byte("3")))
+	assert.Nil(t, leaderDB.Apply([]byte("counter"), []byte("4")))
+
+	waitFor(t, hasValue(follower, "counter", "7"))
+	waitFor(t, hasValue(follower, "k2", "v2"))
+	_, err = follower.Get([]byte("k1"))
+	assert.Equal(t, constant.ErrNotExist, err)
+
+	// 主节点记录从节点确认的位置
+	waitFor(t, func() bool {
+		for _, pos := range leader.Followers() {
+			return pos == *leaderDB.EndPosition()
+		}
+		return false
+	})
+
+	// 从节点重启后从持久化的位置续传
+	assert.Nil(t, follower.Close())
+	assert.Nil(t, followerDB.Close())
+	assert.Nil(t, leaderDB.Put([]byte("k3"), []byte("v3")))
+
+	followerDB = openTestEngine(t, followerDir)
+	defer followerDB.Close()
+	follower, err = NewFollower(followerDB, leader.Addr(), testReplicationOptions)
+	assert.Nil(t, err)
+	defer follower.Close()
+
+	waitFor(t, hasValue(follower, "k3", "v3"))
+	val, err := follower.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, "7", string(val))
+}
+
+func TestLeader_RetainAndSnapshot(t *testing.T) {
+	leaderDB := openTestEngine(t, tempDir(t))
+	defer leaderDB.Close()
+
+	// 从节点落后任意数量的数据文件都发送快照
+	opts := *testReplicationOptions
+	opts.MaxLagFiles = 0
+
+	leader, err := NewLeader(leaderDB, "127.0.0.1:0", &opts)
+	assert.Nil(t, err)
+	defer leader.Close()
+
+	// 从节点断开时主节点保留其需要的数据文件
+	leaderDB.Retain("slow-follower", &model.LogRecordPos{})
+	assert.Nil(t, leaderDB.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, leaderDB.Put([]byte("k1"), []byte("v2")))
+	assert.Equal(t, constant.ErrMergeRetained, leaderDB.Merge())
+	leaderDB.Release("slow-follower")
+	assert.Nil(t, leaderDB.Merge())
+	assert.Nil(t, leaderDB.Put([]byte("k2"), []byte("v2")))
+
+	followerDB := openTestEngine(t, tempDir(t))
+	defer followerDB.Close()
+	assert.Nil(t, followerDB.Put([]byte("stale"), []byte("x")))
+
+	follower, err := NewFollower(followerDB, leader.Addr(), &opts)
+	assert.Nil(t, err)
+	defer follower.Close()
+
+	waitFor(t, hasValue(follower, "k2", "v2"))
+	waitFor(t, hasValue(follower, "k1", "v2"))
+	_, err = follower.Get([]byte("stale"))
+	assert.Equal(t, constant.ErrNotExist, err)
+}
+
+func TestLeader_ReleaseOnDisconnect(t *testing.T) {
+	leaderDB := openTestEngine(t, tempDir(t))
+	defer leaderDB.Close()
+	assert.Nil(t, leaderDB.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, leaderDB.Put([]byte("k1"), []byte("v2")))
+
+	leader, err := NewLeader(leaderDB, "127.0.0.1:0", testReplicationOptions)
+	assert.Nil(t, err)
+	defer leader.Close()
+
+	followerDB := openTestEngine(t, tempDir(t))
+	defer followerDB.Close()
+	follower, err := NewFollower(followerDB, leader.Addr(), testReplicationOptions)
+	assert.Nil(t, err)
+	waitFor(t, hasValue(follower, "k1", "v2"))
+
+	// 连接期间从节点需要第一个数据文件
+	assert.Equal(t, constant.ErrMergeRetained, leaderDB.Merge())
+
+	// 断开后主节点不再保留其需要的数据文件
+	assert.Nil(t, follower.Close())
+	waitFor(t, func() bool {
+		return leaderDB.Merge() != constant.ErrMergeRetained
+	})
+	assert.Equal(t, 0, len(leader.Followers()))
+}
+
+func TestLeaderFollower_MergeBatch(t *testing.T) {
+	leaderDB := openTestEngine(t, tempDir(t))
+	defer leaderDB.Close()
+
+	leader, err := NewLeader(leaderDB, "127.0.0.1:0", testReplicationOptions)
+	assert.Nil(t, err)
+	defer leader.Close()
+
+	followerDir := tempDir(t)
+	followerDB := openTestEngine(t, followerDir)
+	follower, err := NewFollower(followerDB, leader.Addr(), testReplicationOptions)
+	assert.Nil(t, err)
+
+	// 同一批次内的多个合并操作数依次合并，而非相互覆盖
+	assert.Nil(t, leaderDB.Put([]byte("counter"), []byte("10")))
+	wb := leaderDB.NewWriteBatch(model.DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Merge([]byte("counter"), []byte("1")))
+	assert.Nil(t, wb.Merge([]byte("counter"), []byte("2")))
+	assert.Nil(t, wb.Merge([]byte("fresh"), []byte("5")))
+	assert.Nil(t, wb.Commit())
+
+	waitFor(t, hasValue(follower, "fresh", "5"))
+	waitFor(t, hasValue(follower, "counter", "13"))
+
+	// 同步位置与变更写在同一批次内，重启后从引擎中读取
+	waitFor(t, func() bool {
+		return follower.Position() == *leaderDB.EndPosition()
+	})
+	position := follower.Position()
+	assert.Nil(t, follower.Close())
+	assert.Nil(t, followerDB.Close())
+
+	followerDB = openTestEngine(t, followerDir)
+	defer followerDB.Close()
+	pos, err := loadFollowerPos(followerDB)
+	assert.Nil(t, err)
+	assert.Equal(t, position, *pos)
+	val, err := followerDB.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, "13", string(val))
+}
+
+func TestReadFrame_Limit(t *testing.T) {
+	// 帧头声明的长度超过上限时不分配内存，直接返回错误
+	frame := append([]byte{msgEvent}, binary.AppendUvarint(nil, 1<<40)...)
+	_, _, err := readFrame(bufio.NewReader(bytes.NewReader(frame)), maxFrameSize)
+	assert.Equal(t, errFrameTooLarge, err)
+
+	buf := &bytes.Buffer{}
+	writer := bufio.NewWriter(buf)
+	assert.Nil(t, writeFrame(writer, msgAck, encodePos(&model.LogRecordPos{FileID: 3, Offset: 1 << 40})))
+	assert.Nil(t, writer.Flush())
+	typ, payload, err := readFrame(bufio.NewReader(buf), maxAckFrameSize)
+	assert.Nil(t, err)
+	assert.Equal(t, msgAck, typ)
+	pos, err := decodePos(payload)
+	assert.Nil(t, err)
+	assert.Equal(t, model.LogRecordPos{FileID: 3, Offset: 1 << 40}, *pos)
+
+	// 从节点发送的消息只包含一个位置
+	frame = append([]byte{msgAck}, binary.AppendUvarint(nil, maxAckFrameSize+1)...)
+	_, _, err = readFrame(bufio.NewReader(bytes.NewReader(frame)), maxAckFrameSize)
+	assert.Equal(t, errFrameTooLarge, err)
+}
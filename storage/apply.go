@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"kv-db-lab/pkg"
+)
+
+// mergeChain 尚未合并的操作数链：基础值位置 + 按写入顺序排列的操作数位置
+type mergeChain struct {
+	base     *model.LogRecordPos
+	operands []*model.LogRecordPos
+}
+
+// Apply
+//
+//	@Description: 追加写入一个合并操作数而非完整value，读取时由配置的MergeOperator将基础值与操作数合并
+//	@receiver db
+//	@param key
+//	@param operand
+//	@return error
+func (db *Engine) Apply(key, operand []byte) error {
+	if len(key) == 0 {
+		return constant.ErrEmptyParam
+	}
+	if db.option.MergeOperator == nil {
+		return constant.ErrMergeOperatorNotSet
+	}
+	// B+树索引启动时不会回放数据文件，无法重建操作数链
+	if db.option.Index == model.BPlusTree {
+		return constant.ErrMergeOperatorIndex
+	}
+
+	if db.replicator != nil {
+		_, err := db.replicator.Replicate(&model.WriteCommand{
+			Type:    model.WriteApply,
+			Records: []*model.LogRecord{{Key: key, Value: operand, Status: constant.LogRecordMerge}},
+		})
+		return err
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.apply(key, operand)
+}
+
+// apply 追加写入合并操作数，调用方需持有db.lock
+func (db *Engine) apply(key, operand []byte) error {
+	if db.shouldFold(key) {
+		return db.foldAndPut(key, operand)
+	}
+
+	logRecord := &model.LogRecord{
+		Key:    pkg.LogRecordKeySeq(key, constant.NoneTransactionID),
+		Value:  operand,
+		Status: constant.LogRecordMerge,
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+
+	db.addMergeOperand(key, pos)
+	db.publish(constant.NoneTransactionID, nextPos(pos), &model.ChangeRecord{
+		Key:    key,
+		Value:  operand,
+		Status: constant.LogRecordMerge,
+		Pos:    pos,
+	})
+	return nil
+}
+
+// shouldFold merge期间直接合并为完整值写入，避免操作数链引用即将被merge掉的旧文件，调用方需持有db.lock
+func (db *Engine) shouldFold(key []byte) bool {
+	chain := db.mergeChains[string(key)]
+	return db.isMerging || (chain != nil && len(chain.operands) >= constant.MaxMergeChainLength)
+}
+
+// fold 返回当前值与operands依次合并后的完整value，调用方需持有db.lock
+func (db *Engine) fold(key []byte, operands ...[]byte) ([]byte, error) {
+	old, err := db.get(key)
+	if err != nil && err != constant.ErrNotExist {
+		return nil, err
+	}
+	return db.option.MergeOperator.FullMerge(key, old, operands)
+}
+
+// foldAndPut 将当前值与operand合并后作为完整value写入，调用方需持有db.lock
+func (db *Engine) foldAndPut(key, operand []byte) error {
+	value, err := db.fold(key, operand)
+	if err != nil {
+		return err
+	}
+	return db.put(key, value)
+}
+
+// addMergeOperand 记录新的操作数位置，并让索引指向最新的操作数
+func (db *Engine) addMergeOperand(key []byte, pos *model.LogRecordPos) {
+	chain, ok := db.mergeChains[string(key)]
+	if !ok {
+		// 首个操作数，当前索引位置即为基础值
+		chain = &mergeChain{base: db.index.Get(key)}
+		db.mergeChains[string(key)] = chain
+	}
+	chain.operands = append(chain.operands, pos)
+
+	// 旧位置仍被操作数链引用，不计入无效数据
+	db.index.Put(key, pos)
+}
+
+// dropMergeChain 完整的value或删除记录覆盖了操作数链，链上的数据全部变为无效数据
+// 链上最后一个操作数即为索引中的旧位置，由调用方统计
+func (db *Engine) dropMergeChain(key []byte) {
+	chain, ok := db.mergeChains[string(key)]
+	if !ok {
+		return
+	}
+
+	if chain.base != nil {
+		db.reclaimSize += chain.base.Size
+	}
+	for _, pos := range chain.operands[:len(chain.operands)-1] {
+		db.reclaimSize += pos.Size
+	}
+	delete(db.mergeChains, string(key))
+}
+
+// getValue 根据key与索引位置读取完整value，存在操作数链时进行合并，调用方需持有db.lock
+func (db *Engine) getValue(key []byte, pos *model.LogRecordPos) ([]byte, error) {
+	chain, ok := db.mergeChains[string(key)]
+	if !ok {
+		return db.GetByRecordPos(pos)
+	}
+	return db.foldChain(key, chain)
+}
+
+// foldChain 将基础值与链上的操作数依次合并为完整value，调用方需持有db.lock
+func (db *Engine) foldChain(key []byte, chain *mergeChain) ([]byte, error) {
+	var base []byte
+	if chain.base != nil {
+		value, err := db.GetByRecordPos(chain.base)
+		if err != nil && err != constant.ErrNotExist {
+			return nil, err
+		}
+		base = value
+	}
+
+	operands := make([][]byte, 0, len(chain.operands))
+	for _, operandPos := range chain.operands {
+		operand, err := db.GetByRecordPos(operandPos)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+
+	return db.option.MergeOperator.FullMerge(key, base, operands)
+}
+
+// crossingMergeChains 返回跨越merge边界的操作数链在边界之前的部分，调用方需持有db.lock
+// 最新的操作数不在merge的文件中，merge不会重写这些key，需将边界之前的部分折叠为基础值写入merge结果
+func (db *Engine) crossingMergeChains(nonMergeFileID uint) map[string]*mergeChain {
+	crossing := make(map[string]*mergeChain)
+	for key, chain := range db.mergeChains {
+		if chain.operands[len(chain.operands)-1].FileID < nonMergeFileID {
+			continue
+		}
+
+		prefix := &mergeChain{}
+		if chain.base != nil && chain.base.FileID < nonMergeFileID {
+			prefix.base = chain.base
+		}
+		for _, pos := range chain.operands {
+			if pos.FileID >= nonMergeFileID {
+				break
+			}
+			prefix.operands = append(prefix.operands, pos)
+		}
+		if prefix.base != nil || len(prefix.operands) > 0 {
+			crossing[key] = prefix
+		}
+	}
+	return crossing
+}
+
+// foldMergeChain 供merge使用：若pos仍是key最新的操作数，返回合并后的完整value
+func (db *Engine) foldMergeChain(key []byte, pos *model.LogRecordPos) ([]byte, bool, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	chain, ok := db.mergeChains[string(key)]
+	if !ok {
+		return nil, false, nil
+	}
+	last := chain.operands[len(chain.operands)-1]
+	if last.FileID != pos.FileID || last.Offset != pos.Offset {
+		return nil, false, nil
+	}
+
+	value, err := db.getValue(key, last)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
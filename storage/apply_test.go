@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func openMergeOperatorEngine(t *testing.T, dir string) *Engine {
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	opts.DateFileMergeRatio = 0
+	opts.MergeOperator = model.Int64AddOperator{}
+	db, err := OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	return db
+}
+
+func TestEngine_Apply(t *testing.T) {
+	db := openTestEngine(t)
+	err := db.Apply([]byte("counter"), []byte("1"))
+	assert.Equal(t, constant.ErrMergeOperatorNotSet, err)
+
+	dir, err := os.MkdirTemp("", "bitcask-apply")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	db = openMergeOperatorEngine(t, dir)
+	assert.Nil(t, db.Put([]byte("counter"), []byte("10")))
+	for i := 1; i <= 5; i++ {
+		assert.Nil(t, db.Apply([]byte("counter"), []byte(strconv.Itoa(i))))
+	}
+	assert.Nil(t, db.Apply([]byte("fresh"), []byte("-3")))
+
+	val, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, "25", string(val))
+
+	// 迭代器读到的也是合并后的值
+	iter := db.NewIterate(&model.IteratorOptions{Prefix: []byte("fresh")})
+	iter.Rewind()
+	assert.True(t, iter.Valid())
+	val, err = iter.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "-3", string(val))
+	iter.Close()
+
+	// 重启后由数据文件重建操作数链
+	assert.Nil(t, db.Close())
+	db = openMergeOperatorEngine(t, dir)
+	val, err = db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, "25", string(val))
+
+	// Put覆盖操作数链
+	assert.Nil(t, db.Apply([]byte("fresh"), []byte("4")))
+	assert.Nil(t, db.Put([]byte("fresh"), []byte("100")))
+	assert.Nil(t, db.Apply([]byte("fresh"), []byte("1")))
+	val, err = db.Get([]byte("fresh"))
+	assert.Nil(t, err)
+	assert.Equal(t, "101", string(val))
+
+	// merge将操作数链折叠为完整的value
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db = openMergeOperatorEngine(t, dir)
+	defer db.Close()
+
+	val, err = db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, "25", string(val))
+	val, err = db.Get([]byte("fresh"))
+	assert.Nil(t, err)
+	assert.Equal(t, "101", string(val))
+	assert.Equal(t, 0, len(db.mergeChains))
+}
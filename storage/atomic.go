@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"bytes"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+)
+
+// CompareAndSwap
+//
+//	@Description: 当key的当前值与expected一致时写入newValue，读取与写入在同一把锁内完成
+//	@receiver db
+//	@param key
+//	@param expected  期望的当前值，nil表示期望key不存在
+//	@param newValue
+//	@return bool  是否写入成功
+//	@return error
+func (db *Engine) CompareAndSwap(key, expected, newValue []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, constant.ErrEmptyParam
+	}
+
+	if db.replicator != nil {
+		return db.replicator.Replicate(&model.WriteCommand{
+			Type:         model.WriteCompareAndSwap,
+			Records:      []*model.LogRecord{{Key: key, Value: newValue, Status: constant.LogRecordNormal}},
+			Expected:     expected,
+			ExpectAbsent: expected == nil,
+		})
+	}
+	return db.compareAndSwap(key, expected, newValue)
+}
+
+func (db *Engine) compareAndSwap(key, expected, newValue []byte) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	ok, err := db.valueMatches(key, expected)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if err := db.put(key, newValue); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfAbsent
+//
+//	@Description: 仅当key不存在时写入
+//	@receiver db
+//	@param key
+//	@param value
+//	@return bool  是否写入成功
+//	@return error
+func (db *Engine) PutIfAbsent(key, value []byte) (bool, error) {
+	return db.CompareAndSwap(key, nil, value)
+}
+
+// DeleteIfEquals
+//
+//	@Description: 当key的当前值与expected一致时删除该key
+//	@receiver db
+//	@param key
+//	@param expected
+//	@return bool  是否删除成功
+//	@return error
+func (db *Engine) DeleteIfEquals(key, expected []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, constant.ErrEmptyParam
+	}
+	if expected == nil {
+		return false, nil
+	}
+
+	if db.replicator != nil {
+		return db.replicator.Replicate(&model.WriteCommand{
+			Type:     model.WriteDeleteIfEquals,
+			Records:  []*model.LogRecord{{Key: key, Status: constant.LogRecordDelete}},
+			Expected: expected,
+		})
+	}
+	return db.deleteIfEquals(key, expected)
+}
+
+func (db *Engine) deleteIfEquals(key, expected []byte) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	ok, err := db.valueMatches(key, expected)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if err := db.delete(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Update
+//
+//	@Description: 原子的读-改-写，fn执行期间持有引擎锁，fn内不可再调用引擎的读写接口
+//	配置了Replicator时改为读取后通过CompareAndSwap提交，冲突时重新执行fn
+//	@receiver db
+//	@param key
+//	@param fn  入参为当前值(不存在时为nil)，返回新值；返回nil表示删除该key，返回error则放弃本次更新
+//	@return error
+func (db *Engine) Update(key []byte, fn func(old []byte) ([]byte, error)) error {
+	if len(key) == 0 {
+		return constant.ErrEmptyParam
+	}
+
+	if db.replicator != nil {
+		return db.updateOptimistic(key, fn)
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	old, err := db.get(key)
+	if err != nil && err != constant.ErrNotExist {
+		return err
+	}
+
+	newValue, err := fn(old)
+	if err != nil {
+		return err
+	}
+
+	if newValue == nil {
+		return db.delete(key)
+	}
+	return db.put(key, newValue)
+}
+
+// updateOptimistic 乐观的读-改-写：读取当前值，计算新值后以CAS提交，失败则重试
+func (db *Engine) updateOptimistic(key []byte, fn func(old []byte) ([]byte, error)) error {
+	for {
+		old, err := db.Get(key)
+		if err != nil && err != constant.ErrNotExist {
+			return err
+		}
+
+		newValue, err := fn(old)
+		if err != nil {
+			return err
+		}
+
+		var ok bool
+		switch {
+		case newValue != nil:
+			ok, err = db.CompareAndSwap(key, old, newValue)
+		case old != nil:
+			ok, err = db.DeleteIfEquals(key, old)
+		default:
+			// 本就不存在且需要删除
+			ok = true
+		}
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+}
+
+// valueMatches 判断key当前值是否与expected一致，调用方需持有db.lock
+func (db *Engine) valueMatches(key, expected []byte) (bool, error) {
+	current, err := db.get(key)
+	if err == constant.ErrNotExist {
+		return expected == nil, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if expected == nil {
+		return false, nil
+	}
+	return bytes.Equal(current, expected), nil
+}
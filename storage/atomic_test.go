@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func openTestEngine(t *testing.T) *Engine {
+	dir, err := os.MkdirTemp("", "bitcask-storage")
+	assert.Nil(t, err)
+
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	db, err := OpenWithOptions(&opts)
+	assert.Nil(t, err)
+
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return db
+}
+
+func TestEngine_CompareAndSwap(t *testing.T) {
+	db := openTestEngine(t)
+
+	ok, err := db.CompareAndSwap([]byte("k"), []byte("v0"), []byte("v1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = db.PutIfAbsent([]byte("k"), []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.PutIfAbsent([]byte("k"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = db.CompareAndSwap([]byte("k"), []byte("v1"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := db.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	ok, err = db.DeleteIfEquals([]byte("k"), []byte("v1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.DeleteIfEquals([]byte("k"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.Get([]byte("k"))
+	assert.Equal(t, constant.ErrNotExist, err)
+}
+
+func TestEngine_Update(t *testing.T) {
+	db := openTestEngine(t)
+	key := []byte("counter")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				err := db.Update(key, func(old []byte) ([]byte, error) {
+					n, _ := strconv.Atoi(string(old))
+					return []byte(strconv.Itoa(n + 1)), nil
+				})
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, "400", string(val))
+
+	// 返回nil删除key
+	err = db.Update(key, func(old []byte) ([]byte, error) { return nil, nil })
+	assert.Nil(t, err)
+	_, err = db.Get(key)
+	assert.Equal(t, constant.ErrNotExist, err)
+}
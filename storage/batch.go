@@ -1,10 +1,12 @@
 package storage
 
 import (
+	"bytes"
 	"errors"
 	"kv-db-lab/constant"
 	"kv-db-lab/model"
 	"kv-db-lab/pkg"
+	"sort"
 	"sync"
 	"sync/atomic"
 )
@@ -15,6 +17,9 @@ type WriteBatch struct {
 	engine        *Engine
 	pendingWrites map[string]*model.LogRecord
 	options       *model.WriteBatchOptions
+	parent        *WriteBatch // 嵌套的写批次，提交时写入parent而非引擎
+	conditions    map[string]*model.LogRecord // 提交条件，提交时与写入在同一把锁内检查
+	operands      map[string][][]byte         // 暂存的合并操作数，按写入顺序排列，pendingWrites中对应的记录仅作标识
 }
 
 // Put
@@ -38,9 +43,7 @@ func (w *WriteBatch) Put(key []byte, value []byte) error {
 		Value:  value,
 		Status: constant.LogRecordNormal,
 	}
-	w.pendingWrites[string(key)] = logRecord
-
-	return nil
+	return w.stage(logRecord)
 }
 
 // Delete
@@ -58,14 +61,14 @@ func (w *WriteBatch) Delete(key []byte) error {
 	defer w.lock.Unlock()
 
 	// 先check key是否存在,若不存在该数据则没必要将其暂存去进行系统调用，内存也无暂存数据，则返回数据不存在，若内存中有先前写入的数据，则进行删除
-	lrPos := w.engine.index.Get(key)
-	if lrPos == nil {
+	if !w.committed(key) {
 		// 如果暂存map中不存在数据，那么删除数据本不存在，返回
 		if _, ok := w.pendingWrites[string(key)]; !ok {
 			return constant.ErrNotExist
 		} else {
 			// 如果暂存map中存在数据，那么将其数据删除
 			delete(w.pendingWrites, string(key))
+			delete(w.operands, string(key))
 			return nil
 		}
 	}
@@ -76,10 +79,146 @@ func (w *WriteBatch) Delete(key []byte) error {
 		Value:  nil,
 		Status: constant.LogRecordDelete,
 	}
-	w.pendingWrites[string(key)] = logRecord
+	return w.stage(logRecord)
+}
+
+// Merge
+//
+//	@Description: 暂存一个合并操作数，与Engine.Apply一致，提交后读取时由MergeOperator与基础值合并
+//	@receiver w
+//	@param key
+//	@param operand
+//	@return error
+func (w *WriteBatch) Merge(key, operand []byte) error {
+	if len(key) == 0 {
+		return constant.ErrEmptyParam
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.stage(&model.LogRecord{Key: key, Value: operand, Status: constant.LogRecordMerge})
+}
+
+// stage 暂存一条记录，调用方需持有w.lock
+// 同一个key的多个合并操作数依次暂存，合并操作数之前暂存了完整值或删除时直接合并为完整值
+func (w *WriteBatch) stage(record *model.LogRecord) error {
+	key := string(record.Key)
+	if record.Status != constant.LogRecordMerge {
+		delete(w.operands, key)
+		w.pendingWrites[key] = record
+		return nil
+	}
+
+	if w.engine.option.MergeOperator == nil {
+		return constant.ErrMergeOperatorNotSet
+	}
+	if w.engine.option.Index == model.BPlusTree {
+		return constant.ErrMergeOperatorIndex
+	}
+	if pending, ok := w.pendingWrites[key]; ok && pending.Status != constant.LogRecordMerge {
+		var existing []byte
+		if pending.Status == constant.LogRecordNormal {
+			existing = pending.Value
+		}
+		value, err := w.engine.option.MergeOperator.FullMerge(record.Key, existing, [][]byte{record.Value})
+		if err != nil {
+			return err
+		}
+		w.pendingWrites[key] = &model.LogRecord{Key: record.Key, Value: value, Status: constant.LogRecordNormal}
+		return nil
+	}
+
+	if w.operands == nil {
+		w.operands = make(map[string][][]byte)
+	}
+	w.operands[key] = append(w.operands[key], record.Value)
+	w.pendingWrites[key] = record
 	return nil
 }
 
+// pendingRecords 按暂存顺序展开的全部记录，每个合并操作数为一条记录，调用方需持有w.lock
+func (w *WriteBatch) pendingRecords() []*model.LogRecord {
+	records := make([]*model.LogRecord, 0, len(w.pendingWrites))
+	for key, record := range w.pendingWrites {
+		if record.Status != constant.LogRecordMerge {
+			records = append(records, record)
+			continue
+		}
+		for _, operand := range w.operands[key] {
+			records = append(records, &model.LogRecord{Key: record.Key, Value: operand, Status: constant.LogRecordMerge})
+		}
+	}
+	return records
+}
+
+// mergePending 暂存的合并操作数与本批次之外的值合并后的完整value，调用方需持有w.lock
+func (w *WriteBatch) mergePending(key []byte) ([]byte, error) {
+	var base []byte
+	var err error
+	if w.parent != nil {
+		base, err = w.parent.Get(key)
+	} else {
+		base, err = w.engine.Get(key)
+	}
+	if err != nil && err != constant.ErrNotExist {
+		return nil, err
+	}
+	return w.engine.option.MergeOperator.FullMerge(key, base, w.operands[string(key)])
+}
+
+// Expect
+//
+//	@Description: 登记提交条件，提交时key的值须与expected一致，否则整个批次不写入并返回ErrConditionFailed
+//	@receiver w
+//	@param key
+//	@param expected  期望的值，nil表示期望key不存在
+//	@return error
+func (w *WriteBatch) Expect(key, expected []byte) error {
+	if len(key) == 0 {
+		return constant.ErrEmptyParam
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	condition := &model.LogRecord{Key: key, Value: expected, Status: constant.LogRecordNormal}
+	if expected == nil {
+		condition.Status = constant.LogRecordDelete
+	}
+	if w.conditions == nil {
+		w.conditions = make(map[string]*model.LogRecord)
+	}
+	w.conditions[string(key)] = condition
+	return nil
+}
+
+// conditionsHold 按get读取的当前值检查提交条件
+func (w *WriteBatch) conditionsHold(get func(key []byte) ([]byte, error)) (bool, error) {
+	for _, condition := range w.conditions {
+		current, err := get(condition.Key)
+		if err == constant.ErrNotExist {
+			if condition.Status != constant.LogRecordDelete {
+				return false, nil
+			}
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if condition.Status == constant.LogRecordDelete || !bytes.Equal(current, condition.Value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// resetPending 提交结束后清空暂存数据与提交条件
+func (w *WriteBatch) resetPending() {
+	w.pendingWrites = make(map[string]*model.LogRecord)
+	w.conditions = nil
+	w.operands = nil
+}
+
 // Commit
 //
 //	@Description: 事务提交，将预写的批量数据持久化到磁盘
@@ -95,29 +234,126 @@ func (w *WriteBatch) Commit() error {
 		return errors.New("batch write over max num")
 	}
 
-	// 获取当前事务最新的事务ID
-	transID := atomic.AddUint64(&w.engine.transID, 1)
-
 	// map读安全
 	w.lock.RLock()
 	defer w.lock.RUnlock()
 
-	// 将Btree索引信息先维护在内存中，后续将索引信息批量写入
-	logRecordPoses := make(map[string]*model.LogRecordPos)
+	if w.parent != nil {
+		return w.commitToParent()
+	}
+	if w.engine.replicator != nil {
+		records := w.pendingRecords()
+		conditions := make([]*model.LogRecord, 0, len(w.conditions))
+		for _, condition := range w.conditions {
+			conditions = append(conditions, condition)
+		}
+		ok, err := w.engine.replicator.Replicate(&model.WriteCommand{Type: model.WriteBatch, Records: records, Conditions: conditions})
+		if err != nil {
+			return err
+		}
+		w.resetPending()
+		if !ok {
+			return constant.ErrConditionFailed
+		}
+		return nil
+	}
+	return w.commit()
+}
 
-	// 依次进行批量写入
-	for _, record := range w.pendingWrites {
-		encLogRecord := &model.LogRecord{
-			Key:    pkg.LogRecordKeySeq(record.Key, transID),
-			Value:  record.Value,
-			Status: record.Status,
+// commitToParent 嵌套的写批次提交时将暂存数据写入上层批次，调用方需持有w.lock
+func (w *WriteBatch) commitToParent() error {
+	ok, err := w.conditionsHold(w.parent.Get)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		w.resetPending()
+		return constant.ErrConditionFailed
+	}
+
+	for _, record := range w.pendingRecords() {
+		var err error
+		switch record.Status {
+		case constant.LogRecordDelete:
+			if err = w.parent.Delete(record.Key); err == constant.ErrNotExist {
+				err = nil
+			}
+		case constant.LogRecordMerge:
+			err = w.parent.Merge(record.Key, record.Value)
+		default:
+			err = w.parent.Put(record.Key, record.Value)
 		}
-		logRecordPos, err := w.engine.appendLogRecord(encLogRecord)
 		if err != nil {
 			return err
 		}
+	}
+	w.resetPending()
+	return nil
+}
+
+// commit 将暂存数据写入本地引擎，调用方需持有w.lock
+func (w *WriteBatch) commit() error {
+	// 整个提交过程持有引擎锁，保证批量写入与条件写等操作不会交错
+	w.engine.lock.Lock()
+	defer w.engine.lock.Unlock()
 
-		logRecordPoses[string(record.Key)] = logRecordPos
+	ok, err := w.conditionsHold(w.engine.get)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		w.resetPending()
+		return constant.ErrConditionFailed
+	}
+	return w.commitLocked()
+}
+
+// commitLocked 将暂存数据写入本地引擎，调用方需持有w.lock与引擎锁
+func (w *WriteBatch) commitLocked() error {
+	// 获取当前事务最新的事务ID
+	transID := atomic.AddUint64(&w.engine.transID, 1)
+
+	// 按写入顺序记录每条数据的位置，后续将索引信息批量写入
+	changes := make([]*model.ChangeRecord, 0, len(w.pendingWrites))
+
+	// 依次进行批量写入
+	for _, record := range w.pendingWrites {
+		records := []*model.LogRecord{record}
+		if record.Status == constant.LogRecordMerge {
+			operands := w.operands[string(record.Key)]
+			if w.engine.shouldFold(record.Key) {
+				// 合并操作数与Engine.apply一致，merge期间或操作数链过长时合并为完整值写入
+				value, err := w.engine.fold(record.Key, operands...)
+				if err != nil {
+					return err
+				}
+				records = []*model.LogRecord{{Key: record.Key, Value: value, Status: constant.LogRecordNormal}}
+			} else {
+				records = make([]*model.LogRecord, 0, len(operands))
+				for _, operand := range operands {
+					records = append(records, &model.LogRecord{Key: record.Key, Value: operand, Status: constant.LogRecordMerge})
+				}
+			}
+		}
+
+		for _, record := range records {
+			encLogRecord := &model.LogRecord{
+				Key:    pkg.LogRecordKeySeq(record.Key, transID),
+				Value:  record.Value,
+				Status: record.Status,
+			}
+			logRecordPos, err := w.engine.appendLogRecord(encLogRecord)
+			if err != nil {
+				return err
+			}
+
+			changes = append(changes, &model.ChangeRecord{
+				Key:    record.Key,
+				Value:  record.Value,
+				Status: record.Status,
+				Pos:    logRecordPos,
+			})
+		}
 	}
 
 	// 写一条标识事务已提交的数据 标识是否全部成功写入
@@ -126,7 +362,8 @@ func (w *WriteBatch) Commit() error {
 		Value:  nil,
 		Status: constant.LogRecordNormal,
 	}
-	if _, err := w.engine.appendLogRecord(finishedRecord); err != nil {
+	finishedPos, err := w.engine.appendLogRecord(finishedRecord)
+	if err != nil {
 		return err
 	}
 
@@ -138,18 +375,21 @@ func (w *WriteBatch) Commit() error {
 	}
 
 	// 更新内存索引
-	for _, record := range w.pendingWrites {
-		key := record.Key
-		pos := logRecordPoses[string(key)]
+	for _, change := range changes {
 		var oldPos *model.LogRecordPos
 
-		if record.Status == constant.LogRecordNormal {
-			oldPos = w.engine.index.Put(key, pos)
+		if change.Status == constant.LogRecordMerge {
+			w.engine.addMergeOperand(change.Key, change.Pos)
+			continue
 		}
-		if record.Status == constant.LogRecordDelete {
-			oldPos = w.engine.index.Delete(key)
+		w.engine.dropMergeChain(change.Key)
+		if change.Status == constant.LogRecordNormal {
+			oldPos = w.engine.index.Put(change.Key, change.Pos)
+		}
+		if change.Status == constant.LogRecordDelete {
+			oldPos = w.engine.index.Delete(change.Key)
 			// 更新无效数据大小
-			w.engine.reclaimSize += pos.Size
+			w.engine.reclaimSize += change.Pos.Size
 		}
 
 		if oldPos != nil {
@@ -158,7 +398,151 @@ func (w *WriteBatch) Commit() error {
 		}
 	}
 
+	// 整个批次作为一个变更事件推送
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Pos.FileID < changes[j].Pos.FileID ||
+			(changes[i].Pos.FileID == changes[j].Pos.FileID && changes[i].Pos.Offset < changes[j].Pos.Offset)
+	})
+	w.engine.publish(transID, nextPos(finishedPos), changes...)
+
 	// 清空暂存数据
-	w.pendingWrites = make(map[string]*model.LogRecord)
+	w.resetPending()
 	return nil
 }
+
+// committed key在本批次之外是否存在，嵌套的写批次以上层批次的视图为准
+func (w *WriteBatch) committed(key []byte) bool {
+	if w.parent != nil {
+		_, err := w.parent.Get(key)
+		return err == nil
+	}
+	return w.engine.index.Get(key) != nil
+}
+
+// Get
+//
+//	@Description: 读取key在本批次提交后的值，暂存的写入优先于已提交的数据，用于在批次内读取自身的写入
+//	@receiver w
+//	@param key
+//	@return []byte
+//	@return error
+func (w *WriteBatch) Get(key []byte) ([]byte, error) {
+	w.lock.RLock()
+	record, ok := w.pendingWrites[string(key)]
+	if ok && record.Status == constant.LogRecordMerge {
+		defer w.lock.RUnlock()
+		return w.mergePending(key)
+	}
+	w.lock.RUnlock()
+
+	if ok {
+		if record.Status == constant.LogRecordDelete {
+			return nil, constant.ErrNotExist
+		}
+		return record.Value, nil
+	}
+	if w.parent != nil {
+		return w.parent.Get(key)
+	}
+	return w.engine.Get(key)
+}
+
+// NewIterate 在已提交数据的基础上合并本批次以及上层批次中暂存的写入
+func (w *WriteBatch) NewIterate(opts *model.IteratorOptions) *Iterate {
+	merged := make(map[string]*model.LogRecord)
+	w.collectPending(opts.Prefix, merged)
+
+	pending := make([]*model.LogRecord, 0, len(merged))
+	for _, record := range merged {
+		pending = append(pending, record)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		cmp := bytes.Compare(pending[i].Key, pending[j].Key)
+		if opts.Reverse {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	iter := w.engine.NewIterate(opts)
+	iter.pending = pending
+	return iter
+}
+
+// collectPending 收集满足前缀的暂存写入，下层批次覆盖上层批次
+func (w *WriteBatch) collectPending(prefix []byte, merged map[string]*model.LogRecord) {
+	if w.parent != nil {
+		w.parent.collectPending(prefix, merged)
+	}
+
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	for key, record := range w.pendingWrites {
+		if !bytes.HasPrefix(record.Key, prefix) {
+			continue
+		}
+		// 合并操作数以合并后的完整value参与遍历
+		if record.Status == constant.LogRecordMerge {
+			value, err := w.mergePending(record.Key)
+			if err != nil {
+				continue
+			}
+			record = &model.LogRecord{Key: record.Key, Value: value, Status: constant.LogRecordNormal}
+		}
+		merged[key] = record
+	}
+}
+
+// Update 与Engine.Update一致的读-改-写，读取与写入均在本批次内进行
+func (w *WriteBatch) Update(key []byte, fn func(old []byte) ([]byte, error)) error {
+	if len(key) == 0 {
+		return constant.ErrEmptyParam
+	}
+
+	old, err := w.Get(key)
+	if err != nil && err != constant.ErrNotExist {
+		return err
+	}
+	newValue, err := fn(old)
+	if err != nil {
+		return err
+	}
+
+	if newValue == nil {
+		if err = w.Delete(key); err == constant.ErrNotExist {
+			err = nil
+		}
+		return err
+	}
+	return w.Put(key, newValue)
+}
+
+// DeleteIfEquals 与Engine.DeleteIfEquals一致，比较的是本批次内可见的值
+func (w *WriteBatch) DeleteIfEquals(key, expected []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, constant.ErrEmptyParam
+	}
+	if expected == nil {
+		return false, nil
+	}
+
+	current, err := w.Get(key)
+	if err == constant.ErrNotExist {
+		return false, nil
+	}
+	if err != nil || !bytes.Equal(current, expected) {
+		return false, err
+	}
+	return true, w.Delete(key)
+}
+
+// NewWriteBatch 创建嵌套的写批次，提交时写入本批次，随本批次一同提交到引擎
+func (w *WriteBatch) NewWriteBatch(opts *model.WriteBatchOptions) *WriteBatch {
+	return &WriteBatch{
+		lock:          new(sync.RWMutex),
+		engine:        w.engine,
+		pendingWrites: make(map[string]*model.LogRecord),
+		options:       opts,
+		parent:        w,
+	}
+}
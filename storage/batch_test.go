@@ -2,7 +2,9 @@ package storage
 
 import (
 	"github.com/stretchr/testify/assert"
+	"kv-db-lab/constant"
 	"kv-db-lab/model"
+	"os"
 	"strconv"
 	"testing"
 )
@@ -52,3 +54,194 @@ func TestWriteBatch_Commit(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db)
 }
+
+func TestWriteBatch_Get(t *testing.T) {
+	db := openTestEngine(t)
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("2")))
+
+	wb := db.NewWriteBatch(model.DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("a"), []byte("10")))
+	assert.Nil(t, wb.Delete([]byte("b")))
+	assert.Nil(t, wb.Put([]byte("c"), []byte("3")))
+
+	value, err := wb.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("10"), value)
+	_, err = wb.Get([]byte("b"))
+	assert.Equal(t, constant.ErrNotExist, err)
+	value, err = db.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+
+	// 嵌套的批次提交后写入上层批次，上层批次提交前引擎中不可见
+	child := wb.NewWriteBatch(model.DefaultWriteBatchOptions)
+	assert.Nil(t, child.Delete([]byte("c")))
+	assert.Nil(t, child.Put([]byte("d"), []byte("4")))
+	assert.Nil(t, child.Commit())
+	_, err = wb.Get([]byte("c"))
+	assert.Equal(t, constant.ErrNotExist, err)
+	_, err = db.Get([]byte("d"))
+	assert.Equal(t, constant.ErrNotExist, err)
+
+	assert.Nil(t, wb.Commit())
+	value, err = db.Get([]byte("d"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("4"), value)
+	_, err = db.Get([]byte("b"))
+	assert.Equal(t, constant.ErrNotExist, err)
+}
+
+func TestWriteBatch_NewIterate(t *testing.T) {
+	db := openTestEngine(t)
+	for _, key := range []string{"k1", "k3", "k5", "x"} {
+		assert.Nil(t, db.Put([]byte(key), []byte("old")))
+	}
+
+	wb := db.NewWriteBatch(model.DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k2"), []byte("new")))
+	assert.Nil(t, wb.Put([]byte("k3"), []byte("new")))
+	assert.Nil(t, wb.Delete([]byte("k5")))
+	assert.Nil(t, wb.Put([]byte("k6"), []byte("new")))
+
+	collect := func(iter *Iterate) []string {
+		defer iter.Close()
+		var result []string
+		for ; iter.Valid(); iter.Next() {
+			value, err := iter.Value()
+			assert.Nil(t, err)
+			result = append(result, string(iter.Key())+"="+string(value))
+		}
+		return result
+	}
+
+	iter := wb.NewIterate(&model.IteratorOptions{Prefix: []byte("k")})
+	iter.Rewind()
+	assert.Equal(t, []string{"k1=old", "k2=new", "k3=new", "k6=new"}, collect(iter))
+
+	iter = wb.NewIterate(&model.IteratorOptions{Prefix: []byte("k"), Reverse: true})
+	iter.Seek([]byte("k5"))
+	assert.Equal(t, []string{"k3=new", "k2=new", "k1=old"}, collect(iter))
+
+	iter = wb.NewIterate(&model.IteratorOptions{Prefix: []byte("k")})
+	iter.Seek([]byte("k4"))
+	assert.Equal(t, []string{"k6=new"}, collect(iter))
+}
+
+// loopbackReplicator 编码后直接应用到本地引擎，模拟经过复制日志的写入
+type loopbackReplicator struct {
+	db *Engine
+}
+
+func (r *loopbackReplicator) Replicate(cmd *model.WriteCommand) (bool, error) {
+	decoded, err := model.DecodeWriteCommand(model.EncodeWriteCommand(cmd))
+	if err != nil {
+		return false, err
+	}
+	return r.db.ApplyCommand(decoded)
+}
+
+func TestWriteBatch_Expect(t *testing.T) {
+	db := openTestEngine(t)
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+
+	check := func(t *testing.T, newBatch func() *WriteBatch) {
+		wb := newBatch()
+		assert.Nil(t, wb.Expect([]byte("a"), []byte("1")))
+		assert.Nil(t, wb.Expect([]byte("b"), nil))
+		assert.Nil(t, wb.Put([]byte("b"), []byte("2")))
+		assert.Nil(t, wb.Commit())
+		value, err := wb.Get([]byte("b"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("2"), value)
+
+		// 条件不满足时整个批次都不写入
+		wb = newBatch()
+		assert.Nil(t, wb.Expect([]byte("b"), nil))
+		assert.Nil(t, wb.Put([]byte("b"), []byte("3")))
+		assert.Nil(t, wb.Put([]byte("c"), []byte("3")))
+		assert.Equal(t, constant.ErrConditionFailed, wb.Commit())
+		value, err = wb.Get([]byte("b"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("2"), value)
+		_, err = wb.Get([]byte("c"))
+		assert.Equal(t, constant.ErrNotExist, err)
+
+		wb = newBatch()
+		assert.Nil(t, wb.Expect([]byte("a"), []byte("0")))
+		assert.Nil(t, wb.Delete([]byte("b")))
+		assert.Equal(t, constant.ErrConditionFailed, wb.Commit())
+		_, err = wb.Get([]byte("b"))
+		assert.Nil(t, err)
+		assert.Nil(t, wb.Delete([]byte("b")))
+		assert.Nil(t, wb.Commit())
+	}
+
+	t.Run("engine", func(t *testing.T) {
+		check(t, func() *WriteBatch { return db.NewWriteBatch(model.DefaultWriteBatchOptions) })
+	})
+	t.Run("nested", func(t *testing.T) {
+		parent := db.NewWriteBatch(model.DefaultWriteBatchOptions)
+		check(t, func() *WriteBatch { return parent.NewWriteBatch(model.DefaultWriteBatchOptions) })
+		assert.Nil(t, parent.Commit())
+	})
+	t.Run("replicated", func(t *testing.T) {
+		db.SetReplicator(&loopbackReplicator{db: db})
+		defer db.SetReplicator(nil)
+		check(t, func() *WriteBatch { return db.NewWriteBatch(model.DefaultWriteBatchOptions) })
+	})
+}
+
+func TestWriteBatch_Merge(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-batch-merge")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	db := openMergeOperatorEngine(t, dir)
+	assert.Nil(t, db.Put([]byte("counter"), []byte("10")))
+
+	wb := db.NewWriteBatch(model.DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Merge([]byte("counter"), []byte("1")))
+	assert.Nil(t, wb.Merge([]byte("counter"), []byte("2")))
+	// 暂存的完整值之后的操作数直接合并
+	assert.Nil(t, wb.Put([]byte("fresh"), []byte("100")))
+	assert.Nil(t, wb.Merge([]byte("fresh"), []byte("1")))
+	assert.Nil(t, wb.Merge([]byte("absent"), []byte("5")))
+
+	// 批次内读取与遍历看到合并后的值
+	value, err := wb.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, "13", string(value))
+	iter := wb.NewIterate(&model.IteratorOptions{Prefix: []byte("absent")})
+	iter.Rewind()
+	assert.True(t, iter.Valid())
+	value, err = iter.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "5", string(value))
+	iter.Close()
+
+	// 嵌套批次的操作数提交到上层批次
+	nested := wb.NewWriteBatch(model.DefaultWriteBatchOptions)
+	assert.Nil(t, nested.Merge([]byte("counter"), []byte("4")))
+	assert.Nil(t, nested.Commit())
+	assert.Nil(t, wb.Commit())
+
+	check := func() {
+		for key, expected := range map[string]string{"counter": "17", "fresh": "101", "absent": "5"} {
+			value, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, expected, string(value), key)
+		}
+	}
+	check()
+
+	// 重启后按写入顺序回放同一批次内的多个操作数
+	assert.Nil(t, db.Close())
+	db = openMergeOperatorEngine(t, dir)
+	defer db.Close()
+	check()
+
+	db.option.MergeOperator = nil
+	wb = db.NewWriteBatch(model.DefaultWriteBatchOptions)
+	assert.Equal(t, constant.ErrMergeOperatorNotSet, wb.Merge([]byte("counter"), []byte("1")))
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"github.com/gofrs/flock"
 	"github.com/sirupsen/logrus"
-	"github.com/xiaoxuxiansheng/timewheel"
 	"io"
 	"kv-db-lab/constant"
 	"kv-db-lab/fileIO"
@@ -43,7 +42,17 @@ type Engine struct {
 
 	reclaimSize int64 // 标识有多少数据无效，需要merge
 
-	TimeWheel *timewheel.TimeWheel // 时间轮，用于对过期数据进行定时删除
+	mergeChains map[string]*mergeChain // 尚未合并的操作数链，仅配置了MergeOperator时使用
+
+	nonMergeFileID uint32 // 小于此ID的数据文件由merge重写，变更读取不能从这些文件中间续读
+
+	retainers map[string]*model.LogRecordPos // 仍需读取数据文件的使用者及其读取位置
+
+	replicator model.Replicator // 不为空时写操作先交由Replicator复制，再由ApplyCommand应用到本地
+
+	watchers  map[uint64]*Watcher // 变更订阅者
+	watcherID uint64
+	watchLock *sync.Mutex
 }
 
 // Put
@@ -59,6 +68,22 @@ func (db *Engine) Put(key []byte, value []byte) error {
 		return constant.ErrEmptyParam
 	}
 
+	if db.replicator != nil {
+		_, err := db.replicator.Replicate(&model.WriteCommand{
+			Type:    model.WritePut,
+			Records: []*model.LogRecord{{Key: key, Value: value, Status: constant.LogRecordNormal}},
+		})
+		return err
+	}
+
+	// 写入与索引更新在同一把锁内完成，避免与CAS等条件写交错
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.put(key, value)
+}
+
+// put 写入数据并更新内存索引，调用方需持有db.lock
+func (db *Engine) put(key []byte, value []byte) error {
 	// 给key加入一个特殊值transID，与批写入数据做区分
 	keyTransID := pkg.LogRecordKeySeq(key, constant.NoneTransactionID)
 
@@ -77,10 +102,17 @@ func (db *Engine) Put(key []byte, value []byte) error {
 	}
 
 	// 更新内存索引
+	db.dropMergeChain(key)
 	if oldPos := db.index.Put(key, pos); oldPos != nil {
 		db.reclaimSize += oldPos.Size
 	}
 
+	db.publish(constant.NoneTransactionID, nextPos(pos), &model.ChangeRecord{
+		Key:    key,
+		Value:  value,
+		Status: constant.LogRecordNormal,
+		Pos:    pos,
+	})
 	return nil
 }
 
@@ -92,6 +124,11 @@ func (db *Engine) Get(key []byte) ([]byte, error) {
 
 	db.lock.RLock()
 	defer db.lock.RUnlock()
+	return db.get(key)
+}
+
+// get 根据内存索引读取数据，调用方需持有db.lock
+func (db *Engine) get(key []byte) ([]byte, error) {
 	// 从内存中获取索信息
 	logRecordPos := db.index.Get(key)
 
@@ -100,32 +137,7 @@ func (db *Engine) Get(key []byte) ([]byte, error) {
 		return nil, constant.ErrNotExist
 	}
 
-	// 根据文件ID找到对应数据文件  可能在活跃文件也可能在old文件
-	var dataFile *model.DataFile
-	if db.activeFile.FilePos.FileID == logRecordPos.FileID {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.oldFile[logRecordPos.FileID]
-	}
-
-	// 未找到该文件
-	if dataFile == nil {
-		return nil, constant.ErrNotExist
-	}
-
-	// 根据偏移量去读取数据
-	logRecord, _, err := dataFile.ReadLogRecordByOffset(logRecordPos.Offset)
-	if err != nil {
-		logrus.Error("根据偏移量读取数据失败，err:", err.Error())
-		return nil, err
-	}
-
-	if logRecord.Status == constant.LogRecordDelete {
-		logrus.Info("根据偏移量读取数据的状态是已删除")
-		return nil, constant.ErrNotExist
-	}
-
-	return logRecord.Value, nil
+	return db.getValue(key, logRecordPos)
 }
 
 func (db *Engine) GetByRecordPos(logRecordPos *model.LogRecordPos) ([]byte, error) {
@@ -164,15 +176,12 @@ func (db *Engine) GetByRecordPos(logRecordPos *model.LogRecordPos) ([]byte, erro
 
 // appendLogRecord
 //
-//	@Description: 追加写入数据到活跃文件中
+//	@Description: 追加写入数据到活跃文件中，调用方需持有db.lock
 //	@receiver db
 //	@param logRecord  // 写入数据
 //	@return *model.LogRecordPos  // 写入后返回该数据的索引信息
 //	@return error
 func (db *Engine) appendLogRecord(logRecord *model.LogRecord) (*model.LogRecordPos, error) {
-	db.lock.Lock()
-	defer db.lock.Unlock()
-
 	// 判断当前活跃数据文件是否存在，若不存在需要自己生成
 	if db.activeFile == nil {
 		err := db.setActiveFile()
@@ -229,12 +238,34 @@ func (db *Engine) appendLogRecord(logRecord *model.LogRecord) (*model.LogRecordP
 	return pos, nil
 }
 
+// appendLogRecordWithLock 加锁后追加写入数据到活跃文件中
+func (db *Engine) appendLogRecordWithLock(logRecord *model.LogRecord) (*model.LogRecordPos, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.appendLogRecord(logRecord)
+}
+
 func (db *Engine) Delete(key []byte) error {
 	// 校验入参
 	if len(key) == 0 {
 		return constant.ErrEmptyParam
 	}
 
+	if db.replicator != nil {
+		_, err := db.replicator.Replicate(&model.WriteCommand{
+			Type:    model.WriteDelete,
+			Records: []*model.LogRecord{{Key: key, Status: constant.LogRecordDelete}},
+		})
+		return err
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.delete(key)
+}
+
+// delete 写入删除标识并清理内存索引，调用方需持有db.lock
+func (db *Engine) delete(key []byte) error {
 	// 检验key是否在Btree索引中是否存在，若不存在则没有继续的必要
 	recordPos := db.index.Get(key)
 	if recordPos == nil {
@@ -260,11 +291,17 @@ func (db *Engine) Delete(key []byte) error {
 	db.reclaimSize += Pos.Size
 
 	// 删除索引文件
+	db.dropMergeChain(key)
 	oldPos := db.index.Delete(key)
 	if oldPos != nil {
 		db.reclaimSize += oldPos.Size
 	}
 
+	db.publish(constant.NoneTransactionID, nextPos(Pos), &model.ChangeRecord{
+		Key:    key,
+		Status: constant.LogRecordDelete,
+		Pos:    Pos,
+	})
 	return nil
 }
 
@@ -398,7 +435,7 @@ func (db *Engine) loadIndexFromDateFiles() error {
 
 			// 非事务数据
 			if transID == constant.NoneTransactionID {
-				err := db.updateIndex(logRecord.Key, logRecord.Status, logRecordPos)
+				err := db.updateIndex(realKey, logRecord.Status, logRecordPos)
 				if err != nil {
 					return err
 				}
@@ -450,6 +487,14 @@ func (db *Engine) loadIndexFromDateFiles() error {
 }
 
 func (db *Engine) updateIndex(key []byte, status constant.LogRecordStatus, pos *model.LogRecordPos) error {
+	// 合并操作数追加到操作数链上
+	if status == constant.LogRecordMerge {
+		db.addMergeOperand(key, pos)
+		return nil
+	}
+
+	db.dropMergeChain(key)
+
 	var oldPos *model.LogRecordPos
 	// 如果记录为已删除状态
 	if status == constant.LogRecordDelete {
@@ -512,12 +557,16 @@ func OpenWithOptions(options *model.Options) (*Engine, error) {
 
 	// 初始化engine结构体
 	db := &Engine{
-		option:    options,
-		lock:      &sync.RWMutex{},
-		oldFile:   make(map[uint]*model.DataFile),
-		index:     index.NewIndexer(options.Index, options.DirPath),
-		isInitial: isInitial,
-		fileLock:  fileLock,
+		option:      options,
+		lock:        &sync.RWMutex{},
+		oldFile:     make(map[uint]*model.DataFile),
+		index:       index.NewIndexer(options.Index, options.DirPath),
+		isInitial:   isInitial,
+		fileLock:    fileLock,
+		mergeChains: make(map[string]*mergeChain),
+		retainers:   make(map[string]*model.LogRecordPos),
+		watchers:    make(map[uint64]*Watcher),
+		watchLock:   new(sync.Mutex),
 	}
 
 	// 加载数据目录
@@ -531,6 +580,11 @@ func OpenWithOptions(options *model.Options) (*Engine, error) {
 		return nil, err
 	}
 
+	// 记录merge重写过的数据文件范围
+	if err := db.loadNonMergeFileID(); err != nil {
+		return nil, err
+	}
+
 	// B+树索引由于将索引信息持久化，不需要再加载索引文件
 	if db.option.Index != model.BPlusTree {
 		// 从hint索引文件加载索引
@@ -563,9 +617,6 @@ func OpenWithOptions(options *model.Options) (*Engine, error) {
 			db.activeFile.FilePos.Offset = size
 		}
 	}
-	// 初始化时间轮中间件
-	db.TimeWheel = pkg.InitTimeWheel()
-
 	return db, nil
 }
 
@@ -597,6 +648,7 @@ func (db *Engine) NewWriteBatch(opts *model.WriteBatchOptions) *WriteBatch {
 // GetAllKeys : 获取数据库中所有key
 func (db *Engine) GetAllKeys() [][]byte {
 	iterator := db.index.Iterator(false)
+	defer iterator.Close()
 	keys := make([][]byte, db.index.Size())
 	idx := 0
 
@@ -622,7 +674,7 @@ func (db *Engine) Fold(fn func(key []byte, value []byte) bool) error {
 	defer iter.Close()
 	// 使用迭代器获得pos->value
 	for iter.Rewind(); iter.Valid(); iter.Next() {
-		value, err := db.GetByRecordPos(iter.Value())
+		value, err := db.getValue(iter.Key(), iter.Value())
 		if err != nil {
 			return err
 		}
@@ -784,6 +836,11 @@ func (db *Engine) Stat() *model.EngineStat {
 	}
 }
 
+// DirPath 返回引擎的数据目录
+func (db *Engine) DirPath() string {
+	return db.option.DirPath
+}
+
 // BackUp
 //
 //	@Description: 将数据目录做拷贝，数据备份
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"github.com/stretchr/testify/assert"
+	"fmt"
 	"kv-db-lab/model"
+	"os"
 	"testing"
 )
 
@@ -12,3 +14,64 @@ func TestEngine_BackUp(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Nil(t, err)
 }
+
+func TestEngine_ReopenLoadsIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-reopen")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	db, err := OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, db.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, db.Delete([]byte("k2")))
+	assert.Nil(t, db.Close())
+
+	// 数据文件中的key带有事务序号，重启后索引以去掉序号的key建立
+	db, err = OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	value, err := db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), value)
+	_, err = db.Get([]byte("k2"))
+	assert.NotNil(t, err)
+}
+
+func TestEngine_MergeReopen(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-merge")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	opts.DataFileSize = 64
+	opts.DateFileMergeRatio = 0
+	db, err := OpenWithOptions(&opts)
+	assert.Nil(t, err)
+
+	// 第二轮只覆盖一部分key，其余key的记录由上一次merge重写
+	// merge重写后的记录只带一层事务序号，再次merge与重启后数据仍然完整
+	expected := make(map[string]string)
+	for round := 0; round < 2; round++ {
+		for i := round * 5; i < 10; i++ {
+			key, value := fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d-%d", i, round)
+			assert.Nil(t, db.Put([]byte(key), []byte(value)))
+			expected[key] = value
+		}
+		assert.Nil(t, db.Merge())
+		assert.Equal(t, dir, db.Options().DirPath)
+		assert.Nil(t, db.Close())
+
+		db, err = OpenWithOptions(&opts)
+		assert.Nil(t, err)
+		for key, value := range expected {
+			actual, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte(value), actual)
+		}
+	}
+	assert.Nil(t, db.Close())
+}
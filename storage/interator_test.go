@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"kv-db-lab/model"
+	"os"
 	"testing"
 )
 
@@ -29,3 +30,94 @@ func TestIterate(t *testing.T) {
 	fmt.Println(string(v))
 
 }
+
+func TestIterate_PrefixSeek(t *testing.T) {
+	db := openTestEngine(t)
+	for _, key := range []string{"a1", "b1", "b2", "b4", "c1"} {
+		assert.Nil(t, db.Put([]byte(key), []byte(key)))
+	}
+
+	collect := func(iter *Iterate) []string {
+		var keys []string
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	iter := db.NewIterate(&model.IteratorOptions{Prefix: []byte("b")})
+	iter.Rewind()
+	assert.Equal(t, []string{"b1", "b2", "b4"}, collect(iter))
+
+	// Seek定位到第一个大于等于key的位置
+	iter.Seek([]byte("b2"))
+	assert.Equal(t, []string{"b2", "b4"}, collect(iter))
+	iter.Close()
+
+	reverse := db.NewIterate(&model.IteratorOptions{Prefix: []byte("b"), Reverse: true})
+	reverse.Rewind()
+	assert.Equal(t, []string{"b4", "b2", "b1"}, collect(reverse))
+	reverse.Seek([]byte("b3"))
+	assert.Equal(t, []string{"b2", "b1"}, collect(reverse))
+	reverse.Close()
+}
+
+func TestIterate_ReversePrefix(t *testing.T) {
+	for name, index := range map[string]model.IndexType{"btree": model.Btree, "art": model.ART, "bplustree": model.BPlusTree} {
+		index := index
+		t.Run(name, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "bitcask-iterate")
+			assert.Nil(t, err)
+			defer os.RemoveAll(dir)
+
+			opts := *model.DefaultOptions
+			opts.DirPath = dir
+			opts.Index = index
+			db, err := OpenWithOptions(&opts)
+			assert.Nil(t, err)
+			defer db.Close()
+
+			for _, key := range []string{"a1", "b1", "b2", "b4", "c1", "c2"} {
+				assert.Nil(t, db.Put([]byte(key), []byte(key)))
+			}
+
+			collect := func(prefix string) []string {
+				iter := db.NewIterate(&model.IteratorOptions{Prefix: []byte(prefix), Reverse: true})
+				defer iter.Close()
+				var keys []string
+				for iter.Rewind(); iter.Valid(); iter.Next() {
+					keys = append(keys, string(iter.Key()))
+				}
+				return keys
+			}
+			assert.Equal(t, []string{"b4", "b2", "b1"}, collect("b"))
+			// 前缀区间之后没有key
+			assert.Equal(t, []string{"c2", "c1"}, collect("c"))
+			assert.Equal(t, []string{"a1"}, collect("a"))
+			assert.Nil(t, collect("d"))
+		})
+	}
+}
+
+func TestIterate_PrefixSkip(t *testing.T) {
+	db := openTestEngine(t)
+	for _, key := range []string{"a1", "a2", "b1", "b2", "c1"} {
+		assert.Nil(t, db.Put([]byte(key), []byte(key)))
+	}
+
+	var keys []string
+	iter := db.NewIterate(&model.IteratorOptions{Prefix: []byte("b")})
+	// 定位到前缀区间之前，需要逐个跳过不满足前缀的key
+	iter.Seek([]byte("a"))
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Close()
+	assert.Equal(t, []string{"b1", "b2"}, keys)
+
+	// 没有满足前缀的key时迭代器无效
+	empty := db.NewIterate(&model.IteratorOptions{Prefix: []byte("d")})
+	empty.Rewind()
+	assert.False(t, empty.Valid())
+	empty.Close()
+}
@@ -2,8 +2,11 @@ package storage
 
 import (
 	"bytes"
+	"kv-db-lab/constant"
 	"kv-db-lab/index"
 	"kv-db-lab/model"
+	"kv-db-lab/pkg"
+	"sort"
 )
 
 // Iterate 对外(用户)使用的Iterate结构
@@ -11,39 +14,85 @@ type Iterate struct {
 	indexIter index.Iterator
 	engine    *Engine
 	options   *model.IteratorOptions
+	finished  bool // 已越过前缀所在的区间，后续不会再有满足前缀的key
+
+	// pending 写批次中暂存的写入，按遍历顺序排列，与索引合并遍历，相同的key以暂存的写入为准
+	pending    []*model.LogRecord
+	pendingIdx int
 }
 
 func (it *Iterate) Rewind() {
-	it.indexIter.Rewind()
+	it.finished = false
+
+	// 指定了前缀时直接定位到前缀区间的起点，避免从头逐个跳过
+	prefix := it.options.Prefix
+	if len(prefix) == 0 {
+		it.indexIter.Rewind()
+	} else if !it.options.Reverse {
+		it.indexIter.Seek(prefix)
+	} else if end := pkg.PrefixEnd(prefix); end != nil {
+		// B+树索引的Seek只向后定位，没有大于等于end的key时无效，此时从最后一个key开始
+		it.indexIter.Seek(end)
+		if !it.indexIter.Valid() {
+			it.indexIter.Rewind()
+		}
+	} else {
+		it.indexIter.Rewind()
+	}
 	it.SkipToNext()
+
+	it.pendingIdx = 0
+	it.skipPendingDeletes()
 }
 
 func (it *Iterate) Seek(key []byte) {
+	it.finished = false
 	it.indexIter.Seek(key)
 	it.SkipToNext()
+
+	// 与索引迭代器一致，逆序时定位到第一个小于等于key的位置
+	it.pendingIdx = sort.Search(len(it.pending), func(i int) bool {
+		cmp := bytes.Compare(it.pending[i].Key, key)
+		if it.options.Reverse {
+			return cmp <= 0
+		}
+		return cmp >= 0
+	})
+	it.skipPendingDeletes()
 }
 
 func (it *Iterate) Next() {
-	it.indexIter.Next()
-	it.SkipToNext()
+	if it.onPending() {
+		it.nextPending()
+	} else {
+		it.indexIter.Next()
+		it.SkipToNext()
+	}
+	it.skipPendingDeletes()
 }
 
 func (it *Iterate) Valid() bool {
-	return it.indexIter.Valid()
+	return it.indexValid() || it.pendingIdx < len(it.pending)
 }
 
 func (it *Iterate) Key() []byte {
+	if it.onPending() {
+		return it.pending[it.pendingIdx].Key
+	}
 	return it.indexIter.Key()
 }
 
 // Value 不同于索引迭代器，这里的数据迭代器需要的值是实际存储数据而非pos
 func (it *Iterate) Value() ([]byte, error) {
+	if it.onPending() {
+		return it.pending[it.pendingIdx].Value, nil
+	}
 	pos := it.indexIter.Value()
 
 	it.engine.lock.RLock()
 	defer it.engine.lock.RUnlock()
 
-	return it.engine.GetByRecordPos(pos)
+	return it.engine.getValue(it.Key(), pos)
 
 }
 
@@ -58,18 +107,59 @@ func (it *Iterate) Close() {
 //	@receiver it
 func (it *Iterate) SkipToNext() {
 	prefix := it.options.Prefix
-	prefixLen := len(it.options.Prefix)
-	key := it.Key()
-	keyLen := len(it.Key())
-
 	if prefix == nil {
 		return
 	}
 
-	for ; it.Valid(); it.Next() {
+	for ; it.indexIter.Valid(); it.indexIter.Next() {
+		key := it.indexIter.Key()
 		// 满足前缀则不需要跳过
-		if keyLen >= prefixLen && bytes.Compare(key[:prefixLen], prefix) == 0 {
+		if bytes.HasPrefix(key, prefix) {
 			break
 		}
+
+		// key有序，越过前缀区间后不会再有满足前缀的key
+		cmp := bytes.Compare(key, prefix)
+		if (!it.options.Reverse && cmp > 0) || (it.options.Reverse && cmp < 0) {
+			it.finished = true
+			return
+		}
+	}
+}
+
+func (it *Iterate) indexValid() bool {
+	return !it.finished && it.indexIter.Valid()
+}
+
+// onPending 当前位置是否为暂存的写入，索引与暂存的写入key相同时以暂存的写入为准
+func (it *Iterate) onPending() bool {
+	if it.pendingIdx >= len(it.pending) {
+		return false
+	}
+	return !it.indexValid() || it.comparePending() >= 0
+}
+
+// comparePending 按遍历顺序比较索引与暂存写入的当前key，小于0表示索引在前
+func (it *Iterate) comparePending() int {
+	cmp := bytes.Compare(it.indexIter.Key(), it.pending[it.pendingIdx].Key)
+	if it.options.Reverse {
+		return -cmp
+	}
+	return cmp
+}
+
+// nextPending 前进到下一个暂存的写入，索引中被其覆盖的key一同跳过
+func (it *Iterate) nextPending() {
+	if it.indexValid() && it.comparePending() == 0 {
+		it.indexIter.Next()
+		it.SkipToNext()
+	}
+	it.pendingIdx++
+}
+
+// skipPendingDeletes 跳过暂存的删除以及被其删除的key
+func (it *Iterate) skipPendingDeletes() {
+	for it.onPending() && it.pending[it.pendingIdx].Status == constant.LogRecordDelete {
+		it.nextPending()
 	}
 }
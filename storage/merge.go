@@ -50,6 +50,13 @@ func (db *Engine) Merge() error {
 		db.isMerging = false
 	}()
 
+	// 仍有副本需要读取的数据文件不参与merge，第一个数据文件就被需要时无文件可merge
+	retainedFileID, retained := db.retainedFileID()
+	if retained && retainedFileID == 0 {
+		db.lock.Unlock()
+		return constant.ErrMergeRetained
+	}
+
 	// ====================预处理结束================================================================================
 
 	// 持久化当前活跃数据
@@ -69,12 +76,18 @@ func (db *Engine) Merge() error {
 
 	// 记录没有参加merge的文件ID，如新创建的活跃文件,比这个文件ID小的文件都是merge完成的文件
 	nonMergeFileID := db.activeFile.FilePos.FileID
+	if retained && retainedFileID < nonMergeFileID {
+		nonMergeFileID = retainedFileID
+	}
 
 	// 取出所有的旧文件进行merge，避免与使用者读数据去竞争锁，影响使用者使用体验
 	var mergeFile []*model.DataFile
 	for _, oldFile := range db.oldFile {
-		mergeFile = append(mergeFile, oldFile)
+		if oldFile.FilePos.FileID < nonMergeFileID {
+			mergeFile = append(mergeFile, oldFile)
+		}
 	}
+	crossing := db.crossingMergeChains(nonMergeFileID)
 	db.lock.Unlock()
 
 	// 将merge文件小->大排序，依次merge
@@ -95,10 +108,11 @@ func (db *Engine) Merge() error {
 	}
 
 	// 打开一个新的临时bitcask引擎用于merge操作
-	mergeOptions := db.option
+	// 拷贝一份配置，避免修改当前引擎的配置
+	mergeOptions := *db.option
 	mergeOptions.SyncWrites = true
 	mergeOptions.DirPath = mergePath
-	mergeEngine, err := OpenWithOptions(mergeOptions)
+	mergeEngine, err := OpenWithOptions(&mergeOptions)
 	if err != nil {
 		return err
 	}
@@ -126,9 +140,23 @@ func (db *Engine) Merge() error {
 			// 从db中内存索引的key(最新的) 拿到位置信息与merge中比对，若此数据为最新的数据那么有效，进行重写
 			logRecordPos := db.index.Get(realKey)
 			if logRecordPos != nil && logRecordPos.FileID == dateFile.FilePos.FileID && logRecordPos.Offset == offset {
+				// 合并操作数：将操作数链折叠为一个完整的value
+				if logRecord.Status == constant.LogRecordMerge {
+					value, ok, err := db.foldMergeChain(realKey, logRecordPos)
+					if err != nil {
+						return err
+					}
+					if !ok {
+						offset += size
+						continue
+					}
+					logRecord.Value = value
+					logRecord.Status = constant.LogRecordNormal
+				}
+
 				// 有效，写入
-				logRecord.Key = pkg.LogRecordKeySeq(logRecord.Key, constant.NoneTransactionID)
-				pos, err := mergeEngine.appendLogRecord(logRecord)
+				logRecord.Key = pkg.LogRecordKeySeq(realKey, constant.NoneTransactionID)
+				pos, err := mergeEngine.appendLogRecordWithLock(logRecord)
 				if err != nil {
 					return err
 				}
@@ -143,6 +171,28 @@ func (db *Engine) Merge() error {
 		}
 	}
 
+	// 最新操作数在未merge文件中的操作数链，将merge文件中的部分折叠为基础值写入，重启后其余操作数在此之上回放
+	for key, chain := range crossing {
+		db.lock.RLock()
+		value, err := db.foldChain([]byte(key), chain)
+		db.lock.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		pos, err := mergeEngine.appendLogRecordWithLock(&model.LogRecord{
+			Key:    pkg.LogRecordKeySeq([]byte(key), constant.NoneTransactionID),
+			Value:  value,
+			Status: constant.LogRecordNormal,
+		})
+		if err != nil {
+			return err
+		}
+		if err := hintFile.WriteHintRecord([]byte(key), pos); err != nil {
+			return err
+		}
+	}
+
 	// 全部写完后将hint文件、merge文件持久化
 	if err := hintFile.Sync(); err != nil {
 		return err
@@ -300,6 +350,21 @@ func (db *Engine) getNonMergeFileID(dirPath string) (uint32, error) {
 	return uint32(recordFileID), err
 }
 
+// 若数据目录中存在merge完成的文件，记录未进行merge的最小文件ID
+func (db *Engine) loadNonMergeFileID() error {
+	filePath := path.Join(db.option.DirPath, constant.MergeFinishedName)
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	nonMergeFileID, err := db.getNonMergeFileID(db.option.DirPath)
+	if err != nil {
+		return err
+	}
+	db.nonMergeFileID = nonMergeFileID
+	return nil
+}
+
 func (db *Engine) loadIndexFromHintFile() error {
 	// 查看hint文件是否存在,不存在则直接返回
 	filePath := path.Join(db.option.DirPath, constant.HintFileName)
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kv-db-lab/model"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestEngine_MergeRetainedMergeChain(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-merge-chain")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	opts.DataFileSize = 64
+	opts.DateFileMergeRatio = 0
+	opts.MergeOperator = model.Int64AddOperator{}
+	db, err := OpenWithOptions(&opts)
+	assert.Nil(t, err)
+
+	// c的基础值、d的基础值与首个操作数、e的首个操作数都写在会被merge的文件中
+	assert.Nil(t, db.Put([]byte("c"), []byte("100")))
+	assert.Nil(t, db.Put([]byte("d"), []byte("10")))
+	assert.Nil(t, db.Apply([]byte("d"), []byte("2")))
+	assert.Nil(t, db.Apply([]byte("e"), []byte("5")))
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, db.Put([]byte("filler"), []byte("some-longer-value-"+strconv.Itoa(i))))
+	}
+
+	// 之后的操作数写在仍被使用者需要的文件中，不参与merge
+	retained := db.EndPosition()
+	assert.True(t, retained.FileID > 0)
+	db.Retain("follower", retained)
+	assert.Nil(t, db.Apply([]byte("c"), []byte("1")))
+	assert.Nil(t, db.Apply([]byte("d"), []byte("1")))
+	assert.Nil(t, db.Apply([]byte("e"), []byte("1")))
+
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	// 跨越merge边界的操作数链，merge输出中保留了已折叠的基础值
+	db, err = OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	for key, expected := range map[string]string{"c": "101", "d": "13", "e": "6"} {
+		value, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, string(value), key)
+	}
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"sync"
+)
+
+// SetReplicator
+//
+//	@Description: 设置复制器，之后所有写操作先交由Replicator复制，各节点再通过ApplyCommand按相同顺序应用到本地
+//	@receiver db
+//	@param replicator  为nil时恢复为直接写本地
+func (db *Engine) SetReplicator(replicator model.Replicator) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.replicator = replicator
+}
+
+// ApplyCommand
+//
+//	@Description: 将写操作直接应用到本地引擎，不经过Replicator，供复制状态机调用
+//	@receiver db
+//	@param cmd
+//	@param extra  与写操作在同一批次内原子写入的附加记录(如状态机已应用的日志序号)，写操作失败或条件不满足时仍然写入
+//	@return bool  条件写是否成功，其他写操作成功时为true
+//	@return error
+func (db *Engine) ApplyCommand(cmd *model.WriteCommand, extra ...*model.LogRecord) (bool, error) {
+	if len(extra) > 0 {
+		return db.applyCommandWith(cmd, extra)
+	}
+	if cmd.Type != model.WriteBatch && len(cmd.Records) != 1 {
+		return false, model.ErrInvalidWriteCommand
+	}
+
+	switch cmd.Type {
+	case model.WritePut:
+		db.lock.Lock()
+		defer db.lock.Unlock()
+		return true, db.put(cmd.Records[0].Key, cmd.Records[0].Value)
+	case model.WriteDelete:
+		db.lock.Lock()
+		defer db.lock.Unlock()
+		return true, db.delete(cmd.Records[0].Key)
+	case model.WriteApply:
+		if db.option.MergeOperator == nil {
+			return false, constant.ErrMergeOperatorNotSet
+		}
+		db.lock.Lock()
+		defer db.lock.Unlock()
+		return true, db.apply(cmd.Records[0].Key, cmd.Records[0].Value)
+	case model.WriteCompareAndSwap:
+		expected := cmd.Expected
+		if cmd.ExpectAbsent {
+			expected = nil
+		} else if expected == nil {
+			expected = []byte{}
+		}
+		return db.compareAndSwap(cmd.Records[0].Key, expected, cmd.Records[0].Value)
+	case model.WriteDeleteIfEquals:
+		return db.deleteIfEquals(cmd.Records[0].Key, cmd.Expected)
+	case model.WriteBatch:
+		wb := &WriteBatch{
+			lock:          new(sync.RWMutex),
+			engine:        db,
+			pendingWrites: make(map[string]*model.LogRecord, len(cmd.Records)),
+			options:       &model.WriteBatchOptions{SyncWrite: db.option.SyncWrites, MaxBatchSize: uint(len(cmd.Records))},
+		}
+		for _, record := range cmd.Records {
+			if err := wb.stage(record); err != nil {
+				return false, err
+			}
+		}
+		if len(wb.pendingWrites) == 0 {
+			return true, nil
+		}
+		if len(cmd.Conditions) > 0 {
+			wb.conditions = make(map[string]*model.LogRecord, len(cmd.Conditions))
+			for _, condition := range cmd.Conditions {
+				wb.conditions[string(condition.Key)] = condition
+			}
+		}
+		if err := wb.commit(); err != nil {
+			if err == constant.ErrConditionFailed {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	default:
+		return false, model.ErrInvalidWriteCommand
+	}
+}
+
+// applyCommandWith 将写操作解析为一个写批次，与extra一同提交
+func (db *Engine) applyCommandWith(cmd *model.WriteCommand, extra []*model.LogRecord) (bool, error) {
+	wb := &WriteBatch{
+		lock:          new(sync.RWMutex),
+		engine:        db,
+		pendingWrites: make(map[string]*model.LogRecord, len(cmd.Records)+len(extra)),
+		options:       &model.WriteBatchOptions{SyncWrite: db.option.SyncWrites, MaxBatchSize: uint(len(cmd.Records) + len(extra))},
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	ok, err := db.resolveCommand(cmd, wb)
+	if err != nil || !ok {
+		wb.resetPending()
+	}
+	for _, record := range extra {
+		if err := wb.stage(record); err != nil {
+			return false, err
+		}
+	}
+	if commitErr := wb.commitLocked(); commitErr != nil {
+		return false, commitErr
+	}
+	return ok && err == nil, err
+}
+
+// resolveCommand 检查写操作的条件并将要写入的记录放入wb，返回条件是否满足，调用方需持有db.lock
+func (db *Engine) resolveCommand(cmd *model.WriteCommand, wb *WriteBatch) (bool, error) {
+	if cmd.Type != model.WriteBatch && len(cmd.Records) != 1 {
+		return false, model.ErrInvalidWriteCommand
+	}
+
+	// 单条写操作的记录状态由写操作的类型决定
+	var status constant.LogRecordStatus
+	var expected []byte
+	switch cmd.Type {
+	case model.WritePut:
+		status = constant.LogRecordNormal
+	case model.WriteDelete:
+		status = constant.LogRecordDelete
+	case model.WriteApply:
+		if db.option.MergeOperator == nil {
+			return false, constant.ErrMergeOperatorNotSet
+		}
+		status = constant.LogRecordMerge
+	case model.WriteCompareAndSwap:
+		status = constant.LogRecordNormal
+		if !cmd.ExpectAbsent {
+			expected = cmd.Expected
+			if expected == nil {
+				expected = []byte{}
+			}
+		}
+		if ok, err := db.valueMatches(cmd.Records[0].Key, expected); err != nil || !ok {
+			return false, err
+		}
+	case model.WriteDeleteIfEquals:
+		status = constant.LogRecordDelete
+		if cmd.Expected == nil {
+			return false, nil
+		}
+		if ok, err := db.valueMatches(cmd.Records[0].Key, cmd.Expected); err != nil || !ok {
+			return false, err
+		}
+	case model.WriteBatch:
+		for _, condition := range cmd.Conditions {
+			if wb.conditions == nil {
+				wb.conditions = make(map[string]*model.LogRecord, len(cmd.Conditions))
+			}
+			wb.conditions[string(condition.Key)] = condition
+		}
+		if ok, err := wb.conditionsHold(db.get); err != nil || !ok {
+			return false, err
+		}
+	default:
+		return false, model.ErrInvalidWriteCommand
+	}
+
+	records := cmd.Records
+	if cmd.Type != model.WriteBatch {
+		records = []*model.LogRecord{{Key: records[0].Key, Value: records[0].Value, Status: status}}
+	}
+	for _, record := range records {
+		// 与Engine.delete一致，删除不存在的key时不写入删除记录
+		if record.Status == constant.LogRecordDelete && db.index.Get(record.Key) == nil {
+			continue
+		}
+		if err := wb.stage(record); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"io"
+	"kv-db-lab/fileIO"
+	"kv-db-lab/model"
+)
+
+// EndPosition 返回当前数据文件末尾的位置，即下一条写入记录的位置
+func (db *Engine) EndPosition() *model.LogRecordPos {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.endPosition()
+}
+
+func (db *Engine) endPosition() *model.LogRecordPos {
+	if db.activeFile == nil {
+		return &model.LogRecordPos{}
+	}
+	return &model.LogRecordPos{
+		FileID: db.activeFile.FilePos.FileID,
+		Offset: db.activeFile.FilePos.Offset,
+	}
+}
+
+// Snapshot
+//
+//	@Description: 在同一把读锁内取出所有key的日志位置，释放锁后再依次读取value，从返回的位置续读变更即可得到完整的数据
+//	fn可能很慢(如写网络连接)，遍历期间不阻塞写入；数据文件只追加，锁外按位置读到的仍是取位置时的值
+//	@receiver db
+//	@param fn  返回false时停止遍历
+//	@return *model.LogRecordPos
+//	@return error
+func (db *Engine) Snapshot(fn func(key []byte, value []byte) bool) (*model.LogRecordPos, error) {
+	type entry struct {
+		key   []byte
+		pos   *model.LogRecordPos
+		chain *mergeChain
+	}
+
+	db.lock.RLock()
+	iter := db.index.Iterator(false)
+	entries := make([]entry, 0, db.index.Size())
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		// B+树索引的key只在迭代器的事务内有效，需要拷贝
+		e := entry{key: append([]byte(nil), iter.Key()...), pos: iter.Value()}
+		if chain, ok := db.mergeChains[string(e.key)]; ok {
+			e.chain = &mergeChain{base: chain.base, operands: append([]*model.LogRecordPos(nil), chain.operands...)}
+		}
+		entries = append(entries, e)
+	}
+	iter.Close()
+	end := db.endPosition()
+	db.lock.RUnlock()
+
+	for _, e := range entries {
+		var value []byte
+		var err error
+		db.lock.RLock()
+		if e.chain != nil {
+			value, err = db.foldChain(e.key, e.chain)
+		} else {
+			value, err = db.GetByRecordPos(e.pos)
+		}
+		db.lock.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+		if !fn(e.key, value) {
+			break
+		}
+	}
+	return end, nil
+}
+
+// CopyDataFiles
+//
+//	@Description: 按文件ID从小到大依次读取end之前的数据文件内容，用于直接基于数据文件生成快照
+//	@receiver db
+//	@param end  通常为EndPosition的返回值，end所在文件只读取到end.Offset
+//	@param fn
+//	@return error
+func (db *Engine) CopyDataFiles(end *model.LogRecordPos, fn func(fileID uint, size int64, r io.Reader) error) error {
+	type section struct {
+		fileID uint
+		size   int64
+		reader io.ReaderAt
+	}
+
+	// 只在锁内确定要读取的范围，end之前的数据不会再被修改，读取时无需阻塞写入
+	db.lock.RLock()
+	sections := make([]section, 0)
+	for _, fileID := range db.dataFileIDs() {
+		if fileID > end.FileID {
+			break
+		}
+		dataFile := db.dataFileByID(fileID)
+		size := end.Offset
+		if fileID != end.FileID {
+			var err error
+			if size, err = dataFile.IOManager.Size(); err != nil {
+				db.lock.RUnlock()
+				return err
+			}
+		}
+		sections = append(sections, section{fileID: fileID, size: size, reader: ioReaderAt{dataFile.IOManager}})
+	}
+	db.lock.RUnlock()
+
+	for _, s := range sections {
+		if err := fn(s.fileID, s.size, io.NewSectionReader(s.reader, 0, s.size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ioReaderAt 将IOManager适配为io.ReaderAt
+type ioReaderAt struct {
+	fileIO.IOManager
+}
+
+func (r ioReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return r.IOManager.Read(p, off)
+}
+
+// Options 返回引擎配置的拷贝
+func (db *Engine) Options() model.Options {
+	return *db.option
+}
+
+// Retain
+//
+//	@Description: 登记一个仍需从pos开始读取数据文件的使用者(如副本)，pos所在及之后的数据文件不参与merge
+//	@receiver db
+//	@param name
+//	@param pos
+func (db *Engine) Retain(name string, pos *model.LogRecordPos) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.retainers[name] = &model.LogRecordPos{FileID: pos.FileID, Offset: pos.Offset}
+}
+
+// Release 取消登记
+func (db *Engine) Release(name string) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	delete(db.retainers, name)
+}
+
+// retainedFileID 返回仍有使用者需要读取的最小文件ID，没有使用者时ok为false，调用方需持有db.lock
+func (db *Engine) retainedFileID() (fileID uint, ok bool) {
+	for _, pos := range db.retainers {
+		if !ok || pos.FileID < fileID {
+			fileID, ok = pos.FileID, true
+		}
+	}
+	return fileID, ok
+}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"kv-db-lab/pkg"
+	"sort"
+)
+
+// Watcher 变更订阅者
+type Watcher struct {
+	id     uint64
+	prefix []byte
+	engine *Engine
+	events chan *model.ChangeEvent
+	err    error
+}
+
+// Events 返回接收变更事件的通道，订阅关闭后通道被关闭
+func (w *Watcher) Events() <-chan *model.ChangeEvent {
+	return w.events
+}
+
+// Err 通道关闭后返回关闭原因，消费过慢时为ErrWatchLagged，此时可通过ChangesSince从最后处理的位置补齐
+func (w *Watcher) Err() error {
+	return w.err
+}
+
+// Close 取消订阅
+func (w *Watcher) Close() {
+	w.engine.removeWatcher(w.id, nil)
+}
+
+// filter 过滤出满足前缀的记录，无满足的记录返回nil
+func (w *Watcher) filter(event *model.ChangeEvent) *model.ChangeEvent {
+	if len(w.prefix) == 0 {
+		return event
+	}
+
+	var records []*model.ChangeRecord
+	for _, record := range event.Records {
+		if bytes.HasPrefix(record.Key, w.prefix) {
+			records = append(records, record)
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return &model.ChangeEvent{
+		TransID: event.TransID,
+		Records: records,
+		Next:    event.Next,
+	}
+}
+
+// Watch
+//
+//	@Description: 订阅key满足prefix的所有已提交的Put/Delete/Apply，WriteBatch的提交作为一个事件推送
+//	@receiver db
+//	@param prefix  为空时订阅所有key
+//	@return *Watcher
+func (db *Engine) Watch(prefix []byte) *Watcher {
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+
+	db.watcherID++
+	w := &Watcher{
+		id:     db.watcherID,
+		prefix: append([]byte(nil), prefix...),
+		engine: db,
+		events: make(chan *model.ChangeEvent, constant.WatchChannelSize),
+	}
+	db.watchers[w.id] = w
+	return w
+}
+
+func (db *Engine) removeWatcher(id uint64, err error) {
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+
+	w, ok := db.watchers[id]
+	if !ok {
+		return
+	}
+	w.err = err
+	delete(db.watchers, id)
+	close(w.events)
+}
+
+// publish 向所有订阅者推送变更事件，调用方需持有db.lock保证事件顺序与写入顺序一致
+func (db *Engine) publish(transID uint64, next *model.LogRecordPos, records ...*model.ChangeRecord) {
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+
+	if len(db.watchers) == 0 {
+		return
+	}
+
+	// 拷贝一份数据，避免调用方后续修改传入的切片
+	copied := make([]*model.ChangeRecord, len(records))
+	for i, record := range records {
+		copied[i] = &model.ChangeRecord{
+			Key:    append([]byte(nil), record.Key...),
+			Value:  append([]byte(nil), record.Value...),
+			Status: record.Status,
+			Pos:    record.Pos,
+		}
+	}
+	event := &model.ChangeEvent{TransID: transID, Records: copied, Next: next}
+
+	for id, w := range db.watchers {
+		filtered := w.filter(event)
+		if filtered == nil {
+			continue
+		}
+		select {
+		case w.events <- filtered:
+		default:
+			// 不阻塞写入：消费过慢的订阅者直接关闭
+			w.err = constant.ErrWatchLagged
+			delete(db.watchers, id)
+			close(w.events)
+		}
+	}
+}
+
+// nextPos 返回pos之后下一条记录的位置
+func nextPos(pos *model.LogRecordPos) *model.LogRecordPos {
+	return &model.LogRecordPos{FileID: pos.FileID, Offset: pos.Offset + pos.Size}
+}
+
+// ChangeReader 从指定位置开始顺序读取数据文件中的变更
+type ChangeReader struct {
+	engine *Engine
+	pos    model.LogRecordPos
+
+	// 尚未读到事务完成标识的批写入记录
+	pending map[uint64][]*model.ChangeRecord
+}
+
+// ChangesSince
+//
+//	@Description: 从数据文件的(fileID, offset)处开始读取变更，位置可取自ChangeEvent.Next，(0, 0)表示从头读取
+//	@receiver db
+//	@param fileID
+//	@param offset
+//	@return *ChangeReader
+//	@return error  位置所在文件已被merge重写时返回ErrPositionCompacted
+func (db *Engine) ChangesSince(fileID uint, offset int64) (*ChangeReader, error) {
+	if (fileID != 0 || offset != 0) && fileID < uint(db.nonMergeFileID) {
+		return nil, constant.ErrPositionCompacted
+	}
+
+	return &ChangeReader{
+		engine:  db,
+		pos:     model.LogRecordPos{FileID: fileID, Offset: offset},
+		pending: make(map[uint64][]*model.ChangeRecord),
+	}, nil
+}
+
+// Position 下一条待读取记录的位置
+func (r *ChangeReader) Position() *model.LogRecordPos {
+	return &model.LogRecordPos{FileID: r.pos.FileID, Offset: r.pos.Offset}
+}
+
+// Next
+//
+//	@Description: 读取下一个变更事件，已读到末尾时返回io.EOF，之后有新的写入可继续调用
+//	@receiver r
+//	@return *model.ChangeEvent
+//	@return error
+func (r *ChangeReader) Next() (*model.ChangeEvent, error) {
+	// 批写入提交时持有写锁，读锁内不会读到提交了一半的事务
+	r.engine.lock.RLock()
+	defer r.engine.lock.RUnlock()
+
+	for {
+		dataFile := r.engine.dataFileByID(r.pos.FileID)
+		if dataFile == nil {
+			if !r.nextFile() {
+				return nil, io.EOF
+			}
+			continue
+		}
+
+		logRecord, size, err := dataFile.ReadLogRecordByOffset(r.pos.Offset)
+		if err == io.EOF {
+			if !r.nextFile() {
+				return nil, io.EOF
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		pos := &model.LogRecordPos{FileID: r.pos.FileID, Offset: r.pos.Offset, Size: size}
+		r.pos.Offset += size
+
+		realKey, transID := pkg.PraseKey(logRecord.Key)
+		record := &model.ChangeRecord{
+			Key:    realKey,
+			Value:  logRecord.Value,
+			Status: logRecord.Status,
+			Pos:    pos,
+		}
+		if logRecord.Status == constant.LogRecordDelete {
+			record.Value = nil
+		}
+
+		// 非事务数据直接作为一个事件
+		if transID == constant.NoneTransactionID {
+			return &model.ChangeEvent{Records: []*model.ChangeRecord{record}, Next: r.Position()}, nil
+		}
+
+		// 事务数据读到完成标识后作为一个事件
+		if bytes.Equal(realKey, constant.TxFinKey) {
+			records := r.pending[transID]
+			delete(r.pending, transID)
+			return &model.ChangeEvent{TransID: transID, Records: records, Next: r.Position()}, nil
+		}
+		r.pending[transID] = append(r.pending[transID], record)
+	}
+}
+
+// nextFile 切换到下一个存在的数据文件，已是最新的文件时返回false
+func (r *ChangeReader) nextFile() bool {
+	fileIDs := r.engine.dataFileIDs()
+	idx := sort.Search(len(fileIDs), func(i int) bool {
+		return fileIDs[i] > r.pos.FileID
+	})
+	if idx == len(fileIDs) {
+		return false
+	}
+
+	r.pos = model.LogRecordPos{FileID: fileIDs[idx]}
+	return true
+}
+
+// dataFileByID 根据文件ID找到数据文件，调用方需持有db.lock
+func (db *Engine) dataFileByID(fileID uint) *model.DataFile {
+	if db.activeFile != nil && db.activeFile.FilePos.FileID == fileID {
+		return db.activeFile
+	}
+	return db.oldFile[fileID]
+}
+
+// dataFileIDs 返回有序的数据文件ID，调用方需持有db.lock
+func (db *Engine) dataFileIDs() []uint {
+	fileIDs := make([]uint, 0, len(db.oldFile)+1)
+	for fileID := range db.oldFile {
+		fileIDs = append(fileIDs, fileID)
+	}
+	if db.activeFile != nil {
+		fileIDs = append(fileIDs, db.activeFile.FilePos.FileID)
+	}
+	sort.Slice(fileIDs, func(i, j int) bool {
+		return fileIDs[i] < fileIDs[j]
+	})
+	return fileIDs
+}
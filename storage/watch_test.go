@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"kv-db-lab/constant"
+	"kv-db-lab/model"
+	"os"
+	"testing"
+)
+
+func TestEngine_Watch(t *testing.T) {
+	db := openTestEngine(t)
+
+	w := db.Watch([]byte("user:"))
+	assert.Nil(t, db.Put([]byte("user:1"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("order:1"), []byte("b")))
+	assert.Nil(t, db.Delete([]byte("user:1")))
+
+	wb := db.NewWriteBatch(model.DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user:2"), []byte("c")))
+	assert.Nil(t, wb.Put([]byte("user:3"), []byte("d")))
+	assert.Nil(t, wb.Put([]byte("order:2"), []byte("e")))
+	assert.Nil(t, wb.Commit())
+
+	event := <-w.Events()
+	assert.Equal(t, uint64(0), event.TransID)
+	assert.Equal(t, []byte("user:1"), event.Records[0].Key)
+	assert.Equal(t, constant.LogRecordNormal, event.Records[0].Status)
+
+	event = <-w.Events()
+	assert.Equal(t, constant.LogRecordDelete, event.Records[0].Status)
+
+	event = <-w.Events()
+	assert.NotEqual(t, uint64(0), event.TransID)
+	assert.Equal(t, 2, len(event.Records))
+
+	w.Close()
+	_, ok := <-w.Events()
+	assert.False(t, ok)
+	assert.Nil(t, w.Err())
+}
+
+func TestEngine_WatchLagged(t *testing.T) {
+	db := openTestEngine(t)
+	db.option.SyncWrites = false
+
+	w := db.Watch(nil)
+	for i := 0; i <= constant.WatchChannelSize; i++ {
+		assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	}
+	for range w.Events() {
+	}
+	assert.Equal(t, constant.ErrWatchLagged, w.Err())
+}
+
+func TestEngine_ChangesSince(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-changes")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	opts.DataFileSize = 64
+	db, err := OpenWithOptions(&opts)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	wb := db.NewWriteBatch(model.DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, wb.Delete([]byte("k1")))
+	assert.Nil(t, wb.Commit())
+
+	reader, err := db.ChangesSince(0, 0)
+	assert.Nil(t, err)
+	event, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("k1"), event.Records[0].Key)
+	event, err = reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(event.Records))
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// 重启后从上次的位置续读，跨越多个数据文件
+	last := event.Next
+	assert.Nil(t, db.Close())
+	db, err = OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, db.Put([]byte("k3"), []byte("some-longer-value")))
+	}
+	reader, err = db.ChangesSince(last.FileID, last.Offset)
+	assert.Nil(t, err)
+	var count int
+	for {
+		event, err = reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("k3"), event.Records[0].Key)
+		count++
+	}
+	assert.Equal(t, 5, count)
+	assert.True(t, reader.Position().FileID > last.FileID)
+}
+
+func TestEngine_MergeRetained(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-retained")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := *model.DefaultOptions
+	opts.DirPath = dir
+	opts.DataFileSize = 64
+	opts.DateFileMergeRatio = 0
+	db, err := OpenWithOptions(&opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, db.Put([]byte("k1"), []byte("some-longer-value")))
+	}
+	retained := db.EndPosition()
+	assert.True(t, retained.FileID > 0)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, db.Put([]byte("k2"), []byte("some-longer-value")))
+	}
+
+	// 第一个数据文件仍被需要时无文件可merge
+	db.Retain("follower", &model.LogRecordPos{})
+	assert.Equal(t, constant.ErrMergeRetained, db.Merge())
+
+	// 只merge使用者不再需要的数据文件，重启后仍能从使用者的位置续读
+	db.Retain("follower", retained)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = OpenWithOptions(&opts)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	_, err = db.ChangesSince(0, 1)
+	assert.Equal(t, constant.ErrPositionCompacted, err)
+	reader, err := db.ChangesSince(retained.FileID, retained.Offset)
+	assert.Nil(t, err)
+	var count int
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("k2"), event.Records[0].Key)
+		count++
+	}
+	assert.Equal(t, 3, count)
+
+	value, err := db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("some-longer-value"), value)
+}
+
+func TestEngine_Snapshot(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-snapshot")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	db := openMergeOperatorEngine(t, dir)
+	defer db.Close()
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("2")))
+	assert.Nil(t, db.Put([]byte("c"), []byte("3")))
+	assert.Nil(t, db.Apply([]byte("c"), []byte("4")))
+	end := db.EndPosition()
+
+	// 遍历期间不持有引擎锁，回调中的写入不会阻塞，也不会出现在快照中
+	values := make(map[string]string)
+	pos, err := db.Snapshot(func(key []byte, value []byte) bool {
+		if len(values) == 0 {
+			assert.Nil(t, db.Put([]byte("b"), []byte("changed")))
+			assert.Nil(t, db.Apply([]byte("c"), []byte("100")))
+			assert.Nil(t, db.Put([]byte("d"), []byte("new")))
+		}
+		values[string(key)] = string(value)
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, *end, *pos)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2", "c": "7"}, values)
+}